@@ -6,6 +6,9 @@ require (
 	github.com/golang-jwt/jwt/v5 v5.2.0
 	github.com/gorilla/handlers v1.5.2
 	github.com/gorilla/mux v1.8.1
+	smtpmail v0.0.0
 )
 
 require github.com/felixge/httpsnoop v1.0.3 // indirect
+
+replace smtpmail => ../smtpmail
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	langPtBR    = "pt-BR"
+	langEn      = "en"
+	defaultLang = langPtBR
+)
+
+// messages é o catálogo de mensagens exibidas ao cliente, por chave e idioma
+var messages = map[string]map[string]string{
+	"invalid_body":                  {langPtBR: "Dados inválidos", langEn: "Invalid data"},
+	"internal_error":                {langPtBR: "Erro interno", langEn: "Internal error"},
+	"validation_failed":             {langPtBR: "Alguns campos são inválidos", langEn: "Some fields are invalid"},
+	"too_many_requests":             {langPtBR: "Muitas solicitações, tente novamente mais tarde", langEn: "Too many requests, try again later"},
+	"invalid_credentials":           {langPtBR: "Credenciais inválidas", langEn: "Invalid credentials"},
+	"token_missing":                 {langPtBR: "Token não fornecido", langEn: "Token not provided"},
+	"token_invalid":                 {langPtBR: "Token inválido", langEn: "Invalid token"},
+	"email_exists":                  {langPtBR: "Email já cadastrado", langEn: "Email already registered"},
+	"user_not_found":                {langPtBR: "Usuário não encontrado", langEn: "User not found"},
+	"admin_only":                    {langPtBR: "Acesso restrito a administradores", langEn: "Administrators only"},
+	"institution_not_found":         {langPtBR: "Instituição não encontrada", langEn: "Institution not found"},
+	"slug_exists":                   {langPtBR: "Slug já cadastrado", langEn: "Slug already in use"},
+	"email_domain_not_allowed":      {langPtBR: "Domínio de email não permitido para esta instituição", langEn: "Email domain not allowed for this institution"},
+	"invite_required":               {langPtBR: "Esta instituição exige um código de convite para o cadastro", langEn: "This institution requires an invite code to register"},
+	"invite_invalid":                {langPtBR: "Código de convite inválido", langEn: "Invalid invite code"},
+	"invite_expired":                {langPtBR: "Código de convite expirado", langEn: "Invite code has expired"},
+	"invite_exhausted":              {langPtBR: "Código de convite já atingiu o limite de usos", langEn: "Invite code has reached its usage limit"},
+	"invite_not_found":              {langPtBR: "Convite não encontrado", langEn: "Invite not found"},
+	"invite_other_tenant":           {langPtBR: "Administradores só gerenciam convites da própria instituição", langEn: "Administrators can only manage invites for their own institution"},
+	"magic_link_sent":               {langPtBR: "Se o email estiver cadastrado, enviaremos um link de acesso", langEn: "If the email is registered, we will send a sign-in link"},
+	"magic_link_missing":            {langPtBR: "Link inválido", langEn: "Invalid link"},
+	"magic_link_invalid":            {langPtBR: "Link inválido ou expirado", langEn: "Invalid or expired link"},
+	"magic_link_device_mismatch":    {langPtBR: "Abra o link no mesmo dispositivo em que ele foi solicitado", langEn: "Open the link on the same device where it was requested"},
+	"impersonation_forbidden":       {langPtBR: "Ação não permitida durante a personificação de um usuário", langEn: "Action not allowed while impersonating a user"},
+	"impersonation_admin_forbidden": {langPtBR: "Não é permitido personificar administradores", langEn: "Administrators cannot be impersonated"},
+	"webauthn_credential_invalid":   {langPtBR: "Credencial inválida", langEn: "Invalid credential"},
+	"webauthn_challenge_invalid":    {langPtBR: "Desafio inválido ou expirado", langEn: "Invalid or expired challenge"},
+	"webauthn_uv_required":          {langPtBR: "Verificação do usuário é obrigatória", langEn: "User verification is required"},
+	"passkey_exists":                {langPtBR: "Passkey já cadastrada", langEn: "Passkey already registered"},
+	"passkey_not_found":             {langPtBR: "Passkey não encontrada", langEn: "Passkey not found"},
+	"passkey_required_for_mfa":      {langPtBR: "Cadastre uma passkey antes de ativar o segundo fator", langEn: "Register a passkey before enabling the second factor"},
+
+	// Mensagens de campo
+	"field_required":          {langPtBR: "Campo obrigatório", langEn: "This field is required"},
+	"field_min_length":        {langPtBR: "Deve ter pelo menos %d caracteres", langEn: "Must be at least %d characters long"},
+	"field_not_negative":      {langPtBR: "Não pode ser negativo", langEn: "Must not be negative"},
+	"slug_format":             {langPtBR: "Use de 2 a 63 caracteres: letras minúsculas, números e hífens", langEn: "Use 2 to 63 characters: lowercase letters, digits and hyphens"},
+	"registration_mode":       {langPtBR: "Use open, domain ou invite", langEn: "Use open, domain or invite"},
+	"password_min_length":     {langPtBR: "A senha deve ter pelo menos %d caracteres", langEn: "Password must be at least %d characters long"},
+	"password_require_upper":  {langPtBR: "A senha deve conter uma letra maiúscula", langEn: "Password must contain an uppercase letter"},
+	"password_require_digit":  {langPtBR: "A senha deve conter um número", langEn: "Password must contain a digit"},
+	"password_require_symbol": {langPtBR: "A senha deve conter um símbolo", langEn: "Password must contain a symbol"},
+}
+
+// FieldError descreve um problema de validação em um campo específico do corpo da requisição
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+
+	key  string
+	args []interface{}
+}
+
+func fieldError(field, key string, args ...interface{}) FieldError {
+	return FieldError{Field: field, key: key, args: args}
+}
+
+// preferredLanguage escolhe o idioma suportado de maior peso no Accept-Language
+func preferredLanguage(r *http.Request) string {
+	type candidate struct {
+		lang string
+		q    float64
+	}
+
+	var candidates []candidate
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+
+		tag = strings.ToLower(tag)
+		switch {
+		case tag == "pt" || strings.HasPrefix(tag, "pt-"):
+			candidates = append(candidates, candidate{langPtBR, q})
+		case tag == "en" || strings.HasPrefix(tag, "en-"):
+			candidates = append(candidates, candidate{langEn, q})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	if len(candidates) > 0 && candidates[0].q > 0 {
+		return candidates[0].lang
+	}
+	return defaultLang
+}
+
+// localize traduz a chave para o idioma da requisição, formatando os argumentos
+func localize(r *http.Request, key string, args ...interface{}) string {
+	translations, ok := messages[key]
+	if !ok {
+		return key
+	}
+	text, ok := translations[preferredLanguage(r)]
+	if !ok {
+		text = translations[defaultLang]
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(text, args...)
+	}
+	return text
+}
+
+type requestIDKey struct{}
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestIDMiddleware propaga o X-Request-ID recebido (ou gera um novo) e o devolve na resposta
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			raw := make([]byte, 8)
+			rand.Read(raw)
+			id = hex.EncodeToString(raw)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// writeErrorResponse responde com o envelope de erro padrão, com a mensagem no idioma do cliente
+func writeErrorResponse(w http.ResponseWriter, r *http.Request, statusCode int, errorCode, messageKey string, args ...interface{}) {
+	writeErrorEnvelope(w, r, statusCode, ErrorResponse{
+		Error:   errorCode,
+		Message: localize(r, messageKey, args...),
+	})
+}
+
+// writeValidationError responde 400 VALIDATION_ERROR com os problemas de cada campo
+func writeValidationError(w http.ResponseWriter, r *http.Request, details []FieldError) {
+	for i := range details {
+		details[i].Message = localize(r, details[i].key, details[i].args...)
+	}
+
+	// Com um único problema, a mensagem principal já o descreve
+	message := localize(r, "validation_failed")
+	if len(details) == 1 {
+		message = details[0].Message
+	}
+
+	writeErrorEnvelope(w, r, http.StatusBadRequest, ErrorResponse{
+		Error:   "VALIDATION_ERROR",
+		Message: message,
+		Details: details,
+	})
+}
+
+func writeErrorEnvelope(w http.ResponseWriter, r *http.Request, statusCode int, body ErrorResponse) {
+	body.Code = statusCode
+	body.RequestID = requestID(r)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Language", preferredLanguage(r))
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	impersonationTTL = 15 * time.Minute
+
+	errImpersonationForbidden = "IMPERSONATION_FORBIDDEN"
+)
+
+// Actor identifica quem realmente está agindo quando um token é de personificação (claim "act", RFC 8693)
+type Actor struct {
+	UserID int    `json:"user_id"`
+	Email  string `json:"email"`
+}
+
+type ImpersonateRequest struct {
+	UserID int    `json:"user_id"`
+	Reason string `json:"reason"`
+}
+
+type ImpersonateResponse struct {
+	Token     string    `json:"token"`
+	User      User      `json:"user"`
+	Actor     Actor     `json:"act"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// rejectImpersonation recusa ações sensíveis (senha, passkeys, exclusões) feitas com token de personificação
+func rejectImpersonation(w http.ResponseWriter, r *http.Request, claims *Claims, action string) bool {
+	if claims.Act == nil {
+		return false
+	}
+	log.Printf("%s 403 blocked during impersonation of user_id=%d by admin user_id=%d", action, claims.UserID, claims.Act.UserID)
+	writeErrorResponse(w, r, http.StatusForbidden, errImpersonationForbidden, "impersonation_forbidden")
+	return true
+}
+
+func generateImpersonationJWT(target User, actor Actor) (string, time.Time, error) {
+	expirationTime := time.Now().Add(impersonationTTL)
+	claims := &Claims{
+		UserID:   target.ID,
+		Email:    target.Email,
+		TenantID: target.TenantID,
+		Role:     target.Role,
+		Act:      &actor,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSecret)
+	return token, expirationTime, err
+}
+
+func impersonateHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requireAdmin(w, r, "IMPERSONATE")
+	if !ok {
+		return
+	}
+
+	var req ImpersonateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("IMPERSONATE 400 invalid body from %s: %v", r.RemoteAddr, err)
+		writeErrorResponse(w, r, http.StatusBadRequest, "BAD_REQUEST", "invalid_body")
+		return
+	}
+
+	req.Reason = strings.TrimSpace(req.Reason)
+	if len(req.Reason) < 5 {
+		writeValidationError(w, r, []FieldError{fieldError("reason", "field_min_length", 5)})
+		return
+	}
+
+	stored, ok := lookupUserByID(req.UserID)
+	// Usuários de outra instituição são tratados como inexistentes
+	if !ok || stored.TenantID != admin.TenantID {
+		log.Printf("IMPERSONATE 404 admin user_id=%d tenant_id=%d target user_id=%d not found in tenant", admin.ID, admin.TenantID, req.UserID)
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "user_not_found")
+		return
+	}
+
+	if stored.Role == roleAdmin || stored.ID == admin.ID {
+		log.Printf("IMPERSONATE 403 admin user_id=%d cannot impersonate user_id=%d", admin.ID, stored.ID)
+		writeErrorResponse(w, r, http.StatusForbidden, "FORBIDDEN", "impersonation_admin_forbidden")
+		return
+	}
+
+	target, err := openUserPII(stored)
+	if err != nil {
+		log.Printf("IMPERSONATE 500 openUserPII error for user_id=%d: %v", stored.ID, err)
+		writeErrorResponse(w, r, http.StatusInternalServerError, "INTERNAL_ERROR", "internal_error")
+		return
+	}
+
+	adminProfile, err := openUserPII(admin)
+	if err != nil {
+		log.Printf("IMPERSONATE 500 openUserPII error for user_id=%d: %v", admin.ID, err)
+		writeErrorResponse(w, r, http.StatusInternalServerError, "INTERNAL_ERROR", "internal_error")
+		return
+	}
+
+	actor := Actor{UserID: admin.ID, Email: adminProfile.Email}
+	token, expiresAt, err := generateImpersonationJWT(target, actor)
+	if err != nil {
+		log.Printf("IMPERSONATE 500 generateImpersonationJWT error for user_id=%d: %v", target.ID, err)
+		writeErrorResponse(w, r, http.StatusInternalServerError, "INTERNAL_ERROR", "internal_error")
+		return
+	}
+
+	response := ImpersonateResponse{
+		Token:     token,
+		User:      target,
+		Actor:     actor,
+		ExpiresAt: expiresAt,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+	log.Printf("IMPERSONATE 200 admin user_id=%d email=%s as user_id=%d tenant_id=%d until %s reason=%q",
+		admin.ID, actor.Email, target.ID, target.TenantID, expiresAt.Format(time.RFC3339), req.Reason)
+}
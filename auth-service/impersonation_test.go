@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestImpersonateScopedToAdminTenant(t *testing.T) {
+	admin := seedTestAdmin(t, "admin-suporte@exemplo.com")
+	const reason = "Chamado #42: teste de suporte"
+
+	local := registerTestUser(t, "aluno-local@exemplo.com")
+	rec := doRequest(t, impersonateHandler, http.MethodPost, "/admin/impersonate", ImpersonateRequest{UserID: local.User.ID, Reason: reason}, admin.Token)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("personificar usuário da instituição: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	addTestInstitution(t, "faculdade-vizinha")
+	rec = doRequest(t, registerHandler, http.MethodPost, "/register", RegisterRequest{Email: "aluno-vizinho@exemplo.com", Password: "senha-de-teste", Tenant: "faculdade-vizinha"}, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("cadastro em outra instituição: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var foreign AuthResponse
+	decodeBody(t, rec, &foreign)
+
+	rec = doRequest(t, impersonateHandler, http.MethodPost, "/admin/impersonate", ImpersonateRequest{UserID: foreign.User.ID, Reason: reason}, admin.Token)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("personificar usuário de outra instituição: status %d; esperado 404", rec.Code)
+	}
+
+	rec = doRequest(t, impersonateHandler, http.MethodPost, "/admin/impersonate", ImpersonateRequest{UserID: admin.User.ID, Reason: reason}, admin.Token)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("personificar o próprio admin: status %d; esperado 403", rec.Code)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Modos de cadastro de uma instituição
+const (
+	registrationOpen   = "open"
+	registrationDomain = "domain"
+	registrationInvite = "invite"
+)
+
+// Códigos de erro do cadastro
+const (
+	errInviteRequired        = "INVITE_REQUIRED"
+	errInviteInvalid         = "INVITE_INVALID"
+	errInviteExpired         = "INVITE_EXPIRED"
+	errInviteExhausted       = "INVITE_EXHAUSTED"
+	errEmailDomainNotAllowed = "EMAIL_DOMAIN_NOT_ALLOWED"
+)
+
+// InviteCode é um código de convite de uso único ou múltiplo. Apenas o hash do código é
+// guardado; o código em si é exibido somente na criação.
+type InviteCode struct {
+	ID        int        `json:"id"`
+	Prefix    string     `json:"prefix"`
+	CodeHash  string     `json:"-"`
+	TenantID  int        `json:"tenant_id"`
+	MaxUses   int        `json:"max_uses"`
+	Uses      int        `json:"uses"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Revoked   bool       `json:"revoked"`
+	CreatedBy int        `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type CreateInviteRequest struct {
+	TenantID       int `json:"tenant_id,omitempty"`
+	MaxUses        int `json:"max_uses"`
+	ExpiresInHours int `json:"expires_in_hours,omitempty"`
+}
+
+type CreateInviteResponse struct {
+	Code   string     `json:"code"`
+	Invite InviteCode `json:"invite"`
+}
+
+var (
+	inviteCodes  []InviteCode
+	nextInviteID = 1
+)
+
+// inviteTenant resolve a instituição em que o administrador pode gerenciar convites. Os
+// administradores da instituição padrão, que criam e configuram as demais, atendem qualquer
+// instituição pelo tenant_id; os demais, só a própria. Sem tenant_id, vale a do administrador.
+func inviteTenant(admin User, requested int) (int, bool) {
+	if requested == 0 || requested == admin.TenantID {
+		return admin.TenantID, true
+	}
+	return requested, admin.TenantID == defaultInstitutionID
+}
+
+// registrationMode retorna o modo de cadastro efetivo; sem modo explícito, a lista de domínios ativa o modo "domain"
+func (inst Institution) registrationMode() string {
+	if inst.RegistrationMode != "" {
+		return inst.RegistrationMode
+	}
+	if len(inst.AllowedEmailDomains) > 0 {
+		return registrationDomain
+	}
+	return registrationOpen
+}
+
+func validRegistrationMode(mode string) bool {
+	switch mode {
+	case "", registrationOpen, registrationDomain, registrationInvite:
+		return true
+	}
+	return false
+}
+
+func hashInviteCode(code string) string {
+	hash := sha256.Sum256([]byte(strings.ToUpper(strings.TrimSpace(code))))
+	return hex.EncodeToString(hash[:])
+}
+
+func generateInviteCode() (string, error) {
+	raw := make([]byte, 10)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	code := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)
+	return code[:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:], nil
+}
+
+// findInviteCode localiza um convite pelo código informado no cadastro. Exige storeMu.
+func findInviteCode(code string) *InviteCode {
+	hash := hashInviteCode(strings.ReplaceAll(code, " ", ""))
+	for i := range inviteCodes {
+		if subtle.ConstantTimeCompare([]byte(inviteCodes[i].CodeHash), []byte(hash)) == 1 {
+			return &inviteCodes[i]
+		}
+	}
+	return nil
+}
+
+// checkRegistrationAllowed aplica o modo de cadastro da instituição e retorna o código de
+// erro e a chave da mensagem em caso de recusa. Em modo convite, retorna o convite que deve
+// ter seu uso registrado após o cadastro. Exige storeMu.
+func checkRegistrationAllowed(inst Institution, req RegisterRequest) (*InviteCode, int, string, string) {
+	switch inst.registrationMode() {
+	case registrationDomain:
+		if !inst.emailAllowed(req.Email) {
+			return nil, http.StatusForbidden, errEmailDomainNotAllowed, "email_domain_not_allowed"
+		}
+	case registrationInvite:
+		if strings.TrimSpace(req.InviteCode) == "" {
+			return nil, http.StatusForbidden, errInviteRequired, "invite_required"
+		}
+		invite := findInviteCode(req.InviteCode)
+		if invite == nil || invite.Revoked || invite.TenantID != inst.ID {
+			return nil, http.StatusForbidden, errInviteInvalid, "invite_invalid"
+		}
+		if invite.ExpiresAt != nil && time.Now().After(*invite.ExpiresAt) {
+			return nil, http.StatusForbidden, errInviteExpired, "invite_expired"
+		}
+		if invite.MaxUses > 0 && invite.Uses >= invite.MaxUses {
+			return nil, http.StatusForbidden, errInviteExhausted, "invite_exhausted"
+		}
+		return invite, 0, "", ""
+	}
+	return nil, 0, "", ""
+}
+
+func createInviteHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requireAdmin(w, r, "INVITE CREATE")
+	if !ok {
+		return
+	}
+
+	var req CreateInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("INVITE CREATE 400 invalid body from %s: %v", r.RemoteAddr, err)
+		writeErrorResponse(w, r, http.StatusBadRequest, "BAD_REQUEST", "invalid_body")
+		return
+	}
+
+	tenantID, allowed := inviteTenant(admin, req.TenantID)
+	if !allowed {
+		log.Printf("INVITE CREATE 403 admin user_id=%d tenant_id=%d for tenant_id=%d", admin.ID, admin.TenantID, req.TenantID)
+		writeErrorResponse(w, r, http.StatusForbidden, "FORBIDDEN", "invite_other_tenant")
+		return
+	}
+	req.TenantID = tenantID
+
+	var details []FieldError
+	if req.MaxUses < 0 {
+		details = append(details, fieldError("max_uses", "field_not_negative"))
+	}
+	if req.ExpiresInHours < 0 {
+		details = append(details, fieldError("expires_in_hours", "field_not_negative"))
+	}
+	if len(details) > 0 {
+		writeValidationError(w, r, details)
+		return
+	}
+	if req.MaxUses == 0 {
+		req.MaxUses = 1
+	}
+
+	code, err := generateInviteCode()
+	if err != nil {
+		log.Printf("INVITE CREATE 500 generateInviteCode error: %v", err)
+		writeErrorResponse(w, r, http.StatusInternalServerError, "INTERNAL_ERROR", "internal_error")
+		return
+	}
+
+	storeMu.Lock()
+	if findInstitutionByID(req.TenantID) == nil {
+		storeMu.Unlock()
+		writeErrorResponse(w, r, http.StatusBadRequest, "TENANT_NOT_FOUND", "institution_not_found")
+		return
+	}
+
+	invite := InviteCode{
+		ID:        nextInviteID,
+		Prefix:    code[:4],
+		CodeHash:  hashInviteCode(code),
+		TenantID:  req.TenantID,
+		MaxUses:   req.MaxUses,
+		CreatedBy: admin.ID,
+		CreatedAt: time.Now(),
+	}
+	if req.ExpiresInHours > 0 {
+		expiresAt := time.Now().Add(time.Duration(req.ExpiresInHours) * time.Hour)
+		invite.ExpiresAt = &expiresAt
+	}
+
+	inviteCodes = append(inviteCodes, invite)
+	nextInviteID++
+	storeMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreateInviteResponse{Code: code, Invite: invite})
+	log.Printf("INVITE CREATE 201 id=%d tenant_id=%d max_uses=%d by admin user_id=%d", invite.ID, invite.TenantID, invite.MaxUses, admin.ID)
+}
+
+func listInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requireAdmin(w, r, "INVITES")
+	if !ok {
+		return
+	}
+
+	requested, _ := strconv.Atoi(r.URL.Query().Get("tenant_id"))
+	tenantID, allowed := inviteTenant(admin, requested)
+	if !allowed {
+		log.Printf("INVITES 403 admin user_id=%d tenant_id=%d for tenant_id=%d", admin.ID, admin.TenantID, requested)
+		writeErrorResponse(w, r, http.StatusForbidden, "FORBIDDEN", "invite_other_tenant")
+		return
+	}
+
+	result := []InviteCode{}
+	storeMu.RLock()
+	for _, invite := range inviteCodes {
+		if invite.TenantID == tenantID {
+			result = append(result, invite)
+		}
+	}
+	storeMu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func revokeInviteHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requireAdmin(w, r, "INVITE REVOKE")
+	if !ok {
+		return
+	}
+
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	revoked := false
+	storeMu.Lock()
+	for i := range inviteCodes {
+		if _, allowed := inviteTenant(admin, inviteCodes[i].TenantID); inviteCodes[i].ID == id && allowed {
+			inviteCodes[i].Revoked = true
+			revoked = true
+			break
+		}
+	}
+	storeMu.Unlock()
+
+	if !revoked {
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "invite_not_found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	log.Printf("INVITE REVOKE 204 id=%d by admin user_id=%d", id, admin.ID)
+}
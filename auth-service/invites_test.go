@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// addTestTenantAdmin cria diretamente no store um administrador de outra instituição e
+// retorna seu token de sessão
+func addTestTenantAdmin(t *testing.T, tenantID int, slug, email string) AuthResponse {
+	t.Helper()
+
+	salt, _ := generateSalt()
+	hashed, err := hashPassword("senha-do-admin", salt)
+	if err != nil {
+		t.Fatalf("hashPassword: %v", err)
+	}
+
+	storeMu.Lock()
+	admin := User{ID: nextUserID, TenantID: tenantID, Email: email, Role: roleAdmin, Password: hashed, Salt: salt, CreatedAt: time.Now()}
+	if err := sealUserPII(&admin); err != nil {
+		storeMu.Unlock()
+		t.Fatalf("sealUserPII: %v", err)
+	}
+	users = append(users, admin)
+	adminAccounts[admin.ID] = tenantID
+	nextUserID++
+	storeMu.Unlock()
+
+	rec := doRequest(t, loginHandler, http.MethodPost, "/login", LoginRequest{Email: email, Password: "senha-do-admin", Tenant: slug}, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login do admin da instituição: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp AuthResponse
+	decodeBody(t, rec, &resp)
+	return resp
+}
+
+// revokeInvite chama o DELETE /invites/{id}
+func revokeInvite(token string, id int) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodDelete, "/invites/"+strconv.Itoa(id), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req = mux.SetURLVars(req, map[string]string{"id": strconv.Itoa(id)})
+	rec := httptest.NewRecorder()
+	revokeInviteHandler(rec, req)
+	return rec
+}
+
+func TestInviteRegistrationInOtherTenant(t *testing.T) {
+	admin := seedTestAdmin(t, "admin-convites@exemplo.com")
+	tenantID := addTestInstitution(t, "faculdade-por-convite")
+	storeMu.Lock()
+	findInstitutionByID(tenantID).RegistrationMode = registrationInvite
+	storeMu.Unlock()
+
+	// Sem convite, a instituição não aceita cadastros
+	rec := doRequest(t, registerHandler, http.MethodPost, "/register", RegisterRequest{Email: "aluno@faculdade.edu", Password: "senha-de-teste", Tenant: "faculdade-por-convite"}, "")
+	if rec.Code != http.StatusForbidden || errorCode(t, rec) != errInviteRequired {
+		t.Fatalf("cadastro sem convite: status %d: %s; esperado 403 %s", rec.Code, rec.Body.String(), errInviteRequired)
+	}
+
+	// O administrador da instituição padrão emite o convite para ela
+	rec = doRequest(t, createInviteHandler, http.MethodPost, "/invites", CreateInviteRequest{TenantID: tenantID, MaxUses: 1}, admin.Token)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("convite para a instituição: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var created CreateInviteResponse
+	decodeBody(t, rec, &created)
+	if created.Invite.TenantID != tenantID {
+		t.Fatalf("tenant_id do convite = %d; esperado %d", created.Invite.TenantID, tenantID)
+	}
+
+	rec = doRequest(t, registerHandler, http.MethodPost, "/register", RegisterRequest{Email: "aluno@faculdade.edu", Password: "senha-de-teste", Tenant: "faculdade-por-convite", InviteCode: created.Code}, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("cadastro com convite: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var registered AuthResponse
+	decodeBody(t, rec, &registered)
+	if registered.User.TenantID != tenantID {
+		t.Fatalf("usuário cadastrado na instituição %d; esperado %d", registered.User.TenantID, tenantID)
+	}
+
+	// O convite era de uso único
+	rec = doRequest(t, registerHandler, http.MethodPost, "/register", RegisterRequest{Email: "outro@faculdade.edu", Password: "senha-de-teste", Tenant: "faculdade-por-convite", InviteCode: created.Code}, "")
+	if rec.Code != http.StatusForbidden || errorCode(t, rec) != errInviteExhausted {
+		t.Fatalf("segundo cadastro com o convite: status %d: %s; esperado 403 %s", rec.Code, rec.Body.String(), errInviteExhausted)
+	}
+
+	rec = doRequest(t, listInvitesHandler, http.MethodGet, "/invites?tenant_id="+strconv.Itoa(tenantID), nil, admin.Token)
+	var listed []InviteCode
+	decodeBody(t, rec, &listed)
+	if rec.Code != http.StatusOK || len(listed) != 1 || listed[0].ID != created.Invite.ID || listed[0].Uses != 1 {
+		t.Fatalf("listagem da instituição: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := revokeInvite(admin.Token, created.Invite.ID); rec.Code != http.StatusNoContent {
+		t.Fatalf("revogar convite da instituição: status %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestInvitesScopedToTenantAdmin(t *testing.T) {
+	root := seedTestAdmin(t, "admin-raiz@exemplo.com")
+	ownTenant := addTestInstitution(t, "faculdade-do-admin")
+	otherTenant := addTestInstitution(t, "faculdade-vizinha")
+	admin := addTestTenantAdmin(t, ownTenant, "faculdade-do-admin", "admin@faculdade-do-admin.edu")
+
+	rec := doRequest(t, createInviteHandler, http.MethodPost, "/invites", CreateInviteRequest{TenantID: otherTenant, MaxUses: 5}, admin.Token)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("convite para outra instituição: status %d; esperado 403", rec.Code)
+	}
+	rec = doRequest(t, createInviteHandler, http.MethodPost, "/invites", CreateInviteRequest{TenantID: defaultInstitutionID, MaxUses: 5}, admin.Token)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("convite para a instituição padrão: status %d; esperado 403", rec.Code)
+	}
+
+	rec = doRequest(t, createInviteHandler, http.MethodPost, "/invites", CreateInviteRequest{MaxUses: 5}, admin.Token)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("convite da própria instituição: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var created CreateInviteResponse
+	decodeBody(t, rec, &created)
+	if created.Invite.TenantID != ownTenant {
+		t.Fatalf("tenant_id do convite = %d; esperado %d", created.Invite.TenantID, ownTenant)
+	}
+
+	rec = doRequest(t, createInviteHandler, http.MethodPost, "/invites", CreateInviteRequest{TenantID: otherTenant, MaxUses: 1}, root.Token)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("convite do admin da instituição padrão: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var foreign CreateInviteResponse
+	decodeBody(t, rec, &foreign)
+
+	if rec := doRequest(t, listInvitesHandler, http.MethodGet, "/invites?tenant_id="+strconv.Itoa(otherTenant), nil, admin.Token); rec.Code != http.StatusForbidden {
+		t.Fatalf("listagem de outra instituição: status %d; esperado 403", rec.Code)
+	}
+	rec = doRequest(t, listInvitesHandler, http.MethodGet, "/invites", nil, admin.Token)
+	var listed []InviteCode
+	decodeBody(t, rec, &listed)
+	if rec.Code != http.StatusOK || len(listed) != 1 || listed[0].ID != created.Invite.ID {
+		t.Fatalf("listagem da própria instituição: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := revokeInvite(admin.Token, foreign.Invite.ID); rec.Code != http.StatusNotFound {
+		t.Fatalf("revogar convite de outra instituição: status %d; esperado 404", rec.Code)
+	}
+	if rec := revokeInvite(admin.Token, created.Invite.ID); rec.Code != http.StatusNoContent {
+		t.Fatalf("revogar convite da própria instituição: status %d: %s", rec.Code, rec.Body.String())
+	}
+}
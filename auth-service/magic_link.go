@@ -0,0 +1,285 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"smtpmail"
+)
+
+const (
+	magicLinkTTL    = 15 * time.Minute
+	magicLinkCookie = "magic_link_nonce"
+)
+
+type MagicLinkRequest struct {
+	Email  string `json:"email"`
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// MagicLinkClaims são as claims do token enviado por email. O nonce guarda o hash
+// do cookie entregue ao dispositivo que fez a solicitação.
+type MagicLinkClaims struct {
+	UserID    int    `json:"user_id"`
+	NonceHash string `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+// magicLinkStore guarda os links emitidos e ainda não utilizados (jti -> expiração)
+type magicLinkStore struct {
+	mu      sync.Mutex
+	pending map[string]time.Time
+}
+
+func (s *magicLinkStore) add(jti string, expiresAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, exp := range s.pending {
+		if now.After(exp) {
+			delete(s.pending, id)
+		}
+	}
+	s.pending[jti] = expiresAt
+}
+
+// consume marca o link como utilizado; retorna false se ele já foi usado ou expirou
+func (s *magicLinkStore) consume(jti string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	exp, ok := s.pending[jti]
+	if !ok {
+		return false
+	}
+	delete(s.pending, jti)
+	return time.Now().Before(exp)
+}
+
+var (
+	mailer           smtpmail.Mailer = smtpmail.LogMailer{}
+	magicLinkBaseURL                 = "http://localhost:3000"
+	magicLinks                       = &magicLinkStore{pending: make(map[string]time.Time)}
+
+	// Throttling: no máximo 3 links por email e 10 solicitações por IP a cada 15 minutos
+	magicLinkEmailLimiter = newRateLimiter(3, 15*time.Minute)
+	magicLinkIPLimiter    = newRateLimiter(10, 15*time.Minute)
+)
+
+// magicLinkKey deriva uma chave própria para os links, impedindo que eles sejam aceitos como token de sessão
+func magicLinkKey() []byte {
+	return purposeKey("magic-link")
+}
+
+func hashNonce(nonce string) string {
+	hash := sha256.Sum256([]byte(nonce))
+	return hex.EncodeToString(hash[:])
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func generateMagicLink(user User, nonce string) (string, error) {
+	jti, err := generateSalt()
+	if err != nil {
+		return "", err
+	}
+
+	expirationTime := time.Now().Add(magicLinkTTL)
+	claims := &MagicLinkClaims{
+		UserID:    user.ID,
+		NonceHash: hashNonce(nonce),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(magicLinkKey())
+	if err != nil {
+		return "", err
+	}
+
+	magicLinks.add(jti, expirationTime)
+	return magicLinkBaseURL + "/login/magic-link/callback?token=" + url.QueryEscape(token), nil
+}
+
+func magicLinkRequestHandler(w http.ResponseWriter, r *http.Request) {
+	var req MagicLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("MAGICLINK 400 invalid body from %s: %v", r.RemoteAddr, err)
+		writeErrorResponse(w, r, http.StatusBadRequest, "BAD_REQUEST", "invalid_body")
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if email == "" {
+		log.Printf("MAGICLINK 400 missing email from %s", r.RemoteAddr)
+		writeErrorResponse(w, r, http.StatusBadRequest, "BAD_REQUEST", "invalid_body")
+		return
+	}
+
+	if !magicLinkIPLimiter.Allow(clientIP(r)) || !magicLinkEmailLimiter.Allow(email) {
+		log.Printf("MAGICLINK 429 throttled email=%s from %s", email, r.RemoteAddr)
+		writeErrorResponse(w, r, http.StatusTooManyRequests, "TOO_MANY_REQUESTS", "too_many_requests")
+		return
+	}
+
+	// O nonce vincula o link ao dispositivo que o solicitou
+	nonce, err := generateSalt()
+	if err != nil {
+		log.Printf("MAGICLINK 500 generateSalt error for %s: %v", email, err)
+		writeErrorResponse(w, r, http.StatusInternalServerError, "INTERNAL_ERROR", "internal_error")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     magicLinkCookie,
+		Value:    nonce,
+		Path:     "/login/magic-link",
+		MaxAge:   int(magicLinkTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	// A resposta é sempre a mesma, e sai antes do envio, para não revelar quais emails estão
+	// cadastrados nem pelo conteúdo nem pelo tempo de resposta
+	response := map[string]string{
+		"message": localize(r, "magic_link_sent"),
+	}
+
+	if stored, ok := lookupUserByTenantEmail(req.Tenant, email); !ok {
+		log.Printf("MAGICLINK 202 unknown email: %s", email)
+	} else {
+		go sendMagicLink(mailer, stored, nonce)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(response)
+}
+
+// sendMagicLink gera o link e o envia por email; roda fora da requisição, então os erros só vão para o log
+func sendMagicLink(m smtpmail.Mailer, stored User, nonce string) {
+	user, err := openUserPII(stored)
+	var link string
+	if err == nil {
+		link, err = generateMagicLink(user, nonce)
+	}
+	if err == nil {
+		body := fmt.Sprintf("Use o link abaixo para entrar. Ele expira em %d minutos e só pode ser usado uma vez.\n\n%s\n", int(magicLinkTTL.Minutes()), link)
+		err = m.Send(user.Email, "Seu link de acesso", body)
+	}
+	if err != nil {
+		log.Printf("MAGICLINK send error for user_id=%d: %v", stored.ID, err)
+		return
+	}
+	log.Printf("MAGICLINK sent user_id=%d email=%s", user.ID, user.Email)
+}
+
+func magicLinkCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	tokenString := r.URL.Query().Get("token")
+	if tokenString == "" {
+		log.Printf("MAGICLINK 400 missing token from %s", r.RemoteAddr)
+		writeErrorResponse(w, r, http.StatusBadRequest, "MAGIC_LINK_INVALID", "magic_link_missing")
+		return
+	}
+
+	claims := &MagicLinkClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return magicLinkKey(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !token.Valid {
+		log.Printf("MAGICLINK 401 invalid token from %s: %v", r.RemoteAddr, err)
+		writeErrorResponse(w, r, http.StatusUnauthorized, "MAGIC_LINK_INVALID", "magic_link_invalid")
+		return
+	}
+
+	cookie, err := r.Cookie(magicLinkCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(hashNonce(cookie.Value)), []byte(claims.NonceHash)) != 1 {
+		log.Printf("MAGICLINK 401 device mismatch user_id=%d from %s", claims.UserID, r.RemoteAddr)
+		writeErrorResponse(w, r, http.StatusUnauthorized, "MAGIC_LINK_DEVICE_MISMATCH", "magic_link_device_mismatch")
+		return
+	}
+
+	if !magicLinks.consume(claims.ID) {
+		log.Printf("MAGICLINK 401 link already used user_id=%d from %s", claims.UserID, r.RemoteAddr)
+		writeErrorResponse(w, r, http.StatusUnauthorized, "MAGIC_LINK_INVALID", "magic_link_invalid")
+		return
+	}
+
+	stored, ok := lookupUserByID(claims.UserID)
+	if !ok {
+		log.Printf("MAGICLINK 401 unknown user_id=%d", claims.UserID)
+		writeErrorResponse(w, r, http.StatusUnauthorized, "MAGIC_LINK_INVALID", "magic_link_invalid")
+		return
+	}
+
+	user, err := openUserPII(stored)
+	if err != nil {
+		log.Printf("MAGICLINK 500 openUserPII error for user_id=%d: %v", stored.ID, err)
+		writeErrorResponse(w, r, http.StatusInternalServerError, "INTERNAL_ERROR", "internal_error")
+		return
+	}
+
+	// O link já foi consumido: o cookie de vínculo não é mais necessário
+	http.SetCookie(w, &http.Cookie{
+		Name:     magicLinkCookie,
+		Value:    "",
+		Path:     "/login/magic-link",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+
+	// O link substitui só a senha: com a passkey exigida como segundo fator, o token só é
+	// emitido após /webauthn/login/finish, como no loginHandler
+	if user.WebAuthnMFA {
+		mfaToken, err := generateMFAToken(user)
+		if err != nil {
+			log.Printf("MAGICLINK 500 generateMFAToken error for %s: %v", user.Email, err)
+			writeErrorResponse(w, r, http.StatusInternalServerError, "INTERNAL_ERROR", "internal_error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(MFARequiredResponse{MFARequired: true, MFAToken: mfaToken})
+		log.Printf("MAGICLINK 200 mfa required user_id=%d email=%s", user.ID, user.Email)
+		return
+	}
+
+	// Gerar JWT
+	sessionToken, err := generateJWT(user)
+	if err != nil {
+		log.Printf("MAGICLINK 500 generateJWT error for %s: %v", user.Email, err)
+		writeErrorResponse(w, r, http.StatusInternalServerError, "INTERNAL_ERROR", "internal_error")
+		return
+	}
+
+	response := AuthResponse{
+		Token: sessionToken,
+		User:  user,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+	log.Printf("MAGICLINK 200 user_id=%d email=%s", user.ID, user.Email)
+}
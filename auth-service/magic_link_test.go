@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type sentMail struct {
+	to, subject, body string
+}
+
+// captureMailer entrega as mensagens enviadas em um canal, descartando as que não cabem nele;
+// com release, cada envio espera o canal ser fechado antes de terminar
+type captureMailer struct {
+	sent    chan sentMail
+	release chan struct{}
+}
+
+func (m *captureMailer) Send(to, subject, body string) error {
+	if m.release != nil {
+		<-m.release
+	}
+	select {
+	case m.sent <- sentMail{to: to, subject: subject, body: body}:
+	default:
+	}
+	return nil
+}
+
+// useCaptureMailer troca o mailer do serviço durante o teste
+func useCaptureMailer(t *testing.T) *captureMailer {
+	t.Helper()
+
+	m := &captureMailer{sent: make(chan sentMail, 8)}
+	previous := mailer
+	mailer = m
+	t.Cleanup(func() { mailer = previous })
+	return m
+}
+
+// requestMagicLink chama o POST /login/magic-link a partir do endereço informado
+func requestMagicLink(t *testing.T, email, remoteAddr string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	raw, _ := json.Marshal(MagicLinkRequest{Email: email})
+	req := httptest.NewRequest(http.MethodPost, "/login/magic-link", bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = remoteAddr
+	rec := httptest.NewRecorder()
+	magicLinkRequestHandler(rec, req)
+	return rec
+}
+
+// nonceCookie retorna o cookie de vínculo definido na resposta da solicitação
+func nonceCookie(t *testing.T, rec *httptest.ResponseRecorder) *http.Cookie {
+	t.Helper()
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == magicLinkCookie {
+			return c
+		}
+	}
+	t.Fatalf("resposta sem o cookie %s", magicLinkCookie)
+	return nil
+}
+
+// receiveMail espera a próxima mensagem enviada
+func receiveMail(t *testing.T, m *captureMailer) sentMail {
+	t.Helper()
+	select {
+	case mail := <-m.sent:
+		return mail
+	case <-time.After(5 * time.Second):
+		t.Fatal("nenhum email enviado")
+	}
+	return sentMail{}
+}
+
+// callbackQuery extrai a query do link contido no corpo do email
+func callbackQuery(t *testing.T, body string) string {
+	t.Helper()
+	for _, field := range strings.Fields(body) {
+		if strings.Contains(field, "/login/magic-link/callback?") {
+			parsed, err := url.Parse(field)
+			if err != nil {
+				t.Fatalf("link inválido %q: %v", field, err)
+			}
+			return parsed.RawQuery
+		}
+	}
+	t.Fatalf("email sem link de acesso: %q", body)
+	return ""
+}
+
+// openMagicLink chama o callback com o cookie de vínculo, se houver
+func openMagicLink(query string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/login/magic-link/callback?"+query, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	magicLinkCallbackHandler(rec, req)
+	return rec
+}
+
+func TestMagicLinkLoginIsSingleUse(t *testing.T) {
+	const email = "link-uso-unico@exemplo.com"
+	registerTestUser(t, email)
+	m := useCaptureMailer(t)
+
+	rec := requestMagicLink(t, email, "198.51.100.1:1000")
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("solicitação: status %d: %s", rec.Code, rec.Body.String())
+	}
+	cookie := nonceCookie(t, rec)
+	mail := receiveMail(t, m)
+	if mail.to != email {
+		t.Fatalf("email enviado para %q; esperado %q", mail.to, email)
+	}
+	query := callbackQuery(t, mail.body)
+
+	callback := openMagicLink(query, cookie)
+	var resp AuthResponse
+	decodeBody(t, callback, &resp)
+	if callback.Code != http.StatusOK || resp.Token == "" || resp.User.Email != email {
+		t.Fatalf("callback: status %d: %s", callback.Code, callback.Body.String())
+	}
+
+	callback = openMagicLink(query, cookie)
+	if callback.Code != http.StatusUnauthorized || errorCode(t, callback) != "MAGIC_LINK_INVALID" {
+		t.Fatalf("segundo uso do link: status %d: %s; esperado 401 MAGIC_LINK_INVALID", callback.Code, callback.Body.String())
+	}
+}
+
+func TestMagicLinkDeviceMismatch(t *testing.T) {
+	const email = "link-dispositivo@exemplo.com"
+	registerTestUser(t, email)
+	m := useCaptureMailer(t)
+
+	rec := requestMagicLink(t, email, "198.51.100.2:1000")
+	cookie := nonceCookie(t, rec)
+	query := callbackQuery(t, receiveMail(t, m).body)
+
+	for name, c := range map[string]*http.Cookie{
+		"sem cookie":  nil,
+		"outro nonce": {Name: magicLinkCookie, Value: "nonce-de-outro-dispositivo"},
+	} {
+		callback := openMagicLink(query, c)
+		if callback.Code != http.StatusUnauthorized || errorCode(t, callback) != "MAGIC_LINK_DEVICE_MISMATCH" {
+			t.Fatalf("%s: status %d: %s; esperado 401 MAGIC_LINK_DEVICE_MISMATCH", name, callback.Code, callback.Body.String())
+		}
+	}
+
+	// As tentativas de outro dispositivo não consomem o link
+	if callback := openMagicLink(query, cookie); callback.Code != http.StatusOK {
+		t.Fatalf("callback no dispositivo de origem: status %d: %s", callback.Code, callback.Body.String())
+	}
+}
+
+func TestMagicLinkUnknownEmailLooksTheSame(t *testing.T) {
+	const email = "link-conhecido@exemplo.com"
+	registerTestUser(t, email)
+	m := useCaptureMailer(t)
+	// O envio fica preso até o fim do teste: a resposta não pode esperar por ele
+	m.release = make(chan struct{})
+	defer close(m.release)
+
+	known := requestMagicLink(t, email, "198.51.100.3:1000")
+	unknown := requestMagicLink(t, "link-desconhecido@exemplo.com", "198.51.100.3:1000")
+
+	if known.Code != http.StatusAccepted || unknown.Code != http.StatusAccepted {
+		t.Fatalf("status %d e %d; esperado 202 nos dois", known.Code, unknown.Code)
+	}
+	if known.Body.String() != unknown.Body.String() {
+		t.Fatalf("respostas diferentes: %q e %q", known.Body.String(), unknown.Body.String())
+	}
+	nonceCookie(t, unknown)
+}
+
+func TestMagicLinkUnknownEmailSendsNothing(t *testing.T) {
+	m := useCaptureMailer(t)
+
+	if rec := requestMagicLink(t, "link-ninguem@exemplo.com", "198.51.100.4:1000"); rec.Code != http.StatusAccepted {
+		t.Fatalf("solicitação: status %d: %s", rec.Code, rec.Body.String())
+	}
+	select {
+	case mail := <-m.sent:
+		t.Fatalf("email enviado para %q", mail.to)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMagicLinkThrottling(t *testing.T) {
+	useCaptureMailer(t)
+
+	// Por email: 3 links a cada 15 minutos, de qualquer endereço
+	const email = "link-limite@exemplo.com"
+	for i := 0; i < 3; i++ {
+		if rec := requestMagicLink(t, email, "198.51.100.10:1000"); rec.Code != http.StatusAccepted {
+			t.Fatalf("solicitação %d: status %d", i+1, rec.Code)
+		}
+	}
+	rec := requestMagicLink(t, email, "198.51.100.11:1000")
+	if rec.Code != http.StatusTooManyRequests || errorCode(t, rec) != "TOO_MANY_REQUESTS" {
+		t.Fatalf("quarta solicitação: status %d: %s; esperado 429", rec.Code, rec.Body.String())
+	}
+
+	// Por IP: 10 solicitações a cada 15 minutos, para quaisquer emails
+	for i := 0; i < 10; i++ {
+		if rec := requestMagicLink(t, "link-ip-"+string(rune('a'+i))+"@exemplo.com", "198.51.100.12:1000"); rec.Code != http.StatusAccepted {
+			t.Fatalf("solicitação %d do mesmo IP: status %d", i+1, rec.Code)
+		}
+	}
+	if rec := requestMagicLink(t, "link-ip-outro@exemplo.com", "198.51.100.12:2000"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("décima primeira solicitação do mesmo IP: status %d; esperado 429", rec.Code)
+	}
+}
+
+func TestMagicLinkRequiresSecondFactor(t *testing.T) {
+	const email = "link-mfa@exemplo.com"
+	user := registerTestUser(t, email)
+	registerPasskey(t, newSoftAuthenticator(t), user.Token)
+	if rec := doRequest(t, webauthnMFAHandler, http.MethodPut, "/webauthn/mfa", WebAuthnMFARequest{Enabled: true}, user.Token); rec.Code != http.StatusOK {
+		t.Fatalf("ativar mfa: status %d: %s", rec.Code, rec.Body.String())
+	}
+	m := useCaptureMailer(t)
+
+	rec := requestMagicLink(t, email, "198.51.100.5:1000")
+	cookie := nonceCookie(t, rec)
+	callback := openMagicLink(callbackQuery(t, receiveMail(t, m).body), cookie)
+
+	var resp struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+		Token       string `json:"token"`
+	}
+	decodeBody(t, callback, &resp)
+	if callback.Code != http.StatusOK || !resp.MFARequired || resp.Token != "" {
+		t.Fatalf("callback: status %d: %s; esperado mfa_required sem token de sessão", callback.Code, callback.Body.String())
+	}
+	if _, err := validateMFAToken(resp.MFAToken); err != nil {
+		t.Fatalf("mfa_token inválido: %v", err)
+	}
+}
@@ -15,24 +15,40 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+
+	"smtpmail"
 )
 
 type User struct {
 	ID        int       `json:"id"`
+	TenantID  int       `json:"tenant_id"`
 	Email     string    `json:"email"`
+	Role      string    `json:"role"`
 	Password  string    `json:"-"`
 	Salt      string    `json:"-"`
 	CreatedAt time.Time `json:"created_at"`
+
+	// Passkeys (WebAuthn) e se elas são exigidas como segundo fator no login por senha
+	Credentials []WebAuthnCredential `json:"-"`
+	WebAuthnMFA bool                 `json:"webauthn_mfa"`
+
+	// Email cifrado em repouso (AES-GCM) e índice cego (HMAC) para buscas.
+	// Usuários armazenados têm Email vazio; use openUserPII para decifrá-lo.
+	EmailEncrypted string `json:"-"`
+	EmailIndex     string `json:"-"`
 }
 
 type LoginRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	Tenant   string `json:"tenant,omitempty"`
 }
 
 type RegisterRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email      string `json:"email"`
+	Password   string `json:"password"`
+	Tenant     string `json:"tenant,omitempty"`
+	InviteCode string `json:"invite_code,omitempty"`
 }
 
 type AuthResponse struct {
@@ -40,9 +56,22 @@ type AuthResponse struct {
 	User  User   `json:"user"`
 }
 
+// ErrorResponse é o envelope de erro comum aos serviços: código estável, mensagem
+// localizada, detalhes por campo e o ID da requisição para correlação nos logs
+type ErrorResponse struct {
+	Error     string       `json:"error"`
+	Message   string       `json:"message,omitempty"`
+	Code      int          `json:"code"`
+	Details   []FieldError `json:"details,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
 type Claims struct {
-	UserID int    `json:"user_id"`
-	Email  string `json:"email"`
+	UserID   int    `json:"user_id"`
+	Email    string `json:"email"`
+	TenantID int    `json:"tenant_id"`
+	Role     string `json:"role"`
+	Act      *Actor `json:"act,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -50,6 +79,9 @@ var (
 	users      []User
 	jwtSecret  = []byte("seu-jwt-secret-super-seguro-aqui")
 	nextUserID = 1
+
+	// corsOrigins são as origens do frontend autorizadas a chamar o serviço com cookies (CORS_ORIGINS)
+	corsOrigins = []string{"http://localhost:3000"}
 )
 
 func generateSalt() (string, error) {
@@ -78,8 +110,10 @@ func verifyPassword(password, salt, hashedPassword string) bool {
 func generateJWT(user User) (string, error) {
 	expirationTime := time.Now().Add(24 * time.Hour)
 	claims := &Claims{
-		UserID: user.ID,
-		Email:  user.Email,
+		UserID:   user.ID,
+		Email:    user.Email,
+		TenantID: user.TenantID,
+		Role:     user.Role,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -90,6 +124,13 @@ func generateJWT(user User) (string, error) {
 	return token.SignedString(jwtSecret)
 }
 
+// purposeKey deriva do segredo JWT uma chave exclusiva para um tipo de token,
+// de modo que links de login e tokens de MFA nunca sejam aceitos como sessão
+func purposeKey(purpose string) []byte {
+	key := sha256.Sum256(append([]byte(purpose+":"), jwtSecret...))
+	return key[:]
+}
+
 func validateToken(tokenString string) (*Claims, error) {
 	claims := &Claims{}
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
@@ -111,24 +152,15 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 	var req RegisterRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Printf("REGISTER 400 invalid body from %s: %v", r.RemoteAddr, err)
-		http.Error(w, "Dados inválidos", http.StatusBadRequest)
+		writeErrorResponse(w, r, http.StatusBadRequest, "BAD_REQUEST", "invalid_body")
 		return
 	}
 
-	// Verificar se email já existe
-	for _, user := range users {
-		if user.Email == req.Email {
-			log.Printf("REGISTER 409 email exists: %s", req.Email)
-			http.Error(w, "Email já cadastrado", http.StatusConflict)
-			return
-		}
-	}
-
 	// Gerar salt único
 	salt, err := generateSalt()
 	if err != nil {
 		log.Printf("REGISTER 500 generateSalt error for %s: %v", req.Email, err)
-		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		writeErrorResponse(w, r, http.StatusInternalServerError, "INTERNAL_ERROR", "internal_error")
 		return
 	}
 
@@ -136,27 +168,78 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 	hashedPassword, err := hashPassword(req.Password, salt)
 	if err != nil {
 		log.Printf("REGISTER 500 hashPassword error for %s: %v", req.Email, err)
-		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		writeErrorResponse(w, r, http.StatusInternalServerError, "INTERNAL_ERROR", "internal_error")
+		return
+	}
+
+	// As verificações e a inserção formam uma única seção crítica, para que cadastros
+	// simultâneos não dupliquem emails ou IDs nem excedam o limite de usos de um convite
+	storeMu.Lock()
+
+	// Resolver instituição (tenant) e aplicar sua configuração
+	inst := findInstitutionBySlug(req.Tenant)
+	if inst == nil {
+		storeMu.Unlock()
+		log.Printf("REGISTER 400 unknown tenant %q from %s", req.Tenant, r.RemoteAddr)
+		writeErrorResponse(w, r, http.StatusBadRequest, "TENANT_NOT_FOUND", "institution_not_found")
+		return
+	}
+
+	invite, status, errorCode, messageKey := checkRegistrationAllowed(*inst, req)
+	if errorCode != "" {
+		storeMu.Unlock()
+		log.Printf("REGISTER %d %s for tenant %s: %s", status, errorCode, inst.Slug, req.Email)
+		writeErrorResponse(w, r, status, errorCode, messageKey)
 		return
 	}
 
-	// Criar usuário
+	if fieldErr := inst.PasswordPolicy.validate(req.Password); fieldErr != nil {
+		storeMu.Unlock()
+		log.Printf("REGISTER 400 password policy %s for tenant %s", fieldErr.key, inst.Slug)
+		writeValidationError(w, r, []FieldError{*fieldErr})
+		return
+	}
+
+	// Verificar se email já existe na instituição
+	if findUserByEmail(inst.ID, req.Email) != nil {
+		storeMu.Unlock()
+		log.Printf("REGISTER 409 email exists: %s", req.Email)
+		writeErrorResponse(w, r, http.StatusConflict, "EMAIL_EXISTS", "email_exists")
+		return
+	}
+
+	// Criar usuário; administradores só são criados na inicialização (seedAdminsFromEnv)
 	user := User{
 		ID:        nextUserID,
-		Email:     req.Email,
+		TenantID:  inst.ID,
+		Email:     strings.TrimSpace(req.Email),
+		Role:      roleStudent,
 		Password:  hashedPassword,
 		Salt:      salt,
 		CreatedAt: time.Now(),
 	}
 
-	users = append(users, user)
+	// Criptografar PII antes de armazenar
+	stored := user
+	if err := sealUserPII(&stored); err != nil {
+		storeMu.Unlock()
+		log.Printf("REGISTER 500 sealUserPII error for %s: %v", req.Email, err)
+		writeErrorResponse(w, r, http.StatusInternalServerError, "INTERNAL_ERROR", "internal_error")
+		return
+	}
+
+	users = append(users, stored)
 	nextUserID++
+	if invite != nil {
+		invite.Uses++
+	}
+	storeMu.Unlock()
 
 	// Gerar JWT
 	token, err := generateJWT(user)
 	if err != nil {
 		log.Printf("REGISTER 500 generateJWT error for %s: %v", req.Email, err)
-		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		writeErrorResponse(w, r, http.StatusInternalServerError, "INTERNAL_ERROR", "internal_error")
 		return
 	}
 
@@ -167,50 +250,72 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
-	log.Printf("REGISTER 200 user_id=%d email=%s", user.ID, user.Email)
+	log.Printf("REGISTER 200 user_id=%d tenant_id=%d email=%s", user.ID, user.TenantID, user.Email)
 }
 
 func loginHandler(w http.ResponseWriter, r *http.Request) {
 	var req LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Printf("LOGIN 400 invalid body from %s: %v", r.RemoteAddr, err)
-		http.Error(w, "Dados inválidos", http.StatusBadRequest)
+		writeErrorResponse(w, r, http.StatusBadRequest, "BAD_REQUEST", "invalid_body")
 		return
 	}
 
-	// Buscar usuário
-	var user *User
-	for i := range users {
-		if users[i].Email == req.Email {
-			user = &users[i]
-			break
-		}
+	inst, ok := lookupInstitutionBySlug(req.Tenant)
+	if !ok {
+		log.Printf("LOGIN 401 unknown tenant %q for %s", req.Tenant, req.Email)
+		writeErrorResponse(w, r, http.StatusUnauthorized, "INVALID_CREDENTIALS", "invalid_credentials")
+		return
 	}
 
-	if user == nil {
+	// Buscar usuário pelo índice cego do email
+	stored, ok := lookupUserByEmail(inst.ID, req.Email)
+	if !ok {
 		log.Printf("LOGIN 401 unknown email: %s", req.Email)
-		http.Error(w, "Credenciais inválidas", http.StatusUnauthorized)
+		writeErrorResponse(w, r, http.StatusUnauthorized, "INVALID_CREDENTIALS", "invalid_credentials")
 		return
 	}
 
 	// Verificar senha
-	if !verifyPassword(req.Password, user.Salt, user.Password) {
+	if !verifyPassword(req.Password, stored.Salt, stored.Password) {
 		log.Printf("LOGIN 401 wrong password for %s", req.Email)
-		http.Error(w, "Credenciais inválidas", http.StatusUnauthorized)
+		writeErrorResponse(w, r, http.StatusUnauthorized, "INVALID_CREDENTIALS", "invalid_credentials")
+		return
+	}
+
+	user, err := openUserPII(stored)
+	if err != nil {
+		log.Printf("LOGIN 500 openUserPII error for user_id=%d: %v", stored.ID, err)
+		writeErrorResponse(w, r, http.StatusInternalServerError, "INTERNAL_ERROR", "internal_error")
+		return
+	}
+
+	// Passkey exigida como segundo fator: o token só é emitido após /webauthn/login/finish
+	if user.WebAuthnMFA {
+		mfaToken, err := generateMFAToken(user)
+		if err != nil {
+			log.Printf("LOGIN 500 generateMFAToken error for %s: %v", req.Email, err)
+			writeErrorResponse(w, r, http.StatusInternalServerError, "INTERNAL_ERROR", "internal_error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(MFARequiredResponse{MFARequired: true, MFAToken: mfaToken})
+		log.Printf("LOGIN 200 mfa required user_id=%d email=%s", user.ID, user.Email)
 		return
 	}
 
 	// Gerar JWT
-	token, err := generateJWT(*user)
+	token, err := generateJWT(user)
 	if err != nil {
 		log.Printf("LOGIN 500 generateJWT error for %s: %v", req.Email, err)
-		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		writeErrorResponse(w, r, http.StatusInternalServerError, "INTERNAL_ERROR", "internal_error")
 		return
 	}
 
 	response := AuthResponse{
 		Token: token,
-		User:  *user,
+		User:  user,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -222,7 +327,7 @@ func validateTokenHandler(w http.ResponseWriter, r *http.Request) {
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
 		log.Printf("VALIDATE 401 missing token from %s", r.RemoteAddr)
-		http.Error(w, "Token não fornecido", http.StatusUnauthorized)
+		writeErrorResponse(w, r, http.StatusUnauthorized, "UNAUTHORIZED", "token_missing")
 		return
 	}
 
@@ -230,18 +335,32 @@ func validateTokenHandler(w http.ResponseWriter, r *http.Request) {
 	claims, err := validateToken(tokenString)
 	if err != nil {
 		log.Printf("VALIDATE 401 invalid token from %s: %v", r.RemoteAddr, err)
-		http.Error(w, "Token inválido", http.StatusUnauthorized)
+		writeErrorResponse(w, r, http.StatusUnauthorized, "UNAUTHORIZED", "token_invalid")
 		return
 	}
 
+	// Tokens emitidos antes dos tenants pertencem à instituição padrão
+	if claims.TenantID == 0 {
+		claims.TenantID = defaultInstitutionID
+	}
+
 	response := map[string]interface{}{
-		"valid":   true,
-		"user_id": claims.UserID,
-		"email":   claims.Email,
+		"valid":     true,
+		"user_id":   claims.UserID,
+		"email":     claims.Email,
+		"tenant_id": claims.TenantID,
+		"role":      claims.Role,
+	}
+	if claims.Act != nil {
+		response["act"] = claims.Act
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
+	if claims.Act != nil {
+		log.Printf("VALIDATE 200 user_id=%d email=%s impersonated by admin user_id=%d", claims.UserID, claims.Email, claims.Act.UserID)
+		return
+	}
 	log.Printf("VALIDATE 200 user_id=%d email=%s", claims.UserID, claims.Email)
 }
 
@@ -257,7 +376,7 @@ func requestLogMiddleware(next http.Handler) http.Handler {
 		lrw := &loggingResponseWriter{ResponseWriter: w, status: 200}
 		next.ServeHTTP(lrw, r)
 		duration := time.Since(start)
-		log.Printf("HTTP %s %s -> %d (%dB) in %s from %s", r.Method, r.URL.Path, lrw.status, lrw.bytes, duration, r.RemoteAddr)
+		log.Printf("HTTP %s %s -> %d (%dB) in %s from %s req=%s", r.Method, r.URL.Path, lrw.status, lrw.bytes, duration, r.RemoteAddr, requestID(r))
 	})
 }
 
@@ -284,21 +403,90 @@ func main() {
 		port = "8080"
 	}
 
+	keyring, err := newPIIKeyringFromEnv()
+	if err != nil {
+		log.Fatalf("Configuração de criptografia de PII inválida: %v", err)
+	}
+	piiKeys = keyring
+	if err := seedAdminsFromEnv(); err != nil {
+		log.Fatalf("Erro ao criar os administradores de ADMIN_EMAILS: %v", err)
+	}
+
+	if mode := os.Getenv("REGISTRATION_MODE"); mode != "" {
+		if !validRegistrationMode(mode) {
+			log.Fatalf("REGISTRATION_MODE inválido: %s (use open, domain ou invite)", mode)
+		}
+		findInstitutionByID(defaultInstitutionID).RegistrationMode = mode
+	}
+	for _, domain := range strings.Split(os.Getenv("ALLOWED_EMAIL_DOMAINS"), ",") {
+		if domain = normalizeEmail(domain); domain != "" {
+			inst := findInstitutionByID(defaultInstitutionID)
+			inst.AllowedEmailDomains = append(inst.AllowedEmailDomains, domain)
+		}
+	}
+
+	if rpID := os.Getenv("WEBAUTHN_RP_ID"); rpID != "" {
+		webauthnRPID = rpID
+	}
+	if rpName := os.Getenv("WEBAUTHN_RP_NAME"); rpName != "" {
+		webauthnRPName = rpName
+	}
+	if origins := os.Getenv("WEBAUTHN_ORIGINS"); origins != "" {
+		webauthnOrigins = strings.Split(origins, ",")
+	}
+
+	if origins := os.Getenv("CORS_ORIGINS"); origins != "" {
+		corsOrigins = strings.Split(origins, ",")
+	}
+
+	if baseURL := os.Getenv("MAGIC_LINK_BASE_URL"); baseURL != "" {
+		magicLinkBaseURL = strings.TrimSuffix(baseURL, "/")
+	}
+	mailer = smtpmail.NewFromEnv()
+
 	r := mux.NewRouter()
-	// Middleware de logging básico
+	// Middleware de ID de requisição e logging básico
+	r.Use(requestIDMiddleware)
 	r.Use(requestLogMiddleware)
 
 	// Rotas públicas
 	r.HandleFunc("/health", healthHandler).Methods("GET")
 	r.HandleFunc("/register", registerHandler).Methods("POST")
 	r.HandleFunc("/login", loginHandler).Methods("POST")
+	r.HandleFunc("/login/magic-link", magicLinkRequestHandler).Methods("POST")
+	r.HandleFunc("/login/magic-link/callback", magicLinkCallbackHandler).Methods("GET")
 	r.HandleFunc("/validate", validateTokenHandler).Methods("GET")
 
-	// CORS
+	// Instituições (tenants)
+	r.HandleFunc("/institutions", listInstitutionsHandler).Methods("GET")
+	r.HandleFunc("/institutions", createInstitutionHandler).Methods("POST")
+	r.HandleFunc("/institutions/{id:[0-9]+}", updateInstitutionHandler).Methods("PUT")
+	r.HandleFunc("/institutions/{slug}", getInstitutionHandler).Methods("GET")
+
+	// Suporte: personificação auditada
+	r.HandleFunc("/admin/impersonate", impersonateHandler).Methods("POST")
+
+	// Convites de cadastro
+	r.HandleFunc("/invites", listInvitesHandler).Methods("GET")
+	r.HandleFunc("/invites", createInviteHandler).Methods("POST")
+	r.HandleFunc("/invites/{id:[0-9]+}", revokeInviteHandler).Methods("DELETE")
+
+	// WebAuthn (passkeys)
+	r.HandleFunc("/webauthn/register/begin", webauthnRegisterBeginHandler).Methods("POST")
+	r.HandleFunc("/webauthn/register/finish", webauthnRegisterFinishHandler).Methods("POST")
+	r.HandleFunc("/webauthn/login/begin", webauthnLoginBeginHandler).Methods("POST")
+	r.HandleFunc("/webauthn/login/finish", webauthnLoginFinishHandler).Methods("POST")
+	r.HandleFunc("/webauthn/credentials", listWebAuthnCredentialsHandler).Methods("GET")
+	r.HandleFunc("/webauthn/credentials/{id}", deleteWebAuthnCredentialHandler).Methods("DELETE")
+	r.HandleFunc("/webauthn/mfa", webauthnMFAHandler).Methods("PUT")
+
+	// CORS: com credenciais (o cookie do link de acesso), só para as origens do frontend
 	corsHandler := handlers.CORS(
-		handlers.AllowedOrigins([]string{"*"}),
+		handlers.AllowedOrigins(corsOrigins),
+		handlers.AllowCredentials(),
 		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
-		handlers.AllowedHeaders([]string{"Content-Type", "Authorization"}),
+		handlers.AllowedHeaders([]string{"Content-Type", "Authorization", "Accept-Language", "X-Request-ID"}),
+		handlers.ExposedHeaders([]string{"X-Request-ID", "Content-Language"}),
 	)(r)
 
 	fmt.Printf("Auth Service rodando na porta %s\n", port)
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// Sem PII_KEYS, as chaves de desenvolvimento são derivadas do segredo JWT
+	keyring, err := newPIIKeyringFromEnv()
+	if err != nil {
+		log.Fatalf("keyring de teste: %v", err)
+	}
+	piiKeys = keyring
+	// Os testes fazem muitas cerimônias a partir do mesmo endereço
+	webauthnIPLimiter = newRateLimiter(1<<20, time.Minute)
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// doRequest chama o handler diretamente, com o corpo em JSON e o token de sessão, se houver
+func doRequest(t *testing.T, handler http.HandlerFunc, method, target string, body interface{}, token string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("json.Marshal: %v", err)
+		}
+		reader = bytes.NewReader(raw)
+	}
+	req := httptest.NewRequest(method, target, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+// decodeBody decodifica a resposta JSON em v
+func decodeBody(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("resposta não é JSON (%d): %v: %s", rec.Code, err, rec.Body.String())
+	}
+}
+
+// errorCode retorna o campo "error" de uma resposta de erro
+func errorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var resp ErrorResponse
+	decodeBody(t, rec, &resp)
+	return resp.Error
+}
+
+// registerTestUser cadastra um usuário na instituição padrão e retorna a resposta do /register
+func registerTestUser(t *testing.T, email string) AuthResponse {
+	t.Helper()
+
+	rec := doRequest(t, registerHandler, http.MethodPost, "/register", RegisterRequest{Email: email, Password: "senha-de-teste"}, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("register %s: status %d: %s", email, rec.Code, rec.Body.String())
+	}
+	var resp AuthResponse
+	decodeBody(t, rec, &resp)
+	return resp
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// piiKeyring guarda as chaves AES-256-GCM usadas para criptografar dados pessoais em repouso.
+// Cada valor cifrado carrega o ID da chave ("v1:<base64>"), permitindo rotação: novos valores
+// usam a chave ativa e valores antigos continuam legíveis enquanto a chave estiver no keyring.
+type piiKeyring struct {
+	activeID string
+	keys     map[string][]byte
+	indexKey []byte
+}
+
+var piiKeys *piiKeyring
+
+// newPIIKeyringFromEnv lê PII_KEYS ("id:base64,id:base64"), PII_ACTIVE_KEY_ID e PII_INDEX_KEY (base64).
+// Sem PII_KEYS, deriva chaves de desenvolvimento a partir do segredo JWT.
+func newPIIKeyringFromEnv() (*piiKeyring, error) {
+	spec := os.Getenv("PII_KEYS")
+	if spec == "" {
+		log.Printf("PII_KEYS não configurado: usando chaves de desenvolvimento derivadas do segredo JWT")
+		return &piiKeyring{
+			activeID: "dev",
+			keys:     map[string][]byte{"dev": purposeKey("pii-encryption")},
+			indexKey: purposeKey("pii-index"),
+		}, nil
+	}
+
+	kr := &piiKeyring{keys: make(map[string][]byte)}
+	for _, entry := range strings.Split(spec, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("PII_KEYS: entrada inválida %q", entry)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("PII_KEYS: a chave %s deve ter 32 bytes em base64", id)
+		}
+		kr.keys[id] = key
+		if kr.activeID == "" {
+			kr.activeID = id
+		}
+	}
+
+	if active := os.Getenv("PII_ACTIVE_KEY_ID"); active != "" {
+		if _, ok := kr.keys[active]; !ok {
+			return nil, fmt.Errorf("PII_ACTIVE_KEY_ID: chave %s não está em PII_KEYS", active)
+		}
+		kr.activeID = active
+	}
+
+	indexKey, err := base64.StdEncoding.DecodeString(os.Getenv("PII_INDEX_KEY"))
+	if err != nil || len(indexKey) < 32 {
+		return nil, fmt.Errorf("PII_INDEX_KEY deve ter pelo menos 32 bytes em base64")
+	}
+	kr.indexKey = indexKey
+
+	return kr, nil
+}
+
+// encrypt cifra o valor com a chave ativa. O aad (ex.: "user:1:email") amarra o
+// texto cifrado ao registro e campo, impedindo que seja copiado para outro usuário.
+func (kr *piiKeyring) encrypt(plaintext, aad string) (string, error) {
+	gcm, err := newGCM(kr.keys[kr.activeID])
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), []byte(aad))
+	return kr.activeID + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func (kr *piiKeyring) decrypt(value, aad string) (string, error) {
+	id, encoded, ok := strings.Cut(value, ":")
+	if !ok {
+		return "", fmt.Errorf("valor cifrado sem ID de chave")
+	}
+
+	key, ok := kr.keys[id]
+	if !ok {
+		return "", fmt.Errorf("chave %s não encontrada no keyring", id)
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("valor cifrado truncado")
+	}
+
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// blindIndex calcula um HMAC determinístico do valor normalizado, permitindo buscas
+// por igualdade sem decifrar os registros
+func (kr *piiKeyring) blindIndex(field, value string) string {
+	mac := hmac.New(sha256.New, kr.indexKey)
+	mac.Write([]byte(field + ":" + value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func emailIndex(email string) string {
+	return piiKeys.blindIndex("email", normalizeEmail(email))
+}
+
+func emailAAD(userID int) string {
+	return fmt.Sprintf("user:%d:email", userID)
+}
+
+// sealUserPII cifra os dados pessoais do usuário antes de armazená-lo; o campo em texto claro é apagado
+func sealUserPII(user *User) error {
+	encrypted, err := piiKeys.encrypt(strings.TrimSpace(user.Email), emailAAD(user.ID))
+	if err != nil {
+		return err
+	}
+	user.EmailEncrypted = encrypted
+	user.EmailIndex = emailIndex(user.Email)
+	user.Email = ""
+	return nil
+}
+
+// openUserPII retorna uma cópia do usuário armazenado com os dados pessoais decifrados
+func openUserPII(stored User) (User, error) {
+	email, err := piiKeys.decrypt(stored.EmailEncrypted, emailAAD(stored.ID))
+	if err != nil {
+		return User{}, err
+	}
+	stored.Email = email
+	return stored, nil
+}
+
+// findUserByEmail busca o usuário armazenado pelo índice cego do email; emails são únicos por instituição.
+// Exige storeMu.
+func findUserByEmail(tenantID int, email string) *User {
+	index := emailIndex(email)
+	for i := range users {
+		if users[i].TenantID == tenantID && hmac.Equal([]byte(users[i].EmailIndex), []byte(index)) {
+			return &users[i]
+		}
+	}
+	return nil
+}
+
+// findUserByID exige storeMu
+func findUserByID(id int) *User {
+	for i := range users {
+		if users[i].ID == id {
+			return &users[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// testKey retorna uma chave de 32 bytes em base64 preenchida com o byte informado
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(rune(b)), 32)))
+}
+
+// keyringFromEnv monta o keyring como na inicialização, a partir de PII_KEYS e PII_ACTIVE_KEY_ID
+func keyringFromEnv(t *testing.T, keys, active string) *piiKeyring {
+	t.Helper()
+
+	t.Setenv("PII_KEYS", keys)
+	t.Setenv("PII_ACTIVE_KEY_ID", active)
+	t.Setenv("PII_INDEX_KEY", testKey('i'))
+	kr, err := newPIIKeyringFromEnv()
+	if err != nil {
+		t.Fatalf("newPIIKeyringFromEnv: %v", err)
+	}
+	return kr
+}
+
+func TestPIIEncryptRoundTrip(t *testing.T) {
+	kr := keyringFromEnv(t, "k1:"+testKey('a'), "")
+	const email = "aluna@exemplo.com"
+
+	sealed, err := kr.encrypt(email, emailAAD(7))
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	if !strings.HasPrefix(sealed, "k1:") || strings.Contains(sealed, "aluna") {
+		t.Fatalf("valor cifrado = %q; esperado prefixo k1: sem o texto claro", sealed)
+	}
+	if again, _ := kr.encrypt(email, emailAAD(7)); again == sealed {
+		t.Fatal("dois valores cifrados iguais: o nonce não está sendo sorteado")
+	}
+
+	opened, err := kr.decrypt(sealed, emailAAD(7))
+	if err != nil || opened != email {
+		t.Fatalf("decrypt = %q, %v; esperado %q", opened, err, email)
+	}
+
+	// O aad amarra o valor ao usuário: copiado para outro registro, ele não abre
+	if _, err := kr.decrypt(sealed, emailAAD(8)); err == nil {
+		t.Fatal("valor aberto com o aad de outro usuário")
+	}
+
+	raw, _ := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(sealed, "k1:"))
+	raw[len(raw)-1] ^= 1
+	if _, err := kr.decrypt("k1:"+base64.RawStdEncoding.EncodeToString(raw), emailAAD(7)); err == nil {
+		t.Fatal("valor adulterado foi aberto")
+	}
+}
+
+func TestPIIKeyRotation(t *testing.T) {
+	old := keyringFromEnv(t, "k1:"+testKey('a'), "")
+	sealed, err := old.encrypt("rotacao@exemplo.com", emailAAD(1))
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+
+	// Com k2 ativa, os valores novos usam k2 e os antigos continuam legíveis por k1
+	rotated := keyringFromEnv(t, "k1:"+testKey('a')+", k2:"+testKey('b'), "k2")
+	if opened, err := rotated.decrypt(sealed, emailAAD(1)); err != nil || opened != "rotacao@exemplo.com" {
+		t.Fatalf("decrypt com a chave antiga = %q, %v", opened, err)
+	}
+	fresh, err := rotated.encrypt("rotacao@exemplo.com", emailAAD(1))
+	if err != nil || !strings.HasPrefix(fresh, "k2:") {
+		t.Fatalf("encrypt após a rotação = %q, %v; esperado prefixo k2:", fresh, err)
+	}
+	if rotated.blindIndex("email", "rotacao@exemplo.com") != old.blindIndex("email", "rotacao@exemplo.com") {
+		t.Fatal("o índice cego mudou com a rotação")
+	}
+
+	// Removida do keyring, a chave antiga não abre mais os valores dela
+	retired := keyringFromEnv(t, "k2:"+testKey('b'), "")
+	if _, err := retired.decrypt(sealed, emailAAD(1)); err == nil {
+		t.Fatal("valor de k1 aberto sem k1 no keyring")
+	}
+}
+
+func TestPIIKeyringConfigErrors(t *testing.T) {
+	tests := []struct {
+		name, keys, active, index string
+	}{
+		{"entrada sem id", testKey('a'), "", testKey('i')},
+		{"chave curta", "k1:" + base64.StdEncoding.EncodeToString([]byte("curta")), "", testKey('i')},
+		{"chave ativa ausente", "k1:" + testKey('a'), "k9", testKey('i')},
+		{"sem chave de índice", "k1:" + testKey('a'), "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PII_KEYS", tt.keys)
+			t.Setenv("PII_ACTIVE_KEY_ID", tt.active)
+			t.Setenv("PII_INDEX_KEY", tt.index)
+			if _, err := newPIIKeyringFromEnv(); err == nil {
+				t.Fatal("configuração inválida aceita")
+			}
+		})
+	}
+}
+
+func TestStoredEmailIsEncrypted(t *testing.T) {
+	const email = "cifrado@exemplo.com"
+	registered := registerTestUser(t, email)
+
+	storeMu.RLock()
+	stored := *findUserByID(registered.User.ID)
+	storeMu.RUnlock()
+	if stored.Email != "" || stored.EmailEncrypted == "" || strings.Contains(stored.EmailEncrypted, "cifrado") {
+		t.Fatalf("usuário armazenado com email=%q encrypted=%q; esperado só o valor cifrado", stored.Email, stored.EmailEncrypted)
+	}
+	if stored.EmailIndex != emailIndex(email) {
+		t.Fatal("índice cego do email não foi gravado")
+	}
+
+	// Login e verificação de duplicidade encontram o usuário pelo índice, com o email normalizado
+	rec := doRequest(t, loginHandler, http.MethodPost, "/login", LoginRequest{Email: " Cifrado@Exemplo.com ", Password: "senha-de-teste"}, "")
+	var resp AuthResponse
+	decodeBody(t, rec, &resp)
+	if rec.Code != http.StatusOK || resp.User.ID != registered.User.ID || resp.User.Email != email {
+		t.Fatalf("login pelo email: status %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(t, registerHandler, http.MethodPost, "/register", RegisterRequest{Email: "CIFRADO@exemplo.com", Password: "outra-senha"}, "")
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("cadastro duplicado: status %d; esperado 409", rec.Code)
+	}
+
+	// Após a rotação, o registro cifrado com a chave anterior continua abrindo no login
+	previous := piiKeys
+	t.Cleanup(func() { piiKeys = previous })
+	piiKeys = &piiKeyring{
+		activeID: "nova",
+		keys:     map[string][]byte{"dev": previous.keys["dev"], "nova": []byte(strings.Repeat("n", 32))},
+		indexKey: previous.indexKey,
+	}
+	rec = doRequest(t, loginHandler, http.MethodPost, "/login", LoginRequest{Email: email, Password: "senha-de-teste"}, "")
+	decodeBody(t, rec, &resp)
+	if rec.Code != http.StatusOK || resp.User.Email != email {
+		t.Fatalf("login após a rotação: status %d: %s", rec.Code, rec.Body.String())
+	}
+}
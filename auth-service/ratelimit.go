@@ -0,0 +1,62 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter limita o número de eventos por chave dentro de uma janela deslizante
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	events map[string][]time.Time
+	// lastPrune é quando as chaves sem eventos na janela foram removidas pela última vez
+	lastPrune time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:  limit,
+		window: window,
+		events: make(map[string][]time.Time),
+	}
+}
+
+// Allow registra um evento para a chave e informa se ele está dentro do limite
+func (rl *rateLimiter) Allow(key string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-rl.window)
+	if now.Sub(rl.lastPrune) >= rl.window {
+		rl.prune(cutoff)
+		rl.lastPrune = now
+	}
+
+	recent := rl.events[key][:0]
+	for _, t := range rl.events[key] {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+
+	if len(recent) >= rl.limit {
+		rl.events[key] = recent
+		return false
+	}
+
+	rl.events[key] = append(recent, now)
+	return true
+}
+
+// prune remove as chaves cujos eventos já saíram da janela, para que o mapa não cresça com
+// cada IP ou email já visto. Exige rl.mu.
+func (rl *rateLimiter) prune(cutoff time.Time) {
+	for key, events := range rl.events {
+		if len(events) == 0 || !events[len(events)-1].After(cutoff) {
+			delete(rl.events, key)
+		}
+	}
+}
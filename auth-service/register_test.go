@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentRegister(t *testing.T) {
+	otherTenant := addTestInstitution(t, "faculdade-paralela")
+	tenants := []string{"", "faculdade-paralela"}
+	const perTenant = 20
+	const contested = "disputado@exemplo.com"
+
+	// Emails distintos em paralelo, e o mesmo email disputado em cada instituição
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	ids := map[int]bool{}
+	contestedWins := map[int]int{}
+	register := func(email, tenant string) {
+		defer wg.Done()
+		rec := doRequest(t, registerHandler, http.MethodPost, "/register", RegisterRequest{Email: email, Password: "senha-de-teste", Tenant: tenant}, "")
+		if rec.Code == http.StatusConflict && email == contested {
+			return
+		}
+		if rec.Code != http.StatusOK {
+			t.Errorf("register %s em %q: status %d: %s", email, tenant, rec.Code, rec.Body.String())
+			return
+		}
+		var resp AuthResponse
+		decodeBody(t, rec, &resp)
+		mu.Lock()
+		defer mu.Unlock()
+		if ids[resp.User.ID] {
+			t.Errorf("ID de usuário %d repetido", resp.User.ID)
+		}
+		ids[resp.User.ID] = true
+		if email == contested {
+			contestedWins[resp.User.TenantID]++
+		}
+	}
+	for _, tenant := range tenants {
+		for i := 0; i < perTenant; i++ {
+			wg.Add(2)
+			go register(fmt.Sprintf("paralelo-%d@exemplo.com", i), tenant)
+			go register(contested, tenant)
+		}
+	}
+	wg.Wait()
+
+	if contestedWins[defaultInstitutionID] != 1 || contestedWins[otherTenant] != 1 {
+		t.Errorf("email disputado cadastrado %v vezes por instituição; esperado 1 em cada", contestedWins)
+	}
+	if want := len(tenants)*perTenant + len(tenants); len(ids) != want {
+		t.Errorf("%d cadastros bem-sucedidos; esperado %d", len(ids), want)
+	}
+
+	// O store precisa ter exatamente um usuário por email em cada instituição
+	storeMu.RLock()
+	defer storeMu.RUnlock()
+	seen := map[int]bool{}
+	perTenantCount := map[int]int{}
+	for _, user := range users {
+		if seen[user.ID] {
+			t.Errorf("usuário %d duplicado no store", user.ID)
+		}
+		seen[user.ID] = true
+		if user.ID >= nextUserID {
+			t.Errorf("usuário %d não está abaixo de nextUserID=%d", user.ID, nextUserID)
+		}
+		if ids[user.ID] {
+			perTenantCount[user.TenantID]++
+		}
+	}
+	for _, tenantID := range []int{defaultInstitutionID, otherTenant} {
+		if perTenantCount[tenantID] != perTenant+1 {
+			t.Errorf("instituição %d tem %d usuários do teste; esperado %d", tenantID, perTenantCount[tenantID], perTenant+1)
+		}
+		if findUserByEmail(tenantID, contested) == nil {
+			t.Errorf("email disputado ausente na instituição %d", tenantID)
+		}
+	}
+}
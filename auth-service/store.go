@@ -0,0 +1,83 @@
+package main
+
+import "sync"
+
+// storeMu protege os dados em memória compartilhados pelas goroutines do net/http:
+// users, nextUserID, institutions, nextInstitutionID, inviteCodes e nextInviteID.
+//
+// As funções find* retornam ponteiros para dentro desses slices e só podem ser usadas
+// com o lock adquirido (e o ponteiro não pode ser guardado depois de liberá-lo). Fora
+// de uma seção crítica, use as funções lookup*, que retornam cópias.
+var storeMu sync.RWMutex
+
+// cloneUser copia o usuário sem compartilhar o slice de credenciais com o armazenado
+func cloneUser(user User) User {
+	user.Credentials = append([]WebAuthnCredential(nil), user.Credentials...)
+	return user
+}
+
+func cloneInstitution(inst Institution) Institution {
+	inst.AllowedEmailDomains = append([]string(nil), inst.AllowedEmailDomains...)
+	return inst
+}
+
+func lookupUserByID(id int) (User, bool) {
+	storeMu.RLock()
+	defer storeMu.RUnlock()
+
+	user := findUserByID(id)
+	if user == nil {
+		return User{}, false
+	}
+	return cloneUser(*user), true
+}
+
+func lookupUserByEmail(tenantID int, email string) (User, bool) {
+	storeMu.RLock()
+	defer storeMu.RUnlock()
+
+	user := findUserByEmail(tenantID, email)
+	if user == nil {
+		return User{}, false
+	}
+	return cloneUser(*user), true
+}
+
+func lookupInstitutionBySlug(slug string) (Institution, bool) {
+	storeMu.RLock()
+	defer storeMu.RUnlock()
+
+	inst := findInstitutionBySlug(slug)
+	if inst == nil {
+		return Institution{}, false
+	}
+	return cloneInstitution(*inst), true
+}
+
+// lookupUserByTenantEmail resolve a instituição pelo slug e o usuário pelo email numa única leitura
+func lookupUserByTenantEmail(slug, email string) (User, bool) {
+	storeMu.RLock()
+	defer storeMu.RUnlock()
+
+	inst := findInstitutionBySlug(slug)
+	if inst == nil {
+		return User{}, false
+	}
+	user := findUserByEmail(inst.ID, email)
+	if user == nil {
+		return User{}, false
+	}
+	return cloneUser(*user), true
+}
+
+// lookupCredential retorna cópias do usuário dono da credencial e da própria credencial
+func lookupCredential(credentialID []byte) (User, WebAuthnCredential, bool) {
+	storeMu.RLock()
+	defer storeMu.RUnlock()
+
+	user, cred := findCredential(credentialID)
+	if user == nil {
+		return User{}, WebAuthnCredential{}, false
+	}
+	return cloneUser(*user), *cred, true
+}
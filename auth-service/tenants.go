@@ -0,0 +1,377 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultInstitutionID = 1
+
+	roleAdmin   = "admin"
+	roleStudent = "student"
+)
+
+// PasswordPolicy define os requisitos de senha de uma instituição; o valor zero não impõe restrições
+type PasswordPolicy struct {
+	MinLength     int  `json:"min_length"`
+	RequireUpper  bool `json:"require_upper"`
+	RequireDigit  bool `json:"require_digit"`
+	RequireSymbol bool `json:"require_symbol"`
+}
+
+// Institution é um tenant: usuários, matérias e provas de instituições diferentes ficam isolados
+type Institution struct {
+	ID                  int            `json:"id"`
+	Slug                string         `json:"slug"`
+	Nome                string         `json:"nome"`
+	RegistrationMode    string         `json:"registration_mode"`
+	AllowedEmailDomains []string       `json:"allowed_email_domains"`
+	PasswordPolicy      PasswordPolicy `json:"password_policy"`
+	CreatedAt           time.Time      `json:"created_at"`
+	UpdatedAt           time.Time      `json:"updated_at"`
+}
+
+type InstitutionRequest struct {
+	Slug                string         `json:"slug"`
+	Nome                string         `json:"nome"`
+	RegistrationMode    string         `json:"registration_mode"`
+	AllowedEmailDomains []string       `json:"allowed_email_domains"`
+	PasswordPolicy      PasswordPolicy `json:"password_policy"`
+}
+
+var (
+	institutions = []Institution{{
+		ID:                  defaultInstitutionID,
+		Slug:                "default",
+		Nome:                "Instituição padrão",
+		AllowedEmailDomains: []string{},
+		CreatedAt:           time.Now(),
+		UpdatedAt:           time.Now(),
+	}}
+	nextInstitutionID = defaultInstitutionID + 1
+
+	// adminAccounts são os administradores criados na inicialização (ADMIN_EMAILS): ID do
+	// usuário -> instituição. O papel vem só daqui, nunca do email informado no cadastro,
+	// já que o mesmo email pode ser cadastrado por qualquer um em outra instituição.
+	adminAccounts = map[int]int{}
+
+	slugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,62}$`)
+)
+
+// seedAdminsFromEnv cria na instituição padrão as contas de administrador de ADMIN_EMAILS,
+// antes de o serviço aceitar cadastros. A senha vem de ADMIN_PASSWORD; sem ela, a conta
+// recebe uma senha aleatória e só entra pelo link de acesso enviado ao próprio email.
+func seedAdminsFromEnv() error {
+	password := os.Getenv("ADMIN_PASSWORD")
+
+	storeMu.Lock()
+	defer storeMu.Unlock()
+
+	for _, email := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		email = normalizeEmail(email)
+		if email == "" || findUserByEmail(defaultInstitutionID, email) != nil {
+			continue
+		}
+
+		secret := password
+		if secret == "" {
+			random, err := generateSalt()
+			if err != nil {
+				return err
+			}
+			secret = random
+		}
+		salt, err := generateSalt()
+		if err != nil {
+			return err
+		}
+		hashedPassword, err := hashPassword(secret, salt)
+		if err != nil {
+			return err
+		}
+
+		admin := User{
+			ID:        nextUserID,
+			TenantID:  defaultInstitutionID,
+			Email:     email,
+			Role:      roleAdmin,
+			Password:  hashedPassword,
+			Salt:      salt,
+			CreatedAt: time.Now(),
+		}
+		if err := sealUserPII(&admin); err != nil {
+			return err
+		}
+		users = append(users, admin)
+		adminAccounts[admin.ID] = admin.TenantID
+		nextUserID++
+		log.Printf("ADMIN seeded user_id=%d tenant_id=%d email=%s", admin.ID, admin.TenantID, email)
+	}
+	return nil
+}
+
+// isAdmin informa se o usuário é um dos administradores criados na inicialização, na
+// instituição em que foi criado
+func isAdmin(user User) bool {
+	storeMu.RLock()
+	defer storeMu.RUnlock()
+
+	tenantID, ok := adminAccounts[user.ID]
+	return ok && tenantID == user.TenantID && user.Role == roleAdmin
+}
+
+// findInstitutionBySlug resolve o tenant informado pelo cliente; vazio significa a instituição padrão.
+// Exige storeMu.
+func findInstitutionBySlug(slug string) *Institution {
+	slug = strings.ToLower(strings.TrimSpace(slug))
+	if slug == "" {
+		return findInstitutionByID(defaultInstitutionID)
+	}
+	for i := range institutions {
+		if institutions[i].Slug == slug {
+			return &institutions[i]
+		}
+	}
+	return nil
+}
+
+// findInstitutionByID exige storeMu
+func findInstitutionByID(id int) *Institution {
+	for i := range institutions {
+		if institutions[i].ID == id {
+			return &institutions[i]
+		}
+	}
+	return nil
+}
+
+// emailAllowed informa se o domínio do email é aceito pela instituição
+func (inst Institution) emailAllowed(email string) bool {
+	if len(inst.AllowedEmailDomains) == 0 {
+		return true
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := normalizeEmail(email[at+1:])
+	for _, allowed := range inst.AllowedEmailDomains {
+		if domain == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// validate retorna o primeiro requisito da política que a senha não atende
+func (p PasswordPolicy) validate(password string) *FieldError {
+	var fieldErr FieldError
+	if len([]rune(password)) < p.MinLength {
+		fieldErr = fieldError("password", "password_min_length", p.MinLength)
+		return &fieldErr
+	}
+
+	var hasUpper, hasDigit, hasSymbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			hasUpper = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		case unicode.IsPunct(c) || unicode.IsSymbol(c):
+			hasSymbol = true
+		}
+	}
+
+	switch {
+	case p.RequireUpper && !hasUpper:
+		fieldErr = fieldError("password", "password_require_upper")
+	case p.RequireDigit && !hasDigit:
+		fieldErr = fieldError("password", "password_require_digit")
+	case p.RequireSymbol && !hasSymbol:
+		fieldErr = fieldError("password", "password_require_symbol")
+	default:
+		return nil
+	}
+	return &fieldErr
+}
+
+// requireAdmin retorna o usuário autenticado se ele tiver o papel de administrador
+func requireAdmin(w http.ResponseWriter, r *http.Request, action string) (User, bool) {
+	user, claims, err := authenticatedUser(r)
+	if err != nil {
+		log.Printf("%s 401 from %s: %v", action, r.RemoteAddr, err)
+		writeErrorResponse(w, r, http.StatusUnauthorized, "UNAUTHORIZED", "token_invalid")
+		return User{}, false
+	}
+	if rejectImpersonation(w, r, claims, action) {
+		return User{}, false
+	}
+	if !isAdmin(user) {
+		log.Printf("%s 403 user_id=%d is not admin", action, user.ID)
+		writeErrorResponse(w, r, http.StatusForbidden, "FORBIDDEN", "admin_only")
+		return User{}, false
+	}
+	return user, true
+}
+
+func validateInstitutionRequest(req *InstitutionRequest) []FieldError {
+	req.Slug = strings.ToLower(strings.TrimSpace(req.Slug))
+	req.Nome = strings.TrimSpace(req.Nome)
+
+	var details []FieldError
+	if !slugPattern.MatchString(req.Slug) {
+		details = append(details, fieldError("slug", "slug_format"))
+	}
+	if len(req.Nome) < 2 {
+		details = append(details, fieldError("nome", "field_min_length", 2))
+	}
+	if !validRegistrationMode(req.RegistrationMode) {
+		details = append(details, fieldError("registration_mode", "registration_mode"))
+	}
+	if req.PasswordPolicy.MinLength < 0 {
+		details = append(details, fieldError("password_policy.min_length", "field_not_negative"))
+	}
+
+	domains := make([]string, 0, len(req.AllowedEmailDomains))
+	for _, domain := range req.AllowedEmailDomains {
+		domain = normalizeEmail(strings.TrimPrefix(strings.TrimSpace(domain), "@"))
+		if domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	req.AllowedEmailDomains = domains
+	return details
+}
+
+func getInstitutionHandler(w http.ResponseWriter, r *http.Request) {
+	inst, ok := lookupInstitutionBySlug(mux.Vars(r)["slug"])
+	if !ok {
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "institution_not_found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(inst)
+}
+
+func listInstitutionsHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r, "INSTITUTIONS"); !ok {
+		return
+	}
+
+	storeMu.RLock()
+	result := make([]Institution, 0, len(institutions))
+	for _, inst := range institutions {
+		result = append(result, cloneInstitution(inst))
+	}
+	storeMu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func createInstitutionHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requireAdmin(w, r, "INSTITUTION CREATE")
+	if !ok {
+		return
+	}
+
+	var req InstitutionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("INSTITUTION CREATE 400 invalid body from %s: %v", r.RemoteAddr, err)
+		writeErrorResponse(w, r, http.StatusBadRequest, "BAD_REQUEST", "invalid_body")
+		return
+	}
+
+	if details := validateInstitutionRequest(&req); len(details) > 0 {
+		writeValidationError(w, r, details)
+		return
+	}
+
+	storeMu.Lock()
+	if findInstitutionBySlug(req.Slug) != nil {
+		storeMu.Unlock()
+		log.Printf("INSTITUTION CREATE 409 slug exists: %s", req.Slug)
+		writeErrorResponse(w, r, http.StatusConflict, "CONFLICT", "slug_exists")
+		return
+	}
+
+	inst := Institution{
+		ID:                  nextInstitutionID,
+		Slug:                req.Slug,
+		Nome:                req.Nome,
+		RegistrationMode:    req.RegistrationMode,
+		AllowedEmailDomains: req.AllowedEmailDomains,
+		PasswordPolicy:      req.PasswordPolicy,
+		CreatedAt:           time.Now(),
+		UpdatedAt:           time.Now(),
+	}
+	institutions = append(institutions, inst)
+	nextInstitutionID++
+	storeMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(inst)
+	log.Printf("INSTITUTION CREATE 201 id=%d slug=%s by admin user_id=%d", inst.ID, inst.Slug, admin.ID)
+}
+
+func updateInstitutionHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requireAdmin(w, r, "INSTITUTION UPDATE")
+	if !ok {
+		return
+	}
+
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	var req InstitutionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("INSTITUTION UPDATE 400 invalid body from %s: %v", r.RemoteAddr, err)
+		writeErrorResponse(w, r, http.StatusBadRequest, "BAD_REQUEST", "invalid_body")
+		return
+	}
+
+	if details := validateInstitutionRequest(&req); len(details) > 0 {
+		writeValidationError(w, r, details)
+		return
+	}
+
+	storeMu.Lock()
+	inst := findInstitutionByID(id)
+	if inst == nil {
+		storeMu.Unlock()
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "institution_not_found")
+		return
+	}
+
+	if other := findInstitutionBySlug(req.Slug); other != nil && other.ID != inst.ID {
+		storeMu.Unlock()
+		log.Printf("INSTITUTION UPDATE 409 slug exists: %s", req.Slug)
+		writeErrorResponse(w, r, http.StatusConflict, "CONFLICT", "slug_exists")
+		return
+	}
+
+	inst.Slug = req.Slug
+	inst.Nome = req.Nome
+	inst.RegistrationMode = req.RegistrationMode
+	inst.AllowedEmailDomains = req.AllowedEmailDomains
+	inst.PasswordPolicy = req.PasswordPolicy
+	inst.UpdatedAt = time.Now()
+	updated := cloneInstitution(*inst)
+	storeMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+	log.Printf("INSTITUTION UPDATE 200 id=%d slug=%s by admin user_id=%d", updated.ID, updated.Slug, admin.ID)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// addTestInstitution cria uma instituição com cadastro aberto e retorna seu ID
+func addTestInstitution(t *testing.T, slug string) int {
+	t.Helper()
+
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	inst := Institution{
+		ID:                  nextInstitutionID,
+		Slug:                slug,
+		Nome:                slug,
+		RegistrationMode:    registrationOpen,
+		AllowedEmailDomains: []string{},
+		CreatedAt:           time.Now(),
+		UpdatedAt:           time.Now(),
+	}
+	institutions = append(institutions, inst)
+	nextInstitutionID++
+	return inst.ID
+}
+
+// seedTestAdmin cria o administrador da instituição padrão e retorna seu token de sessão
+func seedTestAdmin(t *testing.T, email string) AuthResponse {
+	t.Helper()
+
+	t.Setenv("ADMIN_EMAILS", email)
+	t.Setenv("ADMIN_PASSWORD", "senha-do-admin")
+	if err := seedAdminsFromEnv(); err != nil {
+		t.Fatalf("seedAdminsFromEnv: %v", err)
+	}
+
+	rec := doRequest(t, loginHandler, http.MethodPost, "/login", LoginRequest{Email: email, Password: "senha-do-admin"}, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login do admin: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp AuthResponse
+	decodeBody(t, rec, &resp)
+	return resp
+}
+
+func TestAdminOnlyForSeededAccount(t *testing.T) {
+	const email = "admin-semente@exemplo.com"
+	admin := seedTestAdmin(t, email)
+	if admin.User.Role != roleAdmin {
+		t.Fatalf("papel do admin criado = %q", admin.User.Role)
+	}
+	if rec := doRequest(t, listInstitutionsHandler, http.MethodGet, "/institutions", nil, admin.Token); rec.Code != http.StatusOK {
+		t.Fatalf("admin criado na inicialização: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// O mesmo email cadastrado em outra instituição aberta não ganha o papel
+	addTestInstitution(t, "faculdade-aberta")
+	rec := doRequest(t, registerHandler, http.MethodPost, "/register", RegisterRequest{Email: email, Password: "qualquer", Tenant: "faculdade-aberta"}, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("cadastro em outra instituição: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var impostor AuthResponse
+	decodeBody(t, rec, &impostor)
+	if impostor.User.Role != roleStudent {
+		t.Fatalf("papel do cadastro = %q; esperado %q", impostor.User.Role, roleStudent)
+	}
+	if rec := doRequest(t, listInstitutionsHandler, http.MethodGet, "/institutions", nil, impostor.Token); rec.Code != http.StatusForbidden {
+		t.Fatalf("cadastro com email de admin: status %d; esperado 403", rec.Code)
+	}
+
+	// Na instituição padrão o email já pertence ao admin
+	rec = doRequest(t, registerHandler, http.MethodPost, "/register", RegisterRequest{Email: email, Password: "qualquer"}, "")
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("cadastro do email do admin na instituição padrão: status %d; esperado 409", rec.Code)
+	}
+}
@@ -0,0 +1,803 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+)
+
+const (
+	webauthnCeremonyTTL = 5 * time.Minute
+	mfaTokenTTL         = 5 * time.Minute
+
+	// maxWebAuthnBody limita o corpo das cerimônias; credenciais reais têm poucos KB
+	maxWebAuthnBody = 64 << 10
+
+	// Flags do authenticatorData (WebAuthn §6.1)
+	authDataFlagUserPresent  = 0x01
+	authDataFlagUserVerified = 0x04
+	authDataFlagAttested     = 0x40
+
+	// Algoritmos COSE suportados
+	coseAlgES256 = -7
+	coseAlgEdDSA = -8
+	coseAlgRS256 = -257
+)
+
+// WebAuthnCredential é uma passkey registrada por um usuário
+type WebAuthnCredential struct {
+	ID         []byte    `json:"-"`
+	PublicKey  []byte    `json:"-"`
+	SignCount  uint32    `json:"sign_count"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+// MarshalJSON expõe o ID da credencial no formato base64url usado pelo navegador
+func (c WebAuthnCredential) MarshalJSON() ([]byte, error) {
+	type alias WebAuthnCredential
+	return json.Marshal(struct {
+		ID string `json:"id"`
+		alias
+	}{
+		ID:    base64.RawURLEncoding.EncodeToString(c.ID),
+		alias: alias(c),
+	})
+}
+
+// MFAClaims identificam um login por senha que ainda aguarda o segundo fator
+type MFAClaims struct {
+	UserID int `json:"user_id"`
+	jwt.RegisteredClaims
+}
+
+type MFARequiredResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+type WebAuthnLoginBeginRequest struct {
+	Email    string `json:"email,omitempty"`
+	Tenant   string `json:"tenant,omitempty"`
+	MFAToken string `json:"mfa_token,omitempty"`
+}
+
+type WebAuthnMFARequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// PublicKeyCredential é o objeto retornado por navigator.credentials.create/get, com os campos binários em base64url
+type PublicKeyCredential struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject,omitempty"`
+		AuthenticatorData string `json:"authenticatorData,omitempty"`
+		Signature         string `json:"signature,omitempty"`
+		UserHandle        string `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+type collectedClientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type authenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+// webauthnCeremony guarda o desafio de uma cerimônia em andamento
+type webauthnCeremony struct {
+	kind      string // "register" ou "login"
+	userID    int    // 0 quando o login é por passkey descoberta pelo navegador
+	mfa       bool
+	expiresAt time.Time
+}
+
+type webauthnCeremonyStore struct {
+	mu         sync.Mutex
+	ceremonies map[string]webauthnCeremony
+}
+
+func (s *webauthnCeremonyStore) add(challenge string, c webauthnCeremony) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, existing := range s.ceremonies {
+		if now.After(existing.expiresAt) {
+			delete(s.ceremonies, key)
+		}
+	}
+	s.ceremonies[challenge] = c
+}
+
+// consume remove e retorna a cerimônia; cada desafio só pode ser usado uma vez
+func (s *webauthnCeremonyStore) consume(challenge, kind string) (webauthnCeremony, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.ceremonies[challenge]
+	if !ok {
+		return webauthnCeremony{}, false
+	}
+	delete(s.ceremonies, challenge)
+	if c.kind != kind || time.Now().After(c.expiresAt) {
+		return webauthnCeremony{}, false
+	}
+	return c, true
+}
+
+var (
+	webauthnRPID    = "localhost"
+	webauthnRPName  = "Sistema de Estudos"
+	webauthnOrigins = []string{"http://localhost:3000"}
+
+	webauthnCeremonies = &webauthnCeremonyStore{ceremonies: make(map[string]webauthnCeremony)}
+	webauthnIPLimiter  = newRateLimiter(30, 5*time.Minute)
+)
+
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func newChallenge() (string, error) {
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(challenge), nil
+}
+
+func generateMFAToken(user User) (string, error) {
+	claims := &MFAClaims{
+		UserID: user.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(mfaTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(purposeKey("webauthn-mfa"))
+}
+
+func validateMFAToken(tokenString string) (*MFAClaims, error) {
+	claims := &MFAClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return purposeKey("webauthn-mfa"), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, fmt.Errorf("token inválido")
+	}
+	return claims, nil
+}
+
+// authenticatedUser retorna uma cópia do usuário armazenado (com PII cifrada) dono do token
+// de sessão enviado no header Authorization, junto com as claims do token
+func authenticatedUser(r *http.Request) (User, *Claims, error) {
+	authHeader := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return User{}, nil, fmt.Errorf("token não fornecido")
+	}
+
+	claims, err := validateToken(strings.TrimPrefix(authHeader, "Bearer "))
+	if err != nil {
+		return User{}, nil, err
+	}
+
+	user, ok := lookupUserByID(claims.UserID)
+	if !ok {
+		return User{}, nil, fmt.Errorf("usuário %d não encontrado", claims.UserID)
+	}
+	return user, claims, nil
+}
+
+// findCredential procura a credencial em todos os usuários. Exige storeMu.
+func findCredential(credentialID []byte) (*User, *WebAuthnCredential) {
+	for i := range users {
+		for j := range users[i].Credentials {
+			if bytes.Equal(users[i].Credentials[j].ID, credentialID) {
+				return &users[i], &users[i].Credentials[j]
+			}
+		}
+	}
+	return nil, nil
+}
+
+func credentialDescriptors(user *User) []map[string]string {
+	descriptors := make([]map[string]string, 0, len(user.Credentials))
+	for _, cred := range user.Credentials {
+		descriptors = append(descriptors, map[string]string{
+			"type": "public-key",
+			"id":   base64.RawURLEncoding.EncodeToString(cred.ID),
+		})
+	}
+	return descriptors
+}
+
+func parseClientData(encoded, expectedType string) (*collectedClientData, []byte, error) {
+	raw, err := decodeBase64URL(encoded)
+	if err != nil {
+		return nil, nil, fmt.Errorf("clientDataJSON inválido: %v", err)
+	}
+
+	var clientData collectedClientData
+	if err := json.Unmarshal(raw, &clientData); err != nil {
+		return nil, nil, fmt.Errorf("clientDataJSON inválido: %v", err)
+	}
+
+	if clientData.Type != expectedType {
+		return nil, nil, fmt.Errorf("tipo de cerimônia inesperado: %s", clientData.Type)
+	}
+
+	originAllowed := false
+	for _, origin := range webauthnOrigins {
+		if clientData.Origin == origin {
+			originAllowed = true
+			break
+		}
+	}
+	if !originAllowed {
+		return nil, nil, fmt.Errorf("origem não permitida: %s", clientData.Origin)
+	}
+
+	return &clientData, raw, nil
+}
+
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, fmt.Errorf("authenticatorData muito curto")
+	}
+
+	authData := &authenticatorData{
+		RPIDHash:  data[:32],
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+
+	expectedHash := sha256.Sum256([]byte(webauthnRPID))
+	if !bytes.Equal(authData.RPIDHash, expectedHash[:]) {
+		return nil, fmt.Errorf("rpIdHash não corresponde a %s", webauthnRPID)
+	}
+
+	if authData.Flags&authDataFlagUserPresent == 0 {
+		return nil, fmt.Errorf("presença do usuário não confirmada")
+	}
+
+	if authData.Flags&authDataFlagAttested != 0 {
+		rest := data[37:]
+		if len(rest) < 18 {
+			return nil, fmt.Errorf("attestedCredentialData muito curto")
+		}
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < idLen {
+			return nil, fmt.Errorf("credentialId truncado")
+		}
+		authData.CredentialID = rest[:idLen]
+
+		_, after, err := decodeCBOR(rest[idLen:])
+		if err != nil {
+			return nil, fmt.Errorf("chave pública inválida: %v", err)
+		}
+		authData.PublicKey = rest[idLen : len(rest)-len(after)]
+	}
+
+	return authData, nil
+}
+
+// parseCOSEKey converte uma chave COSE (RFC 9053) em uma chave pública do Go
+func parseCOSEKey(data []byte) (int64, crypto.PublicKey, error) {
+	decoded, _, err := decodeCBOR(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	key, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return 0, nil, fmt.Errorf("chave COSE não é um mapa")
+	}
+
+	alg, _ := key[int64(3)].(int64)
+	switch alg {
+	case coseAlgES256:
+		x, _ := key[int64(-2)].([]byte)
+		y, _ := key[int64(-3)].([]byte)
+		if crv, _ := key[int64(-1)].(int64); crv != 1 || len(x) != 32 || len(y) != 32 {
+			return 0, nil, fmt.Errorf("chave EC2 inválida")
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return 0, nil, fmt.Errorf("ponto fora da curva P-256")
+		}
+		return alg, pub, nil
+	case coseAlgEdDSA:
+		x, _ := key[int64(-2)].([]byte)
+		if crv, _ := key[int64(-1)].(int64); crv != 6 || len(x) != ed25519.PublicKeySize {
+			return 0, nil, fmt.Errorf("chave OKP inválida")
+		}
+		return alg, ed25519.PublicKey(x), nil
+	case coseAlgRS256:
+		n, _ := key[int64(-1)].([]byte)
+		e, _ := key[int64(-2)].([]byte)
+		if len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return 0, nil, fmt.Errorf("chave RSA inválida")
+		}
+		exponent := int(new(big.Int).SetBytes(e).Int64())
+		return alg, &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}, nil
+	}
+	return 0, nil, fmt.Errorf("algoritmo COSE %d não suportado", alg)
+}
+
+func verifyAssertionSignature(coseKey, signedData, signature []byte) error {
+	alg, pub, err := parseCOSEKey(coseKey)
+	if err != nil {
+		return err
+	}
+
+	digest := sha256.Sum256(signedData)
+	switch alg {
+	case coseAlgES256:
+		if !ecdsa.VerifyASN1(pub.(*ecdsa.PublicKey), digest[:], signature) {
+			return fmt.Errorf("assinatura ES256 inválida")
+		}
+	case coseAlgEdDSA:
+		if !ed25519.Verify(pub.(ed25519.PublicKey), signedData, signature) {
+			return fmt.Errorf("assinatura EdDSA inválida")
+		}
+	case coseAlgRS256:
+		if err := rsa.VerifyPKCS1v15(pub.(*rsa.PublicKey), crypto.SHA256, digest[:], signature); err != nil {
+			return fmt.Errorf("assinatura RS256 inválida")
+		}
+	}
+	return nil
+}
+
+func writeWebAuthnOptions(w http.ResponseWriter, options map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"publicKey": options})
+}
+
+func webauthnRegisterBeginHandler(w http.ResponseWriter, r *http.Request) {
+	user, claims, err := authenticatedUser(r)
+	if err != nil {
+		log.Printf("WEBAUTHN 401 register begin from %s: %v", r.RemoteAddr, err)
+		writeErrorResponse(w, r, http.StatusUnauthorized, "UNAUTHORIZED", "token_invalid")
+		return
+	}
+	if rejectImpersonation(w, r, claims, "WEBAUTHN register begin") {
+		return
+	}
+
+	profile, err := openUserPII(user)
+	if err != nil {
+		log.Printf("WEBAUTHN 500 openUserPII error for user_id=%d: %v", user.ID, err)
+		writeErrorResponse(w, r, http.StatusInternalServerError, "INTERNAL_ERROR", "internal_error")
+		return
+	}
+
+	challenge, err := newChallenge()
+	if err != nil {
+		log.Printf("WEBAUTHN 500 challenge error for user_id=%d: %v", user.ID, err)
+		writeErrorResponse(w, r, http.StatusInternalServerError, "INTERNAL_ERROR", "internal_error")
+		return
+	}
+
+	webauthnCeremonies.add(challenge, webauthnCeremony{
+		kind:      "register",
+		userID:    user.ID,
+		expiresAt: time.Now().Add(webauthnCeremonyTTL),
+	})
+
+	writeWebAuthnOptions(w, map[string]interface{}{
+		"challenge": challenge,
+		"rp":        map[string]string{"id": webauthnRPID, "name": webauthnRPName},
+		"user": map[string]string{
+			"id":          base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(user.ID))),
+			"name":        profile.Email,
+			"displayName": profile.Email,
+		},
+		"pubKeyCredParams": []map[string]interface{}{
+			{"type": "public-key", "alg": coseAlgES256},
+			{"type": "public-key", "alg": coseAlgEdDSA},
+			{"type": "public-key", "alg": coseAlgRS256},
+		},
+		"timeout":            int(webauthnCeremonyTTL.Milliseconds()),
+		"attestation":        "none",
+		"excludeCredentials": credentialDescriptors(&user),
+		"authenticatorSelection": map[string]string{
+			"residentKey":      "preferred",
+			"userVerification": "preferred",
+		},
+	})
+	log.Printf("WEBAUTHN 200 register begin user_id=%d email=%s", user.ID, profile.Email)
+}
+
+func webauthnRegisterFinishHandler(w http.ResponseWriter, r *http.Request) {
+	user, claims, err := authenticatedUser(r)
+	if err != nil {
+		log.Printf("WEBAUTHN 401 register finish from %s: %v", r.RemoteAddr, err)
+		writeErrorResponse(w, r, http.StatusUnauthorized, "UNAUTHORIZED", "token_invalid")
+		return
+	}
+	if rejectImpersonation(w, r, claims, "WEBAUTHN register finish") {
+		return
+	}
+
+	var credential PublicKeyCredential
+	r.Body = http.MaxBytesReader(w, r.Body, maxWebAuthnBody)
+	if err := json.NewDecoder(r.Body).Decode(&credential); err != nil {
+		log.Printf("WEBAUTHN 400 invalid body from %s: %v", r.RemoteAddr, err)
+		writeErrorResponse(w, r, http.StatusBadRequest, "BAD_REQUEST", "invalid_body")
+		return
+	}
+
+	clientData, _, err := parseClientData(credential.Response.ClientDataJSON, "webauthn.create")
+	if err != nil {
+		log.Printf("WEBAUTHN 400 register user_id=%d: %v", user.ID, err)
+		writeErrorResponse(w, r, http.StatusBadRequest, "WEBAUTHN_INVALID_CREDENTIAL", "webauthn_credential_invalid")
+		return
+	}
+
+	ceremony, ok := webauthnCeremonies.consume(clientData.Challenge, "register")
+	if !ok || ceremony.userID != user.ID {
+		log.Printf("WEBAUTHN 400 register user_id=%d: desafio desconhecido ou expirado", user.ID)
+		writeErrorResponse(w, r, http.StatusBadRequest, "WEBAUTHN_CHALLENGE_INVALID", "webauthn_challenge_invalid")
+		return
+	}
+
+	attestationRaw, err := decodeBase64URL(credential.Response.AttestationObject)
+	if err != nil {
+		log.Printf("WEBAUTHN 400 register user_id=%d: attestationObject inválido: %v", user.ID, err)
+		writeErrorResponse(w, r, http.StatusBadRequest, "WEBAUTHN_INVALID_CREDENTIAL", "webauthn_credential_invalid")
+		return
+	}
+
+	// Solicitamos attestation "none": o formato e o attStmt não são verificados
+	decoded, _, err := decodeCBOR(attestationRaw)
+	attestation, isMap := decoded.(map[interface{}]interface{})
+	if err != nil || !isMap {
+		log.Printf("WEBAUTHN 400 register user_id=%d: attestationObject inválido: %v", user.ID, err)
+		writeErrorResponse(w, r, http.StatusBadRequest, "WEBAUTHN_INVALID_CREDENTIAL", "webauthn_credential_invalid")
+		return
+	}
+
+	rawAuthData, _ := attestation["authData"].([]byte)
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err == nil && authData.CredentialID == nil {
+		err = fmt.Errorf("authenticatorData sem credencial")
+	}
+	if err == nil {
+		_, _, err = parseCOSEKey(authData.PublicKey)
+	}
+	if err != nil {
+		log.Printf("WEBAUTHN 400 register user_id=%d: %v", user.ID, err)
+		writeErrorResponse(w, r, http.StatusBadRequest, "WEBAUTHN_INVALID_CREDENTIAL", "webauthn_credential_invalid")
+		return
+	}
+
+	cred := WebAuthnCredential{
+		ID:        append([]byte(nil), authData.CredentialID...),
+		PublicKey: append([]byte(nil), authData.PublicKey...),
+		SignCount: authData.SignCount,
+		CreatedAt: time.Now(),
+	}
+
+	// Unicidade da credencial e inserção sob o mesmo lock
+	storeMu.Lock()
+	if owner, _ := findCredential(cred.ID); owner != nil {
+		storeMu.Unlock()
+		log.Printf("WEBAUTHN 409 register user_id=%d: credencial já registrada", user.ID)
+		writeErrorResponse(w, r, http.StatusConflict, "CONFLICT", "passkey_exists")
+		return
+	}
+	stored := findUserByID(user.ID)
+	stored.Credentials = append(stored.Credentials, cred)
+	storeMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(cred)
+	log.Printf("WEBAUTHN 201 register finish user_id=%d", user.ID)
+}
+
+func webauthnLoginBeginHandler(w http.ResponseWriter, r *http.Request) {
+	var req WebAuthnLoginBeginRequest
+	if r.ContentLength != 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxWebAuthnBody)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.Printf("WEBAUTHN 400 invalid body from %s: %v", r.RemoteAddr, err)
+			writeErrorResponse(w, r, http.StatusBadRequest, "BAD_REQUEST", "invalid_body")
+			return
+		}
+	}
+
+	if !webauthnIPLimiter.Allow(clientIP(r)) {
+		log.Printf("WEBAUTHN 429 throttled login begin from %s", r.RemoteAddr)
+		writeErrorResponse(w, r, http.StatusTooManyRequests, "TOO_MANY_REQUESTS", "too_many_requests")
+		return
+	}
+
+	ceremony := webauthnCeremony{kind: "login", expiresAt: time.Now().Add(webauthnCeremonyTTL)}
+	allowCredentials := []map[string]string{}
+	userVerification := "required"
+
+	if req.MFAToken != "" {
+		// Segundo fator após o login por senha
+		claims, err := validateMFAToken(req.MFAToken)
+		if err != nil {
+			log.Printf("WEBAUTHN 401 invalid mfa token from %s: %v", r.RemoteAddr, err)
+			writeErrorResponse(w, r, http.StatusUnauthorized, "UNAUTHORIZED", "token_invalid")
+			return
+		}
+		ceremony.userID = claims.UserID
+		ceremony.mfa = true
+		userVerification = "preferred"
+	} else if req.Email != "" {
+		if user, ok := lookupUserByTenantEmail(req.Tenant, req.Email); ok {
+			ceremony.userID = user.ID
+		}
+	}
+
+	if user, ok := lookupUserByID(ceremony.userID); ok {
+		allowCredentials = credentialDescriptors(&user)
+	}
+
+	challenge, err := newChallenge()
+	if err != nil {
+		log.Printf("WEBAUTHN 500 challenge error: %v", err)
+		writeErrorResponse(w, r, http.StatusInternalServerError, "INTERNAL_ERROR", "internal_error")
+		return
+	}
+	webauthnCeremonies.add(challenge, ceremony)
+
+	writeWebAuthnOptions(w, map[string]interface{}{
+		"challenge":        challenge,
+		"rpId":             webauthnRPID,
+		"timeout":          int(webauthnCeremonyTTL.Milliseconds()),
+		"allowCredentials": allowCredentials,
+		"userVerification": userVerification,
+	})
+	log.Printf("WEBAUTHN 200 login begin user_id=%d mfa=%t from %s", ceremony.userID, ceremony.mfa, r.RemoteAddr)
+}
+
+func webauthnLoginFinishHandler(w http.ResponseWriter, r *http.Request) {
+	if !webauthnIPLimiter.Allow(clientIP(r)) {
+		log.Printf("WEBAUTHN 429 throttled login finish from %s", r.RemoteAddr)
+		writeErrorResponse(w, r, http.StatusTooManyRequests, "TOO_MANY_REQUESTS", "too_many_requests")
+		return
+	}
+
+	var credential PublicKeyCredential
+	r.Body = http.MaxBytesReader(w, r.Body, maxWebAuthnBody)
+	if err := json.NewDecoder(r.Body).Decode(&credential); err != nil {
+		log.Printf("WEBAUTHN 400 invalid body from %s: %v", r.RemoteAddr, err)
+		writeErrorResponse(w, r, http.StatusBadRequest, "BAD_REQUEST", "invalid_body")
+		return
+	}
+
+	clientData, clientDataRaw, err := parseClientData(credential.Response.ClientDataJSON, "webauthn.get")
+	if err != nil {
+		log.Printf("WEBAUTHN 401 login from %s: %v", r.RemoteAddr, err)
+		writeErrorResponse(w, r, http.StatusUnauthorized, "INVALID_CREDENTIALS", "invalid_credentials")
+		return
+	}
+
+	ceremony, ok := webauthnCeremonies.consume(clientData.Challenge, "login")
+	if !ok {
+		log.Printf("WEBAUTHN 401 login from %s: desafio desconhecido ou expirado", r.RemoteAddr)
+		writeErrorResponse(w, r, http.StatusUnauthorized, "WEBAUTHN_CHALLENGE_INVALID", "webauthn_challenge_invalid")
+		return
+	}
+
+	credentialID, err := decodeBase64URL(credential.RawID)
+	if err != nil {
+		log.Printf("WEBAUTHN 401 login from %s: rawId inválido: %v", r.RemoteAddr, err)
+		writeErrorResponse(w, r, http.StatusUnauthorized, "INVALID_CREDENTIALS", "invalid_credentials")
+		return
+	}
+
+	user, cred, found := lookupCredential(credentialID)
+	if !found || (ceremony.userID != 0 && user.ID != ceremony.userID) {
+		log.Printf("WEBAUTHN 401 login unknown credential from %s", r.RemoteAddr)
+		writeErrorResponse(w, r, http.StatusUnauthorized, "INVALID_CREDENTIALS", "invalid_credentials")
+		return
+	}
+
+	rawAuthData, err := decodeBase64URL(credential.Response.AuthenticatorData)
+	if err != nil {
+		log.Printf("WEBAUTHN 401 login user_id=%d: authenticatorData inválido: %v", user.ID, err)
+		writeErrorResponse(w, r, http.StatusUnauthorized, "INVALID_CREDENTIALS", "invalid_credentials")
+		return
+	}
+
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		log.Printf("WEBAUTHN 401 login user_id=%d: %v", user.ID, err)
+		writeErrorResponse(w, r, http.StatusUnauthorized, "INVALID_CREDENTIALS", "invalid_credentials")
+		return
+	}
+
+	// Como fator único, a passkey precisa ter verificado o usuário (PIN, biometria)
+	if !ceremony.mfa && authData.Flags&authDataFlagUserVerified == 0 {
+		log.Printf("WEBAUTHN 401 login user_id=%d: usuário não verificado", user.ID)
+		writeErrorResponse(w, r, http.StatusUnauthorized, "USER_VERIFICATION_REQUIRED", "webauthn_uv_required")
+		return
+	}
+
+	signature, err := decodeBase64URL(credential.Response.Signature)
+	if err == nil {
+		clientDataHash := sha256.Sum256(clientDataRaw)
+		signedData := append(append([]byte(nil), rawAuthData...), clientDataHash[:]...)
+		err = verifyAssertionSignature(cred.PublicKey, signedData, signature)
+	}
+	if err != nil {
+		log.Printf("WEBAUTHN 401 login user_id=%d: %v", user.ID, err)
+		writeErrorResponse(w, r, http.StatusUnauthorized, "INVALID_CREDENTIALS", "invalid_credentials")
+		return
+	}
+
+	// Um contador que não avança indica um possível autenticador clonado. A verificação e o
+	// avanço acontecem sob o mesmo lock, para que duas asserções simultâneas com o mesmo
+	// contador não sejam ambas aceitas.
+	storeMu.Lock()
+	_, stored := findCredential(credentialID)
+	counterOK := stored != nil && ((authData.SignCount == 0 && stored.SignCount == 0) || authData.SignCount > stored.SignCount)
+	if counterOK {
+		stored.SignCount = authData.SignCount
+		stored.LastUsedAt = time.Now()
+	}
+	storeMu.Unlock()
+
+	if !counterOK {
+		log.Printf("WEBAUTHN 401 login user_id=%d: sign count %d <= %d, possível clone", user.ID, authData.SignCount, cred.SignCount)
+		writeErrorResponse(w, r, http.StatusUnauthorized, "INVALID_CREDENTIALS", "invalid_credentials")
+		return
+	}
+
+	profile, err := openUserPII(user)
+	if err != nil {
+		log.Printf("WEBAUTHN 500 openUserPII error for user_id=%d: %v", user.ID, err)
+		writeErrorResponse(w, r, http.StatusInternalServerError, "INTERNAL_ERROR", "internal_error")
+		return
+	}
+
+	// Gerar JWT
+	token, err := generateJWT(profile)
+	if err != nil {
+		log.Printf("WEBAUTHN 500 generateJWT error for %s: %v", profile.Email, err)
+		writeErrorResponse(w, r, http.StatusInternalServerError, "INTERNAL_ERROR", "internal_error")
+		return
+	}
+
+	response := AuthResponse{
+		Token: token,
+		User:  profile,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+	log.Printf("WEBAUTHN 200 login user_id=%d email=%s mfa=%t", profile.ID, profile.Email, ceremony.mfa)
+}
+
+func listWebAuthnCredentialsHandler(w http.ResponseWriter, r *http.Request) {
+	user, _, err := authenticatedUser(r)
+	if err != nil {
+		log.Printf("WEBAUTHN 401 list credentials from %s: %v", r.RemoteAddr, err)
+		writeErrorResponse(w, r, http.StatusUnauthorized, "UNAUTHORIZED", "token_invalid")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"credentials":  user.Credentials,
+		"webauthn_mfa": user.WebAuthnMFA,
+	})
+}
+
+func deleteWebAuthnCredentialHandler(w http.ResponseWriter, r *http.Request) {
+	user, claims, err := authenticatedUser(r)
+	if err != nil {
+		log.Printf("WEBAUTHN 401 delete credential from %s: %v", r.RemoteAddr, err)
+		writeErrorResponse(w, r, http.StatusUnauthorized, "UNAUTHORIZED", "token_invalid")
+		return
+	}
+	if rejectImpersonation(w, r, claims, "WEBAUTHN delete credential") {
+		return
+	}
+
+	credentialID, err := decodeBase64URL(mux.Vars(r)["id"])
+	if err != nil {
+		writeErrorResponse(w, r, http.StatusBadRequest, "BAD_REQUEST", "invalid_body")
+		return
+	}
+
+	removed := false
+	storeMu.Lock()
+	if stored := findUserByID(user.ID); stored != nil {
+		for i, cred := range stored.Credentials {
+			if bytes.Equal(cred.ID, credentialID) {
+				stored.Credentials = append(stored.Credentials[:i], stored.Credentials[i+1:]...)
+				if len(stored.Credentials) == 0 {
+					stored.WebAuthnMFA = false
+				}
+				removed = true
+				break
+			}
+		}
+	}
+	storeMu.Unlock()
+
+	if removed {
+		w.WriteHeader(http.StatusNoContent)
+		log.Printf("WEBAUTHN 204 delete credential user_id=%d", user.ID)
+		return
+	}
+
+	log.Printf("WEBAUTHN 404 delete credential user_id=%d: não encontrada", user.ID)
+	writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "passkey_not_found")
+}
+
+func webauthnMFAHandler(w http.ResponseWriter, r *http.Request) {
+	user, claims, err := authenticatedUser(r)
+	if err != nil {
+		log.Printf("WEBAUTHN 401 mfa settings from %s: %v", r.RemoteAddr, err)
+		writeErrorResponse(w, r, http.StatusUnauthorized, "UNAUTHORIZED", "token_invalid")
+		return
+	}
+	if rejectImpersonation(w, r, claims, "WEBAUTHN mfa settings") {
+		return
+	}
+
+	var req WebAuthnMFARequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("WEBAUTHN 400 invalid body from %s: %v", r.RemoteAddr, err)
+		writeErrorResponse(w, r, http.StatusBadRequest, "BAD_REQUEST", "invalid_body")
+		return
+	}
+
+	// A exigência de uma passkey é conferida sob o mesmo lock da alteração, pois ela pode
+	// estar sendo removida em paralelo
+	storeMu.Lock()
+	stored := findUserByID(user.ID)
+	if req.Enabled && len(stored.Credentials) == 0 {
+		storeMu.Unlock()
+		log.Printf("WEBAUTHN 409 mfa user_id=%d: nenhuma passkey cadastrada", user.ID)
+		writeErrorResponse(w, r, http.StatusConflict, "PASSKEY_REQUIRED", "passkey_required_for_mfa")
+		return
+	}
+	stored.WebAuthnMFA = req.Enabled
+	storeMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"webauthn_mfa": req.Enabled})
+	log.Printf("WEBAUTHN 200 mfa=%t user_id=%d", req.Enabled, user.ID)
+}
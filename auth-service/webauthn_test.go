@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const (
+	testOrigin = "http://localhost:3000"
+	testRPID   = "localhost"
+)
+
+// Codificação CBOR mínima para montar os objetos que um autenticador real enviaria
+
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= 0xffff:
+		head := []byte{major<<5 | 25, 0, 0}
+		binary.BigEndian.PutUint16(head[1:], uint16(n))
+		return head
+	}
+	head := []byte{major<<5 | 26, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(head[1:], uint32(n))
+	return head
+}
+
+func cborInt(v int64) []byte {
+	if v >= 0 {
+		return cborHead(0, uint64(v))
+	}
+	return cborHead(1, uint64(-1-v))
+}
+
+func cborBytes(b []byte) []byte {
+	return append(cborHead(2, uint64(len(b))), b...)
+}
+
+func cborText(s string) []byte {
+	return append(cborHead(3, uint64(len(s))), s...)
+}
+
+// cborMap recebe chaves e valores já codificados, alternados
+func cborMap(items ...[]byte) []byte {
+	out := cborHead(5, uint64(len(items)/2))
+	for _, item := range items {
+		out = append(out, item...)
+	}
+	return out
+}
+
+// softAuthenticator é um autenticador de software com uma chave ES256
+type softAuthenticator struct {
+	key    *ecdsa.PrivateKey
+	credID []byte
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey: %v", err)
+	}
+	credID := make([]byte, 16)
+	rand.Read(credID)
+	return &softAuthenticator{key: key, credID: credID}
+}
+
+// coseKey é a chave pública no formato COSE_Key (kty EC2, alg ES256, crv P-256)
+func (a *softAuthenticator) coseKey() []byte {
+	x := a.key.PublicKey.X.FillBytes(make([]byte, 32))
+	y := a.key.PublicKey.Y.FillBytes(make([]byte, 32))
+	return cborMap(
+		cborInt(1), cborInt(2),
+		cborInt(3), cborInt(coseAlgES256),
+		cborInt(-1), cborInt(1),
+		cborInt(-2), cborBytes(x),
+		cborInt(-3), cborBytes(y),
+	)
+}
+
+// authData monta o authenticatorData; com attested, inclui a credencial (cadastro)
+func (a *softAuthenticator) authData(rpID string, flags byte, signCount uint32, attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append([]byte(nil), rpIDHash[:]...)
+	if attested {
+		flags |= authDataFlagAttested
+	}
+	data = append(data, flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:], signCount)
+	if attested {
+		data = append(data, make([]byte, 16)...) // AAGUID zerado, como na attestation "none"
+		data = append(data, byte(len(a.credID)>>8), byte(len(a.credID)))
+		data = append(data, a.credID...)
+		data = append(data, a.coseKey()...)
+	}
+	return data
+}
+
+func clientDataJSON(ceremonyType, challenge, origin string) []byte {
+	raw, _ := json.Marshal(map[string]string{"type": ceremonyType, "challenge": challenge, "origin": origin})
+	return raw
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ceremony descreve o que o autenticador responde; o valor zero é uma resposta válida
+type ceremony struct {
+	challenge string
+	origin    string
+	rpID      string
+	flags     byte
+	signCount uint32
+}
+
+func (c ceremony) withDefaults() ceremony {
+	if c.origin == "" {
+		c.origin = testOrigin
+	}
+	if c.rpID == "" {
+		c.rpID = testRPID
+	}
+	if c.flags == 0 {
+		c.flags = authDataFlagUserPresent | authDataFlagUserVerified
+	}
+	return c
+}
+
+// attestation é a resposta de navigator.credentials.create
+func (a *softAuthenticator) attestation(c ceremony) PublicKeyCredential {
+	c = c.withDefaults()
+	attestationObject := cborMap(
+		cborText("fmt"), cborText("none"),
+		cborText("attStmt"), cborMap(),
+		cborText("authData"), cborBytes(a.authData(c.rpID, c.flags, c.signCount, true)),
+	)
+
+	var cred PublicKeyCredential
+	cred.ID = b64(a.credID)
+	cred.RawID = b64(a.credID)
+	cred.Type = "public-key"
+	cred.Response.ClientDataJSON = b64(clientDataJSON("webauthn.create", c.challenge, c.origin))
+	cred.Response.AttestationObject = b64(attestationObject)
+	return cred
+}
+
+// assertion é a resposta de navigator.credentials.get, assinada sobre authData || SHA-256(clientDataJSON)
+func (a *softAuthenticator) assertion(t *testing.T, c ceremony) PublicKeyCredential {
+	t.Helper()
+	c = c.withDefaults()
+	authData := a.authData(c.rpID, c.flags, c.signCount, false)
+	clientData := clientDataJSON("webauthn.get", c.challenge, c.origin)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatalf("ecdsa.SignASN1: %v", err)
+	}
+
+	var cred PublicKeyCredential
+	cred.ID = b64(a.credID)
+	cred.RawID = b64(a.credID)
+	cred.Type = "public-key"
+	cred.Response.ClientDataJSON = b64(clientData)
+	cred.Response.AuthenticatorData = b64(authData)
+	cred.Response.Signature = b64(signature)
+	return cred
+}
+
+// beginChallenge chama um handler de início de cerimônia e retorna o desafio emitido
+func beginChallenge(t *testing.T, handler http.HandlerFunc, body interface{}, token string) string {
+	t.Helper()
+	rec := doRequest(t, handler, http.MethodPost, "/webauthn/begin", body, token)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("begin: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var options struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+		} `json:"publicKey"`
+	}
+	decodeBody(t, rec, &options)
+	if options.PublicKey.Challenge == "" {
+		t.Fatalf("begin sem desafio: %s", rec.Body.String())
+	}
+	return options.PublicKey.Challenge
+}
+
+// registerPasskey cadastra a passkey do autenticador para o usuário do token
+func registerPasskey(t *testing.T, a *softAuthenticator, token string) {
+	t.Helper()
+	challenge := beginChallenge(t, webauthnRegisterBeginHandler, nil, token)
+	rec := doRequest(t, webauthnRegisterFinishHandler, http.MethodPost, "/webauthn/register/finish", a.attestation(ceremony{challenge: challenge}), token)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("register finish: status %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// loginWithPasskey faz a cerimônia de login e retorna a resposta do finish
+func loginWithPasskey(t *testing.T, a *softAuthenticator, begin WebAuthnLoginBeginRequest, c ceremony) *httptest.ResponseRecorder {
+	t.Helper()
+	issued := beginChallenge(t, webauthnLoginBeginHandler, begin, "")
+	if c.challenge == "" {
+		c.challenge = issued
+	}
+	return doRequest(t, webauthnLoginFinishHandler, http.MethodPost, "/webauthn/login/finish", a.assertion(t, c), "")
+}
+
+func TestWebAuthnRegisterAndLogin(t *testing.T) {
+	user := registerTestUser(t, "passkey@exemplo.com")
+	a := newSoftAuthenticator(t)
+	registerPasskey(t, a, user.Token)
+
+	// Primeiro fator, com o usuário indicado pelo email
+	rec := loginWithPasskey(t, a, WebAuthnLoginBeginRequest{Email: "passkey@exemplo.com"}, ceremony{signCount: 1})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp AuthResponse
+	decodeBody(t, rec, &resp)
+	claims, err := validateToken(resp.Token)
+	if err != nil || claims.UserID != user.User.ID {
+		t.Fatalf("token do login = %+v, %v; esperado user_id %d", claims, err, user.User.ID)
+	}
+
+	// Passkey descoberta pelo navegador, sem email
+	rec = loginWithPasskey(t, a, WebAuthnLoginBeginRequest{}, ceremony{signCount: 2})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login sem email: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	stored, _ := lookupUserByID(user.User.ID)
+	if len(stored.Credentials) != 1 || stored.Credentials[0].SignCount != 2 {
+		t.Fatalf("credenciais = %+v; esperada uma com sign count 2", stored.Credentials)
+	}
+}
+
+func TestWebAuthnRegisterRejections(t *testing.T) {
+	user := registerTestUser(t, "passkey-cadastro@exemplo.com")
+
+	tests := []struct {
+		name     string
+		ceremony func(challenge string) ceremony
+		code     string
+	}{
+		{"desafio errado", func(string) ceremony { return ceremony{challenge: b64([]byte("desafio-que-nao-foi-emitido"))} }, "WEBAUTHN_CHALLENGE_INVALID"},
+		{"origem errada", func(c string) ceremony { return ceremony{challenge: c, origin: "https://phishing.example"} }, "WEBAUTHN_INVALID_CREDENTIAL"},
+		{"rpIdHash errado", func(c string) ceremony { return ceremony{challenge: c, rpID: "phishing.example"} }, "WEBAUTHN_INVALID_CREDENTIAL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newSoftAuthenticator(t)
+			challenge := beginChallenge(t, webauthnRegisterBeginHandler, nil, user.Token)
+			rec := doRequest(t, webauthnRegisterFinishHandler, http.MethodPost, "/webauthn/register/finish", a.attestation(tt.ceremony(challenge)), user.Token)
+			if rec.Code != http.StatusBadRequest || errorCode(t, rec) != tt.code {
+				t.Fatalf("status %d: %s; esperado 400 %s", rec.Code, rec.Body.String(), tt.code)
+			}
+		})
+	}
+
+	stored, _ := lookupUserByID(user.User.ID)
+	if len(stored.Credentials) != 0 {
+		t.Fatalf("nenhuma credencial deveria ter sido cadastrada: %+v", stored.Credentials)
+	}
+}
+
+func TestWebAuthnLoginRejections(t *testing.T) {
+	user := registerTestUser(t, "passkey-login@exemplo.com")
+	a := newSoftAuthenticator(t)
+	registerPasskey(t, a, user.Token)
+	begin := WebAuthnLoginBeginRequest{Email: "passkey-login@exemplo.com"}
+
+	tests := []struct {
+		name     string
+		ceremony ceremony
+		code     string
+	}{
+		{"desafio errado", ceremony{challenge: b64([]byte("desafio-que-nao-foi-emitido"))}, "WEBAUTHN_CHALLENGE_INVALID"},
+		{"origem errada", ceremony{origin: "https://phishing.example"}, "INVALID_CREDENTIALS"},
+		{"rpIdHash errado", ceremony{rpID: "phishing.example"}, "INVALID_CREDENTIALS"},
+		{"sem verificação do usuário", ceremony{flags: authDataFlagUserPresent}, "USER_VERIFICATION_REQUIRED"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// O contador avança a cada caso, para que a recusa não venha dele
+			tt.ceremony.signCount = uint32(10 + i)
+			rec := loginWithPasskey(t, a, begin, tt.ceremony)
+			if rec.Code != http.StatusUnauthorized || errorCode(t, rec) != tt.code {
+				t.Fatalf("status %d: %s; esperado 401 %s", rec.Code, rec.Body.String(), tt.code)
+			}
+		})
+	}
+
+	t.Run("assinatura de outra chave", func(t *testing.T) {
+		impostor := newSoftAuthenticator(t)
+		impostor.credID = a.credID
+		rec := loginWithPasskey(t, impostor, begin, ceremony{signCount: 50})
+		if rec.Code != http.StatusUnauthorized || errorCode(t, rec) != "INVALID_CREDENTIALS" {
+			t.Fatalf("status %d: %s; esperado 401 INVALID_CREDENTIALS", rec.Code, rec.Body.String())
+		}
+	})
+}
+
+func TestWebAuthnSignCountRegression(t *testing.T) {
+	user := registerTestUser(t, "passkey-contador@exemplo.com")
+	a := newSoftAuthenticator(t)
+	registerPasskey(t, a, user.Token)
+	begin := WebAuthnLoginBeginRequest{Email: "passkey-contador@exemplo.com"}
+
+	steps := []struct {
+		signCount uint32
+		status    int
+	}{
+		{5, http.StatusOK},
+		{5, http.StatusUnauthorized}, // contador repetido: possível clone
+		{3, http.StatusUnauthorized}, // contador regrediu
+		{6, http.StatusOK},
+	}
+	for _, step := range steps {
+		rec := loginWithPasskey(t, a, begin, ceremony{signCount: step.signCount})
+		if rec.Code != step.status {
+			t.Fatalf("sign count %d: status %d: %s; esperado %d", step.signCount, rec.Code, rec.Body.String(), step.status)
+		}
+	}
+
+	stored, _ := lookupUserByID(user.User.ID)
+	if got := stored.Credentials[0].SignCount; got != 6 {
+		t.Fatalf("sign count armazenado = %d; esperado 6", got)
+	}
+}
+
+func TestWebAuthnSecondFactor(t *testing.T) {
+	const email = "passkey-mfa@exemplo.com"
+	user := registerTestUser(t, email)
+	a := newSoftAuthenticator(t)
+	registerPasskey(t, a, user.Token)
+
+	rec := doRequest(t, webauthnMFAHandler, http.MethodPut, "/webauthn/mfa", WebAuthnMFARequest{Enabled: true}, user.Token)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ativar mfa: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// A senha sozinha não emite mais a sessão
+	rec = doRequest(t, loginHandler, http.MethodPost, "/login", LoginRequest{Email: email, Password: "senha-de-teste"}, "")
+	var mfa MFARequiredResponse
+	decodeBody(t, rec, &mfa)
+	if rec.Code != http.StatusOK || !mfa.MFARequired || mfa.MFAToken == "" {
+		t.Fatalf("login por senha: status %d: %s; esperado mfa_required", rec.Code, rec.Body.String())
+	}
+
+	// Como segundo fator, a passkey não precisa verificar o usuário
+	rec = loginWithPasskey(t, a, WebAuthnLoginBeginRequest{MFAToken: mfa.MFAToken}, ceremony{flags: authDataFlagUserPresent, signCount: 1})
+	var resp AuthResponse
+	decodeBody(t, rec, &resp)
+	if rec.Code != http.StatusOK || resp.Token == "" {
+		t.Fatalf("segundo fator: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// O link de acesso por email também exige o segundo fator
+	const nonce = "nonce-do-dispositivo"
+	link, err := generateMagicLink(user.User, nonce)
+	if err != nil {
+		t.Fatalf("generateMagicLink: %v", err)
+	}
+	parsed, _ := url.Parse(link)
+	req := httptest.NewRequest(http.MethodGet, "/login/magic-link/callback?"+parsed.RawQuery, nil)
+	req.AddCookie(&http.Cookie{Name: magicLinkCookie, Value: nonce})
+	callback := httptest.NewRecorder()
+	magicLinkCallbackHandler(callback, req)
+
+	var linkResp struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+		Token       string `json:"token"`
+	}
+	decodeBody(t, callback, &linkResp)
+	if callback.Code != http.StatusOK || !linkResp.MFARequired || linkResp.MFAToken == "" || linkResp.Token != "" {
+		t.Fatalf("link de acesso: status %d: %s; esperado mfa_required sem token de sessão", callback.Code, callback.Body.String())
+	}
+}
+
+func TestWebAuthnFinishBodyLimit(t *testing.T) {
+	user := registerTestUser(t, "passkey-limite@exemplo.com")
+
+	body := `{"id":"` + strings.Repeat("A", maxWebAuthnBody) + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/webauthn/register/finish", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+user.Token)
+	rec := httptest.NewRecorder()
+	webauthnRegisterFinishHandler(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status %d; esperado 400 para corpo acima do limite", rec.Code)
+	}
+}
+
+func TestDecodeCBORLimits(t *testing.T) {
+	nested := func(depth int) []byte {
+		return append(bytes.Repeat([]byte{0x81}, depth), 0x00) // [[[...[0]...]]]
+	}
+
+	if _, _, err := decodeCBOR(nested(maxCBORDepth)); err != nil {
+		t.Fatalf("aninhamento de %d níveis deveria ser aceito: %v", maxCBORDepth, err)
+	}
+	// Sem o limite, esta entrada esgotaria a pilha da goroutine
+	if _, _, err := decodeCBOR(nested(1 << 20)); err == nil {
+		t.Fatal("aninhamento profundo deveria ser recusado")
+	}
+
+	tooMany := append(cborHead(4, maxCBORItems+1), make([]byte, maxCBORItems+1)...)
+	if _, _, err := decodeCBOR(tooMany); err == nil {
+		t.Fatalf("array com %d itens deveria ser recusado", maxCBORItems+1)
+	}
+	tooManyPairs := cborHead(5, maxCBORItems+1)
+	for i := 0; i <= maxCBORItems; i++ {
+		tooManyPairs = append(tooManyPairs, cborInt(int64(i))...)
+		tooManyPairs = append(tooManyPairs, 0x00)
+	}
+	if _, _, err := decodeCBOR(tooManyPairs); err == nil {
+		t.Fatalf("mapa com %d pares deveria ser recusado", maxCBORItems+1)
+	}
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// Arquivo é um arquivo anexado a uma prova/trabalho; o conteúdo fica no BlobStore
+type Arquivo struct {
+	ID          int       `json:"id"`
+	Nome        string    `json:"nome"`
+	ContentType string    `json:"content_type"`
+	Tamanho     int64     `json:"tamanho"`
+	SHA256      string    `json:"sha256"`
+	CreatedAt   time.Time `json:"created_at"`
+	StorageKey  string    `json:"-"`
+}
+
+const (
+	maxAttachmentsPerProva = 20
+	maxAttachmentNameRunes = 255
+)
+
+var (
+	// maxAttachmentSize é o tamanho máximo de cada arquivo, em bytes
+	maxAttachmentSize int64 = 10 << 20
+	// nextArquivoID é protegido por storeMu
+	nextArquivoID = 1
+)
+
+// allowedAttachmentTypes são os tipos aceitos, detectados pelo conteúdo e não pela extensão
+var allowedAttachmentTypes = map[string]bool{
+	"application/pdf":              true,
+	"image/png":                    true,
+	"image/jpeg":                   true,
+	"image/gif":                    true,
+	"image/webp":                   true,
+	"text/plain; charset=utf-8":    true,
+	"text/plain; charset=utf-16be": true,
+	"text/plain; charset=utf-16le": true,
+	"application/zip":              true,
+}
+
+// officeTypes refina arquivos do Office, que são ZIPs, pela extensão
+var officeTypes = map[string]string{
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".odt":  "application/vnd.oasis.opendocument.text",
+	".ods":  "application/vnd.oasis.opendocument.spreadsheet",
+	".odp":  "application/vnd.oasis.opendocument.presentation",
+}
+
+// attachmentError é uma recusa de upload, com o status e a mensagem da resposta
+type attachmentError struct {
+	status int
+	code   string
+	key    string
+	args   []interface{}
+}
+
+func (e *attachmentError) Error() string {
+	return fmt.Sprintf("%s %v", e.key, e.args)
+}
+
+// detectAttachmentType identifica o tipo pelo início do conteúdo; false se não for permitido
+func detectAttachmentType(head []byte, filename string) (string, bool) {
+	contentType := http.DetectContentType(head)
+	if !allowedAttachmentTypes[contentType] {
+		return contentType, false
+	}
+	if contentType == "application/zip" {
+		if office, ok := officeTypes[strings.ToLower(filepath.Ext(filename))]; ok {
+			return office, true
+		}
+	}
+	return contentType, true
+}
+
+// sanitizeFilename mantém só o nome base do arquivo enviado, sem caracteres de controle
+func sanitizeFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == "/" {
+		return "arquivo"
+	}
+	if utf8.RuneCountInString(name) > maxAttachmentNameRunes {
+		name = string([]rune(name)[:maxAttachmentNameRunes])
+	}
+	return name
+}
+
+func newStorageKey(tenantID, userID, provaID int) (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("tenants/%d/users/%d/provas/%d/%s", tenantID, userID, provaID, hex.EncodeToString(random)), nil
+}
+
+// storeUpload copia uma parte do multipart para um arquivo temporário, medindo o tamanho,
+// calculando o SHA-256 e detectando o tipo, e só então a envia ao BlobStore
+func storeUpload(ctx context.Context, part io.Reader, filename, key string) (Arquivo, error) {
+	tmp, err := os.CreateTemp("", "anexo-*")
+	if err != nil {
+		return Arquivo{}, err
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+
+	head := make([]byte, 512)
+	headLen, err := io.ReadFull(part, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return Arquivo{}, err
+	}
+	head = head[:headLen]
+	if headLen == 0 {
+		return Arquivo{}, &attachmentError{http.StatusBadRequest, "BAD_REQUEST", "attachment_empty", []interface{}{filename}}
+	}
+	contentType, allowed := detectAttachmentType(head, filename)
+	if !allowed {
+		return Arquivo{}, &attachmentError{http.StatusUnsupportedMediaType, "UNSUPPORTED_MEDIA_TYPE", "attachment_type", []interface{}{filename, contentType}}
+	}
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), io.LimitReader(io.MultiReader(bytes.NewReader(head), part), maxAttachmentSize+1))
+	if err != nil {
+		return Arquivo{}, err
+	}
+	if size > maxAttachmentSize {
+		return Arquivo{}, &attachmentError{http.StatusRequestEntityTooLarge, "PAYLOAD_TOO_LARGE", "attachment_too_large", []interface{}{filename, maxAttachmentSize}}
+	}
+	checksum := hex.EncodeToString(hasher.Sum(nil))
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return Arquivo{}, err
+	}
+	if err := blobStore.Put(ctx, key, tmp, size, contentType, checksum); err != nil {
+		return Arquivo{}, err
+	}
+
+	return Arquivo{
+		Nome:        filename,
+		ContentType: contentType,
+		Tamanho:     size,
+		SHA256:      checksum,
+		CreatedAt:   time.Now(),
+		StorageKey:  key,
+	}, nil
+}
+
+// deleteBlobs remove blobs que não são mais referenciados; falhas só são registradas
+func deleteBlobs(keys []string) {
+	for _, key := range keys {
+		if err := blobStore.Delete(context.Background(), key); err != nil {
+			log.Printf("Erro ao remover anexo %s do armazenamento: %v", key, err)
+		}
+	}
+}
+
+func uploadAnexosHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	storeMu.RLock()
+	prova := findProvaTrabalho(tenantID, userID, id)
+	existing := 0
+	if prova != nil {
+		existing = len(prova.Arquivos)
+	}
+	storeMu.RUnlock()
+	if prova == nil {
+		log.Printf("Tentativa de anexar arquivo a prova/trabalho inexistente: ID %d, User %d", id, userID)
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "prova_not_found")
+		return
+	}
+
+	// Limite do corpo inteiro: todos os arquivos que ainda cabem na prova, mais os cabeçalhos
+	remaining := maxAttachmentsPerProva - existing
+	r.Body = http.MaxBytesReader(w, r.Body, int64(remaining)*maxAttachmentSize+1<<20)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		writeErrorResponse(w, r, http.StatusBadRequest, "BAD_REQUEST", "attachment_multipart")
+		return
+	}
+
+	var uploaded []Arquivo
+	fail := func(err error) {
+		keys := make([]string, len(uploaded))
+		for i, arquivo := range uploaded {
+			keys[i] = arquivo.StorageKey
+		}
+		deleteBlobs(keys)
+
+		var rejected *attachmentError
+		if errors.As(err, &rejected) {
+			writeErrorResponse(w, r, rejected.status, rejected.code, rejected.key, rejected.args...)
+			return
+		}
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeErrorResponse(w, r, http.StatusRequestEntityTooLarge, "PAYLOAD_TOO_LARGE", "attachment_body_too_large")
+			return
+		}
+		log.Printf("Erro ao armazenar anexo da prova/trabalho %d do user %d: %v", id, userID, err)
+		writeErrorResponse(w, r, http.StatusServiceUnavailable, "STORAGE_UNAVAILABLE", "attachment_storage")
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fail(err)
+			return
+		}
+		if part.FileName() == "" {
+			part.Close()
+			continue
+		}
+		if len(uploaded) >= remaining {
+			part.Close()
+			fail(&attachmentError{http.StatusConflict, "CONFLICT", "attachment_limit", []interface{}{maxAttachmentsPerProva}})
+			return
+		}
+
+		key, err := newStorageKey(tenantID, userID, id)
+		if err != nil {
+			fail(err)
+			return
+		}
+		arquivo, err := storeUpload(r.Context(), part, sanitizeFilename(part.FileName()), key)
+		part.Close()
+		if err != nil {
+			fail(err)
+			return
+		}
+		uploaded = append(uploaded, arquivo)
+	}
+	if len(uploaded) == 0 {
+		writeErrorResponse(w, r, http.StatusBadRequest, "BAD_REQUEST", "attachment_multipart")
+		return
+	}
+
+	// A prova pode ter sido excluída ou recebido outros anexos durante o upload
+	storeMu.Lock()
+	prova = findProvaTrabalho(tenantID, userID, id)
+	if prova == nil || len(prova.Arquivos)+len(uploaded) > maxAttachmentsPerProva {
+		storeMu.Unlock()
+		if prova == nil {
+			fail(&attachmentError{http.StatusNotFound, "NOT_FOUND", "prova_not_found", nil})
+		} else {
+			fail(&attachmentError{http.StatusConflict, "CONFLICT", "attachment_limit", []interface{}{maxAttachmentsPerProva}})
+		}
+		return
+	}
+	for i := range uploaded {
+		uploaded[i].ID = nextArquivoID
+		nextArquivoID++
+	}
+	prova.Arquivos = append(prova.Arquivos, uploaded...)
+	prova.ImpersonatedBy = impersonatorID(r)
+	prova.Version++
+	prova.UpdatedAt = time.Now()
+	publishEvent(tenantID, userID, eventProvaUpdated, *prova)
+	version := prova.Version
+	storeMu.Unlock()
+
+	logUserMutation(r, userID, "UPLOAD", fmt.Sprintf("%d anexo(s) na prova-trabalho %d", len(uploaded), id))
+	w.Header().Set("ETag", entityTag(version))
+	writeSuccessResponse(w, r, "attachments_uploaded", uploaded)
+}
+
+// findArquivo retorna o anexo da prova/trabalho do usuário; storeMu deve estar travado
+func findArquivo(tenantID, userID, provaID, arquivoID int) (*ProvaTrabalho, int) {
+	prova := findProvaTrabalho(tenantID, userID, provaID)
+	if prova == nil {
+		return nil, -1
+	}
+	for i, arquivo := range prova.Arquivos {
+		if arquivo.ID == arquivoID {
+			return prova, i
+		}
+	}
+	return prova, -1
+}
+
+// parseRange interpreta um header Range com um único intervalo de bytes (RFC 9110). Pedidos
+// com vários intervalos ou inválidos são ignorados e o arquivo é enviado inteiro.
+func parseRange(header string, size int64) (start, length int64, partial, satisfiable bool) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, size, false, true
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, size, false, true
+	}
+
+	if first == "" {
+		// Sufixo: os últimos n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, size, false, true
+		}
+		if n == 0 {
+			return 0, 0, true, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, size, false, true
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, size, false, true
+		}
+		if end > size-1 {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return 0, 0, true, false
+	}
+	return start, end - start + 1, true, true
+}
+
+func downloadAnexoHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+	arquivoID, _ := strconv.Atoi(vars["anexoId"])
+
+	storeMu.RLock()
+	prova, index := findArquivo(tenantID, userID, id, arquivoID)
+	var arquivo Arquivo
+	if index >= 0 {
+		arquivo = prova.Arquivos[index]
+	}
+	storeMu.RUnlock()
+	if index < 0 {
+		log.Printf("Tentativa de baixar anexo inexistente: Anexo %d, Prova %d, User %d", arquivoID, id, userID)
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "attachment_not_found")
+		return
+	}
+
+	etag := `"` + arquivo.SHA256 + `"`
+	start, length, partial, satisfiable := int64(0), arquivo.Tamanho, false, true
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		// Com If-Range, o intervalo só vale se o arquivo ainda for o mesmo
+		if ifRange := r.Header.Get("If-Range"); ifRange == "" || ifRange == etag {
+			start, length, partial, satisfiable = parseRange(rangeHeader, arquivo.Tamanho)
+		}
+	}
+
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("ETag", etag)
+	if !satisfiable {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", arquivo.Tamanho))
+		writeErrorResponse(w, r, http.StatusRequestedRangeNotSatisfiable, "RANGE_NOT_SATISFIABLE", "attachment_range")
+		return
+	}
+
+	body, err := blobStore.Get(r.Context(), arquivo.StorageKey, start, length)
+	if err != nil {
+		if errors.Is(err, errBlobNotFound) {
+			log.Printf("Anexo %d da prova/trabalho %d sem conteúdo no armazenamento (%s)", arquivoID, id, arquivo.StorageKey)
+			writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "attachment_not_found")
+			return
+		}
+		log.Printf("Erro ao ler anexo %d da prova/trabalho %d: %v", arquivoID, id, err)
+		writeErrorResponse(w, r, http.StatusServiceUnavailable, "STORAGE_UNAVAILABLE", "attachment_storage")
+		return
+	}
+	defer body.Close()
+
+	checksum, _ := hex.DecodeString(arquivo.SHA256)
+	w.Header().Set("Content-Type", arquivo.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": arquivo.Nome}))
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.Header().Set("Repr-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(checksum)+":")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, no-cache")
+
+	status := http.StatusOK
+	if partial {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, arquivo.Tamanho))
+		status = http.StatusPartialContent
+	}
+	w.WriteHeader(status)
+
+	if _, err := io.Copy(w, body); err != nil {
+		log.Printf("Download do anexo %d interrompido para user %d: %v", arquivoID, userID, err)
+		return
+	}
+	logUserAction(userID, "DOWNLOAD", fmt.Sprintf("anexo %d da prova-trabalho %d", arquivoID, id))
+}
+
+func deleteAnexoHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+	arquivoID, _ := strconv.Atoi(vars["anexoId"])
+
+	storeMu.Lock()
+	prova, index := findArquivo(tenantID, userID, id, arquivoID)
+	if index < 0 {
+		storeMu.Unlock()
+		log.Printf("Tentativa de excluir anexo inexistente: Anexo %d, Prova %d, User %d", arquivoID, id, userID)
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "attachment_not_found")
+		return
+	}
+	key := prova.Arquivos[index].StorageKey
+	// Nova lista em vez de remover no lugar: cópias da prova feitas antes ainda podem estar
+	// sendo serializadas fora do lock
+	prova.Arquivos = append(prova.Arquivos[:index:index], prova.Arquivos[index+1:]...)
+	prova.ImpersonatedBy = impersonatorID(r)
+	prova.Version++
+	prova.UpdatedAt = time.Now()
+	publishEvent(tenantID, userID, eventProvaUpdated, *prova)
+	version := prova.Version
+	storeMu.Unlock()
+
+	deleteBlobs([]string{key})
+
+	logUserMutation(r, userID, "DELETE", fmt.Sprintf("anexo %d da prova-trabalho %d", arquivoID, id))
+	w.Header().Set("ETag", entityTag(version))
+	writeSuccessResponse(w, r, "attachment_deleted", nil)
+}
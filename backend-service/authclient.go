@@ -0,0 +1,297 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// authHTTPClient é compartilhado por todas as chamadas ao Auth Service, reaproveitando conexões.
+// Os prazos de cada chamada vêm do contexto (ver fetchTokenValidation).
+var authHTTPClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   2 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 100,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 2 * time.Second,
+	},
+}
+
+var (
+	// errTokenInvalid indica que o Auth Service recusou o token (resposta 4xx)
+	errTokenInvalid = errors.New("token inválido")
+	// errAuthUnavailable indica que não foi possível consultar o Auth Service
+	errAuthUnavailable = errors.New("auth service indisponível")
+)
+
+var (
+	authAttemptTimeout = 2 * time.Second
+	authCallBudget     = 5 * time.Second
+	authMaxAttempts    = 3
+	authRetryBaseDelay = 100 * time.Millisecond
+
+	authBreaker = newCircuitBreaker("auth-service", 5, 30*time.Second)
+
+	// Métricas das chamadas ao Auth Service
+	authCallsSucceeded atomic.Int64
+	authCallsRejected  atomic.Int64
+	authCallsFailed    atomic.Int64
+	authRetries        atomic.Int64
+	tokenCacheHits     atomic.Int64
+	tokenCacheMisses   atomic.Int64
+)
+
+var (
+	// tokenCacheTTL limita por quanto tempo uma validação é reaproveitada, mesmo que o token
+	// expire depois; assim mudanças no Auth Service (papel, tenant) chegam ao backend logo
+	tokenCacheTTL  = 60 * time.Second
+	tokenCacheSize = 10000
+
+	tokenCache  = newTokenValidationCache(tokenCacheSize)
+	tokenFlight = &validationGroup{calls: make(map[string]*validationCall)}
+)
+
+type tokenCacheEntry struct {
+	key       string
+	auth      AuthResponse
+	expiresAt time.Time
+}
+
+// tokenValidationCache é um LRU limitado de tokens já validados, indexado pelo hash do token
+type tokenValidationCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+func newTokenValidationCache(capacity int) *tokenValidationCache {
+	return &tokenValidationCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (c *tokenValidationCache) get(key string) (AuthResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return AuthResponse{}, false
+	}
+	entry := elem.Value.(*tokenCacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return AuthResponse{}, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.auth, true
+}
+
+func (c *tokenValidationCache) add(key string, auth AuthResponse, expiresAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = &tokenCacheEntry{key: key, auth: auth, expiresAt: expiresAt}
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&tokenCacheEntry{key: key, auth: auth, expiresAt: expiresAt})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*tokenCacheEntry).key)
+	}
+}
+
+type validationCall struct {
+	done chan struct{}
+	auth *AuthResponse
+	err  error
+}
+
+// validationGroup garante uma única chamada ao Auth Service por token em andamento;
+// requisições simultâneas com o mesmo token aguardam e compartilham o resultado
+type validationGroup struct {
+	mu    sync.Mutex
+	calls map[string]*validationCall
+}
+
+func (g *validationGroup) do(ctx context.Context, key string, fn func() (*AuthResponse, error)) (*AuthResponse, error) {
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.auth, call.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	call := &validationCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	call.auth, call.err = fn()
+	close(call.done)
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+
+	return call.auth, call.err
+}
+
+func tokenCacheKey(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// tokenExpiry lê a claim exp do JWT sem verificar a assinatura (quem verifica é o Auth
+// Service); serve apenas para não manter no cache um token depois que ele expira
+func tokenExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
+}
+
+// validateToken valida o token no Auth Service, usando o cache e agrupando chamadas simultâneas.
+// Retorna errTokenInvalid se o token foi recusado e errAuthUnavailable se o Auth Service
+// não pôde ser consultado (circuito aberto, timeout ou erro 5xx após as tentativas).
+func validateToken(ctx context.Context, token, requestID string) (*AuthResponse, error) {
+	key := tokenCacheKey(token)
+	if auth, ok := tokenCache.get(key); ok {
+		tokenCacheHits.Add(1)
+		return &auth, nil
+	}
+	tokenCacheMisses.Add(1)
+
+	return tokenFlight.do(ctx, key, func() (*AuthResponse, error) {
+		if !authBreaker.Allow() {
+			return nil, fmt.Errorf("%w: circuito aberto", errAuthUnavailable)
+		}
+
+		// A chamada é compartilhada com outras requisições, então não é cancelada se o
+		// cliente que a iniciou desistir; o prazo é o menor entre o orçamento da chamada e
+		// o prazo da requisição, se houver
+		deadline := time.Now().Add(authCallBudget)
+		if requestDeadline, ok := ctx.Deadline(); ok && requestDeadline.Before(deadline) {
+			deadline = requestDeadline
+		}
+		callCtx, cancel := context.WithDeadline(context.WithoutCancel(ctx), deadline)
+		defer cancel()
+
+		authResp, err := fetchTokenValidationWithRetry(callCtx, token, requestID)
+		switch {
+		case err == nil:
+			authBreaker.Success()
+			authCallsSucceeded.Add(1)
+		case errors.Is(err, errTokenInvalid):
+			// O Auth Service respondeu: está saudável, o token é que foi recusado
+			authBreaker.Success()
+			authCallsRejected.Add(1)
+			return nil, err
+		default:
+			authBreaker.Failure()
+			authCallsFailed.Add(1)
+			return nil, fmt.Errorf("%w: %v", errAuthUnavailable, err)
+		}
+
+		if tokenCacheTTL <= 0 {
+			return authResp, nil
+		}
+		expiresAt := time.Now().Add(tokenCacheTTL)
+		if exp, ok := tokenExpiry(token); ok && exp.Before(expiresAt) {
+			expiresAt = exp
+		}
+		tokenCache.add(key, *authResp, expiresAt)
+		return authResp, nil
+	})
+}
+
+// fetchTokenValidationWithRetry repete a validação (idempotente) em falhas de rede e
+// respostas 5xx, com backoff exponencial e jitter, até authMaxAttempts tentativas
+func fetchTokenValidationWithRetry(ctx context.Context, token, requestID string) (*AuthResponse, error) {
+	for attempt := 1; ; attempt++ {
+		authResp, err := fetchTokenValidation(ctx, token, requestID)
+		if err == nil || errors.Is(err, errTokenInvalid) || attempt >= authMaxAttempts {
+			return authResp, err
+		}
+
+		// Full jitter: espera aleatória entre 0 e base * 2^(tentativa-1)
+		delay := time.Duration(rand.Int63n(int64(authRetryBaseDelay) << (attempt - 1)))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, err
+		}
+		authRetries.Add(1)
+	}
+}
+
+func fetchTokenValidation(ctx context.Context, token, requestID string) (*AuthResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, authAttemptTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", authServiceURL+"/validate", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-Request-ID", requestID)
+
+	resp, err := authHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return nil, fmt.Errorf("auth service respondeu %d", resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errTokenInvalid
+	}
+
+	var authResp AuthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&authResp); err != nil {
+		return nil, err
+	}
+
+	return &authResp, nil
+}
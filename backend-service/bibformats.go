@@ -0,0 +1,858 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// bibSyntaxError aponta a linha em que a leitura de um arquivo BibTeX ou RIS falhou
+type bibSyntaxError struct {
+	line int
+}
+
+func (e *bibSyntaxError) Error() string {
+	return fmt.Sprintf("erro de sintaxe na linha %d", e.line)
+}
+
+// foldText deixa o texto em minúsculas e sem acentos, para comparações e ordenação
+func foldText(text string) string {
+	return strings.Map(func(r rune) rune {
+		r = unicode.ToLower(r)
+		if folded, ok := accentFolding[r]; ok {
+			return folded
+		}
+		return r
+	}, text)
+}
+
+var yearPattern = regexp.MustCompile(`\d{4}`)
+
+func yearFrom(text string) int {
+	year, _ := strconv.Atoi(yearPattern.FindString(text))
+	return year
+}
+
+// nameSuffixes fazem parte do sobrenome na entrada ABNT (ex.: "SILVA FILHO, João")
+var nameSuffixes = map[string]bool{"filho": true, "junior": true, "jr": true, "jr.": true, "neto": true, "sobrinho": true}
+
+// invertName converte "Nome Sobrenome" em "Sobrenome, Nome"; partículas como "da" ficam com o
+// prenome, como pede a ABNT ("Silva, João da")
+func invertName(name string) string {
+	words := strings.Fields(name)
+	if len(words) < 2 {
+		return name
+	}
+	surname := len(words) - 1
+	if len(words) > 2 && nameSuffixes[foldText(words[surname])] {
+		surname--
+	}
+	return strings.Join(words[surname:], " ") + ", " + strings.Join(words[:surname], " ")
+}
+
+// ---- BibTeX ----
+
+var bibtexTipos = map[string]string{
+	"book": refTipoLivro, "booklet": refTipoLivro,
+	"inbook": refTipoCapitulo, "incollection": refTipoCapitulo, "inproceedings": refTipoCapitulo, "conference": refTipoCapitulo,
+	"article": refTipoArtigo,
+	"online":  refTipoSite, "electronic": refTipoSite, "www": refTipoSite, "webpage": refTipoSite,
+	"phdthesis": refTipoTese, "thesis": refTipoTese,
+	"mastersthesis": refTipoDissertacao,
+}
+
+var (
+	latexDotlessI = regexp.MustCompile(`\\i\b\s*`)
+	latexAccent   = regexp.MustCompile(`\\([` + "`" + `'^~"c])\s*(?:\{\s*([A-Za-z])\s*\}|([A-Za-z]))`)
+	latexCommand  = regexp.MustCompile(`\\[A-Za-z]+\*?\s*`)
+	latexSpecials = strings.NewReplacer(`\&`, "&", `\%`, "%", `\$`, "$", `\#`, "#", `\_`, "_", `\{`, "", `\}`, "", `\\`, " ")
+	latexDashes   = strings.NewReplacer("---", "—", "--", "–", "~", " ")
+	latexEscape   = strings.NewReplacer(`\`, `\textbackslash{}`, "{", `\{`, "}", `\}`, "&", `\&`, "%", `\%`, "$", `\$`, "#", `\#`, "_", `\_`, "~", `\textasciitilde{}`, "^", `\textasciicircum{}`)
+)
+
+// latexAccents mapeia acento LaTeX + letra para o caractere acentuado (ex.: "'a" → 'á')
+var latexAccents = func() map[string]rune {
+	table := map[string]string{
+		"'":  "aáeéiíoóuúyýAÁEÉIÍOÓUÚYÝ",
+		"`":  "aàeèiìoòuùAÀEÈIÌOÒUÙ",
+		"^":  "aâeêiîoôuûAÂEÊIÎOÔUÛ",
+		"~":  "aãoõnñAÃOÕNÑ",
+		"\"": "aäeëiïoöuüAÄEËIÏOÖUÜ",
+		"c":  "cçCÇ",
+	}
+	accents := make(map[string]rune)
+	for accent, pairs := range table {
+		runes := []rune(pairs)
+		for i := 0; i+1 < len(runes); i += 2 {
+			accents[accent+string(runes[i])] = runes[i+1]
+		}
+	}
+	return accents
+}()
+
+// latexToText converte um valor BibTeX em texto: acentos LaTeX viram caracteres acentuados e
+// comandos e chaves são removidos
+func latexToText(value string) string {
+	value = latexDotlessI.ReplaceAllString(value, "i")
+	value = latexAccent.ReplaceAllStringFunc(value, func(match string) string {
+		parts := latexAccent.FindStringSubmatch(match)
+		if accented, ok := latexAccents[parts[1]+parts[2]+parts[3]]; ok {
+			return string(accented)
+		}
+		return match
+	})
+	value = latexSpecials.Replace(value)
+	value = latexCommand.ReplaceAllString(value, "")
+	value = strings.NewReplacer("{", "", "}", "").Replace(value)
+	return strings.Join(strings.Fields(latexDashes.Replace(value)), " ")
+}
+
+// wholeBraced informa se o valor inteiro está entre um único par de chaves, como em
+// author = {{Associação Brasileira de Normas Técnicas}}
+func wholeBraced(value string) bool {
+	if len(value) < 2 || value[0] != '{' || value[len(value)-1] != '}' {
+		return false
+	}
+	depth := 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 && i < len(value)-1 {
+				return false
+			}
+		}
+	}
+	return depth == 0
+}
+
+// bibtexAuthors separa um campo author pelos "and" fora de chaves
+func bibtexAuthors(raw string) []string {
+	var tokens []string
+	depth, start := 0, -1
+	for i, c := range raw {
+		if unicode.IsSpace(c) && depth == 0 {
+			if start >= 0 {
+				tokens = append(tokens, raw[start:i])
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+		switch c {
+		case '{':
+			depth++
+		case '}':
+			if depth > 0 {
+				depth--
+			}
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, raw[start:])
+	}
+
+	var authors []string
+	var group []string
+	flush := func() {
+		name := strings.Join(group, " ")
+		group = nil
+		switch {
+		case name == "" || strings.EqualFold(name, "others"):
+		case wholeBraced(name):
+			authors = append(authors, latexToText(name))
+		case strings.Contains(name, ","):
+			authors = append(authors, latexToText(name))
+		default:
+			authors = append(authors, invertName(latexToText(name)))
+		}
+	}
+	for _, token := range tokens {
+		if strings.EqualFold(token, "and") {
+			flush()
+			continue
+		}
+		group = append(group, token)
+	}
+	flush()
+	return authors
+}
+
+type bibtexParser struct {
+	src string
+	pos int
+}
+
+func (p *bibtexParser) fail() error {
+	return &bibSyntaxError{line: strings.Count(p.src[:min(p.pos, len(p.src))], "\n") + 1}
+}
+
+func (p *bibtexParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *bibtexParser) skipSpace() {
+	for !p.eof() && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *bibtexParser) ident() string {
+	start := p.pos
+	for !p.eof() {
+		c := p.src[p.pos]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("_-.:+/", c) >= 0) {
+			break
+		}
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+// balanced lê de open até o close correspondente e devolve o conteúdo entre eles
+func (p *bibtexParser) balanced(open, close byte) (string, error) {
+	start := p.pos + 1
+	depth := 0
+	for ; !p.eof(); p.pos++ {
+		switch p.src[p.pos] {
+		case open:
+			depth++
+		case close:
+			depth--
+			if depth == 0 {
+				p.pos++
+				return p.src[start : p.pos-1], nil
+			}
+		}
+	}
+	return "", p.fail()
+}
+
+// value lê o valor de um campo: {...}, "..." ou um número/macro, concatenados com #
+func (p *bibtexParser) value() (string, error) {
+	var parts []string
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return "", p.fail()
+		}
+		switch c := p.src[p.pos]; {
+		case c == '{':
+			part, err := p.balanced('{', '}')
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, part)
+		case c == '"':
+			p.pos++
+			start, depth := p.pos, 0
+			for ; !p.eof() && (p.src[p.pos] != '"' || depth > 0); p.pos++ {
+				switch p.src[p.pos] {
+				case '{':
+					depth++
+				case '}':
+					depth--
+				}
+			}
+			if p.eof() {
+				return "", p.fail()
+			}
+			parts = append(parts, p.src[start:p.pos])
+			p.pos++
+		default:
+			part := p.ident()
+			if part == "" {
+				return "", p.fail()
+			}
+			parts = append(parts, part)
+		}
+		p.skipSpace()
+		if p.eof() || p.src[p.pos] != '#' {
+			return strings.Join(parts, ""), nil
+		}
+		p.pos++
+	}
+}
+
+// parseBibTeX lê as entradas de um arquivo BibTeX; @comment, @preamble e @string são ignorados
+func parseBibTeX(src string) ([]Referencia, error) {
+	p := &bibtexParser{src: src}
+	var refs []Referencia
+	for {
+		at := strings.IndexByte(p.src[p.pos:], '@')
+		if at < 0 {
+			return refs, nil
+		}
+		p.pos += at + 1
+		p.skipSpace()
+		entryType := strings.ToLower(p.ident())
+		p.skipSpace()
+		if p.eof() || (p.src[p.pos] != '{' && p.src[p.pos] != '(') {
+			return nil, p.fail()
+		}
+		open, close := p.src[p.pos], byte('}')
+		if open == '(' {
+			close = ')'
+		}
+		if entryType == "comment" || entryType == "preamble" || entryType == "string" {
+			if _, err := p.balanced(open, close); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// Chave de citação, que não é guardada
+		p.pos++
+		for !p.eof() && p.src[p.pos] != ',' && p.src[p.pos] != close {
+			p.pos++
+		}
+		fields := map[string]string{}
+		for {
+			p.skipSpace()
+			if p.eof() {
+				return nil, p.fail()
+			}
+			if p.src[p.pos] == ',' {
+				p.pos++
+				continue
+			}
+			if p.src[p.pos] == close {
+				p.pos++
+				break
+			}
+			name := strings.ToLower(p.ident())
+			p.skipSpace()
+			if name == "" || p.eof() || p.src[p.pos] != '=' {
+				return nil, p.fail()
+			}
+			p.pos++
+			value, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			fields[name] = value
+		}
+		refs = append(refs, bibtexReferencia(entryType, fields))
+	}
+}
+
+func bibtexReferencia(entryType string, fields map[string]string) Referencia {
+	field := func(names ...string) string {
+		for _, name := range names {
+			if value := latexToText(fields[name]); value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+
+	ref := Referencia{
+		Tipo:      bibtexTipos[entryType],
+		Autores:   bibtexAuthors(fields["author"]),
+		Titulo:    field("title"),
+		Ano:       yearFrom(field("year", "date")),
+		Edicao:    field("edition"),
+		Local:     field("address", "location"),
+		Editora:   field("publisher"),
+		Periodico: field("journal", "journaltitle", "booktitle"),
+		Volume:    field("volume"),
+		Numero:    field("number", "issue"),
+		Paginas:   field("pages"),
+		DOI:       field("doi"),
+		ISBN:      field("isbn"),
+		URL:       strings.TrimSpace(fields["url"]),
+	}
+	if subtitle := field("subtitle"); subtitle != "" {
+		ref.Titulo += ": " + subtitle
+	}
+	if ref.Tipo == "" {
+		// @misc com URL e sem editora é, quase sempre, uma página da web
+		ref.Tipo = refTipoOutro
+		if ref.URL != "" && ref.Editora == "" {
+			ref.Tipo = refTipoSite
+		}
+	}
+	if ref.Tipo == refTipoTese || ref.Tipo == refTipoDissertacao {
+		ref.Editora = field("school", "institution")
+	}
+	if accessed := field("urldate"); accessed != "" {
+		if _, err := time.Parse("2006-01-02", accessed); err == nil {
+			ref.AcessadoEm = accessed
+		}
+	}
+	return ref
+}
+
+var bibtexEntryTypes = map[string]string{
+	refTipoLivro: "book", refTipoCapitulo: "incollection", refTipoArtigo: "article", refTipoSite: "misc",
+	refTipoTese: "phdthesis", refTipoDissertacao: "mastersthesis", refTipoOutro: "misc",
+}
+
+var citationKeyChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// bibtexKey monta a chave de citação sobrenome + ano + primeira palavra do título
+// (ex.: silva2020calculo), numerando as repetidas
+func bibtexKey(ref Referencia, used map[string]int) string {
+	key := "ref"
+	if len(ref.Autores) > 0 {
+		surname, _, _ := strings.Cut(ref.Autores[0], ",")
+		if words := strings.Fields(surname); len(words) > 0 {
+			if word := citationKeyChars.ReplaceAllString(foldText(words[0]), ""); word != "" {
+				key = word
+			}
+		}
+	}
+	if ref.Ano > 0 {
+		key += strconv.Itoa(ref.Ano)
+	}
+	for _, word := range strings.Fields(foldText(ref.Titulo)) {
+		if word = citationKeyChars.ReplaceAllString(word, ""); len(word) >= 4 {
+			key += word
+			break
+		}
+	}
+
+	n := used[key]
+	used[key]++
+	if n > 0 {
+		return fmt.Sprintf("%s-%d", key, n+1)
+	}
+	return key
+}
+
+// formatBibTeX exporta as referências como entradas BibTeX em UTF-8
+func formatBibTeX(list []Referencia) string {
+	var b strings.Builder
+	used := map[string]int{}
+	for i, ref := range list {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "@%s{%s,\n", bibtexEntryTypes[ref.Tipo], bibtexKey(ref, used))
+		field := func(name, value string) {
+			if value != "" {
+				fmt.Fprintf(&b, "  %s = {%s},\n", name, value)
+			}
+		}
+
+		authors := make([]string, len(ref.Autores))
+		for i, autor := range ref.Autores {
+			authors[i] = latexEscape.Replace(autor)
+			if !strings.Contains(autor, ",") {
+				// Entidades entre chaves, para que o BibTeX não as separe em nome e sobrenome
+				authors[i] = "{" + authors[i] + "}"
+			}
+		}
+		field("author", strings.Join(authors, " and "))
+		field("title", latexEscape.Replace(ref.Titulo))
+		if ref.Tipo == refTipoArtigo {
+			field("journal", latexEscape.Replace(ref.Periodico))
+		} else {
+			field("booktitle", latexEscape.Replace(ref.Periodico))
+		}
+		field("edition", latexEscape.Replace(ref.Edicao))
+		field("volume", latexEscape.Replace(ref.Volume))
+		field("number", latexEscape.Replace(ref.Numero))
+		field("pages", strings.ReplaceAll(latexEscape.Replace(ref.Paginas), "-", "--"))
+		if ref.Tipo == refTipoTese || ref.Tipo == refTipoDissertacao {
+			field("school", latexEscape.Replace(ref.Editora))
+		} else {
+			field("publisher", latexEscape.Replace(ref.Editora))
+		}
+		field("address", latexEscape.Replace(ref.Local))
+		if ref.Ano > 0 {
+			field("year", strconv.Itoa(ref.Ano))
+		}
+		field("doi", ref.DOI)
+		field("isbn", ref.ISBN)
+		field("url", ref.URL)
+		field("urldate", ref.AcessadoEm)
+		b.WriteString("}\n")
+	}
+	return b.String()
+}
+
+// ---- RIS ----
+
+var risTipos = map[string]string{
+	"BOOK": refTipoLivro, "EBOOK": refTipoLivro,
+	"CHAP": refTipoCapitulo, "ECHAP": refTipoCapitulo, "CONF": refTipoCapitulo, "CPAPER": refTipoCapitulo,
+	"JOUR": refTipoArtigo, "EJOUR": refTipoArtigo, "MGZN": refTipoArtigo, "NEWS": refTipoArtigo,
+	"ELEC": refTipoSite, "WEB": refTipoSite, "BLOG": refTipoSite,
+	"THES": refTipoTese,
+}
+
+var risEntryTypes = map[string]string{
+	refTipoLivro: "BOOK", refTipoCapitulo: "CHAP", refTipoArtigo: "JOUR", refTipoSite: "ELEC",
+	refTipoTese: "THES", refTipoDissertacao: "THES", refTipoOutro: "GEN",
+}
+
+var risLinePattern = regexp.MustCompile(`^([A-Z][A-Z0-9])  -(?: (.*))?$`)
+
+// parseRIS lê as entradas de um arquivo RIS (TY ... ER); linhas sem tag continuam o campo anterior
+func parseRIS(src string) ([]Referencia, error) {
+	var refs []Referencia
+	var fields map[string][]string
+	var entryType, lastTag string
+	lines := strings.Split(strings.TrimPrefix(src, "\ufeff"), "\n")
+	for i, line := range lines {
+		line = strings.TrimRight(line, " \r\t")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		match := risLinePattern.FindStringSubmatch(line)
+		if match == nil {
+			if fields == nil || lastTag == "" {
+				return nil, &bibSyntaxError{line: i + 1}
+			}
+			values := fields[lastTag]
+			values[len(values)-1] += " " + strings.TrimSpace(line)
+			continue
+		}
+
+		tag, value := match[1], strings.TrimSpace(match[2])
+		switch {
+		case tag == "TY":
+			if fields != nil {
+				return nil, &bibSyntaxError{line: i + 1}
+			}
+			fields, entryType, lastTag = map[string][]string{}, value, ""
+		case fields == nil:
+			return nil, &bibSyntaxError{line: i + 1}
+		case tag == "ER":
+			refs = append(refs, risReferencia(entryType, fields))
+			fields = nil
+		default:
+			fields[tag] = append(fields[tag], value)
+			lastTag = tag
+		}
+	}
+	if fields != nil {
+		return nil, &bibSyntaxError{line: len(lines)}
+	}
+	return refs, nil
+}
+
+func risReferencia(entryType string, fields map[string][]string) Referencia {
+	first := func(tags ...string) string {
+		for _, tag := range tags {
+			for _, value := range fields[tag] {
+				if value != "" {
+					return value
+				}
+			}
+		}
+		return ""
+	}
+
+	ref := Referencia{
+		Tipo:      risTipos[entryType],
+		Autores:   append(append([]string{}, fields["AU"]...), fields["A1"]...),
+		Titulo:    first("TI", "T1"),
+		Ano:       yearFrom(first("PY", "Y1", "DA")),
+		Edicao:    first("ET"),
+		Local:     first("CY"),
+		Editora:   first("PB"),
+		Periodico: first("T2", "JO", "JF", "BT", "JA"),
+		Volume:    first("VL"),
+		Numero:    first("IS"),
+		Paginas:   first("SP"),
+		DOI:       first("DO"),
+		URL:       first("UR", "L2"),
+	}
+	if ref.Tipo == "" {
+		ref.Tipo = refTipoOutro
+	}
+	if degree := foldText(first("M3")); ref.Tipo == refTipoTese && (strings.Contains(degree, "mestrado") || strings.Contains(degree, "master")) {
+		ref.Tipo = refTipoDissertacao
+	}
+	if end := first("EP"); end != "" && ref.Paginas != "" && !strings.Contains(ref.Paginas, "-") {
+		ref.Paginas += "-" + end
+	}
+	// SN também guarda ISSN em artigos; só fica se for um ISBN válido
+	if isbn := normalizeReferencia(Referencia{ISBN: first("SN")}).ISBN; validISBN(isbn) {
+		ref.ISBN = isbn
+	}
+	if accessed := strings.TrimRight(strings.ReplaceAll(first("Y2"), "/", "-"), "-"); accessed != "" {
+		if _, err := time.Parse("2006-01-02", accessed); err == nil {
+			ref.AcessadoEm = accessed
+		}
+	}
+	return ref
+}
+
+// formatRIS exporta as referências em RIS, com quebras de linha CRLF como pede o formato
+func formatRIS(list []Referencia) string {
+	var b strings.Builder
+	for _, ref := range list {
+		line := func(tag, value string) {
+			if value != "" {
+				fmt.Fprintf(&b, "%s  - %s\r\n", tag, value)
+			}
+		}
+		line("TY", risEntryTypes[ref.Tipo])
+		for _, autor := range ref.Autores {
+			line("AU", autor)
+		}
+		line("TI", ref.Titulo)
+		if ref.Tipo == refTipoArtigo {
+			line("JO", ref.Periodico)
+		} else {
+			line("T2", ref.Periodico)
+		}
+		if ref.Ano > 0 {
+			line("PY", strconv.Itoa(ref.Ano))
+		}
+		line("ET", ref.Edicao)
+		line("VL", ref.Volume)
+		line("IS", ref.Numero)
+		start, end, _ := strings.Cut(ref.Paginas, "-")
+		line("SP", start)
+		line("EP", end)
+		line("PB", ref.Editora)
+		line("CY", ref.Local)
+		switch ref.Tipo {
+		case refTipoTese:
+			line("M3", "Tese (Doutorado)")
+		case refTipoDissertacao:
+			line("M3", "Dissertação (Mestrado)")
+		}
+		line("DO", ref.DOI)
+		line("SN", ref.ISBN)
+		line("UR", ref.URL)
+		line("Y2", strings.ReplaceAll(ref.AcessadoEm, "-", "/"))
+		b.WriteString("ER  - \r\n\r\n")
+	}
+	return b.String()
+}
+
+// ---- ABNT (NBR 6023) ----
+
+var abntMonths = [...]string{"jan.", "fev.", "mar.", "abr.", "maio", "jun.", "jul.", "ago.", "set.", "out.", "nov.", "dez."}
+
+// abntWriter escreve os elementos da referência como texto puro ou como HTML, com o destaque
+// (negrito) em <strong>
+type abntWriter struct {
+	html bool
+}
+
+func (w abntWriter) text(s string) string {
+	if w.html {
+		return html.EscapeString(s)
+	}
+	return s
+}
+
+func (w abntWriter) bold(s string) string {
+	if w.html {
+		return "<strong>" + html.EscapeString(s) + "</strong>"
+	}
+	return s
+}
+
+// period é o ponto que encerra um elemento, omitido se o texto já termina em pontuação
+func period(s string) string {
+	if strings.HasSuffix(s, ".") || strings.HasSuffix(s, "?") || strings.HasSuffix(s, "!") {
+		return ""
+	}
+	return "."
+}
+
+// abntAuthors lista até três autores como "SOBRENOME, Nome"; com mais de três, o primeiro
+// seguido de "et al."
+func abntAuthors(autores []string) string {
+	format := func(autor string) string {
+		surname, given, found := strings.Cut(autor, ",")
+		if !found {
+			return strings.ToUpper(autor)
+		}
+		if given = strings.TrimSpace(given); given == "" {
+			return strings.ToUpper(strings.TrimSpace(surname))
+		}
+		return strings.ToUpper(strings.TrimSpace(surname)) + ", " + given
+	}
+	if len(autores) > 3 {
+		return format(autores[0]) + " et al."
+	}
+	names := make([]string, len(autores))
+	for i, autor := range autores {
+		names[i] = format(autor)
+	}
+	return strings.Join(names, "; ")
+}
+
+// titleEntry destaca a primeira palavra do título, usada como entrada quando não há autor
+func titleEntry(title string) string {
+	if i := strings.IndexFunc(title, unicode.IsSpace); i > 0 {
+		return strings.ToUpper(title[:i]) + title[i:]
+	}
+	return strings.ToUpper(title)
+}
+
+func abntYear(ano int) string {
+	if ano == 0 {
+		return "[s. d.]"
+	}
+	return strconv.Itoa(ano)
+}
+
+func abntEdition(edicao string) string {
+	number := strings.TrimRight(edicao, "ªºa.")
+	if _, err := strconv.Atoi(number); err == nil {
+		if number == "1" {
+			return ""
+		}
+		return number + ". ed."
+	}
+	if edicao == "" {
+		return ""
+	}
+	return edicao + period(edicao)
+}
+
+// abntImprint é a publicação: "Local: Editora, ano.", com [S. l.] e [s. n.] para o que falta
+func abntImprint(ref Referencia) string {
+	local, editora := ref.Local, ref.Editora
+	if local == "" {
+		local = "[S. l.]"
+	}
+	if editora == "" {
+		editora = "[s. n.]"
+	}
+	return local + ": " + editora + ", " + abntYear(ref.Ano) + "."
+}
+
+func abntEntry(ref Referencia, w abntWriter) string {
+	var parts []string
+	add := func(rendered string) {
+		if rendered != "" {
+			parts = append(parts, rendered)
+		}
+	}
+
+	authors := abntAuthors(ref.Autores)
+	if authors != "" {
+		add(w.text(authors + period(authors)))
+	}
+	// Título destacado em negrito; sem autor, a entrada é o próprio título, sem destaque
+	mainTitle := func() string {
+		if authors == "" {
+			return w.text(titleEntry(ref.Titulo) + period(ref.Titulo))
+		}
+		return w.bold(ref.Titulo) + period(ref.Titulo)
+	}
+	plainTitle := func() string {
+		if authors == "" {
+			return w.text(titleEntry(ref.Titulo) + period(ref.Titulo))
+		}
+		return w.text(ref.Titulo + period(ref.Titulo))
+	}
+
+	switch ref.Tipo {
+	case refTipoCapitulo:
+		add(plainTitle())
+		if ref.Periodico != "" {
+			add(w.text("In: ") + w.bold(ref.Periodico) + period(ref.Periodico))
+		}
+		add(w.text(abntEdition(ref.Edicao)))
+		add(w.text(abntImprint(ref)))
+		if ref.Paginas != "" {
+			add(w.text("p. " + ref.Paginas + "."))
+		}
+	case refTipoArtigo:
+		add(plainTitle())
+		details := []string{}
+		for _, detail := range [][2]string{{"", ref.Local}, {"v. ", ref.Volume}, {"n. ", ref.Numero}, {"p. ", ref.Paginas}} {
+			if detail[1] != "" {
+				details = append(details, detail[0]+detail[1])
+			}
+		}
+		details = append(details, abntYear(ref.Ano))
+		if ref.Periodico != "" {
+			add(w.bold(ref.Periodico) + w.text(", "+strings.Join(details, ", ")+"."))
+		} else {
+			add(w.text(strings.Join(details, ", ") + "."))
+		}
+	case refTipoSite:
+		add(mainTitle())
+		if ref.Ano > 0 {
+			add(w.text(strconv.Itoa(ref.Ano) + "."))
+		}
+	case refTipoTese, refTipoDissertacao:
+		add(mainTitle())
+		add(w.text(abntYear(ref.Ano) + "."))
+		academic := "Tese (Doutorado)"
+		if ref.Tipo == refTipoDissertacao {
+			academic = "Dissertação (Mestrado)"
+		}
+		if ref.Editora != "" {
+			academic += " – " + ref.Editora
+		}
+		if ref.Local != "" {
+			academic += ", " + ref.Local
+		}
+		if ref.Ano > 0 {
+			academic += ", " + strconv.Itoa(ref.Ano)
+		}
+		add(w.text(academic + "."))
+	case refTipoLivro:
+		add(mainTitle())
+		add(w.text(abntEdition(ref.Edicao)))
+		add(w.text(abntImprint(ref)))
+	default:
+		add(mainTitle())
+		add(w.text(abntEdition(ref.Edicao)))
+		if ref.Local != "" || ref.Editora != "" {
+			add(w.text(abntImprint(ref)))
+		} else if ref.Ano > 0 {
+			add(w.text(strconv.Itoa(ref.Ano) + "."))
+		}
+	}
+
+	if ref.DOI != "" {
+		add(w.text("DOI: " + ref.DOI + "."))
+	}
+	if ref.URL != "" {
+		add(w.text("Disponível em: " + ref.URL + "."))
+		if accessed, err := time.Parse("2006-01-02", ref.AcessadoEm); err == nil {
+			add(w.text(fmt.Sprintf("Acesso em: %d %s %d.", accessed.Day(), abntMonths[accessed.Month()-1], accessed.Year())))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// formatABNT formata as referências conforme a NBR 6023, em ordem alfabética; em HTML, cada
+// referência é um parágrafo com o destaque em <strong>
+func formatABNT(list []Referencia, asHTML bool) string {
+	type entry struct {
+		sortKey, text string
+	}
+	entries := make([]entry, len(list))
+	for i, ref := range list {
+		plain := abntEntry(ref, abntWriter{})
+		entries[i] = entry{sortKey: foldText(plain), text: plain}
+		if asHTML {
+			entries[i].text = "<p>" + abntEntry(ref, abntWriter{html: true}) + "</p>"
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].sortKey < entries[j].sortKey })
+
+	var b strings.Builder
+	for i, e := range entries {
+		if i > 0 && !asHTML {
+			b.WriteString("\n")
+		}
+		b.WriteString(e.text)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "regrava os arquivos esperados em testdata")
+
+// checkGolden compara got com testdata/bibliografia/name; com -update, grava got no arquivo
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+
+	path := filepath.Join("testdata", "bibliografia", name)
+	if *updateGolden {
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatalf("gravar %s: %v", path, err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ler %s: %v", path, err)
+	}
+	if got != string(want) {
+		t.Errorf("%s difere do esperado:\n--- obtido\n%s\n--- esperado\n%s", name, got, want)
+	}
+}
+
+// importTestdata lê um arquivo de testdata/bibliografia como o POST .../bibliografia
+func importTestdata(t *testing.T, name string) []Referencia {
+	t.Helper()
+
+	src, err := os.ReadFile(filepath.Join("testdata", "bibliografia", name))
+	if err != nil {
+		t.Fatalf("ler %s: %v", name, err)
+	}
+	var refs []Referencia
+	if filepath.Ext(name) == ".bib" {
+		refs, err = parseBibTeX(string(src))
+	} else {
+		refs, err = parseRIS(string(src))
+	}
+	if err != nil {
+		t.Fatalf("importar %s: %v", name, err)
+	}
+	if details := validateBibliografia(refs); len(details) > 0 {
+		t.Fatalf("%s: referências inválidas: %+v", name, details)
+	}
+	return normalizeBibliografia(refs)
+}
+
+func TestBibliografiaGolden(t *testing.T) {
+	for _, input := range []string{"entrada.bib", "entrada.ris"} {
+		t.Run(input, func(t *testing.T) {
+			refs := importTestdata(t, input)
+			prefix := strings.TrimPrefix(filepath.Ext(input), ".")
+
+			imported, _ := json.MarshalIndent(refs, "", "  ")
+			checkGolden(t, prefix+"-importado.json", string(imported)+"\n")
+			checkGolden(t, prefix+"-exportado.bib", formatBibTeX(refs))
+			checkGolden(t, prefix+"-exportado.ris", formatRIS(refs))
+			checkGolden(t, prefix+"-abnt.txt", formatABNT(refs, false))
+			checkGolden(t, prefix+"-abnt.html", formatABNT(refs, true))
+
+			// O que é exportado em BibTeX e RIS volta igual na reimportação
+			for format, exported := range map[string]string{"bibtex": formatBibTeX(refs), "ris": formatRIS(refs)} {
+				var again []Referencia
+				var err error
+				if format == "bibtex" {
+					again, err = parseBibTeX(exported)
+				} else {
+					again, err = parseRIS(exported)
+				}
+				if err != nil {
+					t.Fatalf("reimportar %s: %v", format, err)
+				}
+				if again = normalizeBibliografia(again); !reflect.DeepEqual(again, refs) {
+					t.Errorf("reimportação do %s difere:\n%+v\nesperado\n%+v", format, again, refs)
+				}
+			}
+		})
+	}
+}
+
+func TestInvertName(t *testing.T) {
+	tests := map[string]string{
+		"João da Silva":             "Silva, João da",
+		"Maria Conceição Souza":     "Souza, Maria Conceição",
+		"José Pereira Filho":        "Pereira Filho, José",
+		"Carlos Alberto Santos Jr.": "Santos Jr., Carlos Alberto",
+		"Pedro Neto":                "Neto, Pedro",
+		"Aristóteles":               "Aristóteles",
+	}
+	for name, want := range tests {
+		if got := invertName(name); got != want {
+			t.Errorf("invertName(%q) = %q; esperado %q", name, got, want)
+		}
+	}
+}
+
+func TestLatexToText(t *testing.T) {
+	tests := map[string]string{
+		`Jo{\~a}o`:                      "João",
+		`Jo\~{a}o`:                      "João",
+		`Concei\c{c}\~ao`:               "Conceição",
+		`{\c C}ear{\'a}`:                "Çeará",
+		`Bras{\'\i}lia`:                 "Brasília",
+		`M{\"u}ller`:                    "Müller",
+		`\^{E}xito \` + "`" + `a vista`: "Êxito à vista",
+		`P\&D em 50\%`:                  "P&D em 50%",
+		`{NBR} 6023 --- refer\^encias`:  "NBR 6023 — referências",
+		`p.~10--20`:                     "p. 10–20",
+		`\emph{ênfase}  e   espaços`:    "ênfase e espaços",
+	}
+	for value, want := range tests {
+		if got := latexToText(value); got != want {
+			t.Errorf("latexToText(%q) = %q; esperado %q", value, got, want)
+		}
+	}
+}
+
+func TestBibtexAuthors(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want []string
+	}{
+		{"João da Silva and Pereira Filho, José", []string{"Silva, João da", "Pereira Filho, José"}},
+		{"{Associação Brasileira de Normas Técnicas} and others", []string{"Associação Brasileira de Normas Técnicas"}},
+		{"{Barnes and Noble} AND Ana   Costa", []string{"Barnes and Noble", "Costa, Ana"}},
+		{"Sand, Alexandre and Anderson Brandão", []string{"Sand, Alexandre", "Brandão, Anderson"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := bibtexAuthors(tt.raw); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("bibtexAuthors(%q) = %q; esperado %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestABNTAuthorsAndEdition(t *testing.T) {
+	authors := map[string][]string{
+		"SILVA, João da":                           {"Silva, João da"},
+		"SILVA, João da; PEREIRA FILHO, José":      {"Silva, João da", "Pereira Filho, José"},
+		"ASSOCIAÇÃO BRASILEIRA DE NORMAS TÉCNICAS": {"Associação Brasileira de Normas Técnicas"},
+		"COSTA, Ana et al.":                        {"Costa, Ana", "Lima, Bruno", "Dias, Carla", "Rocha, Daniel"},
+		"COSTA, Ana; LIMA, Bruno; DIAS, Carla":     {"Costa, Ana", "Lima, Bruno", "Dias, Carla"},
+		"ÁVILA":                                    {"Ávila, "},
+	}
+	for want, autores := range authors {
+		if got := abntAuthors(autores); got != want {
+			t.Errorf("abntAuthors(%q) = %q; esperado %q", autores, got, want)
+		}
+	}
+
+	editions := map[string]string{"": "", "1": "", "1ª": "", "2": "2. ed.", "3a.": "3. ed.", "Ed. rev.": "Ed. rev.", "Edição revista": "Edição revista."}
+	for edicao, want := range editions {
+		if got := abntEdition(edicao); got != want {
+			t.Errorf("abntEdition(%q) = %q; esperado %q", edicao, got, want)
+		}
+	}
+}
+
+func TestBibliografiaSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		name, src string
+		parse     func(string) ([]Referencia, error)
+		line      int
+	}{
+		{"BibTeX sem chave de fechamento", "@book{a,\n  title = {Sem fim\n", parseBibTeX, 3},
+		{"BibTeX sem =", "@book{a,\n  title {X}\n}", parseBibTeX, 2},
+		{"RIS sem ER", "TY  - BOOK\nTI  - Sem fim\n", parseRIS, 3},
+		{"RIS com tag fora de entrada", "TI  - Solto\n", parseRIS, 1},
+		{"RIS com TY dentro de entrada", "TY  - BOOK\nTY  - JOUR\nER  - \n", parseRIS, 2},
+	}
+	for _, tt := range tests {
+		_, err := tt.parse(tt.src)
+		var syntax *bibSyntaxError
+		if !errors.As(err, &syntax) || syntax.line != tt.line {
+			t.Errorf("%s: erro %v; esperado erro de sintaxe na linha %d", tt.name, err, tt.line)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var errBlobNotFound = errors.New("blob não encontrado")
+
+// BlobStore guarda o conteúdo dos arquivos anexados; os metadados ficam na prova/trabalho
+type BlobStore interface {
+	// Put grava size bytes de body em key; sha256Hex é o checksum já calculado do conteúdo
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType, sha256Hex string) error
+	// Get lê length bytes a partir de offset (length < 0 lê até o fim)
+	Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+var blobStore BlobStore = newLocalBlobStore(filepath.Join("data", "anexos"))
+
+// newBlobStoreFromEnv escolhe o BlobStore por BLOB_STORE: "local" (padrão, em BLOB_DIR) ou
+// "s3" (qualquer serviço compatível com S3, como MinIO, configurado pelas variáveis S3_*)
+func newBlobStoreFromEnv() BlobStore {
+	switch os.Getenv("BLOB_STORE") {
+	case "", "local":
+		dir := os.Getenv("BLOB_DIR")
+		if dir == "" {
+			dir = filepath.Join("data", "anexos")
+		}
+		log.Printf("Anexos armazenados em disco: %s", dir)
+		return newLocalBlobStore(dir)
+	case "s3":
+		store := &s3BlobStore{
+			endpoint:  strings.TrimSuffix(os.Getenv("S3_ENDPOINT"), "/"),
+			bucket:    os.Getenv("S3_BUCKET"),
+			region:    os.Getenv("S3_REGION"),
+			accessKey: os.Getenv("S3_ACCESS_KEY"),
+			secretKey: os.Getenv("S3_SECRET_KEY"),
+			client:    &http.Client{Timeout: 5 * time.Minute},
+		}
+		if store.region == "" {
+			store.region = "us-east-1"
+		}
+		if store.endpoint == "" || store.bucket == "" || store.accessKey == "" || store.secretKey == "" {
+			log.Fatalf("BLOB_STORE=s3 requer S3_ENDPOINT, S3_BUCKET, S3_ACCESS_KEY e S3_SECRET_KEY")
+		}
+		if err := store.ensureBucket(context.Background()); err != nil {
+			log.Fatalf("Erro ao preparar o bucket %s: %v", store.bucket, err)
+		}
+		log.Printf("Anexos armazenados no bucket %s de %s", store.bucket, store.endpoint)
+		return store
+	}
+	log.Fatalf("BLOB_STORE inválido: %s", os.Getenv("BLOB_STORE"))
+	return nil
+}
+
+// localBlobStore guarda cada blob em um arquivo sob root
+type localBlobStore struct {
+	root string
+}
+
+func newLocalBlobStore(root string) *localBlobStore {
+	return &localBlobStore{root: root}
+}
+
+func (s *localBlobStore) path(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(key))
+}
+
+func (s *localBlobStore) Put(ctx context.Context, key string, body io.Reader, size int64, contentType, sha256Hex string) error {
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	// Grava em um arquivo temporário e renomeia, para nunca expor um blob incompleto
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if written != size {
+		return fmt.Errorf("blob %s: %d bytes gravados, %d esperados", key, written, size)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *localBlobStore) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	file, err := os.Open(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return file, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(file, length), file}, nil
+}
+
+func (s *localBlobStore) Delete(ctx context.Context, key string) error {
+	err := os.Remove(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// s3BlobStore fala a API REST do S3 (endereçamento por caminho, como no MinIO), assinando as
+// requisições com AWS Signature Version 4
+type s3BlobStore struct {
+	endpoint  string
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+const emptyPayloadSHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func (s *s3BlobStore) objectURL(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return s.endpoint + "/" + url.PathEscape(s.bucket) + "/" + strings.Join(segments, "/")
+}
+
+func (s *s3BlobStore) do(ctx context.Context, method, target string, body io.Reader, size int64, payloadHash string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	signS3Request(req, payloadHash, s.accessKey, s.secretKey, s.region, time.Now().UTC())
+	return s.client.Do(req)
+}
+
+// s3Error lê o corpo de uma resposta de erro do S3 para a mensagem
+func s3Error(resp *http.Response, operation string) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("S3 %s: status %d: %s", operation, resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+// ensureBucket cria o bucket se ele ainda não existir
+func (s *s3BlobStore) ensureBucket(ctx context.Context) error {
+	bucketURL := s.endpoint + "/" + url.PathEscape(s.bucket)
+	resp, err := s.do(ctx, http.MethodHead, bucketURL, nil, 0, emptyPayloadSHA256, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("S3 HEAD bucket: status %d", resp.StatusCode)
+	}
+
+	resp, err = s.do(ctx, http.MethodPut, bucketURL, nil, 0, emptyPayloadSHA256, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp, "PUT bucket")
+	}
+	return nil
+}
+
+func (s *s3BlobStore) Put(ctx context.Context, key string, body io.Reader, size int64, contentType, sha256Hex string) error {
+	header := http.Header{"Content-Type": {contentType}}
+	resp, err := s.do(ctx, http.MethodPut, s.objectURL(key), body, size, sha256Hex, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp, "PUT")
+	}
+	return nil
+}
+
+func (s *s3BlobStore) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	header := http.Header{}
+	switch {
+	case length >= 0:
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	case offset > 0:
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := s.do(ctx, http.MethodGet, s.objectURL(key), nil, 0, emptyPayloadSHA256, header)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, errBlobNotFound
+	}
+	defer resp.Body.Close()
+	return nil, s3Error(resp, "GET")
+}
+
+func (s *s3BlobStore) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, s.objectURL(key), nil, 0, emptyPayloadSHA256, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error(resp, "DELETE")
+	}
+	return nil
+}
+
+// signS3Request adiciona à requisição os headers x-amz-* e Authorization da AWS Signature
+// Version 4, assinando host, x-amz-content-sha256 e x-amz-date
+func signS3Request(req *http.Request, payloadHash, accessKey, secretKey, region string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQueryString(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+secretKey), date)
+	signingKey = hmacSHA256(signingKey, region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func canonicalQueryString(values url.Values) string {
+	if len(values) == 0 {
+		return ""
+	}
+	// url.Values.Encode ordena as chaves; a SigV4 exige espaços como %20
+	return strings.ReplaceAll(values.Encode(), "+", "%20")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
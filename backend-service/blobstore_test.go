@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 é um servidor S3 mínimo em memória que confere a assinatura SigV4 de cada requisição
+type fakeS3 struct {
+	accessKey string
+	secretKey string
+	region    string
+
+	mu      sync.Mutex
+	buckets map[string]bool
+	objects map[string][]byte
+	// rejected guarda o motivo de cada requisição recusada
+	rejected []string
+}
+
+// verify recalcula a assinatura a partir da requisição recebida e confere o payload
+func (f *fakeS3) verify(r *http.Request, body []byte) error {
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	sum := sha256.Sum256(body)
+	if payloadHash != hex.EncodeToString(sum[:]) {
+		return fmt.Errorf("x-amz-content-sha256 %q não confere com o corpo", payloadHash)
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	signedAt, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil || time.Since(signedAt).Abs() > 15*time.Minute {
+		return fmt.Errorf("x-amz-date inválido: %q", amzDate)
+	}
+
+	auth := r.Header.Get("Authorization")
+	fields := map[string]string{}
+	rest, ok := strings.CutPrefix(auth, "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return fmt.Errorf("Authorization sem AWS4-HMAC-SHA256: %q", auth)
+	}
+	for _, part := range strings.Split(rest, ", ") {
+		name, value, _ := strings.Cut(part, "=")
+		fields[name] = value
+	}
+	scope := signedAt.Format("20060102") + "/" + f.region + "/s3/aws4_request"
+	if fields["Credential"] != f.accessKey+"/"+scope {
+		return fmt.Errorf("Credential %q; esperado %q", fields["Credential"], f.accessKey+"/"+scope)
+	}
+
+	names := strings.Split(fields["SignedHeaders"], ";")
+	if !sort.StringsAreSorted(names) {
+		return fmt.Errorf("SignedHeaders fora de ordem: %q", fields["SignedHeaders"])
+	}
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	for _, required := range []string{"host", "x-amz-content-sha256", "x-amz-date"} {
+		if !strings.Contains(";"+fields["SignedHeaders"]+";", ";"+required+";") {
+			return fmt.Errorf("header %s não assinado", required)
+		}
+	}
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		r.URL.RawQuery,
+		canonicalHeaders.String(),
+		fields["SignedHeaders"],
+		payloadHash,
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := []byte("AWS4" + f.secretKey)
+	for _, part := range []string{signedAt.Format("20060102"), f.region, "s3", "aws4_request"} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(stringToSign))
+	if want := hex.EncodeToString(mac.Sum(nil)); !hmac.Equal([]byte(fields["Signature"]), []byte(want)) {
+		return fmt.Errorf("assinatura %s; esperado %s", fields["Signature"], want)
+	}
+	return nil
+}
+
+func (f *fakeS3) rejections() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.rejected...)
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.verify(r, body); err != nil {
+		f.rejected = append(f.rejected, fmt.Sprintf("%s %s: %v", r.Method, r.URL.Path, err))
+		http.Error(w, "SignatureDoesNotMatch", http.StatusForbidden)
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if key == "" {
+		switch r.Method {
+		case http.MethodHead:
+			if !f.buckets[bucket] {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case http.MethodPut:
+			f.buckets[bucket] = true
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	if !f.buckets[bucket] {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+
+	object, exists := f.objects[r.URL.Path]
+	switch r.Method {
+	case http.MethodPut:
+		if int64(len(body)) != r.ContentLength {
+			http.Error(w, "IncompleteBody", http.StatusBadRequest)
+			return
+		}
+		f.objects[r.URL.Path] = body
+	case http.MethodGet:
+		if !exists {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		http.ServeContent(w, r, key, time.Time{}, bytes.NewReader(object))
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestS3BlobStore(t *testing.T) {
+	fake := &fakeS3{accessKey: "chave-de-teste", secretKey: "segredo-de-teste", region: "sa-east-1", buckets: map[string]bool{}, objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	store := &s3BlobStore{
+		endpoint:  server.URL,
+		bucket:    "anexos",
+		region:    fake.region,
+		accessKey: fake.accessKey,
+		secretKey: fake.secretKey,
+		client:    server.Client(),
+	}
+	ctx := context.Background()
+	if err := store.ensureBucket(ctx); err != nil {
+		t.Fatalf("ensureBucket: %v", err)
+	}
+
+	content := []byte("conteúdo do anexo para o teste do S3")
+	sum := sha256.Sum256(content)
+	const key = "1/42/resumo da aula.txt"
+	if err := store.Put(ctx, key, bytes.NewReader(content), int64(len(content)), "text/plain", hex.EncodeToString(sum[:])); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	read := func(offset, length int64) []byte {
+		t.Helper()
+		body, err := store.Get(ctx, key, offset, length)
+		if err != nil {
+			t.Fatalf("Get(%d, %d): %v", offset, length, err)
+		}
+		defer body.Close()
+		data, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatalf("Get(%d, %d): %v", offset, length, err)
+		}
+		return data
+	}
+	if got := read(0, -1); !bytes.Equal(got, content) {
+		t.Fatalf("Get inteiro = %q", got)
+	}
+	if got := read(4, 8); !bytes.Equal(got, content[4:12]) {
+		t.Fatalf("Get parcial = %q; esperado %q", got, content[4:12])
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Get(ctx, key, 0, -1); err != errBlobNotFound {
+		t.Fatalf("Get após Delete: %v; esperado errBlobNotFound", err)
+	}
+
+	if rejected := fake.rejections(); len(rejected) > 0 {
+		t.Fatalf("requisições recusadas pelo S3: %v", rejected)
+	}
+
+	// Uma chave secreta diferente é recusada pelo servidor
+	wrong := *store
+	wrong.secretKey = "outro-segredo"
+	if err := wrong.Put(ctx, key, bytes.NewReader(content), int64(len(content)), "text/plain", hex.EncodeToString(sum[:])); err == nil {
+		t.Fatal("Put com chave errada foi aceito")
+	}
+	if rejected := fake.rejections(); len(rejected) != 1 || !strings.Contains(rejected[0], "assinatura") {
+		t.Fatalf("recusas = %v; esperado uma por assinatura", rejected)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half_open"
+	}
+	return "closed"
+}
+
+// circuitBreaker interrompe as chamadas a uma dependência após falhas consecutivas.
+// Aberto, recusa tudo até o fim do cooldown; então deixa passar uma única chamada de
+// teste (meio-aberto), que fecha o circuito se tiver sucesso ou o reabre se falhar.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+
+	// Métricas
+	transitions map[breakerState]int64
+	rejected    int64
+}
+
+// breakerMetrics é uma fotografia do estado e dos contadores do breaker
+type breakerMetrics struct {
+	State       breakerState
+	Failures    int
+	Transitions map[breakerState]int64
+	Rejected    int64
+}
+
+func newCircuitBreaker(name string, threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		name:        name,
+		threshold:   threshold,
+		cooldown:    cooldown,
+		transitions: make(map[breakerState]int64),
+	}
+}
+
+// Allow informa se uma chamada pode ser feita agora
+func (b *circuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			b.rejected++
+			return false
+		}
+		b.setState(breakerHalfOpen)
+		b.probing = true
+		return true
+	case breakerHalfOpen:
+		if b.probing {
+			b.rejected++
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+// Success registra uma chamada bem-sucedida
+func (b *circuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.probing = false
+	if b.state != breakerClosed {
+		b.setState(breakerClosed)
+	}
+}
+
+// Failure registra uma falha da dependência
+func (b *circuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.probing = false
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= b.threshold) {
+		b.openedAt = time.Now()
+		b.setState(breakerOpen)
+	}
+}
+
+func (b *circuitBreaker) Metrics() breakerMetrics {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	transitions := make(map[breakerState]int64, len(b.transitions))
+	for state, count := range b.transitions {
+		transitions[state] = count
+	}
+	return breakerMetrics{
+		State:       b.state,
+		Failures:    b.failures,
+		Transitions: transitions,
+		Rejected:    b.rejected,
+	}
+}
+
+func (b *circuitBreaker) setState(state breakerState) {
+	log.Printf("Circuit breaker %s: %s -> %s (falhas consecutivas: %d)", b.name, b.state, state, b.failures)
+	b.state = state
+	b.transitions[state]++
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// CalendarFeed é um endereço secreto de assinatura do calendário de entregas do usuário, de
+// todas as matérias ou de uma só
+type CalendarFeed struct {
+	Token     string    `json:"token"`
+	MateriaID int       `json:"materia_id,omitempty"`
+	URL       string    `json:"url"`
+	WebcalURL string    `json:"webcal_url"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    int       `json:"-"`
+	TenantID  int       `json:"-"`
+}
+
+type CreateCalendarFeedRequest struct {
+	MateriaID int `json:"materia_id"`
+}
+
+const (
+	calendarProdID    = "-//Sistema de Estudos//Provas e Trabalhos//PT-BR"
+	calendarUIDDomain = "sistema-estudos"
+	calendarRefresh   = "PT1H"
+)
+
+var (
+	// calendarFeeds é protegido por storeMu
+	calendarFeeds []CalendarFeed
+	// publicURL é o endereço público do serviço usado nos links de assinatura; vazio usa o
+	// Host da requisição
+	publicURL = ""
+
+	// Lembretes (VALARM) antes da entrega: eventos com horário e eventos de dia inteiro
+	calendarAlarms       = []time.Duration{24 * time.Hour, time.Hour}
+	calendarAllDayAlarms = []time.Duration{7 * 24 * time.Hour, 24 * time.Hour}
+)
+
+func publicBaseURL(r *http.Request) string {
+	if publicURL != "" {
+		return publicURL
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// withFeedURLs preenche os endereços de assinatura do feed
+func withFeedURLs(r *http.Request, feed CalendarFeed) CalendarFeed {
+	feed.URL = publicBaseURL(r) + "/calendar/" + feed.Token + ".ics"
+	if _, rest, ok := strings.Cut(feed.URL, "://"); ok {
+		feed.WebcalURL = "webcal://" + rest
+	}
+	return feed
+}
+
+func newCalendarToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func getCalendarFeedsHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
+
+	feeds := []CalendarFeed{}
+	storeMu.RLock()
+	for _, feed := range calendarFeeds {
+		if feed.TenantID == tenantID && feed.UserID == userID {
+			feeds = append(feeds, withFeedURLs(r, feed))
+		}
+	}
+	storeMu.RUnlock()
+
+	logUserAction(userID, "GET", "feeds de calendário")
+	writeSuccessResponse(w, r, "calendar_feeds_loaded", feeds)
+}
+
+// createCalendarFeedHandler gera o endereço de assinatura de todas as matérias (materia_id
+// omitido) ou de uma matéria; se já existir um para o mesmo escopo, ele é revogado e
+// substituído por um novo token
+func createCalendarFeedHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
+
+	var req CreateCalendarFeedRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeErrorResponse(w, r, http.StatusBadRequest, "BAD_REQUEST", "invalid_body")
+		return
+	}
+	if req.MateriaID < 0 {
+		writeValidationError(w, r, []FieldError{fieldError("materia_id", "query_positive_int")})
+		return
+	}
+
+	token, err := newCalendarToken()
+	if err != nil {
+		log.Printf("Erro ao gerar token de calendário para user %d: %v", userID, err)
+		writeErrorResponse(w, r, http.StatusInternalServerError, "INTERNAL_ERROR", "calendar_feed_failed")
+		return
+	}
+
+	storeMu.Lock()
+	if req.MateriaID != 0 && findMateria(tenantID, userID, req.MateriaID) == nil {
+		storeMu.Unlock()
+		log.Printf("Tentativa de criar feed de calendário para matéria inexistente: MateriaID %d, User %d", req.MateriaID, userID)
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "materia_not_found")
+		return
+	}
+	kept := calendarFeeds[:0]
+	regenerated := false
+	for _, feed := range calendarFeeds {
+		if feed.TenantID == tenantID && feed.UserID == userID && feed.MateriaID == req.MateriaID {
+			regenerated = true
+			continue
+		}
+		kept = append(kept, feed)
+	}
+	feed := CalendarFeed{
+		Token:     token,
+		MateriaID: req.MateriaID,
+		CreatedAt: time.Now(),
+		UserID:    userID,
+		TenantID:  tenantID,
+	}
+	calendarFeeds = append(kept, feed)
+	storeMu.Unlock()
+
+	if regenerated {
+		logUserMutation(r, userID, "REGENERATE", fmt.Sprintf("feed de calendário (matéria %d)", req.MateriaID))
+	} else {
+		logUserMutation(r, userID, "CREATE", fmt.Sprintf("feed de calendário (matéria %d)", req.MateriaID))
+	}
+	writeSuccessResponse(w, r, "calendar_feed_created", withFeedURLs(r, feed))
+}
+
+func deleteCalendarFeedHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
+	token := mux.Vars(r)["token"]
+
+	storeMu.Lock()
+	index := -1
+	for i, feed := range calendarFeeds {
+		if feed.Token == token && feed.TenantID == tenantID && feed.UserID == userID {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		storeMu.Unlock()
+		log.Printf("Tentativa de revogar feed de calendário inexistente: User %d", userID)
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "calendar_feed_not_found")
+		return
+	}
+	calendarFeeds = append(calendarFeeds[:index], calendarFeeds[index+1:]...)
+	storeMu.Unlock()
+
+	logUserMutation(r, userID, "DELETE", "feed de calendário")
+	writeSuccessResponse(w, r, "calendar_feed_deleted", nil)
+}
+
+// calendarEvent é uma prova/trabalho com data de entrega, com o nome da matéria
+type calendarEvent struct {
+	prova   ProvaTrabalho
+	materia string
+}
+
+// calendarFeedHandler serve o feed iCalendar (RFC 5545) de um token; é público, porque os
+// aplicativos de calendário não enviam o JWT: o próprio token é a credencial
+func calendarFeedHandler(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+
+	storeMu.RLock()
+	var feed *CalendarFeed
+	for i := range calendarFeeds {
+		if calendarFeeds[i].Token == token {
+			feed = &calendarFeeds[i]
+			break
+		}
+	}
+	if feed == nil {
+		storeMu.RUnlock()
+		log.Printf("Feed de calendário com token inválido - IP: %s", r.RemoteAddr)
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "calendar_feed_not_found")
+		return
+	}
+	tenantID, userID, materiaID := feed.TenantID, feed.UserID, feed.MateriaID
+
+	nomes := map[int]string{}
+	for _, materia := range materias {
+		if materia.TenantID == tenantID && materia.UserID == userID && materia.DeletedAt == nil {
+			nomes[materia.ID] = materia.Nome
+		}
+	}
+	if _, ok := nomes[materiaID]; materiaID != 0 && !ok {
+		storeMu.RUnlock()
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "materia_not_found")
+		return
+	}
+	var events []calendarEvent
+	for _, prova := range provasTrabalhos {
+		if prova.TenantID != tenantID || prova.UserID != userID || prova.DeletedAt != nil || prova.DataEntrega == nil {
+			continue
+		}
+		if materiaID != 0 && prova.MateriaID != materiaID {
+			continue
+		}
+		events = append(events, calendarEvent{prova: prova, materia: nomes[prova.MateriaID]})
+	}
+	storeMu.RUnlock()
+
+	sort.Slice(events, func(i, j int) bool {
+		a, b := events[i].prova, events[j].prova
+		if !a.DataEntrega.Equal(*b.DataEntrega) {
+			return a.DataEntrega.Before(*b.DataEntrega)
+		}
+		return a.ID < b.ID
+	})
+
+	name := "Provas e trabalhos"
+	if materiaID != 0 {
+		name += " – " + nomes[materiaID]
+	}
+	body := buildICS(name, events)
+
+	// O conteúdo só muda quando alguma prova/trabalho muda, então o ETag evita reenviar o feed
+	// a cada sincronização
+	sum := sha256.Sum256([]byte(body))
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": "provas-trabalhos.ics"}))
+	io.WriteString(w, body)
+	logUserAction(userID, "GET", fmt.Sprintf("feed de calendário (%d eventos)", len(events)))
+}
+
+// buildICS monta o VCALENDAR com um VEVENT por entrega. O UID é fixo por prova/trabalho e o
+// SEQUENCE acompanha a versão, para que os aplicativos atualizem o evento em vez de duplicá-lo.
+func buildICS(name string, events []calendarEvent) string {
+	var w icsWriter
+	w.line("BEGIN", "VCALENDAR")
+	w.line("VERSION", "2.0")
+	w.line("PRODID", calendarProdID)
+	w.line("CALSCALE", "GREGORIAN")
+	w.line("X-WR-CALNAME", icsText(name))
+	w.line("REFRESH-INTERVAL;VALUE=DURATION", calendarRefresh)
+	w.line("X-PUBLISHED-TTL", calendarRefresh)
+
+	for _, event := range events {
+		prova := event.prova
+		summary := prova.Titulo
+		if event.materia != "" {
+			summary += " – " + event.materia
+		}
+
+		w.line("BEGIN", "VEVENT")
+		w.line("UID", fmt.Sprintf("prova-trabalho-%d@%s", prova.ID, calendarUIDDomain))
+		w.line("DTSTAMP", icsTime(prova.UpdatedAt))
+		w.line("CREATED", icsTime(prova.CreatedAt))
+		w.line("LAST-MODIFIED", icsTime(prova.UpdatedAt))
+		w.line("SEQUENCE", fmt.Sprint(prova.Version))
+
+		// Datas sem horário (meia-noite UTC, como o frontend envia) viram eventos de dia inteiro
+		due := prova.DataEntrega.UTC()
+		alarms := calendarAlarms
+		if due.Hour() == 0 && due.Minute() == 0 && due.Second() == 0 {
+			w.line("DTSTART;VALUE=DATE", due.Format("20060102"))
+			w.line("DTEND;VALUE=DATE", due.AddDate(0, 0, 1).Format("20060102"))
+			alarms = calendarAllDayAlarms
+		} else {
+			w.line("DTSTART", icsTime(due))
+		}
+
+		w.line("SUMMARY", icsText(summary))
+		if prova.ConteudosEstudo != "" {
+			w.line("DESCRIPTION", icsText(prova.ConteudosEstudo))
+		}
+		if event.materia != "" {
+			w.line("CATEGORIES", icsText(event.materia))
+		}
+		w.line("STATUS", "CONFIRMED")
+		w.line("TRANSP", "TRANSPARENT")
+		for _, before := range alarms {
+			w.line("BEGIN", "VALARM")
+			w.line("ACTION", "DISPLAY")
+			w.line("DESCRIPTION", icsText("Entrega: "+summary))
+			w.line("TRIGGER", icsDuration(before))
+			w.line("END", "VALARM")
+		}
+		w.line("END", "VEVENT")
+	}
+
+	w.line("END", "VCALENDAR")
+	return w.b.String()
+}
+
+// icsWriter escreve linhas de conteúdo iCalendar terminadas em CRLF e dobradas em 75 octetos
+type icsWriter struct {
+	b strings.Builder
+}
+
+func (w *icsWriter) line(name, value string) {
+	line := name + ":" + value
+	limit := 75
+	for len(line) > limit {
+		// Não quebra no meio de um caractere UTF-8
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		w.b.WriteString(line[:cut])
+		w.b.WriteString("\r\n ")
+		line = line[cut:]
+		limit = 74
+	}
+	w.b.WriteString(line)
+	w.b.WriteString("\r\n")
+}
+
+var icsTextEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+// icsText escapa um valor TEXT (RFC 5545, 3.3.11)
+func icsText(text string) string {
+	return icsTextEscaper.Replace(text)
+}
+
+func icsTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// icsDuration é o TRIGGER de um lembrete disparado antes do início do evento
+func icsDuration(before time.Duration) string {
+	switch {
+	case before%(24*time.Hour) == 0:
+		return fmt.Sprintf("-P%dD", before/(24*time.Hour))
+	case before%time.Hour == 0:
+		return fmt.Sprintf("-PT%dH", before/time.Hour)
+	}
+	return fmt.Sprintf("-PT%dM", before/time.Minute)
+}
@@ -0,0 +1,545 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	// Base de fusos embutida: a imagem alpine não traz /usr/share/zoneinfo e os TZID dos
+	// calendários precisam ser resolvidos
+	_ "time/tzdata"
+)
+
+const (
+	maxICSBody      = 2 << 20
+	defaultTimezone = "America/Sao_Paulo"
+
+	icsAcaoCriar         = "criar"
+	icsAcaoAtualizar     = "atualizar"
+	icsAcaoSemAlteracoes = "sem_alteracoes"
+	icsAcaoIgnorar       = "ignorar"
+)
+
+// icsSyntaxError aponta a linha em que a leitura de um arquivo .ics falhou
+type icsSyntaxError struct {
+	line int
+}
+
+func (e *icsSyntaxError) Error() string {
+	return fmt.Sprintf("erro de sintaxe na linha %d", e.line)
+}
+
+type icsProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// icsComponent é um VEVENT ou VTODO com as propriedades do próprio componente (as de
+// componentes aninhados, como VALARM, são descartadas)
+type icsComponent struct {
+	name  string
+	props []icsProperty
+}
+
+func (c icsComponent) get(name string) (icsProperty, bool) {
+	for _, prop := range c.props {
+		if prop.name == name {
+			return prop, true
+		}
+	}
+	return icsProperty{}, false
+}
+
+// icsEvent é um evento do calendário já interpretado
+type icsEvent struct {
+	UID         string
+	Titulo      string
+	Descricao   string
+	Categoria   string
+	DataEntrega *time.Time
+	Cancelado   bool
+	Recorrente  bool
+}
+
+// ICSImportItem é o que a importação faz (ou faria, no dry run) com um evento
+type ICSImportItem struct {
+	UID         string     `json:"uid"`
+	Titulo      string     `json:"titulo"`
+	DataEntrega *time.Time `json:"data_entrega,omitempty"`
+	MateriaID   int        `json:"materia_id,omitempty"`
+	Materia     string     `json:"materia,omitempty"`
+	Acao        string     `json:"acao"`
+	Motivo      string     `json:"motivo,omitempty"`
+	ProvaID     int        `json:"prova_trabalho_id,omitempty"`
+}
+
+// ICSImportResult resume a importação; no dry run nada é gravado e as matérias e
+// provas/trabalhos a criar ainda não têm ID
+type ICSImportResult struct {
+	DryRun          bool            `json:"dry_run"`
+	Criadas         int             `json:"criadas"`
+	Atualizadas     int             `json:"atualizadas"`
+	SemAlteracoes   int             `json:"sem_alteracoes"`
+	Ignoradas       int             `json:"ignoradas"`
+	MateriasCriadas []string        `json:"materias_criadas"`
+	Itens           []ICSImportItem `json:"itens"`
+}
+
+type icsImportOptions struct {
+	materiaID    int
+	autoMaterias bool
+	dryRun       bool
+	location     *time.Location
+}
+
+// unfoldICS junta as linhas dobradas (RFC 5545, 3.1), guardando o número da linha original
+func unfoldICS(src string) ([]string, []int) {
+	var lines []string
+	var numbers []int
+	for i, line := range strings.Split(strings.TrimPrefix(src, "\ufeff"), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		lines = append(lines, line)
+		numbers = append(numbers, i+1)
+	}
+	return lines, numbers
+}
+
+// parseICSLine separa NOME;PARAM=valor:VALOR, respeitando parâmetros entre aspas
+func parseICSLine(line string) (icsProperty, bool) {
+	inQuotes := false
+	colon := -1
+	for i := 0; i < len(line) && colon < 0; i++ {
+		switch line[i] {
+		case '"':
+			inQuotes = !inQuotes
+		case ':':
+			if !inQuotes {
+				colon = i
+			}
+		}
+	}
+	if colon <= 0 {
+		return icsProperty{}, false
+	}
+
+	var parts []string
+	start := 0
+	inQuotes = false
+	head := line[:colon]
+	for i := 0; i < len(head); i++ {
+		switch head[i] {
+		case '"':
+			inQuotes = !inQuotes
+		case ';':
+			if !inQuotes {
+				parts = append(parts, head[start:i])
+				start = i + 1
+			}
+		}
+	}
+	parts = append(parts, head[start:])
+
+	prop := icsProperty{name: strings.ToUpper(parts[0]), params: map[string]string{}, value: line[colon+1:]}
+	for _, param := range parts[1:] {
+		key, value, _ := strings.Cut(param, "=")
+		prop.params[strings.ToUpper(key)] = strings.Trim(value, `"`)
+	}
+	return prop, true
+}
+
+// parseICS lê os VEVENT e VTODO de um VCALENDAR, com o nome do calendário (X-WR-CALNAME)
+func parseICS(src string) (string, []icsComponent, error) {
+	lines, numbers := unfoldICS(src)
+	var stack []string
+	var components []icsComponent
+	var current *icsComponent
+	calName := ""
+	for i, line := range lines {
+		prop, ok := parseICSLine(line)
+		if !ok {
+			return "", nil, &icsSyntaxError{line: numbers[i]}
+		}
+		value := strings.ToUpper(strings.TrimSpace(prop.value))
+		switch {
+		case prop.name == "BEGIN":
+			if len(stack) == 0 && value != "VCALENDAR" {
+				return "", nil, &icsSyntaxError{line: numbers[i]}
+			}
+			if len(stack) == 1 && (value == "VEVENT" || value == "VTODO") {
+				components = append(components, icsComponent{name: value})
+				current = &components[len(components)-1]
+			}
+			stack = append(stack, value)
+		case prop.name == "END":
+			if len(stack) == 0 || stack[len(stack)-1] != value {
+				return "", nil, &icsSyntaxError{line: numbers[i]}
+			}
+			stack = stack[:len(stack)-1]
+			if len(stack) == 1 {
+				current = nil
+			}
+		case len(stack) == 0:
+			return "", nil, &icsSyntaxError{line: numbers[i]}
+		case len(stack) == 1 && prop.name == "X-WR-CALNAME":
+			calName = icsUnescape(prop.value)
+		case len(stack) == 2 && current != nil:
+			current.props = append(current.props, prop)
+		}
+	}
+	if len(stack) > 0 {
+		return "", nil, &icsSyntaxError{line: numbers[len(numbers)-1]}
+	}
+	return calName, components, nil
+}
+
+var icsTextUnescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+
+func icsUnescape(value string) string {
+	return icsTextUnescaper.Replace(value)
+}
+
+// firstICSCategory é a primeira categoria de CATEGORIES (lista separada por vírgulas não escapadas)
+func firstICSCategory(value string) string {
+	start := 0
+	for i := 0; i <= len(value); i++ {
+		if i == len(value) || value[i] == ',' && (i == 0 || value[i-1] != '\\') {
+			if category := strings.TrimSpace(icsUnescape(value[start:i])); category != "" {
+				return category
+			}
+			start = i + 1
+		}
+	}
+	return ""
+}
+
+// parseICSTime interpreta DATE, DATE-TIME em UTC (sufixo Z), com TZID ou flutuante (em loc).
+// Datas sem horário ficam à meia-noite UTC, como as que o frontend envia.
+func parseICSTime(prop icsProperty, loc *time.Location) (time.Time, error) {
+	value := strings.TrimSpace(prop.value)
+	if prop.params["VALUE"] == "DATE" || len(value) == len("20060102") {
+		return time.Parse("20060102", value)
+	}
+	if strings.HasSuffix(value, "Z") {
+		return time.Parse("20060102T150405Z", value)
+	}
+	if tzid := prop.params["TZID"]; tzid != "" {
+		if named, err := time.LoadLocation(strings.TrimPrefix(tzid, "/")); err == nil {
+			loc = named
+		}
+	}
+	return time.ParseInLocation("20060102T150405", value, loc)
+}
+
+func icsEventFrom(component icsComponent, loc *time.Location) icsEvent {
+	text := func(name string) string {
+		prop, _ := component.get(name)
+		return strings.TrimSpace(icsUnescape(prop.value))
+	}
+
+	event := icsEvent{
+		UID:       text("UID"),
+		Titulo:    text("SUMMARY"),
+		Descricao: text("DESCRIPTION"),
+		Cancelado: strings.EqualFold(text("STATUS"), "CANCELLED"),
+	}
+	if categories, ok := component.get("CATEGORIES"); ok {
+		event.Categoria = firstICSCategory(categories.value)
+	}
+	_, event.Recorrente = component.get("RRULE")
+
+	// Em tarefas (VTODO) a entrega é o DUE; em eventos, o início
+	dateProps := []string{"DTSTART"}
+	if component.name == "VTODO" {
+		dateProps = []string{"DUE", "DTSTART"}
+	}
+	for _, name := range dateProps {
+		if prop, ok := component.get(name); ok {
+			if due, err := parseICSTime(prop, loc); err == nil {
+				event.DataEntrega = &due
+				break
+			}
+		}
+	}
+
+	// UID é obrigatório na RFC 5545; sem ele, título e data identificam o evento nas reimportações
+	if event.UID == "" {
+		start, _ := component.get("DTSTART")
+		sum := sha256.Sum256([]byte(event.Titulo + "\n" + start.value))
+		event.UID = "sem-uid-" + hex.EncodeToString(sum[:12])
+	}
+	return event
+}
+
+// parseICSImportQuery lê materia_id, auto_materias, dry_run (padrão: true) e tz
+func parseICSImportQuery(r *http.Request) (icsImportOptions, []FieldError) {
+	opts := icsImportOptions{dryRun: true}
+	var details []FieldError
+	query := r.URL.Query()
+
+	if raw := query.Get("materia_id"); raw != "" {
+		id, err := strconv.Atoi(raw)
+		if err != nil || id <= 0 {
+			details = append(details, fieldError("materia_id", "query_positive_int"))
+		}
+		opts.materiaID = id
+	}
+	flags := []struct {
+		name   string
+		target *bool
+	}{{"auto_materias", &opts.autoMaterias}, {"dry_run", &opts.dryRun}}
+	for _, flag := range flags {
+		if raw := query.Get(flag.name); raw != "" {
+			value, err := strconv.ParseBool(raw)
+			if err != nil {
+				details = append(details, fieldError(flag.name, "query_bool"))
+			}
+			*flag.target = value
+		}
+	}
+	if opts.materiaID == 0 && !opts.autoMaterias && query.Get("materia_id") == "" {
+		details = append(details, fieldError("materia_id", "ics_materia_required"))
+	}
+
+	tz := query.Get("tz")
+	if tz == "" {
+		tz = defaultTimezone
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		details = append(details, fieldError("tz", "timezone_unknown", tz))
+	}
+	opts.location = loc
+	return opts, details
+}
+
+// importICSHandler importa as entregas de um arquivo .ics. Por padrão é um dry run que só
+// mostra o que seria feito; com dry_run=false, grava. Eventos já importados (mesmo UID) têm
+// título e data atualizados, sem duplicar; os que estão na lixeira não voltam.
+func importICSHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
+
+	opts, details := parseICSImportQuery(r)
+	if len(details) > 0 {
+		writeValidationError(w, r, details)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxICSBody))
+	if err != nil {
+		writeErrorResponse(w, r, http.StatusRequestEntityTooLarge, "PAYLOAD_TOO_LARGE", "ics_too_large", maxICSBody)
+		return
+	}
+	calName, components, err := parseICS(string(body))
+	if err != nil {
+		var syntax *icsSyntaxError
+		if errors.As(err, &syntax) {
+			writeErrorResponse(w, r, http.StatusBadRequest, "BAD_REQUEST", "ics_syntax", syntax.line)
+			return
+		}
+		writeErrorResponse(w, r, http.StatusBadRequest, "BAD_REQUEST", "invalid_body")
+		return
+	}
+	if len(components) == 0 {
+		writeErrorResponse(w, r, http.StatusBadRequest, "BAD_REQUEST", "ics_empty")
+		return
+	}
+	events := make([]icsEvent, len(components))
+	for i, component := range components {
+		events[i] = icsEventFrom(component, opts.location)
+	}
+
+	storeMu.Lock()
+	if opts.materiaID != 0 && findMateria(tenantID, userID, opts.materiaID) == nil {
+		storeMu.Unlock()
+		log.Printf("Tentativa de importar calendário para matéria inexistente: MateriaID %d, User %d", opts.materiaID, userID)
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "materia_not_found")
+		return
+	}
+	result := planICSImport(r, tenantID, userID, calName, events, opts)
+	storeMu.Unlock()
+
+	if opts.dryRun {
+		logUserAction(userID, "PREVIEW", fmt.Sprintf("importação de calendário (%d eventos)", len(events)))
+		writeSuccessResponse(w, r, "ics_preview", result)
+		return
+	}
+	logUserMutation(r, userID, "IMPORT", fmt.Sprintf("calendário: %d criadas, %d atualizadas, %d matérias criadas", result.Criadas, result.Atualizadas, len(result.MateriasCriadas)))
+	writeSuccessResponse(w, r, "ics_imported", result)
+}
+
+// planICSImport decide o destino de cada evento e, fora do dry run, o aplica; storeMu deve
+// estar travado para escrita
+func planICSImport(r *http.Request, tenantID, userID int, calName string, events []icsEvent, opts icsImportOptions) ICSImportResult {
+	result := ICSImportResult{DryRun: opts.dryRun, MateriasCriadas: []string{}, Itens: []ICSImportItem{}}
+	now := time.Now()
+
+	conteudoPadrao := "Importado do calendário"
+	if calName != "" {
+		conteudoPadrao += " " + calName
+	}
+
+	// Matérias do usuário por nome; as criadas no dry run ficam com ID 0 e com o nome em
+	// plannedNomes, para que categorias que só diferem na caixa mostrem a mesma matéria
+	materiaIDs := map[string]int{}
+	materiaNomes := map[int]string{}
+	plannedNomes := map[string]string{}
+	for _, materia := range materias {
+		if materia.TenantID == tenantID && materia.UserID == userID && materia.DeletedAt == nil {
+			materiaIDs[strings.ToLower(strings.TrimSpace(materia.Nome))] = materia.ID
+			materiaNomes[materia.ID] = materia.Nome
+		}
+	}
+	materiaFor := func(categoria string) (int, string, bool) {
+		if !opts.autoMaterias || len([]rune(categoria)) < 2 {
+			return opts.materiaID, materiaNomes[opts.materiaID], opts.materiaID != 0
+		}
+		key := strings.ToLower(categoria)
+		if id, ok := materiaIDs[key]; ok {
+			if id == 0 {
+				return 0, plannedNomes[key], true
+			}
+			return id, materiaNomes[id], true
+		}
+
+		id := 0
+		if !opts.dryRun {
+			materia := Materia{
+				ID:             nextMateriaID,
+				Nome:           categoria,
+				Descricao:      conteudoPadrao,
+				UserID:         userID,
+				TenantID:       tenantID,
+				ImpersonatedBy: impersonatorID(r),
+				Version:        1,
+				CreatedAt:      now,
+				UpdatedAt:      now,
+			}
+			materias = append(materias, materia)
+			nextMateriaID++
+			searchIdx.indexMateria(materia)
+			publishEvent(tenantID, userID, eventMateriaCreated, materia)
+			id = materia.ID
+			materiaNomes[id] = categoria
+		}
+		materiaIDs[key] = id
+		plannedNomes[key] = categoria
+		result.MateriasCriadas = append(result.MateriasCriadas, categoria)
+		return id, categoria, true
+	}
+
+	seen := map[string]bool{}
+	for _, event := range events {
+		item := ICSImportItem{UID: event.UID, Titulo: event.Titulo, DataEntrega: event.DataEntrega}
+		ignore := func(motivo string) {
+			item.Acao, item.Motivo = icsAcaoIgnorar, motivo
+			result.Ignoradas++
+		}
+
+		// Reimportação: a prova/trabalho com o mesmo UID é atualizada, mesmo que esteja em outra matéria
+		var existing *ProvaTrabalho
+		for i := range provasTrabalhos {
+			prova := &provasTrabalhos[i]
+			if prova.TenantID == tenantID && prova.UserID == userID && prova.ImportUID == event.UID {
+				existing = prova
+				break
+			}
+		}
+
+		switch {
+		case seen[event.UID]:
+			ignore("duplicado")
+		case event.Cancelado:
+			ignore("cancelado")
+		case event.Recorrente:
+			ignore("recorrente")
+		case event.DataEntrega == nil:
+			ignore("sem_data")
+		case len([]rune(event.Titulo)) < 3:
+			ignore("titulo_invalido")
+		case existing != nil && existing.DeletedAt != nil:
+			item.ProvaID = existing.ID
+			ignore("na_lixeira")
+		case existing != nil:
+			item.ProvaID, item.MateriaID, item.Materia = existing.ID, existing.MateriaID, materiaNomes[existing.MateriaID]
+			switch {
+			case existing.Titulo == event.Titulo && sameTime(existing.DataEntrega, event.DataEntrega):
+				item.Acao = icsAcaoSemAlteracoes
+				result.SemAlteracoes++
+			case event.DataEntrega.Before(now):
+				ignore("passado")
+			default:
+				item.Acao = icsAcaoAtualizar
+				result.Atualizadas++
+				if !opts.dryRun {
+					existing.Titulo = event.Titulo
+					existing.DataEntrega = event.DataEntrega
+					existing.ImpersonatedBy = impersonatorID(r)
+					existing.Version++
+					existing.UpdatedAt = now
+					searchIdx.indexProva(*existing)
+					publishEvent(tenantID, userID, eventProvaUpdated, *existing)
+				}
+			}
+		case event.DataEntrega.Before(now):
+			ignore("passado")
+		default:
+			materiaID, materiaNome, ok := materiaFor(event.Categoria)
+			if !ok {
+				ignore("sem_materia")
+				break
+			}
+			item.MateriaID, item.Materia = materiaID, materiaNome
+			item.Acao = icsAcaoCriar
+			result.Criadas++
+			if !opts.dryRun {
+				conteudos := event.Descricao
+				if len([]rune(conteudos)) < 5 {
+					conteudos = conteudoPadrao
+				}
+				prova := ProvaTrabalho{
+					ID:              nextProvaID,
+					Titulo:          event.Titulo,
+					ConteudosEstudo: conteudos,
+					Anexos:          []string{},
+					Referencias:     []string{},
+					Bibliografia:    []Referencia{},
+					DataEntrega:     event.DataEntrega,
+					MateriaID:       materiaID,
+					ImportUID:       event.UID,
+					UserID:          userID,
+					TenantID:        tenantID,
+					ImpersonatedBy:  impersonatorID(r),
+					Version:         1,
+					CreatedAt:       now,
+					UpdatedAt:       now,
+				}
+				provasTrabalhos = append(provasTrabalhos, prova)
+				nextProvaID++
+				searchIdx.indexProva(prova)
+				publishEvent(tenantID, userID, eventProvaCreated, prova)
+				item.ProvaID = prova.ID
+			}
+		}
+		seen[event.UID] = true
+		result.Itens = append(result.Itens, item)
+	}
+	return result
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// icsCalendar monta um VCALENDAR com os componentes informados, com quebras CRLF
+func icsCalendar(components ...string) string {
+	return "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nX-WR-CALNAME:Semestre\r\n" + strings.Join(components, "") + "END:VCALENDAR\r\n"
+}
+
+// icsComponentText monta um VEVENT ou VTODO com as propriedades informadas
+func icsComponentText(name string, props ...string) string {
+	return "BEGIN:" + name + "\r\n" + strings.Join(props, "\r\n") + "\r\nEND:" + name + "\r\n"
+}
+
+func icsUTC(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// importICS chama o POST /import/ics com o arquivo e a query informados
+func importICS(t *testing.T, principal Principal, query, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/import/ics"+query, strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/calendar")
+	req = req.WithContext(withPrincipal(req.Context(), principal))
+
+	rec := httptest.NewRecorder()
+	importICSHandler(rec, req)
+	return rec
+}
+
+// importICSResult importa e decodifica o resumo, que deve vir com status 200
+func importICSResult(t *testing.T, principal Principal, query, body string) ICSImportResult {
+	t.Helper()
+
+	rec := importICS(t, principal, query, body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("importar%s: status %d: %s", query, rec.Code, rec.Body.String())
+	}
+	var result ICSImportResult
+	decodeData(t, rec, &result)
+	return result
+}
+
+// icsActions resume cada item como "acao" ou "acao/motivo"
+func icsActions(result ICSImportResult) []string {
+	actions := make([]string, len(result.Itens))
+	for i, item := range result.Itens {
+		actions[i] = item.Acao
+		if item.Motivo != "" {
+			actions[i] += "/" + item.Motivo
+		}
+	}
+	return actions
+}
+
+// storeSnapshot conta as matérias e provas/trabalhos do principal e guarda os próximos IDs
+func storeSnapshot(principal Principal) [4]int {
+	storeMu.RLock()
+	defer storeMu.RUnlock()
+	snapshot := [4]int{0, 0, nextMateriaID, nextProvaID}
+	for _, materia := range materias {
+		if materia.TenantID == principal.TenantID && materia.UserID == principal.UserID {
+			snapshot[0]++
+		}
+	}
+	for _, prova := range provasTrabalhos {
+		if prova.TenantID == principal.TenantID && prova.UserID == principal.UserID {
+			snapshot[1]++
+		}
+	}
+	return snapshot
+}
+
+func TestParseICS(t *testing.T) {
+	src := "\ufeffBEGIN:VCALENDAR\r\n" +
+		"VERSION:2.0\r\n" +
+		"X-WR-CALNAME:Semestre 2025\\, turma A\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:evento-1\r\n" +
+		"SUMMARY:Prova de cál\r\n" +
+		" culo dobrada\r\n" +
+		"DESCRIPTION:Capítulos 1\\, 2\r\n" +
+		"\te 3\r\n" +
+		"DTSTART;TZID=\"America/Sao_Paulo\":20250310T140000\r\n" +
+		"BEGIN:VALARM\r\n" +
+		"TRIGGER:-PT1H\r\n" +
+		"DESCRIPTION:Lembrete\r\n" +
+		"END:VALARM\r\n" +
+		"END:VEVENT\r\n" +
+		"BEGIN:VTIMEZONE\r\n" +
+		"TZID:America/Sao_Paulo\r\n" +
+		"END:VTIMEZONE\r\n" +
+		"BEGIN:VTODO\r\n" +
+		"UID:tarefa-1\r\n" +
+		"DUE;VALUE=DATE:20250320\r\n" +
+		"END:VTODO\r\n" +
+		"END:VCALENDAR\r\n"
+
+	calName, components, err := parseICS(src)
+	if err != nil {
+		t.Fatalf("parseICS: %v", err)
+	}
+	if calName != "Semestre 2025, turma A" {
+		t.Fatalf("nome do calendário = %q", calName)
+	}
+	if len(components) != 2 || components[0].name != "VEVENT" || components[1].name != "VTODO" {
+		t.Fatalf("componentes = %+v; esperado um VEVENT e um VTODO", components)
+	}
+
+	// As linhas dobradas são juntadas e as propriedades do VALARM não vazam para o evento
+	event := components[0]
+	if len(event.props) != 4 {
+		t.Fatalf("propriedades do VEVENT = %+v", event.props)
+	}
+	summary, _ := event.get("SUMMARY")
+	description, _ := event.get("DESCRIPTION")
+	if summary.value != "Prova de cálculo dobrada" || icsUnescape(description.value) != "Capítulos 1, 2e 3" {
+		t.Fatalf("SUMMARY = %q, DESCRIPTION = %q", summary.value, description.value)
+	}
+	if start, _ := event.get("DTSTART"); start.params["TZID"] != "America/Sao_Paulo" || start.value != "20250310T140000" {
+		t.Fatalf("DTSTART = %+v", start)
+	}
+
+	// Os erros apontam a linha do arquivo original, contando as linhas dobradas
+	syntaxErrors := []struct {
+		name, src string
+		line      int
+	}{
+		{"sem VCALENDAR", "BEGIN:VEVENT\r\nEND:VEVENT\r\n", 1},
+		{"linha sem dois pontos", "BEGIN:VCALENDAR\nBEGIN:VEVENT\nSUMMARY:Prova\n dobrada\nsem valor\nEND:VEVENT\nEND:VCALENDAR\n", 5},
+		{"END trocado", "BEGIN:VCALENDAR\nBEGIN:VEVENT\nEND:VTODO\nEND:VCALENDAR\n", 3},
+		{"sem END", "BEGIN:VCALENDAR\nBEGIN:VEVENT\nUID:1\n\n", 3},
+		{"propriedade após o fim", "BEGIN:VCALENDAR\nEND:VCALENDAR\nUID:solto\n", 3},
+	}
+	for _, tt := range syntaxErrors {
+		_, _, err := parseICS(tt.src)
+		var syntax *icsSyntaxError
+		if !errors.As(err, &syntax) || syntax.line != tt.line {
+			t.Errorf("%s: erro %v; esperado erro de sintaxe na linha %d", tt.name, err, tt.line)
+		}
+	}
+}
+
+func TestParseICSTime(t *testing.T) {
+	saoPaulo, err := time.LoadLocation("America/Sao_Paulo")
+	if err != nil {
+		t.Fatalf("LoadLocation: %v", err)
+	}
+	tests := []struct {
+		line string
+		want time.Time
+	}{
+		{"DTSTART:20250310T140000Z", time.Date(2025, 3, 10, 14, 0, 0, 0, time.UTC)},
+		{"DTSTART;TZID=America/Manaus:20250310T140000", time.Date(2025, 3, 10, 18, 0, 0, 0, time.UTC)},
+		{`DTSTART;TZID="/Europe/Lisbon":20250710T140000`, time.Date(2025, 7, 10, 13, 0, 0, 0, time.UTC)},
+		// Fuso desconhecido e horário flutuante ficam no fuso da importação
+		{"DTSTART;TZID=Fuso/Inexistente:20250310T140000", time.Date(2025, 3, 10, 17, 0, 0, 0, time.UTC)},
+		{"DTSTART:20250310T140000", time.Date(2025, 3, 10, 17, 0, 0, 0, time.UTC)},
+		// Datas sem horário ficam à meia-noite UTC, com ou sem VALUE=DATE
+		{"DTSTART;VALUE=DATE:20250310", time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)},
+		{"DUE:20250310", time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		prop, ok := parseICSLine(tt.line)
+		if !ok {
+			t.Fatalf("parseICSLine(%q) falhou", tt.line)
+		}
+		got, err := parseICSTime(prop, saoPaulo)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseICSTime(%q) = %v, %v; esperado %v", tt.line, got, err, tt.want)
+		}
+	}
+
+	for _, line := range []string{"DTSTART:2025-03-10", "DTSTART:20250310T1400", "DTSTART;VALUE=DATE:20250310T140000"} {
+		prop, _ := parseICSLine(line)
+		if got, err := parseICSTime(prop, saoPaulo); err == nil {
+			t.Errorf("parseICSTime(%q) = %v; esperado erro", line, got)
+		}
+	}
+}
+
+func TestICSImportAutoMaterias(t *testing.T) {
+	principal := Principal{UserID: 971, TenantID: 1}
+	fisica := createTestMateria(t, principal, "Física")
+
+	soon := time.Now().UTC().AddDate(0, 6, 0).Truncate(time.Second)
+	later := soon.AddDate(0, 0, 7)
+	calc1 := icsComponentText("VEVENT", "UID:calc-1", "SUMMARY:Prova de limites", "CATEGORIES:Cálculo,Provas", "DTSTART:"+icsUTC(soon))
+	calc2 := icsComponentText("VEVENT", "UID:calc-2", "SUMMARY:Lista de derivadas", "CATEGORIES:cálculo", "DTSTART:"+icsUTC(later))
+	semUID := icsComponentText("VEVENT", "SUMMARY:Relatório de óptica", "CATEGORIES:FÍSICA", "DTSTART:"+icsUTC(soon))
+	tarefa := icsComponentText("VTODO", "UID:quim-1", "SUMMARY:Trabalho de soluções", "CATEGORIES:Química", "DTSTART:"+icsUTC(soon), "DUE:"+icsUTC(later))
+	calendar := icsCalendar(
+		calc1, calc2, semUID, tarefa,
+		icsComponentText("VEVENT", "UID:antiga-1", "SUMMARY:Prova antiga", "CATEGORIES:Cálculo", "DTSTART:20000310T140000Z"),
+		icsComponentText("VEVENT", "UID:aula-1", "SUMMARY:Aula semanal", "CATEGORIES:Cálculo", "DTSTART:"+icsUTC(soon), "RRULE:FREQ=WEEKLY"),
+		icsComponentText("VEVENT", "UID:calc-1", "SUMMARY:Prova de limites (cópia)", "CATEGORIES:Cálculo", "DTSTART:"+icsUTC(soon)),
+	)
+	wantActions := []string{icsAcaoCriar, icsAcaoCriar, icsAcaoCriar, icsAcaoCriar, "ignorar/passado", "ignorar/recorrente", "ignorar/duplicado"}
+
+	// O dry run (padrão) mostra o plano sem gravar nada
+	before := storeSnapshot(principal)
+	preview := importICSResult(t, principal, "?auto_materias=true", calendar)
+	if after := storeSnapshot(principal); after != before {
+		t.Fatalf("dry run alterou o store: %v -> %v", before, after)
+	}
+	if !preview.DryRun || preview.Criadas != 4 || preview.Ignoradas != 3 || !reflect.DeepEqual(icsActions(preview), wantActions) {
+		t.Fatalf("prévia = %+v; ações %v", preview, icsActions(preview))
+	}
+	// Cada categoria nova vira uma matéria só, sem diferenciar maiúsculas; a existente é reaproveitada
+	if want := []string{"Cálculo", "Química"}; !reflect.DeepEqual(preview.MateriasCriadas, want) {
+		t.Fatalf("matérias a criar = %v; esperado %v", preview.MateriasCriadas, want)
+	}
+	if item := preview.Itens[1]; item.Materia != "Cálculo" || item.MateriaID != 0 || item.ProvaID != 0 {
+		t.Fatalf("item da prévia = %+v", item)
+	}
+	if item := preview.Itens[2]; item.MateriaID != fisica.ID || !strings.HasPrefix(item.UID, "sem-uid-") {
+		t.Fatalf("evento sem UID = %+v; esperado na matéria %d com UID gerado", item, fisica.ID)
+	}
+	if item := preview.Itens[3]; item.DataEntrega == nil || !item.DataEntrega.Equal(later) {
+		t.Fatalf("tarefa = %+v; esperado a entrega no DUE %v", item, later)
+	}
+
+	result := importICSResult(t, principal, "?auto_materias=true&dry_run=false", calendar)
+	if result.DryRun || result.Criadas != 4 || !reflect.DeepEqual(result.MateriasCriadas, preview.MateriasCriadas) || !reflect.DeepEqual(icsActions(result), wantActions) {
+		t.Fatalf("importação = %+v", result)
+	}
+	if after := storeSnapshot(principal); after[0] != before[0]+2 || after[1] != before[1]+4 {
+		t.Fatalf("store após a importação: %v -> %v; esperado 2 matérias e 4 provas novas", before, after)
+	}
+	calculoID := result.Itens[0].MateriaID
+	if calculoID == 0 || result.Itens[1].MateriaID != calculoID || result.Itens[2].MateriaID != fisica.ID {
+		t.Fatalf("matérias dos itens = %+v", result.Itens[:3])
+	}
+	provaIDs := make([]int, 4)
+	for i, item := range result.Itens[:4] {
+		provaIDs[i] = item.ProvaID
+		if stored := storedProva(t, principal, item.ProvaID); stored.ImportUID != item.UID || stored.ConteudosEstudo != "Importado do calendário Semestre" {
+			t.Fatalf("prova importada = %+v", stored)
+		}
+	}
+
+	// Reimportar o mesmo arquivo não duplica nada, nem o evento sem UID
+	before = storeSnapshot(principal)
+	again := importICSResult(t, principal, "?auto_materias=true&dry_run=false", calendar)
+	if again.Criadas != 0 || again.SemAlteracoes != 4 || len(again.MateriasCriadas) != 0 || storeSnapshot(principal) != before {
+		t.Fatalf("reimportação = %+v", again)
+	}
+	for i, item := range again.Itens[:4] {
+		if item.Acao != icsAcaoSemAlteracoes || item.ProvaID != provaIDs[i] {
+			t.Fatalf("item reimportado = %+v; esperado a prova %d sem alterações", item, provaIDs[i])
+		}
+	}
+
+	// Título novo atualiza; a prova na lixeira não volta; a entrega movida para o passado é ignorada
+	if rec := itemRequest(t, deleteProvaTrabalhoHandler, http.MethodDelete, "/provas-trabalhos/1", provaIDs[1], "", nil, principal); rec.Code != http.StatusOK {
+		t.Fatalf("excluir prova: status %d: %s", rec.Code, rec.Body.String())
+	}
+	changed := icsCalendar(
+		strings.Replace(calc1, "Prova de limites", "Prova de limites e continuidade", 1),
+		calc2,
+		icsComponentText("VTODO", "UID:quim-1", "SUMMARY:Trabalho de soluções", "DUE;VALUE=DATE:20000320"),
+	)
+	updated := importICSResult(t, principal, "?auto_materias=true&dry_run=false", changed)
+	if want := []string{icsAcaoAtualizar, "ignorar/na_lixeira", "ignorar/passado"}; !reflect.DeepEqual(icsActions(updated), want) {
+		t.Fatalf("ações = %v; esperado %v", icsActions(updated), want)
+	}
+	for i, id := range []int{provaIDs[0], provaIDs[1], provaIDs[3]} {
+		if updated.Itens[i].ProvaID != id {
+			t.Fatalf("item %d = %+v; esperado a prova %d", i, updated.Itens[i], id)
+		}
+	}
+	if stored := storedProva(t, principal, provaIDs[0]); stored.Titulo != "Prova de limites e continuidade" || stored.Version != 2 {
+		t.Fatalf("prova atualizada = %+v", stored)
+	}
+	if stored := storedProva(t, principal, provaIDs[3]); !stored.DataEntrega.Equal(later) {
+		t.Fatalf("entrega no passado foi gravada: %v", stored.DataEntrega)
+	}
+	_, deleted := materiaOf(t, provaIDs[1])
+	if !deleted {
+		t.Fatal("prova na lixeira restaurada pela importação")
+	}
+}
+
+func TestICSImportMateriaID(t *testing.T) {
+	principal := Principal{UserID: 972, TenantID: 1}
+	materia := createTestMateria(t, principal, "Química")
+	soon := icsUTC(time.Now().AddDate(0, 1, 0))
+	calendar := icsCalendar(
+		icsComponentText("VEVENT", "UID:q-1", "SUMMARY:Prova de estequiometria", "CATEGORIES:Outra", "DESCRIPTION:Capítulos 3 e 4", "DTSTART:"+soon),
+		icsComponentText("VEVENT", "UID:q-2", "SUMMARY:Feira", "STATUS:CANCELLED", "DTSTART:"+soon),
+		icsComponentText("VEVENT", "UID:q-3", "SUMMARY:Sem data"),
+		icsComponentText("VEVENT", "UID:q-4", "SUMMARY:Ok", "DTSTART:"+soon),
+	)
+
+	for query, status := range map[string]int{
+		"":                           http.StatusBadRequest,
+		"?materia_id=abc":            http.StatusBadRequest,
+		"?materia_id=1&tz=Lua/Base":  http.StatusBadRequest,
+		"?materia_id=99999":          http.StatusNotFound,
+		"?auto_materias=talvez":      http.StatusBadRequest,
+		"?materia_id=1&dry_run=nope": http.StatusBadRequest,
+	} {
+		if rec := importICS(t, principal, query, calendar); rec.Code != status {
+			t.Errorf("importar%s: status %d: %s; esperado %d", query, rec.Code, rec.Body.String(), status)
+		}
+	}
+	for name, body := range map[string]string{"vazio": icsCalendar(), "inválido": "BEGIN:VCALENDAR\r\nsem dois pontos\r\n"} {
+		if rec := importICS(t, principal, "?auto_materias=true", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("calendário %s: status %d; esperado 400", name, rec.Code)
+		}
+	}
+
+	// Com materia_id, a categoria é ignorada e tudo vai para a matéria informada
+	result := importICSResult(t, principal, "?dry_run=false&materia_id="+strconv.Itoa(materia.ID), calendar)
+	if want := []string{icsAcaoCriar, "ignorar/cancelado", "ignorar/sem_data", "ignorar/titulo_invalido"}; !reflect.DeepEqual(icsActions(result), want) {
+		t.Fatalf("ações = %v; esperado %v", icsActions(result), want)
+	}
+	if item := result.Itens[0]; item.MateriaID != materia.ID || item.Materia != "Química" || len(result.MateriasCriadas) != 0 {
+		t.Fatalf("resultado = %+v", result)
+	}
+	if stored := storedProva(t, principal, result.Itens[0].ProvaID); stored.MateriaID != materia.ID || stored.ConteudosEstudo != "Capítulos 3 e 4" {
+		t.Fatalf("prova importada = %+v", stored)
+	}
+}
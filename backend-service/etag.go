@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// requireIfMatch faz PUT, PATCH e DELETE exigirem o header If-Match (428 sem ele). Desligado
+// por padrão para não quebrar clientes antigos, que continuam sobrescrevendo sem checagem.
+var requireIfMatch = false
+
+type preconditionResult int
+
+const (
+	preconditionOK preconditionResult = iota
+	preconditionRequired
+	preconditionFailed
+)
+
+// entityTag é o ETag forte de uma versão de matéria ou prova/trabalho
+func entityTag(version int) string {
+	return fmt.Sprintf(`"v%d"`, version)
+}
+
+// checkIfMatch compara o If-Match da requisição com a versão atual do recurso (RFC 9110,
+// comparação forte: ETags fracos nunca correspondem); storeMu deve estar travado
+func checkIfMatch(r *http.Request, version int) preconditionResult {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		if requireIfMatch {
+			return preconditionRequired
+		}
+		return preconditionOK
+	}
+	if header == "*" {
+		return preconditionOK
+	}
+
+	current := entityTag(version)
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimSpace(tag) == current {
+			return preconditionOK
+		}
+	}
+	return preconditionFailed
+}
+
+// writePreconditionError responde 428 quando o If-Match é obrigatório e não foi enviado, ou
+// 412 com a representação atual do recurso e seu ETag quando a versão diverge
+func writePreconditionError(w http.ResponseWriter, r *http.Request, result preconditionResult, userID int, resource string, current interface{}, version int) {
+	if result == preconditionRequired {
+		writeErrorResponse(w, r, http.StatusPreconditionRequired, "PRECONDITION_REQUIRED", "if_match_required")
+		return
+	}
+
+	log.Printf("User %d: If-Match %s não corresponde à versão atual de %s (%s)", userID, r.Header.Get("If-Match"), resource, entityTag(version))
+	w.Header().Set("ETag", entityTag(version))
+	writeErrorEnvelope(w, r, http.StatusPreconditionFailed, ErrorResponse{
+		Error:   "PRECONDITION_FAILED",
+		Message: localize(r, "version_mismatch"),
+		Current: current,
+	})
+}
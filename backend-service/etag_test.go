@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// requireIfMatchForTest liga o REQUIRE_IF_MATCH durante o teste
+func requireIfMatchForTest(t *testing.T) {
+	t.Helper()
+	previous := requireIfMatch
+	requireIfMatch = true
+	t.Cleanup(func() { requireIfMatch = previous })
+}
+
+// jsonBody serializa o corpo de uma requisição de teste
+func jsonBody(t *testing.T, v interface{}) string {
+	t.Helper()
+	raw, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	return string(raw)
+}
+
+func TestCheckIfMatch(t *testing.T) {
+	tests := []struct {
+		header  string
+		require bool
+		want    preconditionResult
+	}{
+		{"", false, preconditionOK},
+		{"", true, preconditionRequired},
+		{"*", true, preconditionOK},
+		{`"v3"`, true, preconditionOK},
+		{`"v1", "v3"`, false, preconditionOK},
+		{`"v2"`, false, preconditionFailed},
+		{`W/"v3"`, false, preconditionFailed},
+		{`v3`, false, preconditionFailed},
+	}
+	for _, tt := range tests {
+		previous := requireIfMatch
+		requireIfMatch = tt.require
+		r, _ := http.NewRequest(http.MethodPut, "/materias/1", nil)
+		if tt.header != "" {
+			r.Header.Set("If-Match", tt.header)
+		}
+		got := checkIfMatch(r, 3)
+		requireIfMatch = previous
+		if got != tt.want {
+			t.Errorf("If-Match %q (obrigatório: %v) = %d; esperado %d", tt.header, tt.require, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateMateriaVersionMismatch(t *testing.T) {
+	principal := Principal{UserID: 951, TenantID: 1}
+	materia := createTestMateria(t, principal, "Estatística")
+	stale := entityTag(materia.Version)
+
+	body := jsonBody(t, CreateMateriaRequest{Nome: "Estatística I", Descricao: "Primeira edição"})
+	rec := itemRequest(t, updateMateriaHandler, http.MethodPut, "/materias/1", materia.ID, body, map[string]string{"If-Match": stale}, principal)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != entityTag(materia.Version+1) {
+		t.Fatalf("PUT com a versão atual: status %d, ETag %q: %s", rec.Code, rec.Header().Get("ETag"), rec.Body.String())
+	}
+
+	// Um segundo cliente com a versão anterior não sobrescreve a edição
+	body = jsonBody(t, CreateMateriaRequest{Nome: "Estatística II", Descricao: "Edição concorrente"})
+	rec = itemRequest(t, updateMateriaHandler, http.MethodPut, "/materias/1", materia.ID, body, map[string]string{"If-Match": stale}, principal)
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("PUT com versão antiga: status %d: %s; esperado 412", rec.Code, rec.Body.String())
+	}
+	var current Materia
+	resp := ErrorResponse{Current: &current}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Error != "PRECONDITION_FAILED" {
+		t.Fatalf("corpo do 412: %s", rec.Body.String())
+	}
+	if current.ID != materia.ID || current.Nome != "Estatística I" || rec.Header().Get("ETag") != entityTag(current.Version) {
+		t.Fatalf("412 com current=%+v e ETag %q; esperado a versão atual", current, rec.Header().Get("ETag"))
+	}
+
+	// O DELETE também respeita a versão
+	rec = itemRequest(t, deleteMateriaHandler, http.MethodDelete, "/materias/1", materia.ID, "", map[string]string{"If-Match": stale}, principal)
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("DELETE com versão antiga: status %d; esperado 412", rec.Code)
+	}
+	rec = itemRequest(t, deleteMateriaHandler, http.MethodDelete, "/materias/1", materia.ID, "", map[string]string{"If-Match": entityTag(current.Version)}, principal)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("DELETE com a versão atual: status %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestProvaVersionMismatch(t *testing.T) {
+	principal := Principal{UserID: 952, TenantID: 1}
+	materia := createTestMateria(t, principal, "Geografia")
+	prova := createTestProva(t, principal, materia.ID, "Prova de relevo")
+	stale := `"v0"`
+
+	body := jsonBody(t, CreateProvaTrabalhoRequest{Titulo: "Prova de clima", ConteudosEstudo: "Capítulos 4 a 6", MateriaID: materia.ID})
+	rec := itemRequest(t, updateProvaTrabalhoHandler, http.MethodPut, "/provas-trabalhos/1", prova.ID, body, map[string]string{"If-Match": stale}, principal)
+	var current ProvaTrabalho
+	resp := ErrorResponse{Current: &current}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusPreconditionFailed || current.Titulo != prova.Titulo {
+		t.Fatalf("PUT com versão antiga: status %d: %s; esperado 412 com a prova atual", rec.Code, rec.Body.String())
+	}
+
+	rec = itemRequest(t, deleteProvaTrabalhoHandler, http.MethodDelete, "/provas-trabalhos/1", prova.ID, "", map[string]string{"If-Match": stale}, principal)
+	if rec.Code != http.StatusPreconditionFailed || rec.Header().Get("ETag") != entityTag(prova.Version) {
+		t.Fatalf("DELETE com versão antiga: status %d, ETag %q; esperado 412", rec.Code, rec.Header().Get("ETag"))
+	}
+	if stored := storedProva(t, principal, prova.ID); stored.Version != prova.Version || stored.DeletedAt != nil {
+		t.Fatalf("prova alterada apesar do 412: %+v", stored)
+	}
+}
+
+func TestRequireIfMatch(t *testing.T) {
+	principal := Principal{UserID: 953, TenantID: 1}
+	materia := createTestMateria(t, principal, "Sociologia")
+	prova := createTestProva(t, principal, materia.ID, "Resenha")
+	requireIfMatchForTest(t)
+
+	materiaBody := jsonBody(t, CreateMateriaRequest{Nome: "Sociologia I", Descricao: "Sem If-Match"})
+	provaBody := jsonBody(t, CreateProvaTrabalhoRequest{Titulo: "Resenha crítica", ConteudosEstudo: "Capítulos 1 a 3", MateriaID: materia.ID})
+	requests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		id      int
+		body    string
+		header  map[string]string
+	}{
+		{"PUT de matéria", updateMateriaHandler, http.MethodPut, materia.ID, materiaBody, nil},
+		{"PATCH de matéria", patchMateriaHandler, http.MethodPatch, materia.ID, `{"nome": "Sociologia II"}`, map[string]string{"Content-Type": mediaTypeMergePatch}},
+		{"DELETE de matéria", deleteMateriaHandler, http.MethodDelete, materia.ID, "", nil},
+		{"PUT de prova", updateProvaTrabalhoHandler, http.MethodPut, prova.ID, provaBody, nil},
+		{"PATCH de prova", patchProvaTrabalhoHandler, http.MethodPatch, prova.ID, `{"titulo": "Resenha II"}`, map[string]string{"Content-Type": mediaTypeMergePatch}},
+		{"DELETE de prova", deleteProvaTrabalhoHandler, http.MethodDelete, prova.ID, "", nil},
+	}
+	for _, req := range requests {
+		rec := itemRequest(t, req.handler, req.method, "/", req.id, req.body, req.header, principal)
+		if rec.Code != http.StatusPreconditionRequired {
+			t.Errorf("%s sem If-Match: status %d: %s; esperado 428", req.name, rec.Code, rec.Body.String())
+			continue
+		}
+		var resp ErrorResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Error != "PRECONDITION_REQUIRED" || resp.Current != nil {
+			t.Errorf("%s: corpo do 428: %s", req.name, rec.Body.String())
+		}
+	}
+	if stored := storedProva(t, principal, prova.ID); stored.Version != prova.Version {
+		t.Fatalf("prova alterada sem If-Match: versão %d", stored.Version)
+	}
+
+	rec := itemRequest(t, updateMateriaHandler, http.MethodPut, "/materias/1", materia.ID, materiaBody, map[string]string{"If-Match": entityTag(materia.Version)}, principal)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT com If-Match: status %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = itemRequest(t, deleteProvaTrabalhoHandler, http.MethodDelete, "/provas-trabalhos/1", prova.ID, "", map[string]string{"If-Match": "*"}, principal)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("DELETE com If-Match *: status %d: %s", rec.Code, rec.Body.String())
+	}
+}
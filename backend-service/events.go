@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// liveBacklog é quantos eventos recentes de cada usuário ficam guardados para a retomada
+	liveBacklog = 200
+	// liveBuffer é quantos eventos podem esperar por uma conexão lenta antes de ela ser encerrada
+	liveBuffer            = 64
+	maxLiveConnsPerUser   = 10
+	liveRetryMilliseconds = 5000
+
+	liveEventConnected = "conectado"
+	liveEventReset     = "reset"
+
+	// streamTicketTTL é a validade de um ticket de conexão ao vivo, que só pode ser usado uma vez
+	streamTicketTTL = 30 * time.Second
+)
+
+var (
+	liveHeartbeat = 25 * time.Second
+	// liveResumeWindow é quanto tempo os eventos de um usuário sem conexões continuam guardados
+	// para a retomada; depois disso o usuário sai do hub
+	liveResumeWindow = time.Minute
+	liveHub          = newLiveEventHub()
+	liveConnections  atomic.Int64
+	streamTickets    = &streamTicketStore{tickets: map[string]streamTicket{}}
+)
+
+// publishEvent avisa os webhooks e as conexões ao vivo do usuário sobre uma alteração; pode
+// ser chamado com storeMu travado
+func publishEvent(tenantID, userID int, event string, data interface{}) {
+	webhooks.emit(tenantID, userID, event, data)
+	liveHub.publish(tenantID, userID, event, data)
+}
+
+// LiveEvent é uma alteração enviada às conexões abertas do usuário
+type LiveEvent struct {
+	ID     int64           `json:"id"`
+	Evento string          `json:"evento"`
+	Dados  json.RawMessage `json:"dados,omitempty"`
+	Em     time.Time       `json:"em"`
+}
+
+type liveUser struct {
+	backlog []LiveEvent
+	// since é o maior ID já descartado do backlog (ou anterior à entrada do usuário no hub):
+	// só um Last-Event-ID a partir dele é retomável
+	since       int64
+	subscribers map[chan LiveEvent]struct{}
+	// idleSince é quando a última conexão do usuário saiu; zero enquanto houver conexões
+	idleSince time.Time
+}
+
+// liveEventHub distribui os eventos às conexões de cada usuário. Os IDs são globais e começam
+// no instante da inicialização, então um Last-Event-ID anterior a um reinício é reconhecido
+// como não retomável. Só guarda usuários com conexões abertas ou dentro de liveResumeWindow
+// após a última sair. Tem lock próprio e nunca trava storeMu.
+type liveEventHub struct {
+	mu     sync.Mutex
+	nextID int64
+	users  map[userKey]*liveUser
+}
+
+func newLiveEventHub() *liveEventHub {
+	return &liveEventHub{nextID: time.Now().UnixMicro(), users: map[userKey]*liveUser{}}
+}
+
+// user retorna o estado do usuário, criando-o; eventos anteriores à criação não são
+// retomáveis. h.mu deve estar travado
+func (h *liveEventHub) user(key userKey) *liveUser {
+	u, ok := h.users[key]
+	if !ok {
+		u = &liveUser{since: h.nextID - 1, subscribers: map[chan LiveEvent]struct{}{}}
+		h.users[key] = u
+	}
+	return u
+}
+
+func (h *liveEventHub) publish(tenantID, userID int, event string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Erro ao montar o evento ao vivo %s do User %d: %v", event, userID, err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ev := LiveEvent{ID: h.nextID, Evento: event, Dados: payload, Em: time.Now().UTC()}
+	h.nextID++
+
+	// Sem conexões nem retomada pendente, ninguém receberá o evento
+	u, ok := h.users[userKey{TenantID: tenantID, UserID: userID}]
+	if !ok {
+		return
+	}
+	u.backlog = append(u.backlog, ev)
+	if len(u.backlog) > liveBacklog {
+		u.since = u.backlog[0].ID
+		u.backlog = append(u.backlog[:0:0], u.backlog[1:]...)
+	}
+	for ch := range u.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			// Conexão lenta: é encerrada e o cliente retoma pelo Last-Event-ID ao reconectar
+			delete(u.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// liveSubscription é uma conexão registrada, com os eventos a reenviar antes dos novos
+type liveSubscription struct {
+	key    userKey
+	ch     chan LiveEvent
+	replay []LiveEvent
+	// first é o evento enviado depois do replay: "conectado", ou "reset" quando a retomada não
+	// é possível e o cliente deve recarregar os dados
+	first LiveEvent
+}
+
+var errLiveLimit = errors.New("limite de conexões ao vivo atingido")
+
+// subscribe registra a conexão; com lastID, reenvia os eventos posteriores a ele. O registro e
+// o cálculo do replay acontecem sob o mesmo lock, sem perder nem repetir eventos.
+func (h *liveEventHub) subscribe(key userKey, lastID *int64) (*liveSubscription, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	u := h.user(key)
+	if len(u.subscribers) >= maxLiveConnsPerUser {
+		return nil, errLiveLimit
+	}
+	sub := &liveSubscription{
+		key:   key,
+		ch:    make(chan LiveEvent, liveBuffer),
+		first: LiveEvent{ID: h.nextID - 1, Evento: liveEventConnected, Em: time.Now().UTC()},
+	}
+	if lastID != nil {
+		if *lastID < u.since || *lastID >= h.nextID {
+			sub.first.Evento = liveEventReset
+		} else {
+			for _, ev := range u.backlog {
+				if ev.ID > *lastID {
+					sub.replay = append(sub.replay, ev)
+				}
+			}
+		}
+	}
+	u.subscribers[sub.ch] = struct{}{}
+	u.idleSince = time.Time{}
+	return sub, nil
+}
+
+// unsubscribe remove a conexão; quando a última do usuário sai, os eventos ficam guardados por
+// liveResumeWindow para a reconexão e depois o usuário é removido do hub
+func (h *liveEventHub) unsubscribe(sub *liveSubscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	u, ok := h.users[sub.key]
+	if !ok {
+		return
+	}
+	delete(u.subscribers, sub.ch)
+	if len(u.subscribers) > 0 {
+		return
+	}
+	if liveResumeWindow <= 0 {
+		delete(h.users, sub.key)
+		return
+	}
+	idleSince := time.Now()
+	u.idleSince = idleSince
+	time.AfterFunc(liveResumeWindow, func() { h.dropIdle(sub.key, idleSince) })
+}
+
+// dropIdle remove o usuário se ele continua sem conexões desde idleSince
+func (h *liveEventHub) dropIdle(key userKey, idleSince time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if u, ok := h.users[key]; ok && len(u.subscribers) == 0 && u.idleSince.Equal(idleSince) {
+		delete(h.users, key)
+	}
+}
+
+// liveStream é o transporte de uma conexão ao vivo: Server-Sent Events ou WebSocket
+type liveStream interface {
+	send(ev LiveEvent) error
+	heartbeat() error
+}
+
+// streamLiveEvents envia o replay e os eventos novos até a conexão cair. A cada heartbeat o
+// token é validado de novo, então um token revogado encerra a conexão.
+func streamLiveEvents(ctx context.Context, stream liveStream, sub *liveSubscription, token, reqID string) {
+	liveConnections.Add(1)
+	defer liveConnections.Add(-1)
+
+	for _, ev := range sub.replay {
+		if stream.send(ev) != nil {
+			return
+		}
+	}
+	if stream.send(sub.first) != nil {
+		return
+	}
+
+	// Com liveHeartbeat zero não há heartbeat: o canal nil nunca dispara
+	var heartbeat <-chan time.Time
+	if liveHeartbeat > 0 {
+		ticker := time.NewTicker(liveHeartbeat)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-sub.ch:
+			if !ok || stream.send(ev) != nil {
+				return
+			}
+		case <-heartbeat:
+			// Com o Auth Service fora do ar a conexão continua; só um token recusado a encerra
+			if _, err := validateToken(ctx, token, reqID); errors.Is(err, errTokenInvalid) {
+				log.Printf("Conexão ao vivo encerrada: token recusado - User %d: %v", sub.key.UserID, err)
+				return
+			}
+			if stream.heartbeat() != nil {
+				return
+			}
+		}
+	}
+}
+
+// streamTicket autoriza uma única conexão ao vivo. Como o EventSource e o WebSocket do
+// navegador não enviam o header Authorization, o cliente troca o token por um ticket e o passa
+// em ?ticket=, para que o token de sessão não apareça em URLs e logs.
+type streamTicket struct {
+	principal Principal
+	// token é revalidado a cada heartbeat da conexão
+	token   string
+	expires time.Time
+}
+
+// streamTicketStore guarda os tickets emitidos pelo SHA-256 do valor
+type streamTicketStore struct {
+	mu      sync.Mutex
+	tickets map[string]streamTicket
+}
+
+func hashStreamTicket(ticket string) string {
+	sum := sha256.Sum256([]byte(ticket))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *streamTicketStore) issue(principal Principal, token string, now time.Time) (string, time.Time, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", time.Time{}, err
+	}
+	ticket := hex.EncodeToString(raw)
+	expires := now.Add(streamTicketTTL)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, t := range s.tickets {
+		if !now.Before(t.expires) {
+			delete(s.tickets, hash)
+		}
+	}
+	s.tickets[hashStreamTicket(ticket)] = streamTicket{principal: principal, token: token, expires: expires}
+	return ticket, expires, nil
+}
+
+// redeem consome o ticket: ele vale uma única vez, mesmo que a conexão falhe depois
+func (s *streamTicketStore) redeem(ticket string, now time.Time) (streamTicket, bool) {
+	hash := hashStreamTicket(ticket)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tickets[hash]
+	if !ok {
+		return streamTicket{}, false
+	}
+	delete(s.tickets, hash)
+	return t, now.Before(t.expires)
+}
+
+// StreamTicketResponse é o ticket de uma conexão ao vivo
+type StreamTicketResponse struct {
+	Ticket    string    `json:"ticket"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// createStreamTicketHandler emite um ticket para abrir o /events
+func createStreamTicketHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	ticket, expires, err := streamTickets.issue(principal, token, time.Now())
+	if err != nil {
+		log.Printf("Erro ao gerar ticket de conexão ao vivo para user %d: %v", principal.UserID, err)
+		writeErrorResponse(w, r, http.StatusInternalServerError, "INTERNAL_ERROR", "internal_error")
+		return
+	}
+
+	logUserAction(principal.UserID, "CREATE", "ticket de eventos ao vivo")
+	writeSuccessResponse(w, r, "stream_ticket_created", StreamTicketResponse{Ticket: ticket, ExpiresAt: expires})
+}
+
+type streamTokenKey struct{}
+
+// withStreamTicket autentica o /events pelo ?ticket=; sem ticket, exige o header Authorization
+// como as demais rotas
+func withStreamTicket(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		raw := r.URL.Query().Get("ticket")
+		if raw == "" {
+			authMiddleware(next)(w, r)
+			return
+		}
+		ticket, ok := streamTickets.redeem(raw, time.Now())
+		if !ok {
+			log.Printf("Acesso negado: ticket de conexão ao vivo inválido ou expirado - IP: %s", r.RemoteAddr)
+			writeErrorResponse(w, r, http.StatusUnauthorized, "UNAUTHORIZED", "stream_ticket_invalid")
+			return
+		}
+		ctx := withPrincipal(r.Context(), ticket.principal)
+		ctx = context.WithValue(ctx, streamTokenKey{}, ticket.token)
+		log.Printf("Acesso autorizado por ticket: User %d (tenant %d) - %s %s", ticket.principal.UserID, ticket.principal.TenantID, r.Method, r.URL.Path)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// sseStream escreve os eventos no formato text/event-stream
+type sseStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func (s *sseStream) send(ev LiveEvent) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, "id: %d\ndata: %s\n\n", ev.ID, data); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+func (s *sseStream) heartbeat() error {
+	if _, err := fmt.Fprint(s.w, ": ping\n\n"); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// eventsHandler transmite as alterações do usuário por Server-Sent Events ou, com um pedido de
+// upgrade, por WebSocket. A retomada usa o header Last-Event-ID (enviado pelo EventSource ao
+// reconectar) ou ?last_event_id=.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
+
+	raw := r.Header.Get("Last-Event-ID")
+	if raw == "" {
+		raw = r.URL.Query().Get("last_event_id")
+	}
+	var lastID *int64
+	if raw != "" {
+		parsed, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || parsed < 0 {
+			writeValidationError(w, r, []FieldError{fieldError("last_event_id", "query_positive_int")})
+			return
+		}
+		lastID = &parsed
+	}
+
+	websocket := isWebSocketUpgrade(r)
+	flusher, canFlush := w.(http.Flusher)
+	if !websocket && !canFlush {
+		writeErrorResponse(w, r, http.StatusInternalServerError, "INTERNAL_ERROR", "live_unsupported")
+		return
+	}
+
+	sub, err := liveHub.subscribe(userKey{TenantID: tenantID, UserID: userID}, lastID)
+	if err != nil {
+		writeErrorResponse(w, r, http.StatusTooManyRequests, "TOO_MANY_CONNECTIONS", "live_limit", maxLiveConnsPerUser)
+		return
+	}
+	defer liveHub.unsubscribe(sub)
+
+	token, _ := r.Context().Value(streamTokenKey{}).(string)
+	if token == "" {
+		token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	if websocket {
+		conn, err := upgradeWebSocket(w, r)
+		if err != nil {
+			log.Printf("Falha no upgrade para WebSocket - User %d: %v", userID, err)
+			return
+		}
+		defer conn.Close()
+
+		logUserAction(userID, "STREAM", "eventos ao vivo (websocket)")
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			// Lê os frames do cliente (ping, close) até a conexão cair
+			conn.readLoop()
+			cancel()
+		}()
+		streamLiveEvents(ctx, conn, sub, token, requestID(r))
+		cancel()
+		conn.writeClose()
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", liveRetryMilliseconds)
+	flusher.Flush()
+
+	logUserAction(userID, "STREAM", "eventos ao vivo (sse)")
+	streamLiveEvents(r.Context(), &sseStream{w: w, flusher: flusher}, sub, token, requestID(r))
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamTicketSingleUseAndExpiry(t *testing.T) {
+	principal := Principal{UserID: 941, TenantID: 1}
+	now := time.Now()
+
+	ticket, _, err := streamTickets.issue(principal, "token-de-teste", now)
+	if err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+	redeemed, ok := streamTickets.redeem(ticket, now)
+	if !ok || redeemed.principal.UserID != principal.UserID || redeemed.token != "token-de-teste" {
+		t.Fatalf("redeem = %+v, %v", redeemed, ok)
+	}
+	if _, ok := streamTickets.redeem(ticket, now); ok {
+		t.Fatal("ticket aceito duas vezes")
+	}
+
+	expired, _, err := streamTickets.issue(principal, "token-de-teste", now)
+	if err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+	if _, ok := streamTickets.redeem(expired, now.Add(streamTicketTTL)); ok {
+		t.Fatal("ticket expirado aceito")
+	}
+}
+
+func TestEventsWithTicket(t *testing.T) {
+	principal := Principal{UserID: 942, TenantID: 1}
+	server := httptest.NewServer(withStreamTicket(eventsHandler))
+	defer server.Close()
+
+	rec := doRequest(t, createStreamTicketHandler, http.MethodPost, "/events/ticket", nil, principal)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("emitir ticket: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var issued StreamTicketResponse
+	decodeData(t, rec, &issued)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"?ticket="+issued.Ticket, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /events: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /events: status %d", resp.StatusCode)
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "data: ") {
+			if !strings.Contains(scanner.Text(), `"evento":"conectado"`) {
+				t.Fatalf("primeiro evento = %s", scanner.Text())
+			}
+			break
+		}
+	}
+
+	// O mesmo ticket não abre uma segunda conexão, nem o token antigo na URL
+	for _, query := range []string{"?ticket=" + issued.Ticket, "?access_token=token-de-teste"} {
+		again, err := http.Get(server.URL + query)
+		if err != nil {
+			t.Fatalf("GET /events%s: %v", query, err)
+		}
+		again.Body.Close()
+		if again.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("GET /events%s: status %d; esperado 401", query, again.StatusCode)
+		}
+	}
+}
+
+func TestWebSocketRejectsForeignOrigin(t *testing.T) {
+	upgrade := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/events", nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		req.Header.Set("Origin", origin)
+		rec := httptest.NewRecorder()
+		if _, err := upgradeWebSocket(rec, req); err == nil {
+			t.Fatalf("upgrade de %s aceito por um ResponseRecorder", origin)
+		}
+		return rec
+	}
+
+	if rec := upgrade("https://site-malicioso.exemplo"); rec.Code != http.StatusForbidden {
+		t.Fatalf("origem estrangeira: status %d; esperado 403", rec.Code)
+	}
+	// A origem do frontend passa da checagem e só falha no hijack do ResponseRecorder
+	if rec := upgrade(corsOrigins[0]); rec.Code == http.StatusForbidden {
+		t.Fatalf("origem do frontend recusada")
+	}
+}
+
+func TestLiveHubDropsIdleUsers(t *testing.T) {
+	hub := newLiveEventHub()
+	key := userKey{TenantID: 1, UserID: 943}
+
+	// Sem conexões, os eventos não são guardados
+	hub.publish(key.TenantID, key.UserID, eventMateriaCreated, nil)
+	if len(hub.users) != 0 {
+		t.Fatalf("usuário sem conexões guardado no hub")
+	}
+
+	sub, err := hub.subscribe(key, nil)
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	hub.publish(key.TenantID, key.UserID, eventMateriaUpdated, nil)
+	lastID := (<-sub.ch).ID
+	hub.unsubscribe(sub)
+
+	// Dentro da janela de retomada, a reconexão recebe o que perdeu
+	hub.publish(key.TenantID, key.UserID, eventMateriaDeleted, nil)
+	resumed, err := hub.subscribe(key, &lastID)
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	if len(resumed.replay) != 1 || resumed.replay[0].Evento != eventMateriaDeleted || resumed.first.Evento != liveEventConnected {
+		t.Fatalf("retomada = %+v, %s", resumed.replay, resumed.first.Evento)
+	}
+	hub.unsubscribe(resumed)
+
+	// Passada a janela, o usuário sai do hub e a retomada vira reset
+	hub.mu.Lock()
+	idleSince := hub.users[key].idleSince
+	hub.mu.Unlock()
+	hub.dropIdle(key, idleSince)
+	if len(hub.users) != 0 {
+		t.Fatalf("usuário ocioso continua no hub")
+	}
+	again, err := hub.subscribe(key, &lastID)
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	if again.first.Evento != liveEventReset {
+		t.Fatalf("retomada após a janela = %s; esperado reset", again.first.Evento)
+	}
+	hub.unsubscribe(again)
+
+	window := liveResumeWindow
+	liveResumeWindow = 0
+	defer func() { liveResumeWindow = window }()
+	last, _ := hub.subscribe(key, nil)
+	hub.unsubscribe(last)
+	if len(hub.users) != 0 {
+		t.Fatalf("usuário continua no hub sem janela de retomada")
+	}
+}
@@ -5,6 +5,9 @@ go 1.21
 require (
 	github.com/gorilla/handlers v1.5.2
 	github.com/gorilla/mux v1.8.1
+	smtpmail v0.0.0
 )
 
 require github.com/felixge/httpsnoop v1.0.3 // indirect
+
+replace smtpmail => ../smtpmail
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	langPtBR    = "pt-BR"
+	langEn      = "en"
+	defaultLang = langPtBR
+)
+
+// messages é o catálogo de mensagens exibidas ao cliente, por chave e idioma
+var messages = map[string]map[string]string{
+	"invalid_body":               {langPtBR: "Dados inválidos fornecidos", langEn: "Invalid data provided"},
+	"validation_failed":          {langPtBR: "Alguns campos são inválidos", langEn: "Some fields are invalid"},
+	"token_missing":              {langPtBR: "Token de autenticação não fornecido", langEn: "Authentication token not provided"},
+	"token_format":               {langPtBR: "Formato de token inválido", langEn: "Invalid token format"},
+	"token_invalid":              {langPtBR: "Token de autenticação inválido ou expirado", langEn: "Invalid or expired authentication token"},
+	"auth_unavailable":           {langPtBR: "Serviço de autenticação indisponível, tente novamente em instantes", langEn: "Authentication service unavailable, try again shortly"},
+	"impersonation_forbidden":    {langPtBR: "Ação não permitida durante a personificação de um usuário", langEn: "Action not allowed while impersonating a user"},
+	"materia_not_found":          {langPtBR: "Matéria não encontrada ou não pertence ao usuário", langEn: "Subject not found or not owned by the user"},
+	"materia_name_exists":        {langPtBR: "Já existe uma matéria com este nome", langEn: "A subject with this name already exists"},
+	"materia_has_provas":         {langPtBR: "Não é possível excluir a matéria pois existem provas/trabalhos associados a ela; use cascade=true para excluí-los junto ou move_to={id} para transferi-los", langEn: "The subject cannot be deleted because it has exams/assignments; use cascade=true to delete them too or move_to={id} to move them"},
+	"if_match_required":          {langPtBR: "Envie o header If-Match com o ETag da versão que está sendo alterada", langEn: "Send the If-Match header with the ETag of the version being changed"},
+	"version_mismatch":           {langPtBR: "O registro foi alterado por outra requisição; confira a versão atual e tente novamente", langEn: "The record was changed by another request; review the current version and try again"},
+	"trash_item_not_found":       {langPtBR: "Item não encontrado na lixeira", langEn: "Item not found in the trash"},
+	"prova_materia_deleted":      {langPtBR: "A matéria desta prova/trabalho está na lixeira; restaure-a primeiro", langEn: "The subject of this exam/assignment is in the trash; restore it first"},
+	"move_target_not_found":      {langPtBR: "Matéria de destino não encontrada ou não pertence ao usuário", langEn: "Target subject not found or not owned by the user"},
+	"attachment_not_found":       {langPtBR: "Anexo não encontrado", langEn: "Attachment not found"},
+	"attachment_multipart":       {langPtBR: "Envie os arquivos como multipart/form-data", langEn: "Send the files as multipart/form-data"},
+	"attachment_empty":           {langPtBR: "O arquivo %s está vazio", langEn: "The file %s is empty"},
+	"attachment_type":            {langPtBR: "Tipo de arquivo não permitido para %s (%s); envie PDF, imagem, texto ou documento do Office", langEn: "File type not allowed for %s (%s); send a PDF, image, text or Office document"},
+	"attachment_too_large":       {langPtBR: "O arquivo %s excede o limite de %d bytes", langEn: "The file %s exceeds the %d byte limit"},
+	"attachment_body_too_large":  {langPtBR: "O envio excede o tamanho total permitido", langEn: "The upload exceeds the allowed total size"},
+	"attachment_limit":           {langPtBR: "Cada prova/trabalho aceita no máximo %d anexos", langEn: "Each exam/assignment accepts at most %d attachments"},
+	"attachment_range":           {langPtBR: "Intervalo de bytes fora do arquivo", langEn: "Byte range outside the file"},
+	"attachment_storage":         {langPtBR: "Armazenamento de anexos indisponível; tente novamente", langEn: "Attachment storage unavailable; try again"},
+	"bibliografia_media_type":    {langPtBR: "Content-Type não suportado; envie BibTeX (application/x-bibtex) ou RIS (application/x-research-info-systems), ou use ?format=bibtex|ris", langEn: "Unsupported Content-Type; send BibTeX (application/x-bibtex) or RIS (application/x-research-info-systems), or use ?format=bibtex|ris"},
+	"bibliografia_too_large":     {langPtBR: "O arquivo de referências excede o limite de %d bytes", langEn: "The references file exceeds the %d byte limit"},
+	"bibliografia_syntax":        {langPtBR: "Arquivo de referências inválido perto da linha %d", langEn: "Invalid references file near line %d"},
+	"bibliografia_empty":         {langPtBR: "Nenhuma referência encontrada no arquivo", langEn: "No references found in the file"},
+	"bibliografia_limit":         {langPtBR: "Cada prova/trabalho aceita no máximo %d referências", langEn: "Each exam/assignment accepts at most %d references"},
+	"calendar_feed_not_found":    {langPtBR: "Feed de calendário não encontrado ou revogado", langEn: "Calendar feed not found or revoked"},
+	"calendar_feed_failed":       {langPtBR: "Não foi possível gerar o feed de calendário", langEn: "Could not generate the calendar feed"},
+	"ics_too_large":              {langPtBR: "O arquivo de calendário excede o limite de %d bytes", langEn: "The calendar file exceeds the %d byte limit"},
+	"ics_syntax":                 {langPtBR: "Arquivo de calendário inválido perto da linha %d", langEn: "Invalid calendar file near line %d"},
+	"ics_empty":                  {langPtBR: "Nenhum evento ou tarefa encontrado no calendário", langEn: "No events or to-dos found in the calendar"},
+	"notificacao_not_found":      {langPtBR: "Notificação não encontrada", langEn: "Notification not found"},
+	"webhook_not_found":          {langPtBR: "Webhook não encontrado", langEn: "Webhook not found"},
+	"webhook_delivery_not_found": {langPtBR: "Entrega de webhook não encontrada", langEn: "Webhook delivery not found"},
+	"webhook_delivery_pending":   {langPtBR: "A entrega ainda está pendente", langEn: "The delivery is still pending"},
+	"webhook_limit":              {langPtBR: "Cada usuário pode ter no máximo %d webhooks", langEn: "Each user can have at most %d webhooks"},
+	"webhook_failed":             {langPtBR: "Não foi possível preparar o webhook", langEn: "Could not prepare the webhook"},
+	"webhook_inactive":           {langPtBR: "O webhook está inativo; ative-o para reenviar entregas", langEn: "The webhook is inactive; activate it to redeliver"},
+	"live_limit":                 {langPtBR: "Limite de %d conexões ao vivo atingido; feche outra aba e tente novamente", langEn: "Limit of %d live connections reached; close another tab and try again"},
+	"live_unsupported":           {langPtBR: "Conexão sem suporte a streaming", langEn: "Connection does not support streaming"},
+	"websocket_version":          {langPtBR: "Versão do WebSocket não suportada; use 13", langEn: "Unsupported WebSocket version; use 13"},
+	"websocket_handshake":        {langPtBR: "Handshake do WebSocket inválido", langEn: "Invalid WebSocket handshake"},
+	"websocket_origin":           {langPtBR: "Origem não autorizada para o WebSocket", langEn: "Origin not allowed for the WebSocket"},
+	"stream_ticket_invalid":      {langPtBR: "Ticket de conexão ao vivo inválido, expirado ou já usado", langEn: "Live connection ticket is invalid, expired or already used"},
+	"prova_not_found":            {langPtBR: "Prova/Trabalho não encontrado ou não pertence ao usuário", langEn: "Exam/assignment not found or not owned by the user"},
+
+	"materias_loaded":           {langPtBR: "Matérias carregadas com sucesso", langEn: "Subjects loaded successfully"},
+	"materia_loaded":            {langPtBR: "Matéria carregada com sucesso", langEn: "Subject loaded successfully"},
+	"materia_created":           {langPtBR: "Matéria criada com sucesso", langEn: "Subject created successfully"},
+	"materia_updated":           {langPtBR: "Matéria atualizada com sucesso", langEn: "Subject updated successfully"},
+	"materia_deleted":           {langPtBR: "Matéria movida para a lixeira", langEn: "Subject moved to the trash"},
+	"materia_restored":          {langPtBR: "Matéria restaurada com sucesso", langEn: "Subject restored successfully"},
+	"provas_loaded":             {langPtBR: "Provas/Trabalhos carregados com sucesso", langEn: "Exams/assignments loaded successfully"},
+	"prova_loaded":              {langPtBR: "Prova/Trabalho carregado com sucesso", langEn: "Exam/assignment loaded successfully"},
+	"prova_created":             {langPtBR: "Prova/Trabalho criado com sucesso", langEn: "Exam/assignment created successfully"},
+	"prova_updated":             {langPtBR: "Prova/Trabalho atualizada com sucesso", langEn: "Exam/assignment updated successfully"},
+	"prova_deleted":             {langPtBR: "Prova/Trabalho movido para a lixeira", langEn: "Exam/assignment moved to the trash"},
+	"prova_restored":            {langPtBR: "Prova/Trabalho restaurado com sucesso", langEn: "Exam/assignment restored successfully"},
+	"attachments_uploaded":      {langPtBR: "Anexos enviados com sucesso", langEn: "Attachments uploaded successfully"},
+	"attachment_deleted":        {langPtBR: "Anexo excluído com sucesso", langEn: "Attachment deleted successfully"},
+	"bibliografia_loaded":       {langPtBR: "Bibliografia carregada com sucesso", langEn: "Bibliography loaded successfully"},
+	"bibliografia_imported":     {langPtBR: "Referências importadas com sucesso", langEn: "References imported successfully"},
+	"calendar_feeds_loaded":     {langPtBR: "Feeds de calendário carregados com sucesso", langEn: "Calendar feeds loaded successfully"},
+	"calendar_feed_created":     {langPtBR: "Feed de calendário gerado com sucesso", langEn: "Calendar feed generated successfully"},
+	"calendar_feed_deleted":     {langPtBR: "Feed de calendário revogado", langEn: "Calendar feed revoked"},
+	"ics_preview":               {langPtBR: "Prévia da importação do calendário; nada foi gravado", langEn: "Calendar import preview; nothing was saved"},
+	"ics_imported":              {langPtBR: "Calendário importado com sucesso", langEn: "Calendar imported successfully"},
+	"reminder_settings_loaded":  {langPtBR: "Preferências de lembretes carregadas com sucesso", langEn: "Reminder settings loaded successfully"},
+	"reminder_settings_updated": {langPtBR: "Preferências de lembretes atualizadas com sucesso", langEn: "Reminder settings updated successfully"},
+	"notificacoes_loaded":       {langPtBR: "Notificações carregadas com sucesso", langEn: "Notifications loaded successfully"},
+	"notificacao_read":          {langPtBR: "Notificação marcada como lida", langEn: "Notification marked as read"},
+	"webhooks_loaded":           {langPtBR: "Webhooks carregados com sucesso", langEn: "Webhooks loaded successfully"},
+	"webhook_loaded":            {langPtBR: "Webhook carregado com sucesso", langEn: "Webhook loaded successfully"},
+	"webhook_created":           {langPtBR: "Webhook criado; guarde o segredo, ele não será mostrado de novo", langEn: "Webhook created; store the secret, it will not be shown again"},
+	"webhook_updated":           {langPtBR: "Webhook atualizado com sucesso", langEn: "Webhook updated successfully"},
+	"webhook_deleted":           {langPtBR: "Webhook excluído com sucesso", langEn: "Webhook deleted successfully"},
+	"stream_ticket_created":     {langPtBR: "Ticket de conexão ao vivo emitido", langEn: "Live connection ticket issued"},
+	"webhook_tested":            {langPtBR: "Evento de teste enviado", langEn: "Test event sent"},
+	"webhook_deliveries_loaded": {langPtBR: "Entregas de webhook carregadas com sucesso", langEn: "Webhook deliveries loaded successfully"},
+	"webhook_redelivered":       {langPtBR: "Entrega reenfileirada", langEn: "Delivery queued again"},
+	"webhook_test_message":      {langPtBR: "Evento de teste do Sistema de Estudos", langEn: "Sistema de Estudos test event"},
+	"webhook_test_failed":       {langPtBR: "O endereço não confirmou o recebimento do evento", langEn: "The address did not acknowledge the event"},
+	"trash_loaded":              {langPtBR: "Lixeira carregada com sucesso", langEn: "Trash loaded successfully"},
+	"stats_loaded":              {langPtBR: "Estatísticas carregadas com sucesso", langEn: "Statistics loaded successfully"},
+	"search_done":               {langPtBR: "Busca realizada com sucesso", langEn: "Search completed successfully"},
+
+	// Lembretes de entrega
+	"reminder_subject": {langPtBR: "Lembrete: %s", langEn: "Reminder: %s"},
+	"reminder_body":    {langPtBR: "%s (%s) tem entrega em %s, daqui a %s.", langEn: "%s (%s) is due on %s, in %s."},
+	"reminder_day":     {langPtBR: "%d dia", langEn: "%d day"},
+	"reminder_days":    {langPtBR: "%d dias", langEn: "%d days"},
+	"reminder_hour":    {langPtBR: "%d hora", langEn: "%d hour"},
+	"reminder_hours":   {langPtBR: "%d horas", langEn: "%d hours"},
+	"reminder_minute":  {langPtBR: "%d minuto", langEn: "%d minute"},
+	"reminder_minutes": {langPtBR: "%d minutos", langEn: "%d minutes"},
+
+	// Mensagens de campo
+	"field_required":             {langPtBR: "%s é obrigatório", langEn: "%s is required"},
+	"field_min_length":           {langPtBR: "%s deve ter pelo menos %d caracteres", langEn: "%s must be at least %d characters long"},
+	"data_entrega_past":          {langPtBR: "Data de entrega não pode ser no passado", langEn: "Due date cannot be in the past"},
+	"reminder_leads":             {langPtBR: "Informe de 1 a %d antecedências", langEn: "Provide 1 to %d lead times"},
+	"reminder_lead_range":        {langPtBR: "A antecedência deve ficar entre %d e %d minutos", langEn: "The lead time must be between %d and %d minutes"},
+	"reminder_channels_required": {langPtBR: "Escolha pelo menos um canal", langEn: "Choose at least one channel"},
+	"reminder_channel":           {langPtBR: "Canal não suportado: %s; use email, webhook ou in_app", langEn: "Unsupported channel: %s; use email, webhook or in_app"},
+	"reminder_email_missing":     {langPtBR: "A conta não tem email para receber lembretes", langEn: "The account has no email to receive reminders"},
+	"webhook_events_required":    {langPtBR: "Escolha pelo menos um evento", langEn: "Choose at least one event"},
+	"webhook_event":              {langPtBR: "Evento não suportado: %s; use *, materia.*, prova.* ou um de: %s", langEn: "Unsupported event: %s; use *, materia.*, prova.* or one of: %s"},
+	"webhook_status":             {langPtBR: "Status não suportado: %s; use pendente, entregue, falhou ou dead_letter", langEn: "Unsupported status: %s; use pendente, entregue, falhou or dead_letter"},
+	"referencia_tipo":            {langPtBR: "Tipo de referência inválido: %q; use livro, capitulo, artigo, site, tese, dissertacao ou outro", langEn: "Invalid reference type: %q; use livro, capitulo, artigo, site, tese, dissertacao or outro"},
+	"referencia_ano":             {langPtBR: "Ano deve estar entre %d e %d", langEn: "Year must be between %d and %d"},
+	"referencia_doi":             {langPtBR: "DOI inválido; use o formato 10.xxxx/...", langEn: "Invalid DOI; use the 10.xxxx/... format"},
+	"referencia_isbn":            {langPtBR: "ISBN inválido", langEn: "Invalid ISBN"},
+	"referencia_url":             {langPtBR: "URL inválida; use um endereço http ou https", langEn: "Invalid URL; use an http or https address"},
+	"target_url_blocked":         {langPtBR: "Endereços de rede interna não são permitidos", langEn: "Internal network addresses are not allowed"},
+	"target_url_unresolved":      {langPtBR: "Não foi possível resolver o endereço", langEn: "Could not resolve the address"},
+	"referencia_data":            {langPtBR: "Data inválida; use AAAA-MM-DD", langEn: "Invalid date; use YYYY-MM-DD"},
+
+	// Parâmetros de listagem
+	"query_limit_range":    {langPtBR: "Deve ser um número entre 1 e %d", langEn: "Must be a number between 1 and %d"},
+	"query_sort_field":     {langPtBR: "Campo de ordenação não suportado: %s", langEn: "Unsupported sort field: %s"},
+	"query_cursor":         {langPtBR: "Cursor inválido ou de outra ordenação", langEn: "Invalid cursor or cursor from a different sort"},
+	"query_positive_int":   {langPtBR: "Deve ser um número inteiro positivo", langEn: "Must be a positive integer"},
+	"query_date":           {langPtBR: "Data inválida; use RFC 3339 ou AAAA-MM-DD", langEn: "Invalid date; use RFC 3339 or YYYY-MM-DD"},
+	"query_bool":           {langPtBR: "Use true ou false", langEn: "Use true or false"},
+	"patch_media_type":     {langPtBR: "Content-Type não suportado; use application/merge-patch+json ou application/json-patch+json", langEn: "Unsupported Content-Type; use application/merge-patch+json or application/json-patch+json"},
+	"patch_invalid_op":     {langPtBR: "Operação inválida na posição %d do JSON Patch", langEn: "Invalid operation at index %d of the JSON Patch"},
+	"patch_invalid_path":   {langPtBR: "Caminho inválido no patch: %s", langEn: "Invalid patch path: %s"},
+	"patch_path_not_found": {langPtBR: "Caminho não encontrado no documento: %s", langEn: "Path not found in the document: %s"},
+	"patch_test_failed":    {langPtBR: "O teste do JSON Patch falhou em %s", langEn: "JSON Patch test failed at %s"},
+	"patch_result_invalid": {langPtBR: "O patch resulta em um documento inválido ou altera campos não editáveis", langEn: "The patch results in an invalid document or changes non-editable fields"},
+	"move_to_same":         {langPtBR: "A matéria de destino deve ser diferente da excluída", langEn: "The target subject must differ from the deleted one"},
+	"delete_mode_conflict": {langPtBR: "Use cascade ou move_to, não os dois", langEn: "Use either cascade or move_to, not both"},
+	"query_include":        {langPtBR: "Expansão não suportada: %s", langEn: "Unsupported include: %s"},
+	"ics_materia_required": {langPtBR: "Informe materia_id ou use auto_materias=true", langEn: "Provide materia_id or use auto_materias=true"},
+	"timezone_unknown":     {langPtBR: "Fuso horário desconhecido: %s", langEn: "Unknown time zone: %s"},
+	"query_format":         {langPtBR: "Formato não suportado: %s; use json, bibtex, ris, abnt ou abnt-html", langEn: "Unsupported format: %s; use json, bibtex, ris, abnt or abnt-html"},
+}
+
+// fieldLabel é o nome de um campo exibido nas mensagens; é traduzido ao formatar a mensagem
+type fieldLabel string
+
+var fieldLabels = map[fieldLabel]map[string]string{
+	"nome":             {langPtBR: "Nome", langEn: "Name"},
+	"descricao":        {langPtBR: "Descrição", langEn: "Description"},
+	"titulo":           {langPtBR: "Título", langEn: "Title"},
+	"conteudos_estudo": {langPtBR: "Conteúdos de Estudo", langEn: "Study contents"},
+	"materia_id":       {langPtBR: "ID da matéria", langEn: "Subject ID"},
+	"q":                {langPtBR: "Termo de busca", langEn: "Search term"},
+	"url":              {langPtBR: "URL", langEn: "URL"},
+}
+
+// FieldError descreve um problema de validação em um campo específico do corpo da requisição
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+
+	key  string
+	args []interface{}
+}
+
+func fieldError(field, key string, args ...interface{}) FieldError {
+	return FieldError{Field: field, key: key, args: args}
+}
+
+func preferredLanguage(r *http.Request) string {
+	type candidate struct {
+		lang string
+		q    float64
+	}
+
+	var candidates []candidate
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+
+		tag = strings.ToLower(tag)
+		switch {
+		case tag == "pt" || strings.HasPrefix(tag, "pt-"):
+			candidates = append(candidates, candidate{langPtBR, q})
+		case tag == "en" || strings.HasPrefix(tag, "en-"):
+			candidates = append(candidates, candidate{langEn, q})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	if len(candidates) > 0 && candidates[0].q > 0 {
+		return candidates[0].lang
+	}
+	return defaultLang
+}
+
+// localize traduz a chave para o idioma da requisição, formatando os argumentos
+func localize(r *http.Request, key string, args ...interface{}) string {
+	return localizeLang(preferredLanguage(r), key, args...)
+}
+
+// localizeLang traduz a chave para lang; usado fora de uma requisição, como nos lembretes
+func localizeLang(lang, key string, args ...interface{}) string {
+	translations, ok := messages[key]
+	if !ok {
+		return key
+	}
+	text, ok := translations[lang]
+	if !ok {
+		text = translations[defaultLang]
+	}
+	if len(args) > 0 {
+		for i, arg := range args {
+			if label, ok := arg.(fieldLabel); ok {
+				args[i] = fieldLabels[label][lang]
+			}
+		}
+		return fmt.Sprintf(text, args...)
+	}
+	return text
+}
+
+type requestIDKey struct{}
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestIDMiddleware propaga o X-Request-ID recebido (ou gera um novo) e o devolve na resposta
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			raw := make([]byte, 8)
+			rand.Read(raw)
+			id = hex.EncodeToString(raw)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// writeErrorResponse responde com o envelope de erro padrão, com a mensagem no idioma do cliente
+func writeErrorResponse(w http.ResponseWriter, r *http.Request, statusCode int, errorCode, messageKey string, args ...interface{}) {
+	writeErrorEnvelope(w, r, statusCode, ErrorResponse{
+		Error:   errorCode,
+		Message: localize(r, messageKey, args...),
+	})
+}
+
+// writeValidationError responde 400 VALIDATION_ERROR com os problemas de cada campo
+func writeValidationError(w http.ResponseWriter, r *http.Request, details []FieldError) {
+	for i := range details {
+		details[i].Message = localize(r, details[i].key, details[i].args...)
+	}
+
+	// Com um único problema, a mensagem principal já o descreve
+	message := localize(r, "validation_failed")
+	if len(details) == 1 {
+		message = details[0].Message
+	}
+
+	writeErrorEnvelope(w, r, http.StatusBadRequest, ErrorResponse{
+		Error:   "VALIDATION_ERROR",
+		Message: message,
+		Details: details,
+	})
+}
+
+func writeErrorEnvelope(w http.ResponseWriter, r *http.Request, statusCode int, body ErrorResponse) {
+	body.Code = statusCode
+	body.RequestID = requestID(r)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Language", preferredLanguage(r))
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(body)
+}
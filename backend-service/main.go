@@ -1,39 +1,80 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+
+	"smtpmail"
 )
 
 type Materia struct {
-	ID        int       `json:"id"`
-	Nome      string    `json:"nome"`
-	Descricao string    `json:"descricao"`
-	UserID    int       `json:"user_id"`
+	ID        int    `json:"id"`
+	Nome      string `json:"nome"`
+	Descricao string `json:"descricao"`
+	UserID    int    `json:"user_id"`
+	TenantID  int    `json:"tenant_id"`
+	// Administrador que fez a última alteração personificando o usuário (0 = o próprio usuário)
+	ImpersonatedBy int `json:"impersonated_by,omitempty"`
+	// Versão incrementada a cada alteração, exposta como ETag
+	Version   int       `json:"version"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	// Momento em que foi para a lixeira; itens excluídos ficam fora das listagens até a restauração ou o expurgo
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
 type ProvaTrabalho struct {
-	ID              int        `json:"id"`
-	Titulo          string     `json:"titulo"`
-	ConteudosEstudo string     `json:"conteudos_estudo"`
-	Anexos          []string   `json:"anexos"`
-	Referencias     []string   `json:"referencias"`
-	DataEntrega     *time.Time `json:"data_entrega,omitempty"`
-	MateriaID       int        `json:"materia_id"`
-	UserID          int        `json:"user_id"`
-	CreatedAt       time.Time  `json:"created_at"`
-	UpdatedAt       time.Time  `json:"updated_at"`
+	ID              int      `json:"id"`
+	Titulo          string   `json:"titulo"`
+	ConteudosEstudo string   `json:"conteudos_estudo"`
+	Anexos          []string `json:"anexos"`
+	Referencias     []string `json:"referencias"`
+	// Referências estruturadas, exportáveis em BibTeX, RIS e ABNT
+	Bibliografia []Referencia `json:"bibliografia"`
+	DataEntrega  *time.Time   `json:"data_entrega,omitempty"`
+	MateriaID    int          `json:"materia_id"`
+	// Arquivos enviados por POST /provas-trabalhos/{id}/anexos; não são alterados por PUT/PATCH
+	Arquivos []Arquivo `json:"arquivos,omitempty"`
+	// UID do evento de origem quando veio de POST /import/ics; evita duplicar na reimportação
+	ImportUID string `json:"import_uid,omitempty"`
+	UserID    int    `json:"user_id"`
+	TenantID  int    `json:"tenant_id"`
+	// Administrador que fez a última alteração personificando o usuário (0 = o próprio usuário)
+	ImpersonatedBy int `json:"impersonated_by,omitempty"`
+	// Versão incrementada a cada alteração, exposta como ETag
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Momento em que foi para a lixeira; itens excluídos ficam fora das listagens até a restauração ou o expurgo
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// MateriaWithProvas é a matéria com suas provas/trabalhos, retornada com ?include=provas
+type MateriaWithProvas struct {
+	Materia
+	ProvasTrabalhos []ProvaTrabalho `json:"provas_trabalhos"`
+}
+
+// DeleteMateriaSummary resume o que a exclusão de uma matéria afetou
+type DeleteMateriaSummary struct {
+	MateriaID       int   `json:"materia_id"`
+	ProvasExcluidas []int `json:"provas_trabalhos_excluidos"`
+	ProvasMovidas   []int `json:"provas_trabalhos_movidos"`
+	MovidasPara     int   `json:"movidos_para_materia_id,omitempty"`
 }
 
 type CreateMateriaRequest struct {
@@ -42,97 +83,237 @@ type CreateMateriaRequest struct {
 }
 
 type CreateProvaTrabalhoRequest struct {
-	Titulo          string     `json:"titulo"`
-	ConteudosEstudo string     `json:"conteudos_estudo"`
-	Anexos          []string   `json:"anexos"`
-	Referencias     []string   `json:"referencias"`
-	DataEntrega     *time.Time `json:"data_entrega,omitempty"`
-	MateriaID       int        `json:"materia_id"`
+	Titulo          string       `json:"titulo"`
+	ConteudosEstudo string       `json:"conteudos_estudo"`
+	Anexos          []string     `json:"anexos"`
+	Referencias     []string     `json:"referencias"`
+	Bibliografia    []Referencia `json:"bibliografia"`
+	DataEntrega     *time.Time   `json:"data_entrega,omitempty"`
+	MateriaID       int          `json:"materia_id"`
 }
 
-type AuthResponse struct {
-	Valid  bool   `json:"valid"`
+// Actor é o administrador que age em nome do usuário em um token de personificação
+type Actor struct {
 	UserID int    `json:"user_id"`
 	Email  string `json:"email"`
 }
 
+type AuthResponse struct {
+	Valid    bool     `json:"valid"`
+	UserID   int      `json:"user_id"`
+	Email    string   `json:"email"`
+	TenantID int      `json:"tenant_id"`
+	Role     string   `json:"role"`
+	Scopes   []string `json:"scopes,omitempty"`
+	Act      *Actor   `json:"act,omitempty"`
+}
+
 type ErrorResponse struct {
-	Error   string `json:"error"`
-	Message string `json:"message,omitempty"`
-	Code    int    `json:"code"`
+	Error     string       `json:"error"`
+	Message   string       `json:"message,omitempty"`
+	Code      int          `json:"code"`
+	Details   []FieldError `json:"details,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	// Representação atual do recurso, enviada no 412 quando o If-Match não corresponde
+	Current interface{} `json:"current,omitempty"`
 }
 
 type SuccessResponse struct {
-	Message string      `json:"message"`
-	Data    interface{} `json:"data,omitempty"`
+	Message    string      `json:"message"`
+	Data       interface{} `json:"data,omitempty"`
+	Pagination *PageInfo   `json:"pagination,omitempty"`
 }
 
 var (
+	// storeMu protege materias, provasTrabalhos e os contadores de ID, acessados
+	// simultaneamente pelas goroutines do net/http. Verificações de unicidade e de
+	// pertencimento são feitas sob o mesmo lock da escrita que dependem delas.
+	storeMu         sync.RWMutex
 	materias        []Materia
 	provasTrabalhos []ProvaTrabalho
 	nextMateriaID   = 1
 	nextProvaID     = 1
 	authServiceURL  = "http://auth-service:8080"
+	// corsOrigins são as origens do frontend autorizadas pelo CORS e no WebSocket (CORS_ORIGINS)
+	corsOrigins = []string{"http://localhost:3000"}
 )
 
 // Funções auxiliares para validação e resposta
-func writeErrorResponse(w http.ResponseWriter, statusCode int, errorMsg, message string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	json.NewEncoder(w).Encode(ErrorResponse{
-		Error:   errorMsg,
-		Message: message,
-		Code:    statusCode,
-	})
-}
-
-func writeSuccessResponse(w http.ResponseWriter, message string, data interface{}) {
+func writeSuccessResponse(w http.ResponseWriter, r *http.Request, messageKey string, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Language", preferredLanguage(r))
 	json.NewEncoder(w).Encode(SuccessResponse{
-		Message: message,
+		Message: localize(r, messageKey),
 		Data:    data,
 	})
 }
 
-func validateStringField(field, fieldName string, minLength int) error {
-	if strings.TrimSpace(field) == "" {
-		return fmt.Errorf("%s é obrigatório", fieldName)
+func validateStringField(value, field string, minLength int) *FieldError {
+	if strings.TrimSpace(value) == "" {
+		err := fieldError(field, "field_required", fieldLabel(field))
+		return &err
 	}
-	if len(strings.TrimSpace(field)) < minLength {
-		return fmt.Errorf("%s deve ter pelo menos %d caracteres", fieldName, minLength)
+	if len(strings.TrimSpace(value)) < minLength {
+		err := fieldError(field, "field_min_length", fieldLabel(field), minLength)
+		return &err
 	}
 	return nil
 }
 
-func logUserAction(userID int, action, resource string) {
-	log.Printf("User %d: %s %s at %s", userID, action, resource, time.Now().Format(time.RFC3339))
+func validateMateriaRequest(req CreateMateriaRequest) []FieldError {
+	var details []FieldError
+	if err := validateStringField(req.Nome, "nome", 2); err != nil {
+		details = append(details, *err)
+	}
+	if err := validateStringField(req.Descricao, "descricao", 5); err != nil {
+		details = append(details, *err)
+	}
+	return details
 }
 
-func validateToken(token string) (*AuthResponse, error) {
-	req, err := http.NewRequest("GET", authServiceURL+"/validate", nil)
-	if err != nil {
-		return nil, err
+func validateProvaTrabalhoRequest(req CreateProvaTrabalhoRequest) []FieldError {
+	var details []FieldError
+	if err := validateStringField(req.Titulo, "titulo", 3); err != nil {
+		details = append(details, *err)
+	}
+	if err := validateStringField(req.ConteudosEstudo, "conteudos_estudo", 5); err != nil {
+		details = append(details, *err)
+	}
+	if req.MateriaID <= 0 {
+		details = append(details, fieldError("materia_id", "field_required", fieldLabel("materia_id")))
 	}
+	// Validar data de entrega se fornecida
+	if req.DataEntrega != nil && req.DataEntrega.Before(time.Now()) {
+		details = append(details, fieldError("data_entrega", "data_entrega_past"))
+	}
+	details = append(details, validateBibliografia(req.Bibliografia)...)
+	return details
+}
 
-	req.Header.Set("Authorization", "Bearer "+token)
+// findMateria retorna a matéria do usuário com o ID informado, fora da lixeira; storeMu deve estar travado
+func findMateria(tenantID, userID, id int) *Materia {
+	for i := range materias {
+		if materias[i].ID == id && materias[i].TenantID == tenantID && materias[i].UserID == userID && materias[i].DeletedAt == nil {
+			return &materias[i]
+		}
+	}
+	return nil
+}
+
+// findProvaTrabalho retorna a prova/trabalho do usuário com o ID informado, fora da lixeira; storeMu
+// deve estar travado
+func findProvaTrabalho(tenantID, userID, id int) *ProvaTrabalho {
+	for i := range provasTrabalhos {
+		if provasTrabalhos[i].ID == id && provasTrabalhos[i].TenantID == tenantID && provasTrabalhos[i].UserID == userID && provasTrabalhos[i].DeletedAt == nil {
+			return &provasTrabalhos[i]
+		}
+	}
+	return nil
+}
+
+// materiaNameTaken informa se o usuário já tem outra matéria (diferente de excludeID) com o
+// mesmo nome; storeMu deve estar travado
+func materiaNameTaken(tenantID, userID, excludeID int, nome string) bool {
+	for _, materia := range materias {
+		if materia.TenantID == tenantID && materia.UserID == userID && materia.ID != excludeID && materia.DeletedAt == nil && strings.EqualFold(strings.TrimSpace(materia.Nome), strings.TrimSpace(nome)) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyMateriaRequest grava os campos editáveis na matéria, a reindexa e publica a alteração; storeMu
+// deve estar travado
+func applyMateriaRequest(materia *Materia, req CreateMateriaRequest, r *http.Request) {
+	materia.Nome = strings.TrimSpace(req.Nome)
+	materia.Descricao = strings.TrimSpace(req.Descricao)
+	materia.ImpersonatedBy = impersonatorID(r)
+	materia.Version++
+	materia.UpdatedAt = time.Now()
+	searchIdx.indexMateria(*materia)
+	publishEvent(materia.TenantID, materia.UserID, eventMateriaUpdated, *materia)
+}
+
+// applyProvaTrabalhoRequest grava os campos editáveis na prova/trabalho, a reindexa e publica a
+// alteração; storeMu deve estar travado
+func applyProvaTrabalhoRequest(prova *ProvaTrabalho, req CreateProvaTrabalhoRequest, r *http.Request) {
+	prova.Titulo = strings.TrimSpace(req.Titulo)
+	prova.ConteudosEstudo = strings.TrimSpace(req.ConteudosEstudo)
+	prova.Anexos = req.Anexos
+	prova.Referencias = req.Referencias
+	prova.Bibliografia = normalizeBibliografia(req.Bibliografia)
+	prova.DataEntrega = req.DataEntrega
+	prova.MateriaID = req.MateriaID
+	prova.ImpersonatedBy = impersonatorID(r)
+	prova.Version++
+	prova.UpdatedAt = time.Now()
+	searchIdx.indexProva(*prova)
+	publishEvent(prova.TenantID, prova.UserID, eventProvaUpdated, *prova)
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+// provasByMateria agrupa as provas/trabalhos do usuário das matérias informadas, por data de
+// entrega (sem data por último); storeMu deve estar travado
+func provasByMateria(tenantID, userID int, materiaIDs map[int]bool) map[int][]ProvaTrabalho {
+	grouped := make(map[int][]ProvaTrabalho, len(materiaIDs))
+	for id := range materiaIDs {
+		grouped[id] = []ProvaTrabalho{}
 	}
-	defer resp.Body.Close()
+	for _, prova := range provasTrabalhos {
+		if prova.TenantID == tenantID && prova.UserID == userID && prova.DeletedAt == nil && materiaIDs[prova.MateriaID] {
+			grouped[prova.MateriaID] = append(grouped[prova.MateriaID], prova)
+		}
+	}
+	for _, provas := range grouped {
+		sort.SliceStable(provas, func(i, j int) bool {
+			if c := compareSortValues(optionalTimeSortValue(provas[i].DataEntrega), optionalTimeSortValue(provas[j].DataEntrega)); c != 0 {
+				return c < 0
+			}
+			return provas[i].ID < provas[j].ID
+		})
+	}
+	return grouped
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("token inválido")
+// withProvas expande as matérias com suas provas/trabalhos
+func withProvas(tenantID, userID int, list []Materia) []MateriaWithProvas {
+	ids := make(map[int]bool, len(list))
+	for _, materia := range list {
+		ids[materia.ID] = true
 	}
 
-	var authResp AuthResponse
-	if err := json.NewDecoder(resp.Body).Decode(&authResp); err != nil {
-		return nil, err
+	storeMu.RLock()
+	grouped := provasByMateria(tenantID, userID, ids)
+	storeMu.RUnlock()
+
+	expanded := make([]MateriaWithProvas, len(list))
+	for i, materia := range list {
+		expanded[i] = MateriaWithProvas{Materia: materia, ProvasTrabalhos: grouped[materia.ID]}
 	}
+	return expanded
+}
 
-	return &authResp, nil
+func logUserAction(userID int, action, resource string) {
+	log.Printf("User %d: %s %s at %s", userID, action, resource, time.Now().Format(time.RFC3339))
+}
+
+// logUserMutation registra uma alteração, marcando as feitas sob personificação com o administrador responsável
+func logUserMutation(r *http.Request, userID int, action, resource string) {
+	if actorID := impersonatorID(r); actorID != 0 {
+		resource = fmt.Sprintf("%s [IMPERSONATED by admin %d]", resource, actorID)
+	}
+	logUserAction(userID, action, resource)
+}
+
+// blockImpersonation impede ações sensíveis (exclusões) durante a personificação
+func blockImpersonation(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if actorID := impersonatorID(r); actorID != 0 {
+			log.Printf("Ação bloqueada durante personificação: admin %d - %s %s", actorID, r.Method, r.URL.Path)
+			writeErrorResponse(w, r, http.StatusForbidden, "IMPERSONATION_FORBIDDEN", "impersonation_forbidden")
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
 }
 
 func authMiddleware(next http.HandlerFunc) http.HandlerFunc {
@@ -140,225 +321,447 @@ func authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
 			log.Printf("Acesso negado: Token não fornecido - IP: %s", r.RemoteAddr)
-			writeErrorResponse(w, http.StatusUnauthorized, "UNAUTHORIZED", "Token de autenticação não fornecido")
+			writeErrorResponse(w, r, http.StatusUnauthorized, "UNAUTHORIZED", "token_missing")
 			return
 		}
 
 		if !strings.HasPrefix(authHeader, "Bearer ") {
 			log.Printf("Acesso negado: Formato de token inválido - IP: %s", r.RemoteAddr)
-			writeErrorResponse(w, http.StatusUnauthorized, "UNAUTHORIZED", "Formato de token inválido")
+			writeErrorResponse(w, r, http.StatusUnauthorized, "UNAUTHORIZED", "token_format")
 			return
 		}
 
 		token := authHeader[7:] // Remove "Bearer "
-		authResp, err := validateToken(token)
+		authResp, err := validateToken(r.Context(), token, requestID(r))
+		if errors.Is(err, errAuthUnavailable) || errors.Is(err, context.DeadlineExceeded) {
+			// Falha do Auth Service não é falha do token: responder 503 para o cliente tentar de novo
+			log.Printf("Auth Service indisponível - IP: %s, Erro: %v", r.RemoteAddr, err)
+			w.Header().Set("Retry-After", strconv.Itoa(int(authBreaker.cooldown.Seconds())))
+			writeErrorResponse(w, r, http.StatusServiceUnavailable, "AUTH_UNAVAILABLE", "auth_unavailable")
+			return
+		}
 		if err != nil {
 			log.Printf("Acesso negado: Token inválido - IP: %s, Erro: %v", r.RemoteAddr, err)
-			writeErrorResponse(w, http.StatusUnauthorized, "UNAUTHORIZED", "Token de autenticação inválido ou expirado")
+			writeErrorResponse(w, r, http.StatusUnauthorized, "UNAUTHORIZED", "token_invalid")
 			return
 		}
 
-		// Adicionar user_id ao contexto da requisição
-		r.Header.Set("X-User-ID", strconv.Itoa(authResp.UserID))
-		log.Printf("Acesso autorizado: User %d - %s %s", authResp.UserID, r.Method, r.URL.Path)
+		// Identidade autenticada no contexto da requisição
+		r = r.WithContext(withPrincipal(r.Context(), newPrincipal(authResp)))
+
+		if authResp.Act != nil {
+			log.Printf("Acesso autorizado sob personificação: admin %d (%s) como User %d (tenant %d) - %s %s", authResp.Act.UserID, authResp.Act.Email, authResp.UserID, authResp.TenantID, r.Method, r.URL.Path)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		log.Printf("Acesso autorizado: User %d (tenant %d) - %s %s", authResp.UserID, authResp.TenantID, r.Method, r.URL.Path)
 		next.ServeHTTP(w, r)
 	}
 }
 
 func getMateriasHandler(w http.ResponseWriter, r *http.Request) {
-	userID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
 
-	var userMaterias []Materia
-	for _, materia := range materias {
-		if materia.UserID == userID {
-			userMaterias = append(userMaterias, materia)
-		}
+	q, details := parseListQuery(r, sortableFields(materiaSortFields))
+	include, includeDetails := parseInclude(r, materiaIncludes)
+	if details = append(details, includeDetails...); len(details) > 0 {
+		writeValidationError(w, r, details)
+		return
 	}
 
+	userMaterias, nextCursor := queryMaterias(tenantID, userID, q)
+
 	logUserAction(userID, "GET", "materias")
-	writeSuccessResponse(w, "Matérias carregadas com sucesso", userMaterias)
+	if include["provas"] {
+		writePageResponse(w, r, "materias_loaded", withProvas(tenantID, userID, userMaterias), q, nextCursor)
+		return
+	}
+	writePageResponse(w, r, "materias_loaded", userMaterias, q, nextCursor)
+}
+
+func getMateriaHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	include, details := parseInclude(r, materiaIncludes)
+	if len(details) > 0 {
+		writeValidationError(w, r, details)
+		return
+	}
+
+	storeMu.RLock()
+	materia := findMateria(tenantID, userID, id)
+	if materia == nil {
+		storeMu.RUnlock()
+		log.Printf("Tentativa de acessar matéria inexistente: ID %d, User %d", id, userID)
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "materia_not_found")
+		return
+	}
+	found := *materia
+	var provas []ProvaTrabalho
+	if include["provas"] {
+		provas = provasByMateria(tenantID, userID, map[int]bool{id: true})[id]
+	}
+	storeMu.RUnlock()
+
+	logUserAction(userID, "GET", fmt.Sprintf("materia %d", id))
+	w.Header().Set("ETag", entityTag(found.Version))
+	if include["provas"] {
+		writeSuccessResponse(w, r, "materia_loaded", MateriaWithProvas{Materia: found, ProvasTrabalhos: provas})
+		return
+	}
+	writeSuccessResponse(w, r, "materia_loaded", found)
+}
+
+// getMateriaProvasTrabalhosHandler lista as provas/trabalhos de uma matéria, com a mesma
+// paginação, ordenação e filtros de GET /provas-trabalhos
+func getMateriaProvasTrabalhosHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	q, details := parseListQuery(r, sortableFields(provaSortFields))
+	if len(details) > 0 {
+		writeValidationError(w, r, details)
+		return
+	}
+	q.materiaID = id
+
+	storeMu.RLock()
+	materiaExists := findMateria(tenantID, userID, id) != nil
+	storeMu.RUnlock()
+	if !materiaExists {
+		log.Printf("Tentativa de listar provas/trabalhos de matéria inexistente: ID %d, User %d", id, userID)
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "materia_not_found")
+		return
+	}
+
+	userProvas, nextCursor := queryProvasTrabalhos(tenantID, userID, q)
+
+	logUserAction(userID, "GET", fmt.Sprintf("provas-trabalhos da matéria %d", id))
+	writePageResponse(w, r, "provas_loaded", userProvas, q, nextCursor)
 }
 
 func createMateriaHandler(w http.ResponseWriter, r *http.Request) {
-	userID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
 
 	var req CreateMateriaRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Printf("Erro ao decodificar dados da matéria para user %d: %v", userID, err)
-		writeErrorResponse(w, http.StatusBadRequest, "BAD_REQUEST", "Dados inválidos fornecidos")
+		writeErrorResponse(w, r, http.StatusBadRequest, "BAD_REQUEST", "invalid_body")
 		return
 	}
 
 	// Validações
-	if err := validateStringField(req.Nome, "Nome", 2); err != nil {
-		writeErrorResponse(w, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
-		return
-	}
-
-	if err := validateStringField(req.Descricao, "Descrição", 5); err != nil {
-		writeErrorResponse(w, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
+	if details := validateMateriaRequest(req); len(details) > 0 {
+		writeValidationError(w, r, details)
 		return
 	}
 
 	// Verificar se já existe uma matéria com o mesmo nome para este usuário
-	for _, materia := range materias {
-		if materia.UserID == userID && strings.EqualFold(strings.TrimSpace(materia.Nome), strings.TrimSpace(req.Nome)) {
-			writeErrorResponse(w, http.StatusConflict, "CONFLICT", "Já existe uma matéria com este nome")
-			return
-		}
+	storeMu.Lock()
+	if materiaNameTaken(tenantID, userID, 0, req.Nome) {
+		storeMu.Unlock()
+		writeErrorResponse(w, r, http.StatusConflict, "CONFLICT", "materia_name_exists")
+		return
 	}
 
 	materia := Materia{
-		ID:        nextMateriaID,
-		Nome:      strings.TrimSpace(req.Nome),
-		Descricao: strings.TrimSpace(req.Descricao),
-		UserID:    userID,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		ID:             nextMateriaID,
+		Nome:           strings.TrimSpace(req.Nome),
+		Descricao:      strings.TrimSpace(req.Descricao),
+		UserID:         userID,
+		TenantID:       tenantID,
+		ImpersonatedBy: impersonatorID(r),
+		Version:        1,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
 	}
 
 	materias = append(materias, materia)
 	nextMateriaID++
+	searchIdx.indexMateria(materia)
+	publishEvent(tenantID, userID, eventMateriaCreated, materia)
+	storeMu.Unlock()
 
-	logUserAction(userID, "CREATE", fmt.Sprintf("materia %d", materia.ID))
-	writeSuccessResponse(w, "Matéria criada com sucesso", materia)
+	logUserMutation(r, userID, "CREATE", fmt.Sprintf("materia %d", materia.ID))
+	w.Header().Set("ETag", entityTag(materia.Version))
+	writeSuccessResponse(w, r, "materia_created", materia)
 }
 
 func updateMateriaHandler(w http.ResponseWriter, r *http.Request) {
-	userID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
 	vars := mux.Vars(r)
 	id, _ := strconv.Atoi(vars["id"])
 
 	var req CreateMateriaRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Printf("Erro ao decodificar dados da atualização da matéria %d para user %d: %v", id, userID, err)
-		writeErrorResponse(w, http.StatusBadRequest, "BAD_REQUEST", "Dados inválidos fornecidos")
+		writeErrorResponse(w, r, http.StatusBadRequest, "BAD_REQUEST", "invalid_body")
 		return
 	}
 
 	// Validações
-	if err := validateStringField(req.Nome, "Nome", 2); err != nil {
-		writeErrorResponse(w, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
+	if details := validateMateriaRequest(req); len(details) > 0 {
+		writeValidationError(w, r, details)
 		return
 	}
 
-	if err := validateStringField(req.Descricao, "Descrição", 5); err != nil {
-		writeErrorResponse(w, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
+	storeMu.Lock()
+	materia := findMateria(tenantID, userID, id)
+	if materia == nil {
+		storeMu.Unlock()
+		log.Printf("Tentativa de atualizar matéria inexistente: ID %d, User %d", id, userID)
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "materia_not_found")
+		return
+	}
+	if result := checkIfMatch(r, materia.Version); result != preconditionOK {
+		current := *materia
+		storeMu.Unlock()
+		writePreconditionError(w, r, result, userID, fmt.Sprintf("materia %d", id), current, current.Version)
 		return
 	}
 
 	// Verificar se já existe uma matéria com o mesmo nome para este usuário (excluindo a atual)
-	for _, materia := range materias {
-		if materia.UserID == userID && materia.ID != id && strings.EqualFold(strings.TrimSpace(materia.Nome), strings.TrimSpace(req.Nome)) {
-			writeErrorResponse(w, http.StatusConflict, "CONFLICT", "Já existe uma matéria com este nome")
-			return
-		}
+	if materiaNameTaken(tenantID, userID, id, req.Nome) {
+		storeMu.Unlock()
+		writeErrorResponse(w, r, http.StatusConflict, "CONFLICT", "materia_name_exists")
+		return
 	}
 
-	for i, materia := range materias {
-		if materia.ID == id && materia.UserID == userID {
-			materias[i].Nome = strings.TrimSpace(req.Nome)
-			materias[i].Descricao = strings.TrimSpace(req.Descricao)
-			materias[i].UpdatedAt = time.Now()
+	applyMateriaRequest(materia, req, r)
+	updated := *materia
+	storeMu.Unlock()
 
-			logUserAction(userID, "UPDATE", fmt.Sprintf("materia %d", id))
-			writeSuccessResponse(w, "Matéria atualizada com sucesso", materias[i])
-			return
-		}
-	}
-
-	log.Printf("Tentativa de atualizar matéria inexistente: ID %d, User %d", id, userID)
-	writeErrorResponse(w, http.StatusNotFound, "NOT_FOUND", "Matéria não encontrada ou não pertence ao usuário")
+	logUserMutation(r, userID, "UPDATE", fmt.Sprintf("materia %d", id))
+	w.Header().Set("ETag", entityTag(updated.Version))
+	writeSuccessResponse(w, r, "materia_updated", updated)
 }
 
 func deleteMateriaHandler(w http.ResponseWriter, r *http.Request) {
-	userID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
 	vars := mux.Vars(r)
 	id, _ := strconv.Atoi(vars["id"])
 
-	// Verificar se existem provas/trabalhos associados a esta matéria
-	for _, prova := range provasTrabalhos {
-		if prova.MateriaID == id && prova.UserID == userID {
-			writeErrorResponse(w, http.StatusConflict, "CONFLICT", "Não é possível excluir a matéria pois existem provas/trabalhos associados a ela")
-			return
+	cascade, moveTo, details := parseDeleteMateriaQuery(r, id)
+	if len(details) > 0 {
+		writeValidationError(w, r, details)
+		return
+	}
+
+	storeMu.Lock()
+	materia := findMateria(tenantID, userID, id)
+	if materia == nil {
+		storeMu.Unlock()
+		log.Printf("Tentativa de excluir matéria inexistente: ID %d, User %d", id, userID)
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "materia_not_found")
+		return
+	}
+	if result := checkIfMatch(r, materia.Version); result != preconditionOK {
+		current := *materia
+		storeMu.Unlock()
+		writePreconditionError(w, r, result, userID, fmt.Sprintf("materia %d", id), current, current.Version)
+		return
+	}
+
+	// Provas/trabalhos associados a esta matéria: sem cascade ou move_to, a exclusão é recusada
+	var provas []*ProvaTrabalho
+	for i := range provasTrabalhos {
+		prova := &provasTrabalhos[i]
+		if prova.MateriaID == id && prova.TenantID == tenantID && prova.UserID == userID && prova.DeletedAt == nil {
+			provas = append(provas, prova)
 		}
 	}
+	if len(provas) > 0 && !cascade && moveTo == 0 {
+		storeMu.Unlock()
+		writeErrorResponse(w, r, http.StatusConflict, "CONFLICT", "materia_has_provas")
+		return
+	}
 
-	for i, materia := range materias {
-		if materia.ID == id && materia.UserID == userID {
-			materias = append(materias[:i], materias[i+1:]...)
-			logUserAction(userID, "DELETE", fmt.Sprintf("materia %d", id))
-			writeSuccessResponse(w, "Matéria excluída com sucesso", nil)
+	var target *Materia
+	if moveTo != 0 {
+		if target = findMateria(tenantID, userID, moveTo); target == nil {
+			storeMu.Unlock()
+			log.Printf("Tentativa de mover provas/trabalhos para matéria inexistente: MateriaID %d, User %d", moveTo, userID)
+			writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "move_target_not_found")
 			return
 		}
 	}
 
-	log.Printf("Tentativa de excluir matéria inexistente: ID %d, User %d", id, userID)
-	writeErrorResponse(w, http.StatusNotFound, "NOT_FOUND", "Matéria não encontrada ou não pertence ao usuário")
+	// Tudo abaixo acontece sob o mesmo lock: ninguém vê a matéria excluída com provas órfãs
+	now := time.Now()
+	summary := DeleteMateriaSummary{MateriaID: id, ProvasExcluidas: []int{}, ProvasMovidas: []int{}}
+	for _, prova := range provas {
+		prova.ImpersonatedBy = impersonatorID(r)
+		prova.Version++
+		prova.UpdatedAt = now
+		if target != nil {
+			prova.MateriaID = target.ID
+			searchIdx.indexProva(*prova)
+			publishEvent(tenantID, userID, eventProvaUpdated, *prova)
+			summary.ProvasMovidas = append(summary.ProvasMovidas, prova.ID)
+		} else {
+			prova.DeletedAt = &now
+			searchIdx.remove(searchKindProva, prova.ID)
+			publishEvent(tenantID, userID, eventProvaDeleted, *prova)
+			summary.ProvasExcluidas = append(summary.ProvasExcluidas, prova.ID)
+		}
+	}
+	if target != nil {
+		summary.MovidasPara = target.ID
+	}
+
+	// A matéria vai para a lixeira; o expurgo a remove de vez após TRASH_RETENTION
+	materia.DeletedAt = &now
+	materia.ImpersonatedBy = impersonatorID(r)
+	materia.Version++
+	searchIdx.remove(searchKindMateria, id)
+	publishEvent(tenantID, userID, eventMateriaDeleted, *materia)
+	storeMu.Unlock()
+
+	switch {
+	case len(summary.ProvasMovidas) > 0:
+		logUserMutation(r, userID, "DELETE", fmt.Sprintf("materia %d (%d provas-trabalhos movidas para matéria %d)", id, len(summary.ProvasMovidas), summary.MovidasPara))
+	case len(summary.ProvasExcluidas) > 0:
+		logUserMutation(r, userID, "DELETE", fmt.Sprintf("materia %d (cascata: %d provas-trabalhos)", id, len(summary.ProvasExcluidas)))
+	default:
+		logUserMutation(r, userID, "DELETE", fmt.Sprintf("materia %d", id))
+	}
+	writeSuccessResponse(w, r, "materia_deleted", summary)
 }
 
-func getProvasTrabalhosHandler(w http.ResponseWriter, r *http.Request) {
-	userID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
+// parseDeleteMateriaQuery lê as opções de exclusão de matéria: cascade=true leva as
+// provas/trabalhos junto para a lixeira e move_to as transfere para outra matéria
+func parseDeleteMateriaQuery(r *http.Request, id int) (bool, int, []FieldError) {
+	params := r.URL.Query()
+	var cascade bool
+	var moveTo int
+	var details []FieldError
 
-	var userProvas []ProvaTrabalho
-	for _, prova := range provasTrabalhos {
-		if prova.UserID == userID {
-			userProvas = append(userProvas, prova)
+	if value := params.Get("cascade"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			details = append(details, fieldError("cascade", "query_bool"))
 		}
+		cascade = parsed
 	}
-
-	logUserAction(userID, "GET", "provas-trabalhos")
-	writeSuccessResponse(w, "Provas/Trabalhos carregados com sucesso", userProvas)
+	if value := params.Get("move_to"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		switch {
+		case err != nil || parsed <= 0:
+			details = append(details, fieldError("move_to", "query_positive_int"))
+		case parsed == id:
+			details = append(details, fieldError("move_to", "move_to_same"))
+		default:
+			moveTo = parsed
+		}
+	}
+	if cascade && moveTo != 0 {
+		details = append(details, fieldError("move_to", "delete_mode_conflict"))
+	}
+	return cascade, moveTo, details
 }
 
-func createProvaTrabalhoHandler(w http.ResponseWriter, r *http.Request) {
-	userID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
+func getProvasTrabalhosHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
 
-	var req CreateProvaTrabalhoRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Printf("Erro ao decodificar dados da prova/trabalho para user %d: %v", userID, err)
-		writeErrorResponse(w, http.StatusBadRequest, "BAD_REQUEST", "Dados inválidos fornecidos")
+	q, details := parseListQuery(r, sortableFields(provaSortFields))
+	if len(details) > 0 {
+		writeValidationError(w, r, details)
 		return
 	}
 
-	// Validações
-	if err := validateStringField(req.Titulo, "Título", 3); err != nil {
-		writeErrorResponse(w, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
+	userProvas, nextCursor := queryProvasTrabalhos(tenantID, userID, q)
+
+	logUserAction(userID, "GET", "provas-trabalhos")
+	writePageResponse(w, r, "provas_loaded", userProvas, q, nextCursor)
+}
+
+func getProvaTrabalhoHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
 		return
 	}
+	userID, tenantID := principal.UserID, principal.TenantID
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
 
-	if err := validateStringField(req.ConteudosEstudo, "Conteúdos de Estudo", 5); err != nil {
-		writeErrorResponse(w, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
+	storeMu.RLock()
+	prova := findProvaTrabalho(tenantID, userID, id)
+	if prova == nil {
+		storeMu.RUnlock()
+		log.Printf("Tentativa de acessar prova/trabalho inexistente: ID %d, User %d", id, userID)
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "prova_not_found")
 		return
 	}
+	found := *prova
+	storeMu.RUnlock()
 
-	if req.MateriaID <= 0 {
-		writeErrorResponse(w, http.StatusBadRequest, "VALIDATION_ERROR", "ID da matéria é obrigatório")
+	logUserAction(userID, "GET", fmt.Sprintf("prova-trabalho %d", id))
+	w.Header().Set("ETag", entityTag(found.Version))
+	writeSuccessResponse(w, r, "prova_loaded", found)
+}
+
+func createProvaTrabalhoHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
 		return
 	}
+	userID, tenantID := principal.UserID, principal.TenantID
 
-	// Verificar se a matéria pertence ao usuário
-	materiaExists := false
-	var materiaNome string
-	for _, materia := range materias {
-		if materia.ID == req.MateriaID && materia.UserID == userID {
-			materiaExists = true
-			materiaNome = materia.Nome
-			break
-		}
+	var req CreateProvaTrabalhoRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Erro ao decodificar dados da prova/trabalho para user %d: %v", userID, err)
+		writeErrorResponse(w, r, http.StatusBadRequest, "BAD_REQUEST", "invalid_body")
+		return
 	}
 
-	if !materiaExists {
-		log.Printf("Tentativa de criar prova/trabalho para matéria inexistente: MateriaID %d, User %d", req.MateriaID, userID)
-		writeErrorResponse(w, http.StatusNotFound, "NOT_FOUND", "Matéria não encontrada ou não pertence ao usuário")
+	// Validações
+	if details := validateProvaTrabalhoRequest(req); len(details) > 0 {
+		writeValidationError(w, r, details)
 		return
 	}
 
-	// Validar data de entrega se fornecida
-	if req.DataEntrega != nil && req.DataEntrega.Before(time.Now()) {
-		writeErrorResponse(w, http.StatusBadRequest, "VALIDATION_ERROR", "Data de entrega não pode ser no passado")
+	// Verificar se a matéria pertence ao usuário
+	storeMu.Lock()
+	materia := findMateria(tenantID, userID, req.MateriaID)
+	if materia == nil {
+		storeMu.Unlock()
+		log.Printf("Tentativa de criar prova/trabalho para matéria inexistente: MateriaID %d, User %d", req.MateriaID, userID)
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "materia_not_found")
 		return
 	}
+	materiaNome := materia.Nome
 
 	prova := ProvaTrabalho{
 		ID:              nextProvaID,
@@ -366,107 +769,119 @@ func createProvaTrabalhoHandler(w http.ResponseWriter, r *http.Request) {
 		ConteudosEstudo: strings.TrimSpace(req.ConteudosEstudo),
 		Anexos:          req.Anexos,
 		Referencias:     req.Referencias,
+		Bibliografia:    normalizeBibliografia(req.Bibliografia),
 		DataEntrega:     req.DataEntrega,
 		MateriaID:       req.MateriaID,
 		UserID:          userID,
+		TenantID:        tenantID,
+		ImpersonatedBy:  impersonatorID(r),
+		Version:         1,
 		CreatedAt:       time.Now(),
 		UpdatedAt:       time.Now(),
 	}
 
 	provasTrabalhos = append(provasTrabalhos, prova)
 	nextProvaID++
+	searchIdx.indexProva(prova)
+	publishEvent(tenantID, userID, eventProvaCreated, prova)
+	storeMu.Unlock()
 
-	logUserAction(userID, "CREATE", fmt.Sprintf("prova-trabalho %d para matéria %s", prova.ID, materiaNome))
-	writeSuccessResponse(w, "Prova/Trabalho criado com sucesso", prova)
+	logUserMutation(r, userID, "CREATE", fmt.Sprintf("prova-trabalho %d para matéria %s", prova.ID, materiaNome))
+	w.Header().Set("ETag", entityTag(prova.Version))
+	writeSuccessResponse(w, r, "prova_created", prova)
 }
 
 func updateProvaTrabalhoHandler(w http.ResponseWriter, r *http.Request) {
-	userID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
 	vars := mux.Vars(r)
 	id, _ := strconv.Atoi(vars["id"])
 
 	var req CreateProvaTrabalhoRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Printf("Erro ao decodificar dados da atualização da prova/trabalho %d para user %d: %v", id, userID, err)
-		writeErrorResponse(w, http.StatusBadRequest, "BAD_REQUEST", "Dados inválidos fornecidos")
+		writeErrorResponse(w, r, http.StatusBadRequest, "BAD_REQUEST", "invalid_body")
 		return
 	}
 
 	// Validações
-	if err := validateStringField(req.Titulo, "Título", 3); err != nil {
-		writeErrorResponse(w, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
+	if details := validateProvaTrabalhoRequest(req); len(details) > 0 {
+		writeValidationError(w, r, details)
 		return
 	}
 
-	if err := validateStringField(req.ConteudosEstudo, "Conteúdos de Estudo", 5); err != nil {
-		writeErrorResponse(w, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
+	storeMu.Lock()
+	prova := findProvaTrabalho(tenantID, userID, id)
+	if prova == nil {
+		storeMu.Unlock()
+		log.Printf("Tentativa de atualizar prova/trabalho inexistente: ID %d, User %d", id, userID)
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "prova_not_found")
 		return
 	}
-
-	if req.MateriaID <= 0 {
-		writeErrorResponse(w, http.StatusBadRequest, "VALIDATION_ERROR", "ID da matéria é obrigatório")
+	if result := checkIfMatch(r, prova.Version); result != preconditionOK {
+		current := *prova
+		storeMu.Unlock()
+		writePreconditionError(w, r, result, userID, fmt.Sprintf("prova-trabalho %d", id), current, current.Version)
 		return
 	}
 
 	// Verificar se a matéria pertence ao usuário
-	materiaExists := false
-	var materiaNome string
-	for _, materia := range materias {
-		if materia.ID == req.MateriaID && materia.UserID == userID {
-			materiaExists = true
-			materiaNome = materia.Nome
-			break
-		}
-	}
-
-	if !materiaExists {
+	materia := findMateria(tenantID, userID, req.MateriaID)
+	if materia == nil {
+		storeMu.Unlock()
 		log.Printf("Tentativa de atualizar prova/trabalho para matéria inexistente: MateriaID %d, User %d", req.MateriaID, userID)
-		writeErrorResponse(w, http.StatusNotFound, "NOT_FOUND", "Matéria não encontrada ou não pertence ao usuário")
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "materia_not_found")
 		return
 	}
+	materiaNome := materia.Nome
 
-	// Validar data de entrega se fornecida
-	if req.DataEntrega != nil && req.DataEntrega.Before(time.Now()) {
-		writeErrorResponse(w, http.StatusBadRequest, "VALIDATION_ERROR", "Data de entrega não pode ser no passado")
-		return
-	}
-
-	for i, prova := range provasTrabalhos {
-		if prova.ID == id && prova.UserID == userID {
-			provasTrabalhos[i].Titulo = strings.TrimSpace(req.Titulo)
-			provasTrabalhos[i].ConteudosEstudo = strings.TrimSpace(req.ConteudosEstudo)
-			provasTrabalhos[i].Anexos = req.Anexos
-			provasTrabalhos[i].Referencias = req.Referencias
-			provasTrabalhos[i].DataEntrega = req.DataEntrega
-			provasTrabalhos[i].MateriaID = req.MateriaID
-			provasTrabalhos[i].UpdatedAt = time.Now()
-
-			logUserAction(userID, "UPDATE", fmt.Sprintf("prova-trabalho %d para matéria %s", id, materiaNome))
-			writeSuccessResponse(w, "Prova/Trabalho atualizada com sucesso", provasTrabalhos[i])
-			return
-		}
-	}
+	applyProvaTrabalhoRequest(prova, req, r)
+	updated := *prova
+	storeMu.Unlock()
 
-	log.Printf("Tentativa de atualizar prova/trabalho inexistente: ID %d, User %d", id, userID)
-	writeErrorResponse(w, http.StatusNotFound, "NOT_FOUND", "Prova/Trabalho não encontrado ou não pertence ao usuário")
+	logUserMutation(r, userID, "UPDATE", fmt.Sprintf("prova-trabalho %d para matéria %s", id, materiaNome))
+	w.Header().Set("ETag", entityTag(updated.Version))
+	writeSuccessResponse(w, r, "prova_updated", updated)
 }
 
 func deleteProvaTrabalhoHandler(w http.ResponseWriter, r *http.Request) {
-	userID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
 	vars := mux.Vars(r)
 	id, _ := strconv.Atoi(vars["id"])
 
-	for i, prova := range provasTrabalhos {
-		if prova.ID == id && prova.UserID == userID {
-			provasTrabalhos = append(provasTrabalhos[:i], provasTrabalhos[i+1:]...)
-			logUserAction(userID, "DELETE", fmt.Sprintf("prova-trabalho %d", id))
-			writeSuccessResponse(w, "Prova/Trabalho excluído com sucesso", nil)
-			return
-		}
+	storeMu.Lock()
+	prova := findProvaTrabalho(tenantID, userID, id)
+	if prova == nil {
+		storeMu.Unlock()
+		log.Printf("Tentativa de excluir prova/trabalho inexistente: ID %d, User %d", id, userID)
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "prova_not_found")
+		return
+	}
+	if result := checkIfMatch(r, prova.Version); result != preconditionOK {
+		current := *prova
+		storeMu.Unlock()
+		writePreconditionError(w, r, result, userID, fmt.Sprintf("prova-trabalho %d", id), current, current.Version)
+		return
 	}
 
-	log.Printf("Tentativa de excluir prova/trabalho inexistente: ID %d, User %d", id, userID)
-	writeErrorResponse(w, http.StatusNotFound, "NOT_FOUND", "Prova/Trabalho não encontrado ou não pertence ao usuário")
+	// A prova/trabalho vai para a lixeira; o expurgo a remove de vez após TRASH_RETENTION
+	now := time.Now()
+	prova.DeletedAt = &now
+	prova.ImpersonatedBy = impersonatorID(r)
+	prova.Version++
+	searchIdx.remove(searchKindProva, id)
+	publishEvent(tenantID, userID, eventProvaDeleted, *prova)
+	storeMu.Unlock()
+
+	logUserMutation(r, userID, "DELETE", fmt.Sprintf("prova-trabalho %d", id))
+	writeSuccessResponse(w, r, "prova_deleted", nil)
 }
 
 func healthHandler(w http.ResponseWriter, r *http.Request) {
@@ -479,22 +894,28 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func userStatsHandler(w http.ResponseWriter, r *http.Request) {
-	userID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
 
 	var userMaterias []Materia
 	var userProvas []ProvaTrabalho
 
+	storeMu.RLock()
 	for _, materia := range materias {
-		if materia.UserID == userID {
+		if materia.TenantID == tenantID && materia.UserID == userID && materia.DeletedAt == nil {
 			userMaterias = append(userMaterias, materia)
 		}
 	}
 
 	for _, prova := range provasTrabalhos {
-		if prova.UserID == userID {
+		if prova.TenantID == tenantID && prova.UserID == userID && prova.DeletedAt == nil {
 			userProvas = append(userProvas, prova)
 		}
 	}
+	storeMu.RUnlock()
 
 	// Calcular estatísticas
 	totalMaterias := len(userMaterias)
@@ -520,7 +941,33 @@ func userStatsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	logUserAction(userID, "GET", "estatisticas")
-	writeSuccessResponse(w, "Estatísticas carregadas com sucesso", stats)
+	writeSuccessResponse(w, r, "stats_loaded", stats)
+}
+
+// durationFromEnv lê uma duração (ex.: "2s") da variável de ambiente, ou retorna o padrão
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed < 0 {
+		log.Fatalf("%s inválido: %s", name, value)
+	}
+	return parsed
+}
+
+// intFromEnv lê um inteiro positivo da variável de ambiente, ou retorna o padrão
+func intFromEnv(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed <= 0 {
+		log.Fatalf("%s inválido: %s", name, value)
+	}
+	return parsed
 }
 
 func main() {
@@ -534,31 +981,119 @@ func main() {
 		authServiceURL = "http://auth-service:8080"
 	}
 
+	tokenCacheTTL = durationFromEnv("TOKEN_CACHE_TTL", tokenCacheTTL)
+	tokenCache = newTokenValidationCache(intFromEnv("TOKEN_CACHE_SIZE", tokenCacheSize))
+
+	if origins := os.Getenv("CORS_ORIGINS"); origins != "" {
+		corsOrigins = strings.Split(origins, ",")
+	}
+
+	requireIfMatch = os.Getenv("REQUIRE_IF_MATCH") == "true"
+	publicURL = strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/")
+
+	blobStore = newBlobStoreFromEnv()
+	maxAttachmentSize = int64(intFromEnv("MAX_ATTACHMENT_SIZE", int(maxAttachmentSize)))
+
+	notifiers[canalEmail] = &emailNotifier{mailer: smtpmail.NewFromEnv()}
+	reminderLogPath := os.Getenv("REMINDER_LOG_FILE")
+	if reminderLogPath == "" {
+		reminderLogPath = filepath.Join("data", "lembretes-enviados.log")
+	}
+	var err error
+	if reminders, err = openReminderLog(reminderLogPath, time.Now()); err != nil {
+		log.Fatalf("Erro ao abrir o registro de lembretes %s: %v", reminderLogPath, err)
+	}
+	go runReminderScheduler(durationFromEnv("REMINDER_INTERVAL", reminderInterval))
+
+	webhookMaxAttempts = intFromEnv("WEBHOOK_MAX_ATTEMPTS", webhookMaxAttempts)
+	webhookRetryBase = durationFromEnv("WEBHOOK_RETRY_BASE", webhookRetryBase)
+	allowPrivateTargets = os.Getenv("WEBHOOK_ALLOW_PRIVATE") == "true"
+	go webhooks.run()
+	liveHeartbeat = durationFromEnv("LIVE_HEARTBEAT", liveHeartbeat)
+
+	trashRetention = durationFromEnv("TRASH_RETENTION", trashRetention)
+	go runTrashPurge(durationFromEnv("TRASH_PURGE_INTERVAL", trashPurgeInterval))
+
+	authAttemptTimeout = durationFromEnv("AUTH_TIMEOUT", authAttemptTimeout)
+	authMaxAttempts = intFromEnv("AUTH_MAX_ATTEMPTS", authMaxAttempts)
+	authBreaker = newCircuitBreaker("auth-service",
+		intFromEnv("AUTH_BREAKER_THRESHOLD", authBreaker.threshold),
+		durationFromEnv("AUTH_BREAKER_COOLDOWN", authBreaker.cooldown))
+
 	r := mux.NewRouter()
+	r.Use(requestIDMiddleware)
+	r.Use(stripIdentityHeaders)
 
 	// Rota pública
 	r.HandleFunc("/health", healthHandler).Methods("GET")
+	r.HandleFunc("/metrics", metricsHandler).Methods("GET")
+	// Feed de calendário: autenticado pelo token secreto da URL
+	r.HandleFunc("/calendar/{token}.ics", calendarFeedHandler).Methods("GET")
 
-	// Rotas protegidas - Estatísticas
+	// Rotas protegidas - Estatísticas e busca
 	r.HandleFunc("/stats", authMiddleware(userStatsHandler)).Methods("GET")
+	r.HandleFunc("/search", authMiddleware(searchHandler)).Methods("GET")
+
+	// Rotas protegidas - Assinaturas de calendário
+	r.HandleFunc("/calendar/feeds", authMiddleware(getCalendarFeedsHandler)).Methods("GET")
+	r.HandleFunc("/calendar/feeds", authMiddleware(blockImpersonation(createCalendarFeedHandler))).Methods("POST")
+	r.HandleFunc("/import/ics", authMiddleware(importICSHandler)).Methods("POST")
+	r.HandleFunc("/calendar/feeds/{token}", authMiddleware(blockImpersonation(deleteCalendarFeedHandler))).Methods("DELETE")
+
+	// Rotas protegidas - Lembretes e notificações
+	r.HandleFunc("/reminders/settings", authMiddleware(getReminderSettingsHandler)).Methods("GET")
+	r.HandleFunc("/reminders/settings", authMiddleware(blockImpersonation(updateReminderSettingsHandler))).Methods("PUT")
+	r.HandleFunc("/notifications", authMiddleware(getNotificacoesHandler)).Methods("GET")
+	r.HandleFunc("/notifications/{id}/read", authMiddleware(readNotificacaoHandler)).Methods("POST")
+
+	// Rotas protegidas - Atualizações ao vivo (SSE ou WebSocket); o navegador autentica com ?ticket=
+	r.HandleFunc("/events/ticket", authMiddleware(createStreamTicketHandler)).Methods("POST")
+	r.HandleFunc("/events", withStreamTicket(eventsHandler)).Methods("GET")
+
+	// Rotas protegidas - Webhooks
+	r.HandleFunc("/webhooks", authMiddleware(getWebhooksHandler)).Methods("GET")
+	r.HandleFunc("/webhooks", authMiddleware(blockImpersonation(createWebhookHandler))).Methods("POST")
+	r.HandleFunc("/webhooks/dead-letters", authMiddleware(getDeadLettersHandler)).Methods("GET")
+	r.HandleFunc("/webhooks/{id}", authMiddleware(getWebhookHandler)).Methods("GET")
+	r.HandleFunc("/webhooks/{id}", authMiddleware(blockImpersonation(updateWebhookHandler))).Methods("PUT")
+	r.HandleFunc("/webhooks/{id}", authMiddleware(blockImpersonation(deleteWebhookHandler))).Methods("DELETE")
+	r.HandleFunc("/webhooks/{id}/test", authMiddleware(blockImpersonation(testWebhookHandler))).Methods("POST")
+	r.HandleFunc("/webhooks/{id}/deliveries", authMiddleware(getWebhookDeliveriesHandler)).Methods("GET")
+	r.HandleFunc("/webhooks/{id}/deliveries/{deliveryId}/redeliver", authMiddleware(blockImpersonation(redeliverWebhookHandler))).Methods("POST")
 
 	// Rotas protegidas - Matérias
 	r.HandleFunc("/materias", authMiddleware(getMateriasHandler)).Methods("GET")
 	r.HandleFunc("/materias", authMiddleware(createMateriaHandler)).Methods("POST")
+	r.HandleFunc("/materias/{id}", authMiddleware(getMateriaHandler)).Methods("GET")
+	r.HandleFunc("/materias/{id}/bibliografia", authMiddleware(getMateriaBibliografiaHandler)).Methods("GET")
+	r.HandleFunc("/materias/{id}/provas-trabalhos", authMiddleware(getMateriaProvasTrabalhosHandler)).Methods("GET")
 	r.HandleFunc("/materias/{id}", authMiddleware(updateMateriaHandler)).Methods("PUT")
-	r.HandleFunc("/materias/{id}", authMiddleware(deleteMateriaHandler)).Methods("DELETE")
+	r.HandleFunc("/materias/{id}", authMiddleware(patchMateriaHandler)).Methods("PATCH")
+	r.HandleFunc("/materias/{id}", authMiddleware(blockImpersonation(deleteMateriaHandler))).Methods("DELETE")
 
 	// Rotas protegidas - Provas/Trabalhos
 	r.HandleFunc("/provas-trabalhos", authMiddleware(getProvasTrabalhosHandler)).Methods("GET")
 	r.HandleFunc("/provas-trabalhos", authMiddleware(createProvaTrabalhoHandler)).Methods("POST")
+	r.HandleFunc("/provas-trabalhos/{id}", authMiddleware(getProvaTrabalhoHandler)).Methods("GET")
 	r.HandleFunc("/provas-trabalhos/{id}", authMiddleware(updateProvaTrabalhoHandler)).Methods("PUT")
-	r.HandleFunc("/provas-trabalhos/{id}", authMiddleware(deleteProvaTrabalhoHandler)).Methods("DELETE")
+	r.HandleFunc("/provas-trabalhos/{id}", authMiddleware(patchProvaTrabalhoHandler)).Methods("PATCH")
+	r.HandleFunc("/provas-trabalhos/{id}", authMiddleware(blockImpersonation(deleteProvaTrabalhoHandler))).Methods("DELETE")
+	r.HandleFunc("/provas-trabalhos/{id}/bibliografia", authMiddleware(getProvaBibliografiaHandler)).Methods("GET")
+	r.HandleFunc("/provas-trabalhos/{id}/bibliografia", authMiddleware(importBibliografiaHandler)).Methods("POST")
+	r.HandleFunc("/provas-trabalhos/{id}/anexos", authMiddleware(uploadAnexosHandler)).Methods("POST")
+	r.HandleFunc("/provas-trabalhos/{id}/anexos/{anexoId}", authMiddleware(downloadAnexoHandler)).Methods("GET")
+	r.HandleFunc("/provas-trabalhos/{id}/anexos/{anexoId}", authMiddleware(blockImpersonation(deleteAnexoHandler))).Methods("DELETE")
+
+	// Rotas protegidas - Lixeira
+	r.HandleFunc("/trash", authMiddleware(getTrashHandler)).Methods("GET")
+	r.HandleFunc("/trash/{type}/{id}/restore", authMiddleware(restoreTrashHandler)).Methods("POST")
 
 	// CORS
 	corsHandler := handlers.CORS(
-		handlers.AllowedOrigins([]string{"*"}),
-		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
-		handlers.AllowedHeaders([]string{"Content-Type", "Authorization"}),
+		handlers.AllowedOrigins(corsOrigins),
+		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}),
+		handlers.AllowedHeaders([]string{"Content-Type", "Authorization", "Accept-Language", "X-Request-ID", "If-Match", "Range", "If-Range", "Last-Event-ID"}),
+		handlers.ExposedHeaders([]string{"X-Request-ID", "Content-Language", "Accept-Patch", "ETag", "Accept-Ranges", "Content-Range", "Content-Disposition", "Repr-Digest"}),
 	)(r)
 
 	fmt.Printf("Backend Service rodando na porta %s\n", port)
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// doRequest chama o handler diretamente como o principal informado, com o corpo em JSON
+func doRequest(t *testing.T, handler http.HandlerFunc, method, target string, body interface{}, principal Principal) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			t.Errorf("json.Marshal: %v", err)
+			return httptest.NewRecorder()
+		}
+		reader = bytes.NewReader(raw)
+	}
+	req := httptest.NewRequest(method, target, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req = req.WithContext(withPrincipal(req.Context(), principal))
+
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+// decodeData decodifica o campo "data" de uma resposta de sucesso em v
+func decodeData(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	resp := SuccessResponse{Data: v}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Errorf("resposta não é JSON (%d): %v: %s", rec.Code, err, rec.Body.String())
+	}
+}
+
+// itemRequest chama o handler de um item ({id} da rota) com o corpo bruto e os headers informados
+func itemRequest(t *testing.T, handler http.HandlerFunc, method, target string, id int, body string, header map[string]string, principal Principal) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": strconv.Itoa(id)})
+	req = req.WithContext(withPrincipal(req.Context(), principal))
+
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+// createTestMateria cria uma matéria do principal pelo handler
+func createTestMateria(t *testing.T, principal Principal, nome string) Materia {
+	t.Helper()
+
+	rec := doRequest(t, createMateriaHandler, http.MethodPost, "/materias", CreateMateriaRequest{Nome: nome, Descricao: "Matéria de teste"}, principal)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("criar matéria %q: status %d: %s", nome, rec.Code, rec.Body.String())
+	}
+	var materia Materia
+	decodeData(t, rec, &materia)
+	return materia
+}
+
+// createTestProva cria uma prova/trabalho do principal na matéria informada pelo handler
+func createTestProva(t *testing.T, principal Principal, materiaID int, titulo string) ProvaTrabalho {
+	t.Helper()
+
+	req := CreateProvaTrabalhoRequest{Titulo: titulo, ConteudosEstudo: "Capítulos 1 a 3", MateriaID: materiaID}
+	rec := doRequest(t, createProvaTrabalhoHandler, http.MethodPost, "/provas-trabalhos", req, principal)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("criar prova %q: status %d: %s", titulo, rec.Code, rec.Body.String())
+	}
+	var prova ProvaTrabalho
+	decodeData(t, rec, &prova)
+	return prova
+}
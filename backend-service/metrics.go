@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// metricsHandler expõe, no formato texto do Prometheus, o estado do circuit breaker e
+// os contadores das chamadas ao Auth Service e das entregas de lembretes e webhooks, além das conexões ao vivo
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	breaker := authBreaker.Metrics()
+
+	var b strings.Builder
+	writeMetric := func(name, kind, help string, samples ...string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, sample := range samples {
+			fmt.Fprintf(&b, "%s%s\n", name, sample)
+		}
+	}
+
+	writeMetric("auth_breaker_state", "gauge", "Estado do circuit breaker do Auth Service (0=fechado, 1=aberto, 2=meio-aberto)",
+		fmt.Sprintf(" %d", breaker.State))
+	writeMetric("auth_breaker_consecutive_failures", "gauge", "Falhas consecutivas registradas pelo circuit breaker",
+		fmt.Sprintf(" %d", breaker.Failures))
+	writeMetric("auth_breaker_transitions_total", "counter", "Transições do circuit breaker por estado de destino",
+		fmt.Sprintf(`{to="%s"} %d`, breakerClosed, breaker.Transitions[breakerClosed]),
+		fmt.Sprintf(`{to="%s"} %d`, breakerOpen, breaker.Transitions[breakerOpen]),
+		fmt.Sprintf(`{to="%s"} %d`, breakerHalfOpen, breaker.Transitions[breakerHalfOpen]))
+	writeMetric("auth_breaker_rejected_total", "counter", "Chamadas recusadas sem consultar o Auth Service por circuito aberto",
+		fmt.Sprintf(" %d", breaker.Rejected))
+	writeMetric("auth_validations_total", "counter", "Validações de token feitas no Auth Service por resultado",
+		fmt.Sprintf(`{result="valid"} %d`, authCallsSucceeded.Load()),
+		fmt.Sprintf(`{result="invalid"} %d`, authCallsRejected.Load()),
+		fmt.Sprintf(`{result="error"} %d`, authCallsFailed.Load()))
+	writeMetric("auth_retries_total", "counter", "Novas tentativas de validação após falha",
+		fmt.Sprintf(" %d", authRetries.Load()))
+	writeMetric("auth_token_cache_requests_total", "counter", "Consultas ao cache de tokens validados",
+		fmt.Sprintf(`{result="hit"} %d`, tokenCacheHits.Load()),
+		fmt.Sprintf(`{result="miss"} %d`, tokenCacheMisses.Load()))
+	writeMetric("reminder_deliveries_total", "counter", "Lembretes de entrega enviados por canal e resultado",
+		fmt.Sprintf(`{channel="%s",result="sent"} %d`, canalEmail, reminderDeliveries[canalEmail].sent.Load()),
+		fmt.Sprintf(`{channel="%s",result="failed"} %d`, canalEmail, reminderDeliveries[canalEmail].failed.Load()),
+		fmt.Sprintf(`{channel="%s",result="sent"} %d`, canalWebhook, reminderDeliveries[canalWebhook].sent.Load()),
+		fmt.Sprintf(`{channel="%s",result="failed"} %d`, canalWebhook, reminderDeliveries[canalWebhook].failed.Load()),
+		fmt.Sprintf(`{channel="%s",result="sent"} %d`, canalInApp, reminderDeliveries[canalInApp].sent.Load()),
+		fmt.Sprintf(`{channel="%s",result="failed"} %d`, canalInApp, reminderDeliveries[canalInApp].failed.Load()))
+	writeMetric("webhook_deliveries_total", "counter", "Tentativas de entrega de webhooks por resultado",
+		fmt.Sprintf(`{result="delivered"} %d`, webhookDelivered.Load()),
+		fmt.Sprintf(`{result="failed"} %d`, webhookFailures.Load()))
+	writeMetric("webhook_dead_letters_total", "counter", "Entregas de webhooks desistidas após todas as tentativas",
+		fmt.Sprintf(" %d", webhookDeadLetters.Load()))
+	writeMetric("live_connections", "gauge", "Conexões abertas em /events (SSE e WebSocket)",
+		fmt.Sprintf(" %d", liveConnections.Load()))
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"smtpmail"
+)
+
+const (
+	canalEmail   = "email"
+	canalWebhook = "webhook"
+	canalInApp   = "in_app"
+
+	// maxNotificacoes é quantas notificações no app cada usuário mantém; as mais antigas saem
+	maxNotificacoes = 100
+)
+
+// Notifier entrega um lembrete por um canal, usando o destino das preferências do usuário
+type Notifier interface {
+	Notify(ctx context.Context, settings ReminderSettings, reminder Reminder) error
+}
+
+// Reminder é um lembrete de entrega já com assunto e mensagem no idioma do usuário
+type Reminder struct {
+	ProvaID             int       `json:"prova_trabalho_id"`
+	Titulo              string    `json:"titulo"`
+	MateriaID           int       `json:"materia_id"`
+	Materia             string    `json:"materia"`
+	DataEntrega         time.Time `json:"data_entrega"`
+	AntecedenciaMinutos int       `json:"antecedencia_minutos"`
+	Assunto             string    `json:"assunto"`
+	Mensagem            string    `json:"mensagem"`
+	UserID              int       `json:"-"`
+	TenantID            int       `json:"-"`
+}
+
+// notifiers são os canais disponíveis, escolhidos pelo usuário em canais
+var notifiers = map[string]Notifier{
+	canalEmail:   &emailNotifier{mailer: smtpmail.LogMailer{}},
+	canalWebhook: &webhookNotifier{client: newOutboundClient(10 * time.Second)},
+	canalInApp:   inAppNotifier{},
+}
+
+// reminderDeliveries conta as entregas de lembretes por canal, expostas em /metrics
+var reminderDeliveries = map[string]*struct{ sent, failed atomic.Int64 }{
+	canalEmail:   {},
+	canalWebhook: {},
+	canalInApp:   {},
+}
+
+// emailNotifier envia o lembrete para o email da conta do usuário
+type emailNotifier struct {
+	mailer smtpmail.Mailer
+}
+
+func (n *emailNotifier) Notify(ctx context.Context, settings ReminderSettings, reminder Reminder) error {
+	if settings.Email == "" {
+		return fmt.Errorf("usuário sem email")
+	}
+	// net/smtp não aceita contexto: o envio segue em segundo plano, mas o lembrete não espera
+	// além do prazo
+	done := make(chan error, 1)
+	go func() { done <- n.mailer.Send(settings.Email, reminder.Assunto, reminder.Mensagem) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// webhookNotifier envia o lembrete em JSON, por POST, para o endereço escolhido pelo usuário
+type webhookNotifier struct {
+	client *http.Client
+}
+
+func (n *webhookNotifier) Notify(ctx context.Context, settings ReminderSettings, reminder Reminder) error {
+	body, err := json.Marshal(map[string]interface{}{"evento": "lembrete", "lembrete": reminder})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, settings.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "sistema-estudos-lembretes")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook respondeu %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Notificacao é um lembrete exibido no app
+type Notificacao struct {
+	ID          int        `json:"id"`
+	ProvaID     int        `json:"prova_trabalho_id"`
+	Assunto     string     `json:"assunto"`
+	Mensagem    string     `json:"mensagem"`
+	DataEntrega time.Time  `json:"data_entrega"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LidaEm      *time.Time `json:"lida_em,omitempty"`
+	UserID      int        `json:"-"`
+	TenantID    int        `json:"-"`
+}
+
+var (
+	// notificacoes é protegido por storeMu
+	notificacoes      []Notificacao
+	nextNotificacaoID = 1
+)
+
+// inAppNotifier guarda o lembrete na caixa de notificações do usuário
+type inAppNotifier struct{}
+
+func (inAppNotifier) Notify(ctx context.Context, settings ReminderSettings, reminder Reminder) error {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+
+	notificacoes = append(notificacoes, Notificacao{
+		ID:          nextNotificacaoID,
+		ProvaID:     reminder.ProvaID,
+		Assunto:     reminder.Assunto,
+		Mensagem:    reminder.Mensagem,
+		DataEntrega: reminder.DataEntrega,
+		CreatedAt:   time.Now(),
+		UserID:      reminder.UserID,
+		TenantID:    reminder.TenantID,
+	})
+	nextNotificacaoID++
+
+	// Descarta as mais antigas do usuário além do limite
+	excess := -maxNotificacoes
+	for _, n := range notificacoes {
+		if n.TenantID == reminder.TenantID && n.UserID == reminder.UserID {
+			excess++
+		}
+	}
+	if excess > 0 {
+		kept := notificacoes[:0]
+		for _, n := range notificacoes {
+			if excess > 0 && n.TenantID == reminder.TenantID && n.UserID == reminder.UserID {
+				excess--
+				continue
+			}
+			kept = append(kept, n)
+		}
+		notificacoes = kept
+	}
+	return nil
+}
+
+// getNotificacoesHandler lista as notificações do usuário, da mais recente à mais antiga;
+// ?unread=true traz só as não lidas
+func getNotificacoesHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
+
+	unread := false
+	if raw := r.URL.Query().Get("unread"); raw != "" {
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			writeValidationError(w, r, []FieldError{fieldError("unread", "query_bool")})
+			return
+		}
+		unread = parsed
+	}
+
+	result := []Notificacao{}
+	storeMu.RLock()
+	for i := len(notificacoes) - 1; i >= 0; i-- {
+		n := notificacoes[i]
+		if n.TenantID == tenantID && n.UserID == userID && (!unread || n.LidaEm == nil) {
+			result = append(result, n)
+		}
+	}
+	storeMu.RUnlock()
+
+	logUserAction(userID, "GET", "notificações")
+	writeSuccessResponse(w, r, "notificacoes_loaded", result)
+}
+
+// readNotificacaoHandler marca uma notificação como lida
+func readNotificacaoHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
+
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	storeMu.Lock()
+	var found *Notificacao
+	for i := range notificacoes {
+		if n := &notificacoes[i]; n.ID == id && n.TenantID == tenantID && n.UserID == userID {
+			found = n
+			break
+		}
+	}
+	if found == nil {
+		storeMu.Unlock()
+		log.Printf("Tentativa de ler notificação inexistente: ID %d, User %d", id, userID)
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "notificacao_not_found")
+		return
+	}
+	if found.LidaEm == nil {
+		now := time.Now()
+		found.LidaEm = &now
+	}
+	notificacao := *found
+	storeMu.Unlock()
+
+	logUserAction(userID, "READ", fmt.Sprintf("notificação %d", id))
+	writeSuccessResponse(w, r, "notificacao_read", notificacao)
+}
@@ -0,0 +1,545 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	mediaTypeMergePatch = "application/merge-patch+json"
+	mediaTypeJSONPatch  = "application/json-patch+json"
+
+	maxPatchBodySize = 1 << 20
+)
+
+// acceptPatch é anunciado no header Accept-Patch (RFC 5789) das respostas de PATCH
+var acceptPatch = mediaTypeMergePatch + ", " + mediaTypeJSONPatch
+
+// patchError é uma falha ao ler ou aplicar um patch, já com o status e a mensagem da resposta
+type patchError struct {
+	status int
+	code   string
+	key    string
+	args   []interface{}
+}
+
+func (e *patchError) Error() string {
+	return fmt.Sprintf("%s %v", e.key, e.args)
+}
+
+func newPatchError(status int, code, key string, args ...interface{}) *patchError {
+	return &patchError{status: status, code: code, key: key, args: args}
+}
+
+// patchFunc aplica um patch já decodificado a um documento JSON genérico
+type patchFunc func(doc interface{}) (interface{}, *patchError)
+
+// readPatch lê o corpo da requisição conforme o Content-Type: JSON Merge Patch (RFC 7396,
+// também aceito como application/json) ou JSON Patch (RFC 6902)
+func readPatch(w http.ResponseWriter, r *http.Request) (patchFunc, *patchError) {
+	mediaType := mediaTypeMergePatch
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		parsed, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return nil, newPatchError(http.StatusUnsupportedMediaType, "UNSUPPORTED_MEDIA_TYPE", "patch_media_type")
+		}
+		mediaType = parsed
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPatchBodySize))
+	if err != nil {
+		return nil, newPatchError(http.StatusBadRequest, "BAD_REQUEST", "invalid_body")
+	}
+
+	switch mediaType {
+	case mediaTypeMergePatch, "application/json":
+		var patch interface{}
+		if err := json.Unmarshal(body, &patch); err != nil {
+			return nil, newPatchError(http.StatusBadRequest, "BAD_REQUEST", "invalid_body")
+		}
+		return func(doc interface{}) (interface{}, *patchError) {
+			return mergePatch(doc, patch), nil
+		}, nil
+	case mediaTypeJSONPatch:
+		var ops []jsonPatchOp
+		if err := json.Unmarshal(body, &ops); err != nil {
+			return nil, newPatchError(http.StatusBadRequest, "BAD_REQUEST", "invalid_body")
+		}
+		return func(doc interface{}) (interface{}, *patchError) {
+			return applyJSONPatch(doc, ops)
+		}, nil
+	}
+	return nil, newPatchError(http.StatusUnsupportedMediaType, "UNSUPPORTED_MEDIA_TYPE", "patch_media_type")
+}
+
+// patchDocument aplica o patch à representação JSON de current e decodifica o resultado em
+// target, recusando campos que não são editáveis
+func patchDocument(apply patchFunc, current, target interface{}) *patchError {
+	raw, err := json.Marshal(current)
+	if err != nil {
+		return newPatchError(http.StatusInternalServerError, "INTERNAL_ERROR", "patch_result_invalid")
+	}
+	var doc interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return newPatchError(http.StatusInternalServerError, "INTERNAL_ERROR", "patch_result_invalid")
+	}
+
+	patched, perr := apply(doc)
+	if perr != nil {
+		return perr
+	}
+
+	raw, err = json.Marshal(patched)
+	if err != nil {
+		return newPatchError(http.StatusBadRequest, "BAD_REQUEST", "patch_result_invalid")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(target); err != nil {
+		return newPatchError(http.StatusBadRequest, "BAD_REQUEST", "patch_result_invalid")
+	}
+	return nil
+}
+
+func writePatchError(w http.ResponseWriter, r *http.Request, err *patchError) {
+	if err.status == http.StatusUnsupportedMediaType {
+		w.Header().Set("Accept-Patch", acceptPatch)
+	}
+	writeErrorResponse(w, r, err.status, err.code, err.key, err.args...)
+}
+
+// mergePatch aplica um JSON Merge Patch (RFC 7396): objetos são mesclados recursivamente,
+// null remove o campo e qualquer outro valor substitui o atual
+func mergePatch(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = mergePatch(targetObject[key], value)
+	}
+	return targetObject
+}
+
+// jsonPatchOp é uma operação de JSON Patch (RFC 6902)
+type jsonPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// applyJSONPatch aplica as operações em sequência; se alguma falhar, nenhuma é aplicada
+func applyJSONPatch(doc interface{}, ops []jsonPatchOp) (interface{}, *patchError) {
+	for i, op := range ops {
+		path, err := parsePointer(op.Path)
+		if err != nil {
+			return nil, newPatchError(http.StatusBadRequest, "BAD_REQUEST", "patch_invalid_path", op.Path)
+		}
+
+		var value interface{}
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value == nil {
+				return nil, newPatchError(http.StatusBadRequest, "BAD_REQUEST", "patch_invalid_op", i)
+			}
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				return nil, newPatchError(http.StatusBadRequest, "BAD_REQUEST", "patch_invalid_op", i)
+			}
+		case "move", "copy":
+			from, err := parsePointer(op.From)
+			if err != nil {
+				return nil, newPatchError(http.StatusBadRequest, "BAD_REQUEST", "patch_invalid_path", op.From)
+			}
+			if op.Op == "move" && len(from) < len(path) && reflect.DeepEqual(from, path[:len(from)]) {
+				return nil, newPatchError(http.StatusBadRequest, "BAD_REQUEST", "patch_invalid_path", op.Path)
+			}
+			found, ok := pointerGet(doc, from)
+			if !ok {
+				return nil, newPatchError(http.StatusConflict, "CONFLICT", "patch_path_not_found", op.From)
+			}
+			value = deepCopyJSON(found)
+			if op.Op == "move" {
+				if doc, ok = pointerRemove(doc, from); !ok {
+					return nil, newPatchError(http.StatusConflict, "CONFLICT", "patch_path_not_found", op.From)
+				}
+			}
+		case "remove":
+		default:
+			return nil, newPatchError(http.StatusBadRequest, "BAD_REQUEST", "patch_invalid_op", i)
+		}
+
+		ok := true
+		switch op.Op {
+		case "add", "move", "copy":
+			doc, ok = pointerAdd(doc, path, value)
+		case "remove":
+			doc, ok = pointerRemove(doc, path)
+		case "replace":
+			doc, ok = pointerReplace(doc, path, value)
+		case "test":
+			current, found := pointerGet(doc, path)
+			if !found || !reflect.DeepEqual(current, value) {
+				return nil, newPatchError(http.StatusConflict, "CONFLICT", "patch_test_failed", op.Path)
+			}
+		}
+		if !ok {
+			return nil, newPatchError(http.StatusConflict, "CONFLICT", "patch_path_not_found", op.Path)
+		}
+	}
+	return doc, nil
+}
+
+// parsePointer decodifica um JSON Pointer (RFC 6901) em seus segmentos
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("ponteiro sem / inicial: %q", pointer)
+	}
+	segments := strings.Split(pointer[1:], "/")
+	for i, segment := range segments {
+		segments[i] = strings.ReplaceAll(strings.ReplaceAll(segment, "~1", "/"), "~0", "~")
+	}
+	return segments, nil
+}
+
+// arrayIndex converte um segmento em índice de array; "-" (fim do array) só vale ao inserir
+func arrayIndex(segment string, length int, inserting bool) (int, bool) {
+	if inserting && segment == "-" {
+		return length, true
+	}
+	if segment == "" || (len(segment) > 1 && segment[0] == '0') {
+		return 0, false
+	}
+	index, err := strconv.Atoi(segment)
+	if err != nil || index < 0 || index > length || (!inserting && index == length) {
+		return 0, false
+	}
+	return index, true
+}
+
+func pointerGet(doc interface{}, path []string) (interface{}, bool) {
+	for _, segment := range path {
+		switch container := doc.(type) {
+		case map[string]interface{}:
+			value, ok := container[segment]
+			if !ok {
+				return nil, false
+			}
+			doc = value
+		case []interface{}:
+			index, ok := arrayIndex(segment, len(container), false)
+			if !ok {
+				return nil, false
+			}
+			doc = container[index]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// updateParent aplica fn ao contêiner pai do caminho e devolve o documento atualizado
+func updateParent(doc interface{}, path []string, fn func(parent interface{}, segment string) (interface{}, bool)) (interface{}, bool) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+	switch container := doc.(type) {
+	case map[string]interface{}:
+		child, ok := container[path[0]]
+		if !ok {
+			return nil, false
+		}
+		if container[path[0]], ok = updateParent(child, path[1:], fn); !ok {
+			return nil, false
+		}
+		return container, true
+	case []interface{}:
+		index, ok := arrayIndex(path[0], len(container), false)
+		if !ok {
+			return nil, false
+		}
+		if container[index], ok = updateParent(container[index], path[1:], fn); !ok {
+			return nil, false
+		}
+		return container, true
+	}
+	return nil, false
+}
+
+func pointerAdd(doc interface{}, path []string, value interface{}) (interface{}, bool) {
+	if len(path) == 0 {
+		return value, true
+	}
+	return updateParent(doc, path, func(parent interface{}, segment string) (interface{}, bool) {
+		switch container := parent.(type) {
+		case map[string]interface{}:
+			container[segment] = value
+			return container, true
+		case []interface{}:
+			index, ok := arrayIndex(segment, len(container), true)
+			if !ok {
+				return nil, false
+			}
+			container = append(container, nil)
+			copy(container[index+1:], container[index:])
+			container[index] = value
+			return container, true
+		}
+		return nil, false
+	})
+}
+
+func pointerReplace(doc interface{}, path []string, value interface{}) (interface{}, bool) {
+	if len(path) == 0 {
+		return value, true
+	}
+	return updateParent(doc, path, func(parent interface{}, segment string) (interface{}, bool) {
+		switch container := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := container[segment]; !ok {
+				return nil, false
+			}
+			container[segment] = value
+			return container, true
+		case []interface{}:
+			index, ok := arrayIndex(segment, len(container), false)
+			if !ok {
+				return nil, false
+			}
+			container[index] = value
+			return container, true
+		}
+		return nil, false
+	})
+}
+
+func pointerRemove(doc interface{}, path []string) (interface{}, bool) {
+	if len(path) == 0 {
+		return nil, false
+	}
+	return updateParent(doc, path, func(parent interface{}, segment string) (interface{}, bool) {
+		switch container := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := container[segment]; !ok {
+				return nil, false
+			}
+			delete(container, segment)
+			return container, true
+		case []interface{}:
+			index, ok := arrayIndex(segment, len(container), false)
+			if !ok {
+				return nil, false
+			}
+			return append(container[:index], container[index+1:]...), true
+		}
+		return nil, false
+	})
+}
+
+func deepCopyJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			copied[key] = deepCopyJSON(item)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, item := range v {
+			copied[i] = deepCopyJSON(item)
+		}
+		return copied
+	}
+	return value
+}
+
+func materiaRequestFrom(materia Materia) CreateMateriaRequest {
+	return CreateMateriaRequest{Nome: materia.Nome, Descricao: materia.Descricao}
+}
+
+// provaTrabalhoRequestFrom monta o documento editável da prova/trabalho; listas vazias são
+// representadas como [] para que operações como add em /referencias/- funcionem
+func provaTrabalhoRequestFrom(prova ProvaTrabalho) CreateProvaTrabalhoRequest {
+	req := CreateProvaTrabalhoRequest{
+		Titulo:          prova.Titulo,
+		ConteudosEstudo: prova.ConteudosEstudo,
+		Anexos:          prova.Anexos,
+		Referencias:     prova.Referencias,
+		Bibliografia:    prova.Bibliografia,
+		DataEntrega:     prova.DataEntrega,
+		MateriaID:       prova.MateriaID,
+	}
+	if req.Anexos == nil {
+		req.Anexos = []string{}
+	}
+	if req.Referencias == nil {
+		req.Referencias = []string{}
+	}
+	if req.Bibliografia == nil {
+		req.Bibliografia = []Referencia{}
+	}
+	return req
+}
+
+func patchMateriaHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	apply, perr := readPatch(w, r)
+	if perr != nil {
+		log.Printf("Patch inválido para matéria %d do user %d: %v", id, userID, perr)
+		writePatchError(w, r, perr)
+		return
+	}
+
+	storeMu.Lock()
+	materia := findMateria(tenantID, userID, id)
+	if materia == nil {
+		storeMu.Unlock()
+		log.Printf("Tentativa de atualizar matéria inexistente: ID %d, User %d", id, userID)
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "materia_not_found")
+		return
+	}
+	if result := checkIfMatch(r, materia.Version); result != preconditionOK {
+		current := *materia
+		storeMu.Unlock()
+		writePreconditionError(w, r, result, userID, fmt.Sprintf("materia %d", id), current, current.Version)
+		return
+	}
+
+	var req CreateMateriaRequest
+	if perr := patchDocument(apply, materiaRequestFrom(*materia), &req); perr != nil {
+		storeMu.Unlock()
+		writePatchError(w, r, perr)
+		return
+	}
+
+	// Validações sobre o resultado do patch
+	if details := validateMateriaRequest(req); len(details) > 0 {
+		storeMu.Unlock()
+		writeValidationError(w, r, details)
+		return
+	}
+	if materiaNameTaken(tenantID, userID, id, req.Nome) {
+		storeMu.Unlock()
+		writeErrorResponse(w, r, http.StatusConflict, "CONFLICT", "materia_name_exists")
+		return
+	}
+
+	applyMateriaRequest(materia, req, r)
+	updated := *materia
+	storeMu.Unlock()
+
+	logUserMutation(r, userID, "UPDATE", fmt.Sprintf("materia %d (patch)", id))
+	w.Header().Set("Accept-Patch", acceptPatch)
+	w.Header().Set("ETag", entityTag(updated.Version))
+	writeSuccessResponse(w, r, "materia_updated", updated)
+}
+
+func patchProvaTrabalhoHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	apply, perr := readPatch(w, r)
+	if perr != nil {
+		log.Printf("Patch inválido para prova/trabalho %d do user %d: %v", id, userID, perr)
+		writePatchError(w, r, perr)
+		return
+	}
+
+	storeMu.Lock()
+	prova := findProvaTrabalho(tenantID, userID, id)
+	if prova == nil {
+		storeMu.Unlock()
+		log.Printf("Tentativa de atualizar prova/trabalho inexistente: ID %d, User %d", id, userID)
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "prova_not_found")
+		return
+	}
+	if result := checkIfMatch(r, prova.Version); result != preconditionOK {
+		current := *prova
+		storeMu.Unlock()
+		writePreconditionError(w, r, result, userID, fmt.Sprintf("prova-trabalho %d", id), current, current.Version)
+		return
+	}
+
+	var req CreateProvaTrabalhoRequest
+	if perr := patchDocument(apply, provaTrabalhoRequestFrom(*prova), &req); perr != nil {
+		storeMu.Unlock()
+		writePatchError(w, r, perr)
+		return
+	}
+
+	// Validações sobre o resultado do patch; uma data de entrega que já passou só é recusada
+	// se o patch a alterou, para que seja possível editar outros campos de provas antigas
+	var details []FieldError
+	for _, detail := range validateProvaTrabalhoRequest(req) {
+		if detail.Field == "data_entrega" && sameTime(req.DataEntrega, prova.DataEntrega) {
+			continue
+		}
+		details = append(details, detail)
+	}
+	if len(details) > 0 {
+		storeMu.Unlock()
+		writeValidationError(w, r, details)
+		return
+	}
+
+	materia := findMateria(tenantID, userID, req.MateriaID)
+	if materia == nil {
+		storeMu.Unlock()
+		log.Printf("Tentativa de atualizar prova/trabalho para matéria inexistente: MateriaID %d, User %d", req.MateriaID, userID)
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "materia_not_found")
+		return
+	}
+	materiaNome := materia.Nome
+
+	applyProvaTrabalhoRequest(prova, req, r)
+	updated := *prova
+	storeMu.Unlock()
+
+	logUserMutation(r, userID, "UPDATE", fmt.Sprintf("prova-trabalho %d para matéria %s (patch)", id, materiaNome))
+	w.Header().Set("Accept-Patch", acceptPatch)
+	w.Header().Set("ETag", entityTag(updated.Version))
+	writeSuccessResponse(w, r, "prova_updated", updated)
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// jsonValue decodifica um literal JSON de teste
+func jsonValue(t *testing.T, raw string) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(raw), &v); err != nil {
+		t.Fatalf("JSON de teste inválido %q: %v", raw, err)
+	}
+	return v
+}
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name, doc, patch, want string
+	}{
+		{"null remove o campo", `{"a": 1, "b": 2}`, `{"a": null}`, `{"b": 2}`},
+		{"null em campo ausente", `{"a": 1}`, `{"z": null}`, `{"a": 1}`},
+		{"objetos mesclados", `{"a": {"x": 1, "y": 2}}`, `{"a": {"y": null, "z": 3}}`, `{"a": {"x": 1, "z": 3}}`},
+		{"arrays substituídos", `{"a": [1, 2, 3]}`, `{"a": [4]}`, `{"a": [4]}`},
+		{"objeto sobre escalar", `{"a": 1}`, `{"a": {"b": null, "c": 2}}`, `{"a": {"c": 2}}`},
+		{"patch não objeto", `{"a": 1}`, `[1, 2]`, `[1, 2]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mergePatch(jsonValue(t, tt.doc), jsonValue(t, tt.patch))
+			if want := jsonValue(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Fatalf("resultado = %v; esperado %v", got, want)
+			}
+		})
+	}
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	const doc = `{"lista": [1, 2, 3], "obj": {"a": 1}, "x/y": 0, "t~n": 0}`
+	tests := []struct {
+		name, ops, want string
+	}{
+		{"add no fim com -", `[{"op": "add", "path": "/lista/-", "value": 4}]`, `{"lista": [1, 2, 3, 4], "obj": {"a": 1}, "x/y": 0, "t~n": 0}`},
+		{"add no início", `[{"op": "add", "path": "/lista/0", "value": 0}]`, `{"lista": [0, 1, 2, 3], "obj": {"a": 1}, "x/y": 0, "t~n": 0}`},
+		{"add no índice do tamanho", `[{"op": "add", "path": "/lista/3", "value": 4}]`, `{"lista": [1, 2, 3, 4], "obj": {"a": 1}, "x/y": 0, "t~n": 0}`},
+		{"remove do meio", `[{"op": "remove", "path": "/lista/1"}]`, `{"lista": [1, 3], "obj": {"a": 1}, "x/y": 0, "t~n": 0}`},
+		{"replace com escapes", `[{"op": "replace", "path": "/x~1y", "value": 1}, {"op": "replace", "path": "/t~0n", "value": 2}]`, `{"lista": [1, 2, 3], "obj": {"a": 1}, "x/y": 1, "t~n": 2}`},
+		{"move", `[{"op": "move", "from": "/obj/a", "path": "/b"}]`, `{"lista": [1, 2, 3], "obj": {}, "b": 1, "x/y": 0, "t~n": 0}`},
+		{"copy para dentro de si", `[{"op": "copy", "from": "/obj", "path": "/obj/c"}]`, `{"lista": [1, 2, 3], "obj": {"a": 1, "c": {"a": 1}}, "x/y": 0, "t~n": 0}`},
+		{"test que passa", `[{"op": "test", "path": "/obj", "value": {"a": 1}}]`, doc},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ops []jsonPatchOp
+			if err := json.Unmarshal([]byte(tt.ops), &ops); err != nil {
+				t.Fatalf("ops: %v", err)
+			}
+			got, perr := applyJSONPatch(jsonValue(t, doc), ops)
+			if perr != nil {
+				t.Fatalf("applyJSONPatch: %v", perr)
+			}
+			if want := jsonValue(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Fatalf("resultado = %v; esperado %v", got, want)
+			}
+		})
+	}
+}
+
+func TestApplyJSONPatchErrors(t *testing.T) {
+	const doc = `{"lista": [1, 2, 3], "obj": {"a": 1}}`
+	tests := []struct {
+		name, ops string
+		status    int
+		key       string
+	}{
+		{"test que falha", `[{"op": "test", "path": "/obj/a", "value": 2}]`, http.StatusConflict, "patch_test_failed"},
+		{"test em caminho ausente", `[{"op": "test", "path": "/nada", "value": 1}]`, http.StatusConflict, "patch_test_failed"},
+		{"move para dentro de si", `[{"op": "move", "from": "/obj", "path": "/obj/filho"}]`, http.StatusBadRequest, "patch_invalid_path"},
+		{"add além do fim", `[{"op": "add", "path": "/lista/4", "value": 0}]`, http.StatusConflict, "patch_path_not_found"},
+		{"add com zero à esquerda", `[{"op": "add", "path": "/lista/01", "value": 0}]`, http.StatusConflict, "patch_path_not_found"},
+		{"add com índice negativo", `[{"op": "add", "path": "/lista/-1", "value": 0}]`, http.StatusConflict, "patch_path_not_found"},
+		{"replace no índice do tamanho", `[{"op": "replace", "path": "/lista/3", "value": 0}]`, http.StatusConflict, "patch_path_not_found"},
+		{"remove com -", `[{"op": "remove", "path": "/lista/-"}]`, http.StatusConflict, "patch_path_not_found"},
+		{"remove de campo ausente", `[{"op": "remove", "path": "/nada"}]`, http.StatusConflict, "patch_path_not_found"},
+		{"remove da raiz", `[{"op": "remove", "path": ""}]`, http.StatusConflict, "patch_path_not_found"},
+		{"add em pai ausente", `[{"op": "add", "path": "/nada/a", "value": 0}]`, http.StatusConflict, "patch_path_not_found"},
+		{"copy de caminho ausente", `[{"op": "copy", "from": "/nada", "path": "/b"}]`, http.StatusConflict, "patch_path_not_found"},
+		{"caminho sem / inicial", `[{"op": "add", "path": "lista", "value": 0}]`, http.StatusBadRequest, "patch_invalid_path"},
+		{"add sem value", `[{"op": "add", "path": "/b"}]`, http.StatusBadRequest, "patch_invalid_op"},
+		{"operação desconhecida", `[{"op": "increment", "path": "/obj/a"}]`, http.StatusBadRequest, "patch_invalid_op"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ops []jsonPatchOp
+			if err := json.Unmarshal([]byte(tt.ops), &ops); err != nil {
+				t.Fatalf("ops: %v", err)
+			}
+			_, perr := applyJSONPatch(jsonValue(t, doc), ops)
+			if perr == nil {
+				t.Fatal("patch inválido aplicado")
+			}
+			if perr.status != tt.status || perr.key != tt.key {
+				t.Fatalf("erro = %d %s; esperado %d %s", perr.status, perr.key, tt.status, tt.key)
+			}
+		})
+	}
+}
+
+// patchProva chama o PATCH /provas-trabalhos/{id}
+func patchProva(t *testing.T, principal Principal, id int, contentType, body string, header map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	if header == nil {
+		header = map[string]string{}
+	}
+	header["Content-Type"] = contentType
+	return itemRequest(t, patchProvaTrabalhoHandler, http.MethodPatch, "/provas-trabalhos/1", id, body, header, principal)
+}
+
+// storedProva retorna uma cópia da prova/trabalho no store
+func storedProva(t *testing.T, principal Principal, id int) ProvaTrabalho {
+	t.Helper()
+	storeMu.RLock()
+	defer storeMu.RUnlock()
+	prova := findProvaTrabalho(principal.TenantID, principal.UserID, id)
+	if prova == nil {
+		t.Fatalf("prova %d não está no store", id)
+	}
+	return *prova
+}
+
+func TestPatchProvaTrabalho(t *testing.T) {
+	principal := Principal{UserID: 941, TenantID: 1}
+	materia := createTestMateria(t, principal, "Cálculo")
+	prova := createTestProva(t, principal, materia.ID, "Prova 1")
+
+	rec := patchProva(t, principal, prova.ID, mediaTypeMergePatch, `{"titulo": "Prova 1 - remarcada", "referencias": ["Stewart"]}`, nil)
+	var updated ProvaTrabalho
+	decodeData(t, rec, &updated)
+	if rec.Code != http.StatusOK || updated.Titulo != "Prova 1 - remarcada" || updated.ConteudosEstudo != prova.ConteudosEstudo || !reflect.DeepEqual(updated.Referencias, []string{"Stewart"}) {
+		t.Fatalf("merge patch: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if etag := rec.Header().Get("ETag"); etag != entityTag(prova.Version+1) {
+		t.Fatalf("ETag = %q; esperado %q", etag, entityTag(prova.Version+1))
+	}
+
+	rec = patchProva(t, principal, prova.ID, mediaTypeJSONPatch, `[{"op": "add", "path": "/referencias/-", "value": "Guidorizzi"}]`, nil)
+	decodeData(t, rec, &updated)
+	if rec.Code != http.StatusOK || !reflect.DeepEqual(updated.Referencias, []string{"Stewart", "Guidorizzi"}) {
+		t.Fatalf("json patch: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Um test que falha descarta as operações anteriores do mesmo patch
+	before := storedProva(t, principal, prova.ID)
+	rec = patchProva(t, principal, prova.ID, mediaTypeJSONPatch, `[{"op": "replace", "path": "/titulo", "value": "Outra"}, {"op": "test", "path": "/referencias/0", "value": "Outro autor"}]`, nil)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("test que falha: status %d: %s; esperado 409", rec.Code, rec.Body.String())
+	}
+	if after := storedProva(t, principal, prova.ID); after.Titulo != before.Titulo || after.Version != before.Version {
+		t.Fatalf("prova alterada por patch recusado: %+v", after)
+	}
+
+	// Campos fora do documento editável e Content-Types desconhecidos são recusados
+	rec = patchProva(t, principal, prova.ID, mediaTypeMergePatch, `{"user_id": 1}`, nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("campo não editável: status %d; esperado 400", rec.Code)
+	}
+	rec = patchProva(t, principal, prova.ID, "text/plain", `titulo=x`, nil)
+	if rec.Code != http.StatusUnsupportedMediaType || rec.Header().Get("Accept-Patch") != acceptPatch {
+		t.Fatalf("Content-Type desconhecido: status %d, Accept-Patch %q", rec.Code, rec.Header().Get("Accept-Patch"))
+	}
+	rec = patchProva(t, principal, prova.ID, mediaTypeMergePatch, `{"materia_id": 999999}`, nil)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("matéria inexistente: status %d; esperado 404", rec.Code)
+	}
+}
+
+func TestPatchProvaPastDeadline(t *testing.T) {
+	principal := Principal{UserID: 942, TenantID: 1}
+	materia := createTestMateria(t, principal, "História")
+	prova := createTestProva(t, principal, materia.ID, "Trabalho antigo")
+
+	// Provas antigas têm data de entrega no passado, o que a criação não aceita
+	past := time.Now().Add(-48 * time.Hour).UTC().Truncate(time.Second)
+	storeMu.Lock()
+	findProvaTrabalho(principal.TenantID, principal.UserID, prova.ID).DataEntrega = &past
+	storeMu.Unlock()
+
+	rec := patchProva(t, principal, prova.ID, mediaTypeMergePatch, `{"titulo": "Trabalho antigo revisado"}`, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("editar outro campo de prova antiga: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if stored := storedProva(t, principal, prova.ID); !sameTime(stored.DataEntrega, &past) {
+		t.Fatalf("data de entrega alterada para %v", stored.DataEntrega)
+	}
+
+	otherPast := past.Add(-time.Hour).Format(time.RFC3339)
+	rec = patchProva(t, principal, prova.ID, mediaTypeMergePatch, `{"data_entrega": "`+otherPast+`"}`, nil)
+	var resp ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusBadRequest || len(resp.Details) != 1 || resp.Details[0].Field != "data_entrega" {
+		t.Fatalf("mudar para outra data passada: status %d: %s; esperado erro em data_entrega", rec.Code, rec.Body.String())
+	}
+
+	future := time.Now().Add(72 * time.Hour).UTC().Format(time.RFC3339)
+	if rec := patchProva(t, principal, prova.ID, mediaTypeMergePatch, `{"data_entrega": "`+future+`"}`, nil); rec.Code != http.StatusOK {
+		t.Fatalf("mudar para data futura: status %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestPatchIfMatch(t *testing.T) {
+	principal := Principal{UserID: 943, TenantID: 1}
+	materia := createTestMateria(t, principal, "Química")
+	prova := createTestProva(t, principal, materia.ID, "Relatório")
+	stale := entityTag(prova.Version)
+
+	rec := patchProva(t, principal, prova.ID, mediaTypeMergePatch, `{"titulo": "Relatório final"}`, map[string]string{"If-Match": stale})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("If-Match atual: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// O segundo cliente ainda tem a versão anterior: recebe 412 com a representação atual
+	rec = patchProva(t, principal, prova.ID, mediaTypeMergePatch, `{"titulo": "Relatório do segundo cliente"}`, map[string]string{"If-Match": stale})
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("If-Match antigo: status %d: %s; esperado 412", rec.Code, rec.Body.String())
+	}
+	var current ProvaTrabalho
+	resp := ErrorResponse{Current: &current}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Error != "PRECONDITION_FAILED" || current.Titulo != "Relatório final" {
+		t.Fatalf("corpo do 412: %s", rec.Body.String())
+	}
+	if etag := rec.Header().Get("ETag"); etag != entityTag(current.Version) || etag == stale {
+		t.Fatalf("ETag do 412 = %q; esperado %q", etag, entityTag(current.Version))
+	}
+	if stored := storedProva(t, principal, prova.ID); stored.Titulo != "Relatório final" {
+		t.Fatalf("prova alterada apesar do 412: %q", stored.Titulo)
+	}
+
+	// A precondição é checada antes do patch: mesmo um patch inválido recebe o 412
+	rec = patchProva(t, principal, prova.ID, mediaTypeJSONPatch, `[{"op": "test", "path": "/titulo", "value": "outro"}]`, map[string]string{"If-Match": stale})
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("patch inválido com If-Match antigo: status %d; esperado 412", rec.Code)
+	}
+}
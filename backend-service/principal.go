@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+)
+
+// Principal é a identidade autenticada da requisição, validada pelo Auth Service
+type Principal struct {
+	UserID   int
+	Email    string
+	TenantID int
+	Roles    []string
+	Scopes   []string
+	// Administrador que personifica o usuário, se houver
+	Impersonator *Actor
+}
+
+type principalKey struct{}
+
+// identityHeaders nunca são aceitos do cliente: a identidade vem apenas do contexto
+var identityHeaders = []string{"X-User-ID", "X-Tenant-ID", "X-Impersonator-ID"}
+
+func newPrincipal(authResp *AuthResponse) Principal {
+	principal := Principal{
+		UserID:       authResp.UserID,
+		Email:        authResp.Email,
+		TenantID:     authResp.TenantID,
+		Scopes:       authResp.Scopes,
+		Impersonator: authResp.Act,
+	}
+	if authResp.Role != "" {
+		principal.Roles = []string{authResp.Role}
+	}
+	return principal
+}
+
+func withPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// principalFromContext retorna o principal autenticado; um principal sem usuário ou
+// tenant é tratado como ausente
+func principalFromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	if !ok || principal.UserID <= 0 || principal.TenantID <= 0 {
+		return Principal{}, false
+	}
+	return principal, true
+}
+
+// requirePrincipal retorna o principal da requisição ou responde 401; handlers protegidos
+// nunca seguem sem identidade, mesmo se registrados por engano sem o authMiddleware
+func requirePrincipal(w http.ResponseWriter, r *http.Request) (Principal, bool) {
+	principal, ok := principalFromContext(r.Context())
+	if !ok {
+		log.Printf("Acesso negado: requisição sem principal autenticado - %s %s", r.Method, r.URL.Path)
+		writeErrorResponse(w, r, http.StatusUnauthorized, "UNAUTHORIZED", "token_missing")
+		return Principal{}, false
+	}
+	return principal, true
+}
+
+// impersonatorID retorna o administrador que personifica o usuário nesta requisição, ou 0
+func impersonatorID(r *http.Request) int {
+	principal, _ := principalFromContext(r.Context())
+	if principal.Impersonator == nil {
+		return 0
+	}
+	return principal.Impersonator.UserID
+}
+
+// stripIdentityHeaders remove headers de identidade enviados pelo cliente em todas as rotas
+func stripIdentityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, header := range identityHeaders {
+			if r.Header.Get(header) != "" {
+				log.Printf("Header de identidade %s enviado pelo cliente descartado - IP: %s", header, r.RemoteAddr)
+				r.Header.Del(header)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
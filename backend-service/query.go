@@ -0,0 +1,408 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+// sortField é um campo de ordenação; "-" no parâmetro sort indica ordem decrescente
+type sortField struct {
+	name string
+	desc bool
+}
+
+// sortValue é o valor de um campo de ordenação de um registro, comparável entre registros
+// e serializável no cursor. Valores nulos (ex.: sem data de entrega) ficam sempre no fim.
+type sortValue struct {
+	Null bool   `json:"z,omitempty"`
+	S    string `json:"s,omitempty"`
+	N    int64  `json:"n,omitempty"`
+}
+
+func stringSortValue(s string) sortValue { return sortValue{S: strings.ToLower(s)} }
+func intSortValue(n int) sortValue       { return sortValue{N: int64(n)} }
+func timeSortValue(t time.Time) sortValue {
+	return sortValue{N: t.UnixNano()}
+}
+func optionalTimeSortValue(t *time.Time) sortValue {
+	if t == nil {
+		return sortValue{Null: true}
+	}
+	return timeSortValue(*t)
+}
+
+func compareSortValues(a, b sortValue) int {
+	switch {
+	case a.Null || b.Null:
+		if a.Null == b.Null {
+			return 0
+		}
+		if a.Null {
+			return 1
+		}
+		return -1
+	case a.N != b.N:
+		if a.N < b.N {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a.S, b.S)
+}
+
+// listQuery reúne os parâmetros de paginação, ordenação e filtro de uma listagem
+type listQuery struct {
+	// limit 0 devolve a listagem inteira, sem paginação
+	limit    int
+	sort     []sortField
+	sortSpec string
+	after    []sortValue
+
+	materiaID       int
+	dataEntregaFrom *time.Time
+	dataEntregaTo   *time.Time
+	hasDataEntrega  *bool
+	updatedSince    *time.Time
+}
+
+// compare ordena chaves de acordo com os campos de ordenação (o ID é sempre o último, como desempate)
+func (q listQuery) compare(a, b []sortValue) int {
+	for i, field := range q.sort {
+		c := compareSortValues(a[i], b[i])
+		if c == 0 {
+			continue
+		}
+		// Nulos ficam no fim também na ordem decrescente
+		if field.desc && !a[i].Null && !b[i].Null {
+			c = -c
+		}
+		return c
+	}
+	return 0
+}
+
+type pageCursor struct {
+	Sort  string      `json:"sort"`
+	After []sortValue `json:"after"`
+}
+
+func encodeCursor(sortSpec string, after []sortValue) string {
+	raw, _ := json.Marshal(pageCursor{Sort: sortSpec, After: after})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// parseListQuery lê limit, cursor, sort e os filtros da query string. sortable lista os
+// campos aceitos em sort; o ID é acrescentado como desempate. Sem limit nem cursor, a
+// listagem não é paginada, como antes da paginação existir.
+func parseListQuery(r *http.Request, sortable map[string]bool) (listQuery, []FieldError) {
+	params := r.URL.Query()
+	q := listQuery{}
+	var details []FieldError
+
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			details = append(details, fieldError("limit", "query_limit_range", maxPageLimit))
+		} else {
+			q.limit = limit
+		}
+	}
+
+	var specs []string
+	for _, name := range strings.Split(params.Get("sort"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		field := sortField{name: strings.TrimPrefix(name, "-"), desc: strings.HasPrefix(name, "-")}
+		if !sortable[field.name] || field.name == "id" {
+			details = append(details, fieldError("sort", "query_sort_field", field.name))
+			continue
+		}
+		q.sort = append(q.sort, field)
+		specs = append(specs, name)
+	}
+	q.sort = append(q.sort, sortField{name: "id"})
+	q.sortSpec = strings.Join(specs, ",")
+
+	if value := params.Get("cursor"); value != "" {
+		var cursor pageCursor
+		raw, err := base64.RawURLEncoding.DecodeString(value)
+		if err == nil {
+			err = json.Unmarshal(raw, &cursor)
+		}
+		if err != nil || cursor.Sort != q.sortSpec || len(cursor.After) != len(q.sort) {
+			details = append(details, fieldError("cursor", "query_cursor"))
+		} else {
+			q.after = cursor.After
+		}
+		if q.limit == 0 {
+			q.limit = defaultPageLimit
+		}
+	}
+
+	if value := params.Get("materia_id"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil || id <= 0 {
+			details = append(details, fieldError("materia_id", "query_positive_int"))
+		} else {
+			q.materiaID = id
+		}
+	}
+
+	parseTime := func(name string, endOfDay bool) *time.Time {
+		value := params.Get(name)
+		if value == "" {
+			return nil
+		}
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			return &t
+		}
+		// Datas sem horário cobrem o dia inteiro
+		if t, err := time.Parse("2006-01-02", value); err == nil {
+			if endOfDay {
+				t = t.Add(24*time.Hour - time.Nanosecond)
+			}
+			return &t
+		}
+		details = append(details, fieldError(name, "query_date"))
+		return nil
+	}
+	q.dataEntregaFrom = parseTime("data_entrega_from", false)
+	q.dataEntregaTo = parseTime("data_entrega_to", true)
+	q.updatedSince = parseTime("updated_since", false)
+
+	if value := params.Get("has_data_entrega"); value != "" {
+		has, err := strconv.ParseBool(value)
+		if err != nil {
+			details = append(details, fieldError("has_data_entrega", "query_bool"))
+		} else {
+			q.hasDataEntrega = &has
+		}
+	}
+
+	return q, details
+}
+
+type pageEntry[T any] struct {
+	item T
+	key  []sortValue
+}
+
+// pageHeap mantém as limit+1 menores entradas vistas, com a maior no topo
+type pageHeap[T any] struct {
+	entries []pageEntry[T]
+	q       listQuery
+}
+
+func (h *pageHeap[T]) Len() int           { return len(h.entries) }
+func (h *pageHeap[T]) Less(i, j int) bool { return h.q.compare(h.entries[i].key, h.entries[j].key) > 0 }
+func (h *pageHeap[T]) Swap(i, j int)      { h.entries[i], h.entries[j] = h.entries[j], h.entries[i] }
+func (h *pageHeap[T]) Push(x any)         { h.entries = append(h.entries, x.(pageEntry[T])) }
+func (h *pageHeap[T]) Pop() any {
+	last := h.entries[len(h.entries)-1]
+	h.entries = h.entries[:len(h.entries)-1]
+	return last
+}
+
+// pageCollector seleciona uma página sem ordenar a coleção inteira: cada registro que passa
+// nos filtros é oferecido e só as limit+1 primeiras posições após o cursor são mantidas
+type pageCollector[T any] struct {
+	selected *pageHeap[T]
+	key      func(T) []sortValue
+}
+
+func newPageCollector[T any](q listQuery, key func(T) []sortValue) *pageCollector[T] {
+	return &pageCollector[T]{selected: &pageHeap[T]{q: q}, key: key}
+}
+
+func (c *pageCollector[T]) offer(item T) {
+	q := c.selected.q
+	key := c.key(item)
+	if q.after != nil && q.compare(key, q.after) <= 0 {
+		return
+	}
+	if q.limit == 0 {
+		heap.Push(c.selected, pageEntry[T]{item: item, key: key})
+		return
+	}
+	if c.selected.Len() > q.limit && q.compare(key, c.selected.entries[0].key) >= 0 {
+		return
+	}
+	heap.Push(c.selected, pageEntry[T]{item: item, key: key})
+	if c.selected.Len() > q.limit+1 {
+		heap.Pop(c.selected)
+	}
+}
+
+// page retorna os registros da página em ordem e o cursor da próxima, vazio se for a última
+func (c *pageCollector[T]) page() ([]T, string) {
+	q := c.selected.q
+	entries := c.selected.entries
+	sort.Slice(entries, func(i, j int) bool { return q.compare(entries[i].key, entries[j].key) < 0 })
+
+	nextCursor := ""
+	if q.limit > 0 && len(entries) > q.limit {
+		entries = entries[:q.limit]
+		nextCursor = encodeCursor(q.sortSpec, entries[len(entries)-1].key)
+	}
+
+	items := make([]T, 0, len(entries))
+	for _, entry := range entries {
+		items = append(items, entry.item)
+	}
+	return items, nextCursor
+}
+
+var materiaSortFields = map[string]func(Materia) sortValue{
+	"id":         func(m Materia) sortValue { return intSortValue(m.ID) },
+	"nome":       func(m Materia) sortValue { return stringSortValue(m.Nome) },
+	"created_at": func(m Materia) sortValue { return timeSortValue(m.CreatedAt) },
+	"updated_at": func(m Materia) sortValue { return timeSortValue(m.UpdatedAt) },
+}
+
+var provaSortFields = map[string]func(ProvaTrabalho) sortValue{
+	"id":           func(p ProvaTrabalho) sortValue { return intSortValue(p.ID) },
+	"titulo":       func(p ProvaTrabalho) sortValue { return stringSortValue(p.Titulo) },
+	"data_entrega": func(p ProvaTrabalho) sortValue { return optionalTimeSortValue(p.DataEntrega) },
+	"materia_id":   func(p ProvaTrabalho) sortValue { return intSortValue(p.MateriaID) },
+	"created_at":   func(p ProvaTrabalho) sortValue { return timeSortValue(p.CreatedAt) },
+	"updated_at":   func(p ProvaTrabalho) sortValue { return timeSortValue(p.UpdatedAt) },
+}
+
+func sortableFields[T any](fields map[string]func(T) sortValue) map[string]bool {
+	names := make(map[string]bool, len(fields))
+	for name := range fields {
+		names[name] = true
+	}
+	return names
+}
+
+func sortKeyFunc[T any](q listQuery, fields map[string]func(T) sortValue) func(T) []sortValue {
+	return func(item T) []sortValue {
+		key := make([]sortValue, len(q.sort))
+		for i, field := range q.sort {
+			key[i] = fields[field.name](item)
+		}
+		return key
+	}
+}
+
+// queryMaterias retorna uma página das matérias do usuário
+func queryMaterias(tenantID, userID int, q listQuery) ([]Materia, string) {
+	collector := newPageCollector(q, sortKeyFunc(q, materiaSortFields))
+
+	storeMu.RLock()
+	for _, materia := range materias {
+		if materia.TenantID != tenantID || materia.UserID != userID || materia.DeletedAt != nil {
+			continue
+		}
+		if q.updatedSince != nil && materia.UpdatedAt.Before(*q.updatedSince) {
+			continue
+		}
+		collector.offer(materia)
+	}
+	storeMu.RUnlock()
+
+	return collector.page()
+}
+
+// queryProvasTrabalhos retorna uma página das provas/trabalhos do usuário
+func queryProvasTrabalhos(tenantID, userID int, q listQuery) ([]ProvaTrabalho, string) {
+	collector := newPageCollector(q, sortKeyFunc(q, provaSortFields))
+
+	storeMu.RLock()
+	for _, prova := range provasTrabalhos {
+		if prova.TenantID != tenantID || prova.UserID != userID || prova.DeletedAt != nil {
+			continue
+		}
+		if q.materiaID != 0 && prova.MateriaID != q.materiaID {
+			continue
+		}
+		if q.hasDataEntrega != nil && (prova.DataEntrega != nil) != *q.hasDataEntrega {
+			continue
+		}
+		if q.dataEntregaFrom != nil && (prova.DataEntrega == nil || prova.DataEntrega.Before(*q.dataEntregaFrom)) {
+			continue
+		}
+		if q.dataEntregaTo != nil && (prova.DataEntrega == nil || prova.DataEntrega.After(*q.dataEntregaTo)) {
+			continue
+		}
+		if q.updatedSince != nil && prova.UpdatedAt.Before(*q.updatedSince) {
+			continue
+		}
+		collector.offer(prova)
+	}
+	storeMu.RUnlock()
+
+	return collector.page()
+}
+
+// materiaIncludes são as expansões aceitas em ?include= nas respostas de matérias
+var materiaIncludes = map[string]bool{"provas": true}
+
+// parseInclude lê o parâmetro include (valores separados por vírgula)
+func parseInclude(r *http.Request, allowed map[string]bool) (map[string]bool, []FieldError) {
+	include := map[string]bool{}
+	var details []FieldError
+	for _, name := range strings.Split(r.URL.Query().Get("include"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !allowed[name] {
+			details = append(details, fieldError("include", "query_include", name))
+			continue
+		}
+		include[name] = true
+	}
+	return include, details
+}
+
+// PageInfo descreve a página retornada por uma listagem
+type PageInfo struct {
+	Limit      int    `json:"limit"`
+	HasMore    bool   `json:"has_more"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// writePageResponse responde uma listagem paginada, com o link da próxima página no header Link;
+// listagens sem paginação não trazem o campo pagination
+func writePageResponse(w http.ResponseWriter, r *http.Request, messageKey string, data interface{}, q listQuery, nextCursor string) {
+	var pagination *PageInfo
+	if q.limit > 0 {
+		pagination = &PageInfo{
+			Limit:      q.limit,
+			HasMore:    nextCursor != "",
+			NextCursor: nextCursor,
+		}
+	}
+	if nextCursor != "" {
+		next := url.URL{Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+		params := next.Query()
+		params.Set("cursor", nextCursor)
+		params.Set("limit", strconv.Itoa(q.limit))
+		next.RawQuery = params.Encode()
+		w.Header().Set("Link", "<"+next.String()+`>; rel="next"`)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Language", preferredLanguage(r))
+	json.NewEncoder(w).Encode(SuccessResponse{
+		Message:    localize(r, messageKey),
+		Data:       data,
+		Pagination: pagination,
+	})
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestListPaginationOptIn(t *testing.T) {
+	principal := Principal{UserID: 911, TenantID: 1}
+	const total = defaultPageLimit + 10
+	for i := 0; i < total; i++ {
+		rec := doRequest(t, createMateriaHandler, http.MethodPost, "/materias", CreateMateriaRequest{Nome: fmt.Sprintf("Listada %02d", i), Descricao: "Para a listagem"}, principal)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("criar matéria %d: status %d: %s", i, rec.Code, rec.Body.String())
+		}
+	}
+
+	list := func(target string) ([]Materia, *PageInfo) {
+		t.Helper()
+		rec := doRequest(t, getMateriasHandler, http.MethodGet, target, nil, principal)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d: %s", target, rec.Code, rec.Body.String())
+		}
+		var items []Materia
+		resp := SuccessResponse{Data: &items}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("GET %s: resposta não é JSON: %v", target, err)
+		}
+		return items, resp.Pagination
+	}
+
+	// Sem limit nem cursor, a lista vem inteira
+	items, pagination := list("/materias")
+	if len(items) != total || pagination != nil {
+		t.Fatalf("sem paginação: %d itens, pagination=%v; esperado %d sem pagination", len(items), pagination, total)
+	}
+
+	items, pagination = list("/materias?limit=25&sort=nome")
+	if len(items) != 25 || pagination == nil || !pagination.HasMore {
+		t.Fatalf("primeira página: %d itens, pagination=%+v", len(items), pagination)
+	}
+	seen := len(items)
+	for pagination.HasMore {
+		items, pagination = list("/materias?sort=nome&cursor=" + pagination.NextCursor)
+		if pagination == nil || pagination.Limit != defaultPageLimit {
+			t.Fatalf("cursor sem limit: pagination=%+v; esperado limit %d", pagination, defaultPageLimit)
+		}
+		seen += len(items)
+	}
+	if seen != total {
+		t.Fatalf("páginas somam %d itens; esperado %d", seen, total)
+	}
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Referencia é uma referência bibliográfica estruturada. Autores vêm como "Sobrenome, Nome";
+// um autor sem vírgula é tratado como entidade (ex.: "Associação Brasileira de Normas Técnicas").
+type Referencia struct {
+	Tipo    string   `json:"tipo"`
+	Autores []string `json:"autores"`
+	Titulo  string   `json:"titulo"`
+	Ano     int      `json:"ano,omitempty"`
+	Edicao  string   `json:"edicao,omitempty"`
+	Local   string   `json:"local,omitempty"`
+	// Editora; em teses e dissertações, a instituição
+	Editora string `json:"editora,omitempty"`
+	// Periódico de um artigo ou livro de um capítulo
+	Periodico  string `json:"periodico,omitempty"`
+	Volume     string `json:"volume,omitempty"`
+	Numero     string `json:"numero,omitempty"`
+	Paginas    string `json:"paginas,omitempty"`
+	DOI        string `json:"doi,omitempty"`
+	ISBN       string `json:"isbn,omitempty"`
+	URL        string `json:"url,omitempty"`
+	AcessadoEm string `json:"acessado_em,omitempty"`
+}
+
+const (
+	refTipoLivro       = "livro"
+	refTipoCapitulo    = "capitulo"
+	refTipoArtigo      = "artigo"
+	refTipoSite        = "site"
+	refTipoTese        = "tese"
+	refTipoDissertacao = "dissertacao"
+	refTipoOutro       = "outro"
+
+	maxReferenciasPerProva = 200
+	maxBibliografiaBody    = 1 << 20
+)
+
+var refTipos = map[string]bool{
+	refTipoLivro: true, refTipoCapitulo: true, refTipoArtigo: true, refTipoSite: true,
+	refTipoTese: true, refTipoDissertacao: true, refTipoOutro: true,
+}
+
+// Formatos de GET .../bibliografia
+const (
+	bibFormatJSON     = "json"
+	bibFormatBibTeX   = "bibtex"
+	bibFormatRIS      = "ris"
+	bibFormatABNT     = "abnt"
+	bibFormatABNTHTML = "abnt-html"
+)
+
+var bibFormatContentTypes = map[string]string{
+	bibFormatBibTeX:   "application/x-bibtex; charset=utf-8",
+	bibFormatRIS:      "application/x-research-info-systems; charset=utf-8",
+	bibFormatABNT:     "text/plain; charset=utf-8",
+	bibFormatABNTHTML: "text/html; charset=utf-8",
+}
+
+// bibImportMediaTypes associa os Content-Types aceitos na importação ao formato
+var bibImportMediaTypes = map[string]string{
+	"application/x-bibtex":                bibFormatBibTeX,
+	"text/x-bibtex":                       bibFormatBibTeX,
+	"application/x-research-info-systems": bibFormatRIS,
+}
+
+var doiPattern = regexp.MustCompile(`^10\.\d{4,9}/\S+$`)
+
+// BibliografiaImport resume uma importação de BibTeX ou RIS
+type BibliografiaImport struct {
+	Importadas   int          `json:"importadas"`
+	Duplicadas   int          `json:"duplicadas"`
+	Bibliografia []Referencia `json:"bibliografia"`
+}
+
+// normalizeReferencia apara os campos e padroniza DOI, ISBN e páginas
+func normalizeReferencia(ref Referencia) Referencia {
+	ref.Tipo = strings.ToLower(strings.TrimSpace(ref.Tipo))
+	autores := make([]string, 0, len(ref.Autores))
+	for _, autor := range ref.Autores {
+		if autor = strings.Join(strings.Fields(autor), " "); autor != "" {
+			autores = append(autores, autor)
+		}
+	}
+	ref.Autores = autores
+	ref.Titulo = strings.TrimSpace(ref.Titulo)
+	ref.Edicao = strings.TrimSpace(ref.Edicao)
+	ref.Local = strings.TrimSpace(ref.Local)
+	ref.Editora = strings.TrimSpace(ref.Editora)
+	ref.Periodico = strings.TrimSpace(ref.Periodico)
+	ref.Volume = strings.TrimSpace(ref.Volume)
+	ref.Numero = strings.TrimSpace(ref.Numero)
+	ref.URL = strings.TrimSpace(ref.URL)
+	ref.AcessadoEm = strings.TrimSpace(ref.AcessadoEm)
+
+	ref.Paginas = strings.TrimSpace(ref.Paginas)
+	for _, prefix := range []string{"pp.", "p."} {
+		if rest, ok := strings.CutPrefix(ref.Paginas, prefix); ok {
+			ref.Paginas = strings.TrimSpace(rest)
+			break
+		}
+	}
+	ref.Paginas = strings.NewReplacer("--", "-", "–", "-", " ", "").Replace(ref.Paginas)
+
+	ref.DOI = strings.TrimSpace(ref.DOI)
+	for _, prefix := range []string{"https://doi.org/", "http://doi.org/", "https://dx.doi.org/", "http://dx.doi.org/", "doi:"} {
+		if len(ref.DOI) >= len(prefix) && strings.EqualFold(ref.DOI[:len(prefix)], prefix) {
+			ref.DOI = strings.TrimSpace(ref.DOI[len(prefix):])
+			break
+		}
+	}
+
+	// Só o primeiro ISBN, sem hífens nem espaços
+	isbn := strings.TrimSpace(ref.ISBN)
+	if len(isbn) >= 4 && strings.EqualFold(isbn[:4], "isbn") {
+		isbn = strings.TrimLeft(isbn[4:], ": ")
+	}
+	if fields := strings.FieldsFunc(isbn, func(r rune) bool { return r == ',' || r == ';' || r == ' ' || r == '(' }); len(fields) > 0 {
+		isbn = strings.ToUpper(strings.ReplaceAll(fields[0], "-", ""))
+	}
+	ref.ISBN = isbn
+	return ref
+}
+
+func normalizeBibliografia(list []Referencia) []Referencia {
+	normalized := make([]Referencia, len(list))
+	for i, ref := range list {
+		normalized[i] = normalizeReferencia(ref)
+	}
+	return normalized
+}
+
+// validateBibliografia valida as referências de uma prova/trabalho, apontando cada problema
+// como bibliografia[i].campo
+func validateBibliografia(list []Referencia) []FieldError {
+	if len(list) > maxReferenciasPerProva {
+		return []FieldError{fieldError("bibliografia", "bibliografia_limit", maxReferenciasPerProva)}
+	}
+	var details []FieldError
+	for i, ref := range list {
+		details = append(details, validateReferencia(fmt.Sprintf("bibliografia[%d]", i), ref)...)
+	}
+	return details
+}
+
+func validateReferencia(prefix string, ref Referencia) []FieldError {
+	ref = normalizeReferencia(ref)
+	var details []FieldError
+	if !refTipos[ref.Tipo] {
+		details = append(details, fieldError(prefix+".tipo", "referencia_tipo", ref.Tipo))
+	}
+	if ref.Titulo == "" {
+		details = append(details, fieldError(prefix+".titulo", "field_required", fieldLabel("titulo")))
+	}
+	if maxAno := time.Now().Year() + 1; ref.Ano != 0 && (ref.Ano < 1000 || ref.Ano > maxAno) {
+		details = append(details, fieldError(prefix+".ano", "referencia_ano", 1000, maxAno))
+	}
+	if ref.DOI != "" && !doiPattern.MatchString(ref.DOI) {
+		details = append(details, fieldError(prefix+".doi", "referencia_doi"))
+	}
+	if ref.ISBN != "" && !validISBN(ref.ISBN) {
+		details = append(details, fieldError(prefix+".isbn", "referencia_isbn"))
+	}
+	if ref.URL != "" {
+		if parsed, err := url.Parse(ref.URL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			details = append(details, fieldError(prefix+".url", "referencia_url"))
+		}
+	} else if ref.Tipo == refTipoSite {
+		details = append(details, fieldError(prefix+".url", "field_required", fieldLabel("url")))
+	}
+	if ref.AcessadoEm != "" {
+		if _, err := time.Parse("2006-01-02", ref.AcessadoEm); err != nil {
+			details = append(details, fieldError(prefix+".acessado_em", "referencia_data"))
+		}
+	}
+	return details
+}
+
+// validISBN confere o dígito verificador de um ISBN-10 ou ISBN-13 já normalizado
+func validISBN(isbn string) bool {
+	sum := 0
+	switch len(isbn) {
+	case 10:
+		for i, c := range isbn {
+			switch {
+			case c >= '0' && c <= '9':
+				sum += int(c-'0') * (10 - i)
+			case c == 'X' && i == 9:
+				sum += 10
+			default:
+				return false
+			}
+		}
+		return sum%11 == 0
+	case 13:
+		for i, c := range isbn {
+			if c < '0' || c > '9' {
+				return false
+			}
+			digit := int(c - '0')
+			if i%2 == 1 {
+				digit *= 3
+			}
+			sum += digit
+		}
+		return sum%10 == 0
+	}
+	return false
+}
+
+// referenciaKey identifica uma referência para descartar duplicatas: DOI, ISBN ou título e ano
+func referenciaKey(ref Referencia) string {
+	switch {
+	case ref.DOI != "":
+		return "doi:" + strings.ToLower(ref.DOI)
+	case ref.ISBN != "":
+		return "isbn:" + ref.ISBN
+	}
+	return fmt.Sprintf("titulo:%s:%d", strings.Join(strings.Fields(foldText(ref.Titulo)), " "), ref.Ano)
+}
+
+// mergeBibliografia acrescenta a current as referências de added que ainda não estão nela
+func mergeBibliografia(current, added []Referencia) ([]Referencia, int) {
+	seen := make(map[string]bool, len(current)+len(added))
+	merged := make([]Referencia, 0, len(current)+len(added))
+	for _, ref := range current {
+		seen[referenciaKey(ref)] = true
+		merged = append(merged, ref)
+	}
+	duplicates := 0
+	for _, ref := range added {
+		key := referenciaKey(ref)
+		if seen[key] {
+			duplicates++
+			continue
+		}
+		seen[key] = true
+		merged = append(merged, ref)
+	}
+	return merged, duplicates
+}
+
+// bibliografiaText é o texto da bibliografia indexado pela busca
+func bibliografiaText(list []Referencia) string {
+	var b strings.Builder
+	for _, ref := range list {
+		b.WriteString(strings.Join(ref.Autores, "; "))
+		b.WriteString("\n")
+		b.WriteString(ref.Titulo)
+		b.WriteString("\n")
+		if ref.Periodico != "" {
+			b.WriteString(ref.Periodico)
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// parseBibFormat lê ?format= de GET .../bibliografia (padrão: json)
+func parseBibFormat(r *http.Request) (string, []FieldError) {
+	format := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("format")))
+	if format == "" {
+		return bibFormatJSON, nil
+	}
+	if _, ok := bibFormatContentTypes[format]; !ok && format != bibFormatJSON {
+		return "", []FieldError{fieldError("format", "query_format", format)}
+	}
+	return format, nil
+}
+
+// writeBibliografia responde a bibliografia no formato pedido; filename é o nome sugerido para
+// os downloads em BibTeX e RIS
+func writeBibliografia(w http.ResponseWriter, r *http.Request, format, filename string, list []Referencia) {
+	var body string
+	switch format {
+	case bibFormatJSON:
+		writeSuccessResponse(w, r, "bibliografia_loaded", list)
+		return
+	case bibFormatBibTeX:
+		body = formatBibTeX(list)
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename + ".bib"}))
+	case bibFormatRIS:
+		body = formatRIS(list)
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename + ".ris"}))
+	case bibFormatABNT:
+		body = formatABNT(list, false)
+	case bibFormatABNTHTML:
+		body = formatABNT(list, true)
+	}
+	w.Header().Set("Content-Type", bibFormatContentTypes[format])
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	io.WriteString(w, body)
+}
+
+func getProvaBibliografiaHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	format, details := parseBibFormat(r)
+	if len(details) > 0 {
+		writeValidationError(w, r, details)
+		return
+	}
+
+	storeMu.RLock()
+	prova := findProvaTrabalho(tenantID, userID, id)
+	var list []Referencia
+	if prova != nil {
+		list = append([]Referencia{}, prova.Bibliografia...)
+	}
+	storeMu.RUnlock()
+	if prova == nil {
+		log.Printf("Tentativa de exportar bibliografia de prova/trabalho inexistente: ID %d, User %d", id, userID)
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "prova_not_found")
+		return
+	}
+
+	logUserAction(userID, "GET", fmt.Sprintf("bibliografia da prova-trabalho %d (%s)", id, format))
+	writeBibliografia(w, r, format, fmt.Sprintf("prova-trabalho-%d", id), list)
+}
+
+// getMateriaBibliografiaHandler reúne, sem duplicatas, a bibliografia das provas/trabalhos da matéria
+func getMateriaBibliografiaHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	format, details := parseBibFormat(r)
+	if len(details) > 0 {
+		writeValidationError(w, r, details)
+		return
+	}
+
+	storeMu.RLock()
+	materia := findMateria(tenantID, userID, id)
+	list := []Referencia{}
+	if materia != nil {
+		for _, prova := range provasByMateria(tenantID, userID, map[int]bool{id: true})[id] {
+			list, _ = mergeBibliografia(list, prova.Bibliografia)
+		}
+	}
+	storeMu.RUnlock()
+	if materia == nil {
+		log.Printf("Tentativa de exportar bibliografia de matéria inexistente: ID %d, User %d", id, userID)
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "materia_not_found")
+		return
+	}
+
+	logUserAction(userID, "GET", fmt.Sprintf("bibliografia da materia %d (%s)", id, format))
+	writeBibliografia(w, r, format, fmt.Sprintf("materia-%d", id), list)
+}
+
+// importBibliografiaHandler acrescenta à prova/trabalho as referências de um arquivo BibTeX ou
+// RIS, ignorando as que já estão na bibliografia; o formato vem de ?format= ou do Content-Type
+func importBibliografiaHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	format := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("format")))
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		format = bibImportMediaTypes[mediaType]
+	}
+	if format != bibFormatBibTeX && format != bibFormatRIS {
+		writeErrorResponse(w, r, http.StatusUnsupportedMediaType, "UNSUPPORTED_MEDIA_TYPE", "bibliografia_media_type")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBibliografiaBody))
+	if err != nil {
+		writeErrorResponse(w, r, http.StatusRequestEntityTooLarge, "PAYLOAD_TOO_LARGE", "bibliografia_too_large", maxBibliografiaBody)
+		return
+	}
+
+	var imported []Referencia
+	if format == bibFormatBibTeX {
+		imported, err = parseBibTeX(string(body))
+	} else {
+		imported, err = parseRIS(string(body))
+	}
+	if err != nil {
+		var syntax *bibSyntaxError
+		if errors.As(err, &syntax) {
+			writeErrorResponse(w, r, http.StatusBadRequest, "BAD_REQUEST", "bibliografia_syntax", syntax.line)
+			return
+		}
+		writeErrorResponse(w, r, http.StatusBadRequest, "BAD_REQUEST", "invalid_body")
+		return
+	}
+	if len(imported) == 0 {
+		writeErrorResponse(w, r, http.StatusBadRequest, "BAD_REQUEST", "bibliografia_empty")
+		return
+	}
+
+	var details []FieldError
+	for i, ref := range imported {
+		details = append(details, validateReferencia(fmt.Sprintf("entradas[%d]", i), ref)...)
+		imported[i] = normalizeReferencia(ref)
+	}
+	if len(details) > 0 {
+		writeValidationError(w, r, details)
+		return
+	}
+
+	storeMu.Lock()
+	prova := findProvaTrabalho(tenantID, userID, id)
+	if prova == nil {
+		storeMu.Unlock()
+		log.Printf("Tentativa de importar bibliografia em prova/trabalho inexistente: ID %d, User %d", id, userID)
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "prova_not_found")
+		return
+	}
+	merged, duplicates := mergeBibliografia(prova.Bibliografia, imported)
+	if len(merged) > maxReferenciasPerProva {
+		storeMu.Unlock()
+		writeErrorResponse(w, r, http.StatusConflict, "CONFLICT", "bibliografia_limit", maxReferenciasPerProva)
+		return
+	}
+	result := BibliografiaImport{
+		Importadas:   len(merged) - len(prova.Bibliografia),
+		Duplicadas:   duplicates,
+		Bibliografia: merged,
+	}
+	if result.Importadas > 0 {
+		prova.Bibliografia = merged
+		prova.ImpersonatedBy = impersonatorID(r)
+		prova.Version++
+		prova.UpdatedAt = time.Now()
+		searchIdx.indexProva(*prova)
+		publishEvent(tenantID, userID, eventProvaUpdated, *prova)
+	}
+	version := prova.Version
+	storeMu.Unlock()
+
+	logUserMutation(r, userID, "IMPORT", fmt.Sprintf("%d referência(s) %s na prova-trabalho %d (%d duplicadas)", result.Importadas, format, id, duplicates))
+	w.Header().Set("ETag", entityTag(version))
+	writeSuccessResponse(w, r, "bibliografia_imported", result)
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxAntecedencias      = 5
+	minAntecedenciaMinuto = 5
+	maxAntecedenciaMinuto = 30 * 24 * 60
+
+	// reminderWorkers é quantos envios de lembrete acontecem ao mesmo tempo; cada um tem até
+	// reminderNotifyTimeout, então um destino lento não segura os demais
+	reminderWorkers       = 8
+	reminderNotifyTimeout = 15 * time.Second
+)
+
+// ReminderSettings são as preferências de lembretes de entrega do usuário
+type ReminderSettings struct {
+	Ativo bool `json:"ativo"`
+	// Quanto tempo antes da entrega cada lembrete é enviado, em minutos
+	AntecedenciasMinutos []int    `json:"antecedencias_minutos"`
+	Canais               []string `json:"canais"`
+	WebhookURL           string   `json:"webhook_url,omitempty"`
+	FusoHorario          string   `json:"fuso_horario"`
+	// Email da conta e idioma (Accept-Language) de quem salvou as preferências
+	Email     string     `json:"email,omitempty"`
+	Idioma    string     `json:"idioma"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+type UpdateReminderSettingsRequest struct {
+	Ativo                bool     `json:"ativo"`
+	AntecedenciasMinutos []int    `json:"antecedencias_minutos"`
+	Canais               []string `json:"canais"`
+	WebhookURL           string   `json:"webhook_url"`
+	FusoHorario          string   `json:"fuso_horario"`
+}
+
+type userKey struct {
+	TenantID int
+	UserID   int
+}
+
+var (
+	// reminderSettings é protegido por storeMu; quem não salvou preferências usa defaultReminderSettings
+	reminderSettings = map[userKey]ReminderSettings{}
+	reminderInterval = time.Minute
+	reminders        = &reminderLog{sent: map[string]time.Time{}}
+
+	reminderDateLayouts = map[string]string{langPtBR: "02/01/2006 15:04", langEn: "Jan 2, 2006 3:04 PM"}
+	reminderDayLayouts  = map[string]string{langPtBR: "02/01/2006", langEn: "Jan 2, 2006"}
+)
+
+func defaultReminderSettings() ReminderSettings {
+	return ReminderSettings{
+		Ativo:                true,
+		AntecedenciasMinutos: []int{24 * 60, 60},
+		Canais:               []string{canalInApp},
+		FusoHorario:          defaultTimezone,
+		Idioma:               defaultLang,
+	}
+}
+
+// settingsFor retorna as preferências do usuário; storeMu deve estar travado
+func settingsFor(tenantID, userID int) ReminderSettings {
+	if settings, ok := reminderSettings[userKey{TenantID: tenantID, UserID: userID}]; ok {
+		return settings
+	}
+	return defaultReminderSettings()
+}
+
+func getReminderSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
+
+	storeMu.RLock()
+	settings := settingsFor(tenantID, userID)
+	storeMu.RUnlock()
+
+	logUserAction(userID, "GET", "preferências de lembretes")
+	writeSuccessResponse(w, r, "reminder_settings_loaded", settings)
+}
+
+func validateReminderSettings(ctx context.Context, req *UpdateReminderSettingsRequest, email string) []FieldError {
+	var details []FieldError
+
+	if len(req.AntecedenciasMinutos) == 0 || len(req.AntecedenciasMinutos) > maxAntecedencias {
+		details = append(details, fieldError("antecedencias_minutos", "reminder_leads", maxAntecedencias))
+	}
+	for i, minutes := range req.AntecedenciasMinutos {
+		if minutes < minAntecedenciaMinuto || minutes > maxAntecedenciaMinuto {
+			details = append(details, fieldError(fmt.Sprintf("antecedencias_minutos[%d]", i), "reminder_lead_range", minAntecedenciaMinuto, maxAntecedenciaMinuto))
+		}
+	}
+
+	if req.Ativo && len(req.Canais) == 0 {
+		details = append(details, fieldError("canais", "reminder_channels_required"))
+	}
+	for i, canal := range req.Canais {
+		if _, ok := notifiers[canal]; !ok {
+			details = append(details, fieldError(fmt.Sprintf("canais[%d]", i), "reminder_channel", canal))
+		}
+		if canal == canalEmail && email == "" {
+			details = append(details, fieldError(fmt.Sprintf("canais[%d]", i), "reminder_email_missing"))
+		}
+		if canal == canalWebhook {
+			if key := validateTargetURL(ctx, req.WebhookURL); key != "" {
+				details = append(details, fieldError("webhook_url", key))
+			}
+		}
+	}
+
+	if req.FusoHorario != "" {
+		if _, err := time.LoadLocation(req.FusoHorario); err != nil {
+			details = append(details, fieldError("fuso_horario", "timezone_unknown", req.FusoHorario))
+		}
+	}
+	return details
+}
+
+// updateReminderSettingsHandler substitui as preferências de lembretes do usuário
+func updateReminderSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
+
+	var req UpdateReminderSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, r, http.StatusBadRequest, "BAD_REQUEST", "invalid_body")
+		return
+	}
+	if details := validateReminderSettings(r.Context(), &req, principal.Email); len(details) > 0 {
+		writeValidationError(w, r, details)
+		return
+	}
+
+	// Antecedências da maior para a menor, sem repetições
+	sort.Sort(sort.Reverse(sort.IntSlice(req.AntecedenciasMinutos)))
+	leads := req.AntecedenciasMinutos[:1]
+	for _, minutes := range req.AntecedenciasMinutos[1:] {
+		if minutes != leads[len(leads)-1] {
+			leads = append(leads, minutes)
+		}
+	}
+	canais := []string{}
+	for _, canal := range req.Canais {
+		if !containsString(canais, canal) {
+			canais = append(canais, canal)
+		}
+	}
+
+	now := time.Now()
+	settings := ReminderSettings{
+		Ativo:                req.Ativo,
+		AntecedenciasMinutos: leads,
+		Canais:               canais,
+		FusoHorario:          req.FusoHorario,
+		Email:                principal.Email,
+		Idioma:               preferredLanguage(r),
+		UpdatedAt:            &now,
+	}
+	if containsString(canais, canalWebhook) {
+		settings.WebhookURL = req.WebhookURL
+	}
+	if settings.FusoHorario == "" {
+		settings.FusoHorario = defaultTimezone
+	}
+
+	storeMu.Lock()
+	reminderSettings[userKey{TenantID: tenantID, UserID: userID}] = settings
+	storeMu.Unlock()
+
+	logUserMutation(r, userID, "PUT", fmt.Sprintf("preferências de lembretes (%s)", strings.Join(canais, ", ")))
+	writeSuccessResponse(w, r, "reminder_settings_updated", settings)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// reminderLog registra os lembretes já disparados, em memória e em um arquivo, para que um
+// reinício do serviço não os repita
+type reminderLog struct {
+	mu   sync.Mutex
+	path string
+	// chave do lembrete -> data de entrega, usada para descartar registros antigos
+	sent map[string]time.Time
+}
+
+// openReminderLog carrega o registro de path, descartando os lembretes de entregas que já
+// passaram; path vazio mantém o registro só em memória
+func openReminderLog(path string, now time.Time) (*reminderLog, error) {
+	l := &reminderLog{path: path, sent: map[string]time.Time{}}
+	if path == "" {
+		return l, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var kept strings.Builder
+	for _, line := range strings.Split(string(data), "\n") {
+		key, raw, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		due, err := time.Parse(time.RFC3339, raw)
+		if err != nil || !due.After(now) {
+			continue
+		}
+		l.sent[key] = due
+		kept.WriteString(line + "\n")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(kept.String()), 0o600); err != nil {
+		return nil, err
+	}
+	return l, os.Rename(tmp, path)
+}
+
+func (l *reminderLog) wasSent(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.sent[key]
+	return ok
+}
+
+// markSent grava as chaves no arquivo antes de marcá-las; se a gravação falha, nada é marcado
+func (l *reminderLog) markSent(keys []string, due time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var lines strings.Builder
+	for _, key := range keys {
+		if _, ok := l.sent[key]; !ok {
+			fmt.Fprintf(&lines, "%s %s\n", key, due.UTC().Format(time.RFC3339))
+		}
+	}
+	if l.path != "" && lines.Len() > 0 {
+		f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+		if _, err := f.WriteString(lines.String()); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	for _, key := range keys {
+		l.sent[key] = due
+	}
+	return nil
+}
+
+// reminderKey identifica um lembrete; inclui a data de entrega para que uma prova/trabalho
+// remarcada receba novos lembretes
+func reminderKey(prova ProvaTrabalho, minutes int) string {
+	return fmt.Sprintf("%d/%d/%d/%d/%s", prova.TenantID, prova.UserID, prova.ID, minutes, prova.DataEntrega.UTC().Format(time.RFC3339))
+}
+
+// runReminderScheduler verifica, a cada intervalo, as entregas próximas e dispara os
+// lembretes devidos; um intervalo 0 desliga os lembretes
+func runReminderScheduler(interval time.Duration) {
+	if interval <= 0 {
+		log.Printf("Lembretes de entrega desativados")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		dispatchReminders(time.Now())
+	}
+}
+
+type reminderJob struct {
+	settings ReminderSettings
+	reminder Reminder
+	keys     []string
+}
+
+// dispatchReminders envia os lembretes cuja antecedência já foi alcançada. Cada lembrete é
+// registrado antes do envio (no máximo uma entrega, mesmo se o serviço cair no meio); os
+// envios correm em paralelo, até reminderWorkers por vez, e a rodada espera todos terminarem.
+func dispatchReminders(now time.Time) {
+	var jobs []reminderJob
+
+	storeMu.RLock()
+	for _, prova := range provasTrabalhos {
+		if prova.DeletedAt != nil || prova.DataEntrega == nil || !prova.DataEntrega.After(now) {
+			continue
+		}
+		settings := settingsFor(prova.TenantID, prova.UserID)
+		if !settings.Ativo {
+			continue
+		}
+
+		// Só o lembrete da menor antecedência alcançada é enviado; os maiores que ficaram para
+		// trás (ex.: prova cadastrada na véspera) são dados como enviados
+		var keys []string
+		lead := 0
+		for _, minutes := range settings.AntecedenciasMinutos {
+			if prova.DataEntrega.Add(-time.Duration(minutes) * time.Minute).After(now) {
+				continue
+			}
+			keys = append(keys, reminderKey(prova, minutes))
+			lead = minutes
+		}
+		if len(keys) == 0 || reminders.wasSent(keys[len(keys)-1]) {
+			continue
+		}
+
+		materia := ""
+		if m := findMateria(prova.TenantID, prova.UserID, prova.MateriaID); m != nil {
+			materia = m.Nome
+		}
+		jobs = append(jobs, reminderJob{
+			settings: settings,
+			reminder: buildReminder(prova, materia, settings, lead, now),
+			keys:     keys,
+		})
+	}
+	storeMu.RUnlock()
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, reminderWorkers)
+	for _, job := range jobs {
+		reminder := job.reminder
+		if err := reminders.markSent(job.keys, reminder.DataEntrega); err != nil {
+			log.Printf("Erro ao registrar lembrete da prova/trabalho %d, User %d: %v", reminder.ProvaID, reminder.UserID, err)
+			continue
+		}
+		publishEvent(reminder.TenantID, reminder.UserID, eventProvaDueSoon, reminder)
+		for _, canal := range job.settings.Canais {
+			notifier, ok := notifiers[canal]
+			if !ok {
+				continue
+			}
+			wg.Add(1)
+			slots <- struct{}{}
+			go func(canal string, notifier Notifier, settings ReminderSettings) {
+				defer func() {
+					<-slots
+					wg.Done()
+				}()
+				ctx, cancel := context.WithTimeout(context.Background(), reminderNotifyTimeout)
+				defer cancel()
+				if err := notifier.Notify(ctx, settings, reminder); err != nil {
+					reminderDeliveries[canal].failed.Add(1)
+					log.Printf("Falha ao enviar lembrete por %s: Prova %d, User %d: %v", canal, reminder.ProvaID, reminder.UserID, err)
+					return
+				}
+				reminderDeliveries[canal].sent.Add(1)
+			}(canal, notifier, job.settings)
+		}
+		logUserAction(reminder.UserID, "REMIND", fmt.Sprintf("prova/trabalho %d (%d min antes)", reminder.ProvaID, reminder.AntecedenciaMinutos))
+	}
+	wg.Wait()
+}
+
+// buildReminder monta o lembrete no idioma e fuso do usuário
+func buildReminder(prova ProvaTrabalho, materia string, settings ReminderSettings, lead int, now time.Time) Reminder {
+	lang := settings.Idioma
+	if _, ok := reminderDateLayouts[lang]; !ok {
+		lang = defaultLang
+	}
+	loc, err := time.LoadLocation(settings.FusoHorario)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	// Datas sem horário (meia-noite UTC, como o frontend envia) são mostradas só como dia
+	due := prova.DataEntrega.UTC()
+	when := due.In(loc).Format(reminderDateLayouts[lang])
+	if due.Hour() == 0 && due.Minute() == 0 && due.Second() == 0 {
+		when = due.Format(reminderDayLayouts[lang])
+	}
+	if materia == "" {
+		materia = "-"
+	}
+
+	return Reminder{
+		ProvaID:             prova.ID,
+		Titulo:              prova.Titulo,
+		MateriaID:           prova.MateriaID,
+		Materia:             materia,
+		DataEntrega:         *prova.DataEntrega,
+		AntecedenciaMinutos: lead,
+		Assunto:             localizeLang(lang, "reminder_subject", prova.Titulo),
+		Mensagem:            localizeLang(lang, "reminder_body", prova.Titulo, materia, when, formatRemaining(lang, prova.DataEntrega.Sub(now))),
+		UserID:              prova.UserID,
+		TenantID:            prova.TenantID,
+	}
+}
+
+// formatRemaining descreve o tempo até a entrega em dias, horas ou minutos
+func formatRemaining(lang string, d time.Duration) string {
+	minutes := int(math.Round(d.Minutes()))
+	switch {
+	case minutes >= 24*60-30:
+		return plural(lang, (minutes+12*60)/(24*60), "reminder_day")
+	case minutes >= 60-5:
+		return plural(lang, (minutes+30)/60, "reminder_hour")
+	default:
+		return plural(lang, minutes, "reminder_minute")
+	}
+}
+
+func plural(lang string, n int, key string) string {
+	if n == 1 {
+		return localizeLang(lang, key, n)
+	}
+	return localizeLang(lang, key+"s", n)
+}
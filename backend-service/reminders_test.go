@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowNotifier demora em cada envio e registra quantos envios ocorrem ao mesmo tempo
+type slowNotifier struct {
+	delay         time.Duration
+	running, peak atomic.Int32
+	calls         atomic.Int32
+}
+
+func (n *slowNotifier) Notify(ctx context.Context, settings ReminderSettings, reminder Reminder) error {
+	n.calls.Add(1)
+	current := n.running.Add(1)
+	defer n.running.Add(-1)
+	for {
+		peak := n.peak.Load()
+		if current <= peak || n.peak.CompareAndSwap(peak, current) {
+			break
+		}
+	}
+	select {
+	case <-time.After(n.delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestDispatchRemindersRunsNotifiersConcurrently(t *testing.T) {
+	const canal = "lento"
+	const total = reminderWorkers + 4
+	slow := &slowNotifier{delay: 200 * time.Millisecond}
+	notifiers[canal] = slow
+	reminderDeliveries[canal] = &struct{ sent, failed atomic.Int64 }{}
+	t.Cleanup(func() {
+		delete(notifiers, canal)
+		delete(reminderDeliveries, canal)
+	})
+
+	user := userKey{TenantID: 1, UserID: 931}
+	now := time.Now()
+	due := now.Add(30 * time.Minute)
+	storeMu.Lock()
+	reminderSettings[user] = ReminderSettings{Ativo: true, AntecedenciasMinutos: []int{60}, Canais: []string{canal}}
+	for i := 0; i < total; i++ {
+		provasTrabalhos = append(provasTrabalhos, ProvaTrabalho{ID: nextProvaID, Titulo: "Lembrete", DataEntrega: &due, UserID: user.UserID, TenantID: user.TenantID})
+		nextProvaID++
+	}
+	storeMu.Unlock()
+
+	start := time.Now()
+	dispatchReminders(now)
+	elapsed := time.Since(start)
+
+	if calls := slow.calls.Load(); calls != total {
+		t.Fatalf("%d envios; esperado %d", calls, total)
+	}
+	if peak := slow.peak.Load(); peak < 2 || peak > reminderWorkers {
+		t.Fatalf("pico de %d envios simultâneos; esperado entre 2 e %d", peak, reminderWorkers)
+	}
+	if serial := time.Duration(total) * slow.delay; elapsed >= serial/2 {
+		t.Fatalf("rodada levou %v; em série seriam %v", elapsed, serial)
+	}
+	if sent := reminderDeliveries[canal].sent.Load(); sent != total {
+		t.Fatalf("%d lembretes contados como enviados; esperado %d", sent, total)
+	}
+}
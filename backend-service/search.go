@@ -0,0 +1,448 @@
+package main
+
+import (
+	"html"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	searchKindMateria = "materia"
+	searchKindProva   = "prova_trabalho"
+
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+	snippetRadius      = 80
+
+	// Parâmetros do BM25
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// stopwords do português que não são indexadas
+var stopwords = map[string]bool{
+	"a": true, "ao": true, "aos": true, "as": true, "com": true, "como": true, "da": true, "das": true,
+	"de": true, "do": true, "dos": true, "e": true, "em": true, "entre": true, "na": true, "nas": true,
+	"no": true, "nos": true, "o": true, "os": true, "ou": true, "para": true, "pela": true, "pelas": true,
+	"pelo": true, "pelos": true, "por": true, "que": true, "se": true, "sem": true, "sobre": true,
+	"um": true, "uma": true, "umas": true, "uns": true,
+}
+
+var accentFolding = map[rune]rune{
+	'á': 'a', 'à': 'a', 'â': 'a', 'ã': 'a', 'ä': 'a',
+	'é': 'e', 'è': 'e', 'ê': 'e', 'ë': 'e',
+	'í': 'i', 'ì': 'i', 'î': 'i', 'ï': 'i',
+	'ó': 'o', 'ò': 'o', 'ô': 'o', 'õ': 'o', 'ö': 'o',
+	'ú': 'u', 'ù': 'u', 'û': 'u', 'ü': 'u',
+	'ç': 'c', 'ñ': 'n',
+}
+
+// token é um termo do texto com sua posição (em bytes) no texto original
+type token struct {
+	term       string
+	start, end int
+}
+
+// tokenize separa o texto em palavras, aplicando minúsculas, remoção de acentos,
+// stopwords e stemming
+func tokenize(text string) []token {
+	var tokens []token
+	var word strings.Builder
+	start := -1
+
+	flush := func(end int) {
+		if start < 0 {
+			return
+		}
+		raw := word.String()
+		word.Reset()
+		if !stopwords[raw] {
+			tokens = append(tokens, token{term: stemPortuguese(raw), start: start, end: end})
+		}
+		start = -1
+	}
+
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			r = unicode.ToLower(r)
+			if folded, ok := accentFolding[r]; ok {
+				r = folded
+			}
+			word.WriteRune(r)
+			continue
+		}
+		flush(i)
+	}
+	flush(len(text))
+	return tokens
+}
+
+// stemPortuguese é um stemmer leve para o português (já sem acentos): remove plural,
+// advérbio em -mente, diminutivos/aumentativos, sufixos nominais (inclusive o adjetivo
+// em -al) e verbais comuns e a vogal temática final. Não é um stemmer completo, mas leva
+// "integração", "integrar", "integral" e "integrais" ao mesmo termo.
+func stemPortuguese(word string) string {
+	if len(word) < 4 || !isASCIIWord(word) {
+		return word
+	}
+
+	replaceSuffix := func(suffix, replacement string, minStem int) bool {
+		if strings.HasSuffix(word, suffix) && len(word)-len(suffix) >= minStem {
+			word = word[:len(word)-len(suffix)] + replacement
+			return true
+		}
+		return false
+	}
+
+	// Plural
+	switch {
+	case replaceSuffix("oes", "ao", 2), replaceSuffix("aes", "ao", 2), replaceSuffix("ais", "al", 2),
+		replaceSuffix("eis", "el", 2), replaceSuffix("ois", "ol", 2), replaceSuffix("ns", "m", 2),
+		replaceSuffix("res", "r", 3), replaceSuffix("les", "l", 3), replaceSuffix("zes", "z", 3):
+	case strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") && !strings.HasSuffix(word, "us") && !strings.HasSuffix(word, "is"):
+		replaceSuffix("s", "", 3)
+	}
+
+	// Advérbio, grau e sufixos nominais/verbais: no máximo um de cada grupo
+	replaceSuffix("mente", "", 4)
+	for _, suffix := range []string{"issimo", "issima", "zinho", "zinha", "inho", "inha", "zao", "ona"} {
+		if replaceSuffix(suffix, "", 3) {
+			break
+		}
+	}
+	for _, suffix := range []string{
+		"amento", "imento", "mento", "acao", "icao", "cao", "idade", "ismo", "ista", "avel", "ivel",
+		"ando", "endo", "indo", "ado", "ada", "ido", "ida", "aram", "eram", "iram", "al", "ar", "er", "ir",
+	} {
+		if replaceSuffix(suffix, "", 3) {
+			break
+		}
+	}
+
+	// Vogal temática / gênero
+	for _, suffix := range []string{"a", "o", "e"} {
+		if replaceSuffix(suffix, "", 3) {
+			break
+		}
+	}
+	return word
+}
+
+func isASCIIWord(word string) bool {
+	for i := 0; i < len(word); i++ {
+		if word[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+type searchDocRef struct {
+	kind string
+	id   int
+}
+
+type searchField struct {
+	name   string
+	text   string
+	weight float64
+}
+
+type searchDoc struct {
+	tenantID int
+	userID   int
+	title    string
+	// materiaID é a matéria da prova/trabalho (0 para matérias)
+	materiaID int
+	fields    []searchField
+	terms     map[string]float64
+	length    float64
+}
+
+// searchIndex é um índice invertido dos textos de matérias e provas/trabalhos. É
+// atualizado pelos handlers sob storeMu, para refletir a mesma ordem das escritas.
+type searchIndex struct {
+	mu          sync.RWMutex
+	postings    map[string]map[searchDocRef]float64
+	docs        map[searchDocRef]*searchDoc
+	totalLength float64
+}
+
+var searchIdx = newSearchIndex()
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: make(map[string]map[searchDocRef]float64),
+		docs:     make(map[searchDocRef]*searchDoc),
+	}
+}
+
+func (idx *searchIndex) indexMateria(m Materia) {
+	idx.put(searchDocRef{searchKindMateria, m.ID}, &searchDoc{
+		tenantID: m.TenantID,
+		userID:   m.UserID,
+		title:    m.Nome,
+		fields: []searchField{
+			{name: "nome", text: m.Nome, weight: 3},
+			{name: "descricao", text: m.Descricao, weight: 1},
+		},
+	})
+}
+
+func (idx *searchIndex) indexProva(p ProvaTrabalho) {
+	idx.put(searchDocRef{searchKindProva, p.ID}, &searchDoc{
+		tenantID:  p.TenantID,
+		userID:    p.UserID,
+		title:     p.Titulo,
+		materiaID: p.MateriaID,
+		fields: []searchField{
+			{name: "titulo", text: p.Titulo, weight: 3},
+			{name: "conteudos_estudo", text: p.ConteudosEstudo, weight: 1},
+			{name: "referencias", text: strings.Join(p.Referencias, "\n"), weight: 1},
+			{name: "bibliografia", text: bibliografiaText(p.Bibliografia), weight: 1},
+		},
+	})
+}
+
+func (idx *searchIndex) put(ref searchDocRef, doc *searchDoc) {
+	doc.terms = make(map[string]float64)
+	for _, field := range doc.fields {
+		for _, tok := range tokenize(field.text) {
+			doc.terms[tok.term] += field.weight
+			doc.length += field.weight
+		}
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.removeLocked(ref)
+	for term, tf := range doc.terms {
+		if idx.postings[term] == nil {
+			idx.postings[term] = make(map[searchDocRef]float64)
+		}
+		idx.postings[term][ref] = tf
+	}
+	idx.docs[ref] = doc
+	idx.totalLength += doc.length
+}
+
+func (idx *searchIndex) remove(kind string, id int) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.removeLocked(searchDocRef{kind, id})
+}
+
+func (idx *searchIndex) removeLocked(ref searchDocRef) {
+	doc, ok := idx.docs[ref]
+	if !ok {
+		return
+	}
+	for term := range doc.terms {
+		delete(idx.postings[term], ref)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+	idx.totalLength -= doc.length
+	delete(idx.docs, ref)
+}
+
+// SearchResult é um item encontrado pela busca
+type SearchResult struct {
+	Type      string  `json:"type"`
+	ID        int     `json:"id"`
+	Title     string  `json:"title"`
+	MateriaID int     `json:"materia_id,omitempty"`
+	Score     float64 `json:"score"`
+	Field     string  `json:"field"`
+	// Trecho do texto com os termos encontrados entre <mark> e </mark>; o restante é escapado como HTML
+	Snippet string `json:"snippet"`
+}
+
+// search retorna os documentos do usuário que contêm os termos da consulta, ordenados
+// primeiro pela quantidade de termos encontrados e depois pela pontuação BM25
+func (idx *searchIndex) search(tenantID, userID int, query string, limit int) []SearchResult {
+	terms := map[string]bool{}
+	for _, tok := range tokenize(query) {
+		terms[tok.term] = true
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	type match struct {
+		ref     searchDocRef
+		doc     *searchDoc
+		score   float64
+		matched int
+	}
+	matches := map[searchDocRef]*match{}
+
+	totalDocs := float64(len(idx.docs))
+	avgLength := 1.0
+	if totalDocs > 0 && idx.totalLength > 0 {
+		avgLength = idx.totalLength / totalDocs
+	}
+
+	for term := range terms {
+		postings := idx.postings[term]
+		idf := math.Log(1 + (totalDocs-float64(len(postings))+0.5)/(float64(len(postings))+0.5))
+		for ref, tf := range postings {
+			doc := idx.docs[ref]
+			if doc.tenantID != tenantID || doc.userID != userID {
+				continue
+			}
+			m := matches[ref]
+			if m == nil {
+				m = &match{ref: ref, doc: doc}
+				matches[ref] = m
+			}
+			m.matched++
+			m.score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*doc.length/avgLength))
+		}
+	}
+
+	ranked := make([]*match, 0, len(matches))
+	for _, m := range matches {
+		ranked = append(ranked, m)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].matched != ranked[j].matched {
+			return ranked[i].matched > ranked[j].matched
+		}
+		if ranked[i].score != ranked[j].score {
+			return ranked[i].score > ranked[j].score
+		}
+		if ranked[i].ref.kind != ranked[j].ref.kind {
+			return ranked[i].ref.kind < ranked[j].ref.kind
+		}
+		return ranked[i].ref.id < ranked[j].ref.id
+	})
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+
+	results := make([]SearchResult, 0, len(ranked))
+	for _, m := range ranked {
+		field, snippet := bestSnippet(m.doc, terms)
+		results = append(results, SearchResult{
+			Type:      m.ref.kind,
+			ID:        m.ref.id,
+			Title:     m.doc.title,
+			MateriaID: m.doc.materiaID,
+			Score:     math.Round(m.score*1000) / 1000,
+			Field:     field,
+			Snippet:   snippet,
+		})
+	}
+	return results
+}
+
+// bestSnippet escolhe o campo com mais ocorrências dos termos e recorta um trecho em volta
+// da primeira delas, destacando todas as ocorrências do trecho
+func bestSnippet(doc *searchDoc, terms map[string]bool) (string, string) {
+	bestField, bestHits := doc.fields[0], 0
+	var bestTokens []token
+	for _, field := range doc.fields {
+		var hits []token
+		for _, tok := range tokenize(field.text) {
+			if terms[tok.term] {
+				hits = append(hits, tok)
+			}
+		}
+		if len(hits) > bestHits {
+			bestField, bestHits, bestTokens = field, len(hits), hits
+		}
+	}
+
+	text := bestField.text
+	if bestHits == 0 {
+		return bestField.name, html.EscapeString(truncateRunes(text, 2*snippetRadius))
+	}
+
+	start := runeBoundary(text, bestTokens[0].start-snippetRadius)
+	end := runeBoundary(text, bestTokens[0].end+snippetRadius)
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	pos := start
+	for _, tok := range bestTokens {
+		if tok.start < pos || tok.end > end {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[pos:tok.start]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(text[tok.start:tok.end]))
+		b.WriteString("</mark>")
+		pos = tok.end
+	}
+	b.WriteString(html.EscapeString(text[pos:end]))
+	if end < len(text) {
+		b.WriteString("…")
+	}
+	return bestField.name, b.String()
+}
+
+// runeBoundary limita a posição ao texto e a recua até o início de um caractere UTF-8
+func runeBoundary(text string, pos int) int {
+	if pos <= 0 {
+		return 0
+	}
+	if pos >= len(text) {
+		return len(text)
+	}
+	for pos > 0 && !utf8.RuneStart(text[pos]) {
+		pos--
+	}
+	return pos
+}
+
+func truncateRunes(text string, max int) string {
+	if utf8.RuneCountInString(text) <= max {
+		return text
+	}
+	return string([]rune(text)[:max]) + "…"
+}
+
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	limit := defaultSearchLimit
+	var details []FieldError
+	if query == "" {
+		details = append(details, fieldError("q", "field_required", fieldLabel("q")))
+	}
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxSearchLimit {
+			details = append(details, fieldError("limit", "query_limit_range", maxSearchLimit))
+		} else {
+			limit = parsed
+		}
+	}
+	if len(details) > 0 {
+		writeValidationError(w, r, details)
+		return
+	}
+
+	results := searchIdx.search(principal.TenantID, principal.UserID, query, limit)
+
+	logUserAction(principal.UserID, "SEARCH", strconv.Quote(query))
+	writeSuccessResponse(w, r, "search_done", results)
+}
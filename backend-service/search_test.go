@@ -0,0 +1,113 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStemPortuguese(t *testing.T) {
+	groups := [][]string{
+		{"integração", "integrações", "integrar", "integral", "integrais"},
+		{"derivada", "derivadas", "derivar"},
+		{"função", "funções"},
+		{"cálculo", "cálculos"},
+		{"rapidamente", "rápida", "rápido"},
+	}
+	for _, group := range groups {
+		want := tokenize(group[0])[0].term
+		for _, word := range group[1:] {
+			if got := tokenize(word)[0].term; got != want {
+				t.Errorf("radical de %q = %q; esperado %q, o mesmo de %q", word, got, want, group[0])
+			}
+		}
+	}
+
+	tests := []struct {
+		word, want string
+	}{
+		{"integracao", "integr"},
+		{"integrais", "integr"},
+		{"funcoes", "fun"},
+		{"ssl", "ssl"},
+		{"mol", "mol"},
+		{"atlas", "atl"},
+		{"naïve", "naïve"},
+	}
+	for _, tt := range tests {
+		if got := stemPortuguese(tt.word); got != tt.want {
+			t.Errorf("stemPortuguese(%q) = %q; esperado %q", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	text := "Cálculo de Integrais, por partes"
+	got := tokenize(text)
+	want := []token{
+		{term: "calcul", start: 0, end: len("Cálculo")},
+		{term: "integr", start: strings.Index(text, "Integrais"), end: strings.Index(text, ",")},
+		{term: "part", start: strings.Index(text, "partes"), end: len(text)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("tokenize(%q) = %+v; esperado %+v", text, got, want)
+	}
+	if got := tokenize("de para, o - e"); len(got) != 0 {
+		t.Fatalf("só stopwords geraram tokens: %+v", got)
+	}
+}
+
+func TestSearchRankingAndTenantIsolation(t *testing.T) {
+	idx := newSearchIndex()
+	idx.indexMateria(Materia{ID: 1, TenantID: 1, UserID: 10, Nome: "Cálculo Integral", Descricao: "Limites e derivadas"})
+	idx.indexMateria(Materia{ID: 2, TenantID: 1, UserID: 10, Nome: "Física", Descricao: "Aplicações de integrais e derivadas em mecânica"})
+	idx.indexProva(ProvaTrabalho{ID: 3, TenantID: 1, UserID: 10, MateriaID: 1, Titulo: "Prova de integração por partes", ConteudosEstudo: "Integral por partes e substituição"})
+	// Mesmo usuário em outra instituição e outro usuário na mesma instituição
+	idx.indexMateria(Materia{ID: 4, TenantID: 2, UserID: 10, Nome: "Integral dupla"})
+	idx.indexMateria(Materia{ID: 5, TenantID: 1, UserID: 11, Nome: "Integral tripla"})
+
+	results := idx.search(1, 10, "integração por partes", 10)
+	var got []int
+	for _, r := range results {
+		got = append(got, r.ID)
+	}
+	// A prova tem os dois termos; entre as matérias, o termo no nome pesa mais que na descrição
+	if want := []int{3, 1, 2}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ids encontrados = %v; esperado %v", got, want)
+	}
+	if results[0].Type != searchKindProva || results[0].MateriaID != 1 || results[0].Field != "titulo" {
+		t.Fatalf("primeiro resultado = %+v", results[0])
+	}
+	if !strings.Contains(results[0].Snippet, "Prova de <mark>integração</mark> por <mark>partes</mark>") {
+		t.Fatalf("snippet = %q", results[0].Snippet)
+	}
+
+	if results := idx.search(1, 10, "integral", 1); len(results) != 1 {
+		t.Fatalf("limit 1 retornou %d resultados", len(results))
+	}
+	if results := idx.search(2, 10, "integral", 10); len(results) != 1 || results[0].ID != 4 {
+		t.Fatalf("busca na instituição 2 = %+v; esperado só a matéria 4", results)
+	}
+
+	// Removido do índice, o documento deixa de aparecer e não sobra termo órfão
+	idx.remove(searchKindMateria, 4)
+	if results := idx.search(2, 10, "integral", 10); len(results) != 0 {
+		t.Fatalf("busca após a remoção = %+v", results)
+	}
+	if _, ok := idx.postings["dupl"]; ok {
+		t.Fatal("termo da matéria removida continua no índice")
+	}
+}
+
+func TestSearchSnippetEscapesHTML(t *testing.T) {
+	idx := newSearchIndex()
+	idx.indexMateria(Materia{ID: 1, TenantID: 1, UserID: 1, Nome: "Álgebra", Descricao: "<script>matrizes</script> & vetores"})
+
+	results := idx.search(1, 1, "matriz", 10)
+	if len(results) != 1 {
+		t.Fatalf("resultados = %+v", results)
+	}
+	if want := "&lt;script&gt;<mark>matrizes</mark>&lt;/script&gt; &amp; vetores"; results[0].Snippet != want {
+		t.Fatalf("snippet = %q; esperado %q", results[0].Snippet, want)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentCreates(t *testing.T) {
+	alice := Principal{UserID: 901, TenantID: 1, Email: "alice@exemplo.com"}
+	bob := Principal{UserID: 902, TenantID: 2, Email: "bob@exemplo.com"}
+	const perUser = 25
+
+	// Cada usuário cria matérias distintas e todos disputam o mesmo nome
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := map[int]Principal{}
+	duplicates := map[int]int{}
+	for _, principal := range []Principal{alice, bob} {
+		for i := 0; i < perUser; i++ {
+			wg.Add(2)
+			go func(principal Principal, i int) {
+				defer wg.Done()
+				rec := doRequest(t, createMateriaHandler, http.MethodPost, "/materias", CreateMateriaRequest{Nome: fmt.Sprintf("Matéria %d", i), Descricao: "Criada em paralelo"}, principal)
+				if rec.Code != http.StatusOK {
+					t.Errorf("criar matéria %d do user %d: status %d: %s", i, principal.UserID, rec.Code, rec.Body.String())
+					return
+				}
+				var materia Materia
+				decodeData(t, rec, &materia)
+				mu.Lock()
+				defer mu.Unlock()
+				if _, dup := created[materia.ID]; dup {
+					t.Errorf("ID de matéria %d repetido", materia.ID)
+				}
+				created[materia.ID] = principal
+			}(principal, i)
+			go func(principal Principal) {
+				defer wg.Done()
+				rec := doRequest(t, createMateriaHandler, http.MethodPost, "/materias", CreateMateriaRequest{Nome: "Disputada", Descricao: "Mesmo nome em paralelo"}, principal)
+				if rec.Code == http.StatusOK {
+					mu.Lock()
+					duplicates[principal.UserID]++
+					mu.Unlock()
+				} else if rec.Code != http.StatusConflict {
+					t.Errorf("matéria disputada do user %d: status %d", principal.UserID, rec.Code)
+				}
+			}(principal)
+		}
+	}
+	wg.Wait()
+
+	for _, principal := range []Principal{alice, bob} {
+		if duplicates[principal.UserID] != 1 {
+			t.Errorf("matéria disputada criada %d vezes pelo user %d; esperado 1", duplicates[principal.UserID], principal.UserID)
+		}
+	}
+
+	// Provas de matérias distintas criadas em paralelo
+	var materiaID int
+	for id, principal := range created {
+		if principal.UserID == alice.UserID {
+			materiaID = id
+			break
+		}
+	}
+	if materiaID == 0 {
+		t.Fatal("nenhuma matéria criada")
+	}
+	provaIDs := map[int]bool{}
+	for i := 0; i < perUser; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := CreateProvaTrabalhoRequest{Titulo: fmt.Sprintf("Prova %d", i), ConteudosEstudo: "Capítulos 1 a 3", MateriaID: materiaID}
+			rec := doRequest(t, createProvaTrabalhoHandler, http.MethodPost, "/provas-trabalhos", req, alice)
+			if rec.Code != http.StatusOK {
+				t.Errorf("criar prova %d: status %d: %s", i, rec.Code, rec.Body.String())
+				return
+			}
+			var prova ProvaTrabalho
+			decodeData(t, rec, &prova)
+			mu.Lock()
+			defer mu.Unlock()
+			if provaIDs[prova.ID] {
+				t.Errorf("ID de prova %d repetido", prova.ID)
+			}
+			provaIDs[prova.ID] = true
+		}(i)
+	}
+	wg.Wait()
+
+	// O store e os contadores precisam refletir exatamente as criações bem-sucedidas
+	storeMu.RLock()
+	defer storeMu.RUnlock()
+	counts := map[int]int{}
+	seen := map[int]bool{}
+	for _, materia := range materias {
+		if seen[materia.ID] {
+			t.Errorf("matéria %d duplicada no store", materia.ID)
+		}
+		seen[materia.ID] = true
+		if materia.ID >= nextMateriaID {
+			t.Errorf("matéria %d não está abaixo de nextMateriaID=%d", materia.ID, nextMateriaID)
+		}
+		counts[materia.UserID]++
+	}
+	for _, principal := range []Principal{alice, bob} {
+		if counts[principal.UserID] != perUser+1 {
+			t.Errorf("user %d tem %d matérias; esperado %d", principal.UserID, counts[principal.UserID], perUser+1)
+		}
+	}
+	provas := 0
+	for _, prova := range provasTrabalhos {
+		if prova.MateriaID == materiaID {
+			provas++
+		}
+		if prova.ID >= nextProvaID {
+			t.Errorf("prova %d não está abaixo de nextProvaID=%d", prova.ID, nextProvaID)
+		}
+	}
+	if provas != perUser || len(provaIDs) != perUser {
+		t.Errorf("matéria %d tem %d provas (%d IDs); esperado %d", materiaID, provas, len(provaIDs), perUser)
+	}
+}
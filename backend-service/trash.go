@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	trashTypeMaterias = "materias"
+	trashTypeProvas   = "provas-trabalhos"
+)
+
+var (
+	// trashRetention é por quanto tempo um item excluído pode ser restaurado antes do expurgo
+	trashRetention     = 30 * 24 * time.Hour
+	trashPurgeInterval = time.Hour
+)
+
+// TrashedMateria é uma matéria na lixeira, com o momento previsto para o expurgo
+type TrashedMateria struct {
+	Materia
+	PurgeAt time.Time `json:"purge_at"`
+}
+
+// TrashedProvaTrabalho é uma prova/trabalho na lixeira, com o momento previsto para o expurgo
+type TrashedProvaTrabalho struct {
+	ProvaTrabalho
+	PurgeAt time.Time `json:"purge_at"`
+}
+
+// TrashContents lista os itens excluídos do usuário, do mais recente ao mais antigo
+type TrashContents struct {
+	Materias        []TrashedMateria       `json:"materias"`
+	ProvasTrabalhos []TrashedProvaTrabalho `json:"provas_trabalhos"`
+}
+
+func getTrashHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
+
+	trash := TrashContents{Materias: []TrashedMateria{}, ProvasTrabalhos: []TrashedProvaTrabalho{}}
+
+	storeMu.RLock()
+	for _, materia := range materias {
+		if materia.TenantID == tenantID && materia.UserID == userID && materia.DeletedAt != nil {
+			trash.Materias = append(trash.Materias, TrashedMateria{Materia: materia, PurgeAt: materia.DeletedAt.Add(trashRetention)})
+		}
+	}
+	for _, prova := range provasTrabalhos {
+		if prova.TenantID == tenantID && prova.UserID == userID && prova.DeletedAt != nil {
+			trash.ProvasTrabalhos = append(trash.ProvasTrabalhos, TrashedProvaTrabalho{ProvaTrabalho: prova, PurgeAt: prova.DeletedAt.Add(trashRetention)})
+		}
+	}
+	storeMu.RUnlock()
+
+	sort.Slice(trash.Materias, func(i, j int) bool {
+		return trash.Materias[i].DeletedAt.After(*trash.Materias[j].DeletedAt)
+	})
+	sort.Slice(trash.ProvasTrabalhos, func(i, j int) bool {
+		return trash.ProvasTrabalhos[i].DeletedAt.After(*trash.ProvasTrabalhos[j].DeletedAt)
+	})
+
+	logUserAction(userID, "GET", "lixeira")
+	writeSuccessResponse(w, r, "trash_loaded", trash)
+}
+
+func restoreTrashHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	switch vars["type"] {
+	case trashTypeMaterias:
+		restoreMateria(w, r, tenantID, userID, id)
+	case trashTypeProvas:
+		restoreProvaTrabalho(w, r, tenantID, userID, id)
+	default:
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "trash_item_not_found")
+	}
+}
+
+func restoreMateria(w http.ResponseWriter, r *http.Request, tenantID, userID, id int) {
+	storeMu.Lock()
+	var materia *Materia
+	for i := range materias {
+		if materias[i].ID == id && materias[i].TenantID == tenantID && materias[i].UserID == userID && materias[i].DeletedAt != nil {
+			materia = &materias[i]
+			break
+		}
+	}
+	if materia == nil {
+		storeMu.Unlock()
+		log.Printf("Tentativa de restaurar matéria fora da lixeira: ID %d, User %d", id, userID)
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "trash_item_not_found")
+		return
+	}
+
+	// Outra matéria pode ter assumido o nome enquanto esta estava na lixeira
+	if materiaNameTaken(tenantID, userID, id, materia.Nome) {
+		storeMu.Unlock()
+		writeErrorResponse(w, r, http.StatusConflict, "CONFLICT", "materia_name_exists")
+		return
+	}
+
+	// Provas/trabalhos excluídos junto com a matéria (cascade=true) voltam com ela
+	now := time.Now()
+	restoredProvas := 0
+	for i := range provasTrabalhos {
+		prova := &provasTrabalhos[i]
+		if prova.MateriaID == id && prova.TenantID == tenantID && prova.UserID == userID && prova.DeletedAt != nil && prova.DeletedAt.Equal(*materia.DeletedAt) {
+			prova.DeletedAt = nil
+			prova.ImpersonatedBy = impersonatorID(r)
+			prova.Version++
+			prova.UpdatedAt = now
+			searchIdx.indexProva(*prova)
+			publishEvent(tenantID, userID, eventProvaRestored, *prova)
+			restoredProvas++
+		}
+	}
+
+	materia.DeletedAt = nil
+	materia.ImpersonatedBy = impersonatorID(r)
+	materia.Version++
+	materia.UpdatedAt = now
+	searchIdx.indexMateria(*materia)
+	publishEvent(tenantID, userID, eventMateriaRestored, *materia)
+	restored := *materia
+	storeMu.Unlock()
+
+	logUserMutation(r, userID, "RESTORE", fmt.Sprintf("materia %d (%d provas-trabalhos)", id, restoredProvas))
+	w.Header().Set("ETag", entityTag(restored.Version))
+	writeSuccessResponse(w, r, "materia_restored", restored)
+}
+
+func restoreProvaTrabalho(w http.ResponseWriter, r *http.Request, tenantID, userID, id int) {
+	storeMu.Lock()
+	var prova *ProvaTrabalho
+	for i := range provasTrabalhos {
+		if provasTrabalhos[i].ID == id && provasTrabalhos[i].TenantID == tenantID && provasTrabalhos[i].UserID == userID && provasTrabalhos[i].DeletedAt != nil {
+			prova = &provasTrabalhos[i]
+			break
+		}
+	}
+	if prova == nil {
+		storeMu.Unlock()
+		log.Printf("Tentativa de restaurar prova/trabalho fora da lixeira: ID %d, User %d", id, userID)
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "trash_item_not_found")
+		return
+	}
+
+	// A matéria precisa estar ativa; se também foi excluída, deve ser restaurada antes
+	if findMateria(tenantID, userID, prova.MateriaID) == nil {
+		storeMu.Unlock()
+		writeErrorResponse(w, r, http.StatusConflict, "CONFLICT", "prova_materia_deleted")
+		return
+	}
+
+	prova.DeletedAt = nil
+	prova.ImpersonatedBy = impersonatorID(r)
+	prova.Version++
+	prova.UpdatedAt = time.Now()
+	searchIdx.indexProva(*prova)
+	publishEvent(tenantID, userID, eventProvaRestored, *prova)
+	restored := *prova
+	storeMu.Unlock()
+
+	logUserMutation(r, userID, "RESTORE", fmt.Sprintf("prova-trabalho %d", id))
+	w.Header().Set("ETag", entityTag(restored.Version))
+	writeSuccessResponse(w, r, "prova_restored", restored)
+}
+
+// runTrashPurge remove definitivamente, a cada intervalo, os itens há mais de trashRetention
+// na lixeira; um intervalo 0 desliga o expurgo
+func runTrashPurge(interval time.Duration) {
+	if interval <= 0 {
+		log.Printf("Expurgo da lixeira desativado")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		purgeTrash(time.Now().Add(-trashRetention))
+	}
+}
+
+// purgeTrash remove os itens excluídos antes de cutoff
+func purgeTrash(cutoff time.Time) {
+	expired := func(deletedAt *time.Time) bool {
+		return deletedAt != nil && deletedAt.Before(cutoff)
+	}
+
+	storeMu.Lock()
+	keptMaterias := materias[:0]
+	for _, materia := range materias {
+		if !expired(materia.DeletedAt) {
+			keptMaterias = append(keptMaterias, materia)
+		}
+	}
+	purgedMaterias := len(materias) - len(keptMaterias)
+	materias = keptMaterias
+
+	// Os anexos das provas/trabalhos expurgados saem do BlobStore depois de liberar o lock
+	var blobKeys []string
+	keptProvas := provasTrabalhos[:0]
+	for _, prova := range provasTrabalhos {
+		if !expired(prova.DeletedAt) {
+			keptProvas = append(keptProvas, prova)
+			continue
+		}
+		for _, arquivo := range prova.Arquivos {
+			blobKeys = append(blobKeys, arquivo.StorageKey)
+		}
+	}
+	purgedProvas := len(provasTrabalhos) - len(keptProvas)
+	provasTrabalhos = keptProvas
+	storeMu.Unlock()
+
+	deleteBlobs(blobKeys)
+
+	if purgedMaterias > 0 || purgedProvas > 0 {
+		log.Printf("Expurgo da lixeira: %d matérias e %d provas/trabalhos removidos definitivamente", purgedMaterias, purgedProvas)
+	}
+}
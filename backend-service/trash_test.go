@@ -0,0 +1,174 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// deleteMateria chama o DELETE /materias/{id} com a query informada
+func deleteMateria(t *testing.T, principal Principal, id int, query string) *httptest.ResponseRecorder {
+	t.Helper()
+	return itemRequest(t, deleteMateriaHandler, http.MethodDelete, "/materias/"+strconv.Itoa(id)+query, id, "", nil, principal)
+}
+
+// restoreFromTrash chama o POST /lixeira/{type}/{id}/restaurar
+func restoreFromTrash(t *testing.T, principal Principal, kind string, id int) *httptest.ResponseRecorder {
+	t.Helper()
+	return doRequest(t, func(w http.ResponseWriter, r *http.Request) {
+		restoreTrashHandler(w, mux.SetURLVars(r, map[string]string{"type": kind, "id": strconv.Itoa(id)}))
+	}, http.MethodPost, "/lixeira/"+kind+"/"+strconv.Itoa(id)+"/restaurar", nil, principal)
+}
+
+// materiaOf informa a matéria e se a prova/trabalho está na lixeira, direto do store
+func materiaOf(t *testing.T, id int) (int, bool) {
+	t.Helper()
+	storeMu.RLock()
+	defer storeMu.RUnlock()
+	for _, prova := range provasTrabalhos {
+		if prova.ID == id {
+			return prova.MateriaID, prova.DeletedAt != nil
+		}
+	}
+	t.Fatalf("prova %d não está no store", id)
+	return 0, false
+}
+
+func TestDeleteMateriaOptions(t *testing.T) {
+	principal := Principal{UserID: 961, TenantID: 1}
+	materia := createTestMateria(t, principal, "Biologia")
+	prova := createTestProva(t, principal, materia.ID, "Prova de células")
+
+	for query, status := range map[string]int{
+		"":                                     http.StatusConflict,
+		"?cascade=talvez":                      http.StatusBadRequest,
+		"?move_to=0":                           http.StatusBadRequest,
+		"?move_to=" + strconv.Itoa(materia.ID): http.StatusBadRequest,
+		"?cascade=true&move_to=99":             http.StatusBadRequest,
+	} {
+		if rec := deleteMateria(t, principal, materia.ID, query); rec.Code != status {
+			t.Errorf("DELETE%s: status %d: %s; esperado %d", query, rec.Code, rec.Body.String(), status)
+		}
+	}
+	if _, deleted := materiaOf(t, prova.ID); deleted {
+		t.Fatal("prova excluída por DELETE recusado")
+	}
+}
+
+func TestDeleteMateriaMoveTo(t *testing.T) {
+	principal := Principal{UserID: 962, TenantID: 1}
+	other := Principal{UserID: 963, TenantID: 1}
+	origem := createTestMateria(t, principal, "Física I")
+	destino := createTestMateria(t, principal, "Física II")
+	excluida := createTestMateria(t, principal, "Física III")
+	alheia := createTestMateria(t, other, "Física do vizinho")
+	p1 := createTestProva(t, principal, origem.ID, "Lista de cinemática")
+	p2 := createTestProva(t, principal, origem.ID, "Prova de dinâmica")
+
+	if rec := deleteMateria(t, principal, excluida.ID, ""); rec.Code != http.StatusOK {
+		t.Fatalf("excluir matéria vazia: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Matérias na lixeira ou de outro usuário não recebem provas, e nada muda
+	for _, target := range []int{excluida.ID, alheia.ID} {
+		rec := deleteMateria(t, principal, origem.ID, "?move_to="+strconv.Itoa(target))
+		if rec.Code != http.StatusNotFound || rec.Body.Len() == 0 {
+			t.Fatalf("move_to=%d: status %d: %s; esperado 404", target, rec.Code, rec.Body.String())
+		}
+	}
+	for _, id := range []int{p1.ID, p2.ID} {
+		if materiaID, deleted := materiaOf(t, id); materiaID != origem.ID || deleted {
+			t.Fatalf("prova %d alterada por move_to recusado: matéria %d, excluída %v", id, materiaID, deleted)
+		}
+	}
+
+	rec := deleteMateria(t, principal, origem.ID, "?move_to="+strconv.Itoa(destino.ID))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("move_to válido: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var summary DeleteMateriaSummary
+	decodeData(t, rec, &summary)
+	sort.Ints(summary.ProvasMovidas)
+	want := DeleteMateriaSummary{MateriaID: origem.ID, ProvasExcluidas: []int{}, ProvasMovidas: []int{p1.ID, p2.ID}, MovidasPara: destino.ID}
+	if !reflect.DeepEqual(summary, want) {
+		t.Fatalf("resumo = %+v; esperado %+v", summary, want)
+	}
+	for _, id := range []int{p1.ID, p2.ID} {
+		if materiaID, deleted := materiaOf(t, id); materiaID != destino.ID || deleted {
+			t.Fatalf("prova %d: matéria %d, excluída %v; esperado movida para %d", id, materiaID, deleted, destino.ID)
+		}
+	}
+}
+
+func TestDeleteMateriaCascadeAndRestore(t *testing.T) {
+	principal := Principal{UserID: 964, TenantID: 1}
+	materia := createTestMateria(t, principal, "Literatura")
+	antes := createTestProva(t, principal, materia.ID, "Resenha excluída antes")
+	p1 := createTestProva(t, principal, materia.ID, "Seminário de poesia")
+	p2 := createTestProva(t, principal, materia.ID, "Prova de romantismo")
+
+	// Uma prova excluída por conta própria antes da matéria não volta com ela
+	if rec := itemRequest(t, deleteProvaTrabalhoHandler, http.MethodDelete, "/provas-trabalhos/1", antes.ID, "", nil, principal); rec.Code != http.StatusOK {
+		t.Fatalf("excluir prova: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec := deleteMateria(t, principal, materia.ID, "?cascade=true")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("cascade: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var summary DeleteMateriaSummary
+	decodeData(t, rec, &summary)
+	sort.Ints(summary.ProvasExcluidas)
+	want := DeleteMateriaSummary{MateriaID: materia.ID, ProvasExcluidas: []int{p1.ID, p2.ID}, ProvasMovidas: []int{}}
+	if !reflect.DeepEqual(summary, want) {
+		t.Fatalf("resumo = %+v; esperado %+v", summary, want)
+	}
+
+	// A matéria e as provas da cascata vão para a lixeira com o mesmo instante de exclusão
+	storeMu.RLock()
+	var materiaDeletedAt, p1DeletedAt, p2DeletedAt, antesDeletedAt string
+	for _, m := range materias {
+		if m.ID == materia.ID && m.DeletedAt != nil {
+			materiaDeletedAt = m.DeletedAt.String()
+		}
+	}
+	for _, p := range provasTrabalhos {
+		if p.DeletedAt == nil {
+			continue
+		}
+		switch p.ID {
+		case p1.ID:
+			p1DeletedAt = p.DeletedAt.String()
+		case p2.ID:
+			p2DeletedAt = p.DeletedAt.String()
+		case antes.ID:
+			antesDeletedAt = p.DeletedAt.String()
+		}
+	}
+	storeMu.RUnlock()
+	if materiaDeletedAt == "" || p1DeletedAt != materiaDeletedAt || p2DeletedAt != materiaDeletedAt || antesDeletedAt == materiaDeletedAt {
+		t.Fatalf("instantes de exclusão: matéria %q, provas %q %q, prova anterior %q", materiaDeletedAt, p1DeletedAt, p2DeletedAt, antesDeletedAt)
+	}
+
+	// A prova não pode voltar sozinha enquanto a matéria estiver na lixeira
+	if rec := restoreFromTrash(t, principal, trashTypeProvas, p1.ID); rec.Code != http.StatusConflict {
+		t.Fatalf("restaurar prova com a matéria na lixeira: status %d; esperado 409", rec.Code)
+	}
+
+	if rec := restoreFromTrash(t, principal, trashTypeMaterias, materia.ID); rec.Code != http.StatusOK {
+		t.Fatalf("restaurar matéria: status %d: %s", rec.Code, rec.Body.String())
+	}
+	for id, wantDeleted := range map[int]bool{p1.ID: false, p2.ID: false, antes.ID: true} {
+		if _, deleted := materiaOf(t, id); deleted != wantDeleted {
+			t.Fatalf("prova %d após a restauração: na lixeira %v; esperado %v", id, deleted, wantDeleted)
+		}
+	}
+	if rec := restoreFromTrash(t, principal, trashTypeProvas, antes.ID); rec.Code != http.StatusOK {
+		t.Fatalf("restaurar a prova excluída antes: status %d: %s", rec.Code, rec.Body.String())
+	}
+}
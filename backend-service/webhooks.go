@@ -0,0 +1,874 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	eventMateriaCreated  = "materia.created"
+	eventMateriaUpdated  = "materia.updated"
+	eventMateriaDeleted  = "materia.deleted"
+	eventMateriaRestored = "materia.restored"
+	eventProvaCreated    = "prova.created"
+	eventProvaUpdated    = "prova.updated"
+	eventProvaDeleted    = "prova.deleted"
+	eventProvaRestored   = "prova.restored"
+	eventProvaDueSoon    = "prova.due_soon"
+	eventWebhookTest     = "webhook.test"
+
+	webhookStatusPendente   = "pendente"
+	webhookStatusEntregue   = "entregue"
+	webhookStatusFalhou     = "falhou"
+	webhookStatusDeadLetter = "dead_letter"
+
+	maxWebhooksPerUser = 10
+	// maxWebhookDeliveries é quantas entregas finalizadas (e, à parte, quantas dead letters)
+	// cada webhook mantém no histórico
+	maxWebhookDeliveries = 100
+	maxWebhookErrorLen   = 200
+)
+
+// webhookEvents são os eventos que podem ser assinados; o filtro também aceita "*" e "materia.*"/"prova.*"
+var webhookEvents = []string{
+	eventMateriaCreated, eventMateriaUpdated, eventMateriaDeleted, eventMateriaRestored,
+	eventProvaCreated, eventProvaUpdated, eventProvaDeleted, eventProvaRestored, eventProvaDueSoon,
+}
+
+var (
+	// webhookMaxAttempts é o total de tentativas antes de a entrega ir para as dead letters
+	webhookMaxAttempts = 6
+	// webhookRetryBase é a espera antes da segunda tentativa; dobra a cada falha
+	webhookRetryBase = 30 * time.Second
+	webhookClient    = newOutboundClient(10 * time.Second)
+	// allowPrivateTargets libera endereços internos como destino de webhooks e lembretes,
+	// para desenvolvimento local (WEBHOOK_ALLOW_PRIVATE)
+	allowPrivateTargets = false
+
+	webhooks = newWebhookHub()
+
+	webhookDelivered   atomic.Int64
+	webhookFailures    atomic.Int64
+	webhookDeadLetters atomic.Int64
+)
+
+// WebhookSubscription é um endereço que recebe, assinados com HMAC, os eventos escolhidos
+type WebhookSubscription struct {
+	ID        int       `json:"id"`
+	URL       string    `json:"url"`
+	Eventos   []string  `json:"eventos"`
+	Descricao string    `json:"descricao,omitempty"`
+	Ativo     bool      `json:"ativo"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Secret    string    `json:"-"`
+	UserID    int       `json:"-"`
+	TenantID  int       `json:"-"`
+}
+
+// CreatedWebhook é a assinatura recém-criada; o segredo só é mostrado nesta resposta
+type CreatedWebhook struct {
+	WebhookSubscription
+	Secret string `json:"secret"`
+}
+
+type WebhookRequest struct {
+	URL       string   `json:"url"`
+	Eventos   []string `json:"eventos"`
+	Descricao string   `json:"descricao"`
+	// Ausente equivale a true
+	Ativo *bool `json:"ativo"`
+}
+
+// WebhookEvent é o corpo enviado ao endereço assinado
+type WebhookEvent struct {
+	ID       string      `json:"id"`
+	Evento   string      `json:"evento"`
+	CriadoEm time.Time   `json:"criado_em"`
+	Dados    interface{} `json:"dados"`
+}
+
+// WebhookAttempt é uma tentativa de entrega
+type WebhookAttempt struct {
+	Em         time.Time `json:"em"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Erro       string    `json:"erro,omitempty"`
+	DuracaoMs  int64     `json:"duracao_ms"`
+}
+
+// WebhookDelivery é a entrega de um evento a uma assinatura, com o histórico de tentativas
+type WebhookDelivery struct {
+	ID               int              `json:"id"`
+	WebhookID        int              `json:"webhook_id"`
+	EventoID         string           `json:"evento_id"`
+	Evento           string           `json:"evento"`
+	Status           string           `json:"status"`
+	Tentativas       []WebhookAttempt `json:"tentativas"`
+	ProximaTentativa *time.Time       `json:"proxima_tentativa,omitempty"`
+	Payload          json.RawMessage  `json:"payload"`
+	CreatedAt        time.Time        `json:"created_at"`
+	UserID           int              `json:"-"`
+	TenantID         int              `json:"-"`
+	inFlight         bool
+}
+
+// webhookHub guarda as assinaturas e a fila de entregas. Tem lock próprio e nunca trava
+// storeMu, então emit pode ser chamado com storeMu travado.
+type webhookHub struct {
+	mu                 sync.Mutex
+	subscriptions      []WebhookSubscription
+	deliveries         []WebhookDelivery
+	nextSubscriptionID int
+	nextDeliveryID     int
+	wake               chan struct{}
+}
+
+func newWebhookHub() *webhookHub {
+	return &webhookHub{nextSubscriptionID: 1, nextDeliveryID: 1, wake: make(chan struct{}, 1)}
+}
+
+func (s WebhookSubscription) accepts(event string) bool {
+	for _, filter := range s.Eventos {
+		if filter == "*" || filter == event || strings.HasSuffix(filter, ".*") && strings.HasPrefix(event, strings.TrimSuffix(filter, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// newWebhookPayload monta o corpo do evento, retornando também o ID do evento
+func newWebhookPayload(event string, data interface{}) (string, []byte, error) {
+	raw := make([]byte, 12)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, err
+	}
+	id := "evt_" + hex.EncodeToString(raw)
+	payload, err := json.Marshal(WebhookEvent{ID: id, Evento: event, CriadoEm: time.Now().UTC(), Dados: data})
+	return id, payload, err
+}
+
+// emit enfileira o evento para as assinaturas ativas do usuário que o aceitam
+func (h *webhookHub) emit(tenantID, userID int, event string, data interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var eventID string
+	var payload []byte
+	for _, sub := range h.subscriptions {
+		if sub.TenantID != tenantID || sub.UserID != userID || !sub.Ativo || !sub.accepts(event) {
+			continue
+		}
+		if payload == nil {
+			var err error
+			if eventID, payload, err = newWebhookPayload(event, data); err != nil {
+				log.Printf("Erro ao montar o evento %s do User %d: %v", event, userID, err)
+				return
+			}
+		}
+		h.enqueue(sub, eventID, event, payload)
+	}
+	if payload != nil {
+		select {
+		case h.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// enqueue cria uma entrega pendente, pronta para envio; h.mu deve estar travado
+func (h *webhookHub) enqueue(sub WebhookSubscription, eventID, event string, payload []byte) WebhookDelivery {
+	now := time.Now()
+	delivery := WebhookDelivery{
+		ID:               h.nextDeliveryID,
+		WebhookID:        sub.ID,
+		EventoID:         eventID,
+		Evento:           event,
+		Status:           webhookStatusPendente,
+		Tentativas:       []WebhookAttempt{},
+		ProximaTentativa: &now,
+		Payload:          payload,
+		CreatedAt:        now,
+		UserID:           sub.UserID,
+		TenantID:         sub.TenantID,
+	}
+	h.nextDeliveryID++
+	h.deliveries = append(h.deliveries, delivery)
+	return delivery
+}
+
+// run envia as entregas pendentes assim que são criadas e as novas tentativas no horário
+func (h *webhookHub) run() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-h.wake:
+		}
+		h.dispatchDue(time.Now())
+	}
+}
+
+type webhookJob struct {
+	delivery WebhookDelivery
+	url      string
+	secret   string
+}
+
+func (h *webhookHub) dispatchDue(now time.Time) {
+	var jobs []webhookJob
+	h.mu.Lock()
+	for i := range h.deliveries {
+		d := &h.deliveries[i]
+		if d.Status != webhookStatusPendente || d.inFlight || d.ProximaTentativa == nil || d.ProximaTentativa.After(now) {
+			continue
+		}
+		sub := h.findSubscription(d.TenantID, d.UserID, d.WebhookID)
+		if sub == nil || !sub.Ativo {
+			continue
+		}
+		d.inFlight = true
+		jobs = append(jobs, webhookJob{delivery: *d, url: sub.URL, secret: sub.Secret})
+	}
+	h.mu.Unlock()
+
+	for _, job := range jobs {
+		go h.attempt(job)
+	}
+}
+
+// attempt faz uma tentativa e agenda a próxima com backoff exponencial, ou move a entrega
+// para as dead letters depois de webhookMaxAttempts falhas
+func (h *webhookHub) attempt(job webhookJob) {
+	result := sendWebhook(context.Background(), job.url, job.secret, job.delivery)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	d := h.findDelivery(job.delivery.TenantID, job.delivery.UserID, job.delivery.ID)
+	if d == nil {
+		// A assinatura foi excluída durante o envio
+		return
+	}
+	d.inFlight = false
+	d.Tentativas = append(d.Tentativas, result)
+	switch {
+	case result.Erro == "":
+		d.Status = webhookStatusEntregue
+		d.ProximaTentativa = nil
+		webhookDelivered.Add(1)
+	case len(d.Tentativas) >= webhookMaxAttempts:
+		d.Status = webhookStatusDeadLetter
+		d.ProximaTentativa = nil
+		webhookFailures.Add(1)
+		webhookDeadLetters.Add(1)
+		log.Printf("Webhook %d: entrega %d (%s) desistida após %d tentativas: %s", d.WebhookID, d.ID, d.Evento, len(d.Tentativas), result.Erro)
+	default:
+		next := time.Now().Add(webhookBackoff(len(d.Tentativas)))
+		d.ProximaTentativa = &next
+		webhookFailures.Add(1)
+	}
+	h.prune(d.WebhookID)
+}
+
+// webhookBackoff é a espera após a n-ésima falha: webhookRetryBase * 2^(n-1), com até 20% de jitter
+func webhookBackoff(failures int) time.Duration {
+	wait := webhookRetryBase << (failures - 1)
+	if jitter := int64(wait) / 5; jitter > 0 {
+		if n, err := rand.Int(rand.Reader, big.NewInt(jitter)); err == nil {
+			wait += time.Duration(n.Int64())
+		}
+	}
+	return wait
+}
+
+var errBlockedTarget = errors.New("endereço de destino não permitido")
+
+// blockedIP indica se o endereço é interno: loopback, rede privada, link-local, multicast ou
+// não especificado
+func blockedIP(ip net.IP) bool {
+	if allowPrivateTargets {
+		return false
+	}
+	if ip4 := ip.To4(); ip4 != nil && ip4[0] == 0 {
+		return true
+	}
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// resolveTarget resolve o host e retorna seus endereços, ou errBlockedTarget se algum for interno
+func resolveTarget(ctx context.Context, host string) ([]net.IPAddr, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if blockedIP(addr.IP) {
+			return nil, errBlockedTarget
+		}
+	}
+	return addrs, nil
+}
+
+// dialPublic resolve o host e conecta direto ao IP conferido, para que o DNS não possa
+// apontar para outro endereço entre a checagem e a conexão
+func dialPublic(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := resolveTarget(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	for _, addr := range addrs {
+		var conn net.Conn
+		if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(addr.IP.String(), port)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// newOutboundClient cria o cliente para endereços informados pelos usuários: só conecta a
+// IPs públicos, ignora proxies e não segue redirecionamentos
+func newOutboundClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialPublic,
+			TLSHandshakeTimeout: 10 * time.Second,
+			MaxIdleConnsPerHost: 2,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// validateTargetURL confere se a URL é http(s) e se o host resolve apenas para IPs públicos;
+// retorna a chave da mensagem de erro, ou "" se válida
+func validateTargetURL(ctx context.Context, raw string) string {
+	parsed, err := url.Parse(raw)
+	if raw == "" || err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return "referencia_url"
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if _, err := resolveTarget(ctx, parsed.Hostname()); err != nil {
+		if errors.Is(err, errBlockedTarget) {
+			return "target_url_blocked"
+		}
+		return "target_url_unresolved"
+	}
+	return ""
+}
+
+// signWebhook assina "<timestamp>.<corpo>" com HMAC-SHA256
+func signWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// sendWebhook faz um POST da entrega; só respostas 2xx contam como entregues
+func sendWebhook(ctx context.Context, target, secret string, delivery WebhookDelivery) WebhookAttempt {
+	start := time.Now()
+	result := WebhookAttempt{Em: start}
+	fail := func(err error) WebhookAttempt {
+		result.Erro = err.Error()
+		if len(result.Erro) > maxWebhookErrorLen {
+			result.Erro = result.Erro[:maxWebhookErrorLen]
+		}
+		result.DuracaoMs = time.Since(start).Milliseconds()
+		return result
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return fail(err)
+	}
+	timestamp := start.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "sistema-estudos-webhooks")
+	req.Header.Set("X-Webhook-Event", delivery.Evento)
+	req.Header.Set("X-Webhook-Delivery", strconv.Itoa(delivery.ID))
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Webhook-Signature", signWebhook(secret, timestamp, delivery.Payload))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return fail(err)
+	}
+	resp.Body.Close()
+	result.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fail(fmt.Errorf("resposta %d", resp.StatusCode))
+	}
+	result.DuracaoMs = time.Since(start).Milliseconds()
+	return result
+}
+
+// prune descarta as entregas finalizadas mais antigas do webhook além do limite; h.mu deve estar travado
+func (h *webhookHub) prune(webhookID int) {
+	counts := map[string]int{}
+	for _, d := range h.deliveries {
+		if d.WebhookID == webhookID {
+			counts[d.Status]++
+		}
+	}
+	kept := h.deliveries[:0]
+	for _, d := range h.deliveries {
+		if d.WebhookID == webhookID && d.Status != webhookStatusPendente && counts[d.Status] > maxWebhookDeliveries {
+			counts[d.Status]--
+			continue
+		}
+		kept = append(kept, d)
+	}
+	h.deliveries = kept
+}
+
+// dropPending descarta as entregas pendentes do webhook, incluindo as que aguardam nova
+// tentativa; uma tentativa em andamento termina, mas não é registrada. h.mu deve estar travado
+func (h *webhookHub) dropPending(webhookID int) int {
+	dropped := 0
+	kept := h.deliveries[:0]
+	for _, d := range h.deliveries {
+		if d.WebhookID == webhookID && d.Status == webhookStatusPendente {
+			dropped++
+			continue
+		}
+		kept = append(kept, d)
+	}
+	h.deliveries = kept
+	return dropped
+}
+
+// findSubscription retorna a assinatura do usuário; h.mu deve estar travado
+func (h *webhookHub) findSubscription(tenantID, userID, id int) *WebhookSubscription {
+	for i := range h.subscriptions {
+		if sub := &h.subscriptions[i]; sub.ID == id && sub.TenantID == tenantID && sub.UserID == userID {
+			return sub
+		}
+	}
+	return nil
+}
+
+// findDelivery retorna a entrega do usuário; h.mu deve estar travado
+func (h *webhookHub) findDelivery(tenantID, userID, id int) *WebhookDelivery {
+	for i := range h.deliveries {
+		if d := &h.deliveries[i]; d.ID == id && d.TenantID == tenantID && d.UserID == userID {
+			return d
+		}
+	}
+	return nil
+}
+
+func newWebhookSecret() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(raw), nil
+}
+
+func validateWebhookRequest(ctx context.Context, req *WebhookRequest) []FieldError {
+	var details []FieldError
+
+	if key := validateTargetURL(ctx, req.URL); key != "" {
+		details = append(details, fieldError("url", key))
+	}
+
+	if len(req.Eventos) == 0 {
+		details = append(details, fieldError("eventos", "webhook_events_required"))
+	}
+	for i, event := range req.Eventos {
+		if event != "*" && event != "materia.*" && event != "prova.*" && !containsString(webhookEvents, event) {
+			details = append(details, fieldError(fmt.Sprintf("eventos[%d]", i), "webhook_event", event, strings.Join(webhookEvents, ", ")))
+		}
+	}
+	return details
+}
+
+// decodeWebhookRequest lê e valida o corpo do POST/PUT; responde o erro e retorna false se inválido
+func decodeWebhookRequest(w http.ResponseWriter, r *http.Request) (WebhookRequest, bool) {
+	var req WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, r, http.StatusBadRequest, "BAD_REQUEST", "invalid_body")
+		return req, false
+	}
+	req.URL = strings.TrimSpace(req.URL)
+	req.Descricao = strings.TrimSpace(req.Descricao)
+	if details := validateWebhookRequest(r.Context(), &req); len(details) > 0 {
+		writeValidationError(w, r, details)
+		return req, false
+	}
+
+	eventos := []string{}
+	for _, event := range req.Eventos {
+		if !containsString(eventos, event) {
+			eventos = append(eventos, event)
+		}
+	}
+	req.Eventos = eventos
+	return req, true
+}
+
+func getWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
+
+	result := []WebhookSubscription{}
+	webhooks.mu.Lock()
+	for _, sub := range webhooks.subscriptions {
+		if sub.TenantID == tenantID && sub.UserID == userID {
+			result = append(result, sub)
+		}
+	}
+	webhooks.mu.Unlock()
+
+	logUserAction(userID, "GET", "webhooks")
+	writeSuccessResponse(w, r, "webhooks_loaded", result)
+}
+
+func getWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	webhooks.mu.Lock()
+	sub := webhooks.findSubscription(tenantID, userID, id)
+	if sub == nil {
+		webhooks.mu.Unlock()
+		log.Printf("Tentativa de acessar webhook inexistente: ID %d, User %d", id, userID)
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "webhook_not_found")
+		return
+	}
+	found := *sub
+	webhooks.mu.Unlock()
+
+	logUserAction(userID, "GET", fmt.Sprintf("webhook %d", id))
+	writeSuccessResponse(w, r, "webhook_loaded", found)
+}
+
+func createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
+
+	req, ok := decodeWebhookRequest(w, r)
+	if !ok {
+		return
+	}
+	secret, err := newWebhookSecret()
+	if err != nil {
+		log.Printf("Erro ao gerar segredo de webhook: %v", err)
+		writeErrorResponse(w, r, http.StatusInternalServerError, "INTERNAL_ERROR", "webhook_failed")
+		return
+	}
+
+	webhooks.mu.Lock()
+	count := 0
+	for _, sub := range webhooks.subscriptions {
+		if sub.TenantID == tenantID && sub.UserID == userID {
+			count++
+		}
+	}
+	if count >= maxWebhooksPerUser {
+		webhooks.mu.Unlock()
+		writeErrorResponse(w, r, http.StatusConflict, "CONFLICT", "webhook_limit", maxWebhooksPerUser)
+		return
+	}
+
+	now := time.Now()
+	sub := WebhookSubscription{
+		ID:        webhooks.nextSubscriptionID,
+		URL:       req.URL,
+		Eventos:   req.Eventos,
+		Descricao: req.Descricao,
+		Ativo:     req.Ativo == nil || *req.Ativo,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Secret:    secret,
+		UserID:    userID,
+		TenantID:  tenantID,
+	}
+	webhooks.subscriptions = append(webhooks.subscriptions, sub)
+	webhooks.nextSubscriptionID++
+	webhooks.mu.Unlock()
+
+	logUserMutation(r, userID, "CREATE", fmt.Sprintf("webhook %d (%s)", sub.ID, strings.Join(sub.Eventos, ", ")))
+	writeSuccessResponse(w, r, "webhook_created", CreatedWebhook{WebhookSubscription: sub, Secret: secret})
+}
+
+func updateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	req, ok := decodeWebhookRequest(w, r)
+	if !ok {
+		return
+	}
+
+	webhooks.mu.Lock()
+	sub := webhooks.findSubscription(tenantID, userID, id)
+	if sub == nil {
+		webhooks.mu.Unlock()
+		log.Printf("Tentativa de atualizar webhook inexistente: ID %d, User %d", id, userID)
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "webhook_not_found")
+		return
+	}
+	sub.URL = req.URL
+	sub.Eventos = req.Eventos
+	sub.Descricao = req.Descricao
+	sub.Ativo = req.Ativo == nil || *req.Ativo
+	sub.UpdatedAt = time.Now()
+	updated := *sub
+	dropped := 0
+	if !updated.Ativo {
+		dropped = webhooks.dropPending(id)
+	}
+	webhooks.mu.Unlock()
+
+	if dropped > 0 {
+		log.Printf("Webhook %d desativado: %d entregas pendentes descartadas", id, dropped)
+	}
+
+	logUserMutation(r, userID, "UPDATE", fmt.Sprintf("webhook %d", id))
+	writeSuccessResponse(w, r, "webhook_updated", updated)
+}
+
+// deleteWebhookHandler remove a assinatura com o histórico e as entregas pendentes
+func deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	webhooks.mu.Lock()
+	if webhooks.findSubscription(tenantID, userID, id) == nil {
+		webhooks.mu.Unlock()
+		log.Printf("Tentativa de excluir webhook inexistente: ID %d, User %d", id, userID)
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "webhook_not_found")
+		return
+	}
+	keptSubs := webhooks.subscriptions[:0]
+	for _, sub := range webhooks.subscriptions {
+		if sub.ID != id {
+			keptSubs = append(keptSubs, sub)
+		}
+	}
+	webhooks.subscriptions = keptSubs
+	keptDeliveries := webhooks.deliveries[:0]
+	for _, d := range webhooks.deliveries {
+		if d.WebhookID != id {
+			keptDeliveries = append(keptDeliveries, d)
+		}
+	}
+	webhooks.deliveries = keptDeliveries
+	webhooks.mu.Unlock()
+
+	logUserMutation(r, userID, "DELETE", fmt.Sprintf("webhook %d", id))
+	writeSuccessResponse(w, r, "webhook_deleted", nil)
+}
+
+// testWebhookHandler envia na hora um evento webhook.test, em uma única tentativa, e
+// responde com o resultado; funciona também com a assinatura inativa. O status HTTP e o erro
+// do destino ficam só no log, para que o teste não sirva para sondar outros servidores.
+func testWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	eventID, payload, err := newWebhookPayload(eventWebhookTest, map[string]interface{}{"webhook_id": id, "mensagem": localize(r, "webhook_test_message")})
+	if err != nil {
+		log.Printf("Erro ao montar evento de teste do webhook %d: %v", id, err)
+		writeErrorResponse(w, r, http.StatusInternalServerError, "INTERNAL_ERROR", "webhook_failed")
+		return
+	}
+
+	webhooks.mu.Lock()
+	sub := webhooks.findSubscription(tenantID, userID, id)
+	if sub == nil {
+		webhooks.mu.Unlock()
+		log.Printf("Tentativa de testar webhook inexistente: ID %d, User %d", id, userID)
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "webhook_not_found")
+		return
+	}
+	delivery := webhooks.enqueue(*sub, eventID, eventWebhookTest, payload)
+	stored := webhooks.findDelivery(tenantID, userID, delivery.ID)
+	stored.inFlight = true
+	stored.ProximaTentativa = nil
+	target, secret := sub.URL, sub.Secret
+	webhooks.mu.Unlock()
+
+	result := sendWebhook(r.Context(), target, secret, delivery)
+	if result.Erro != "" {
+		log.Printf("Webhook %d: teste falhou (status %d): %s", id, result.StatusCode, result.Erro)
+		result.Erro = localize(r, "webhook_test_failed")
+	}
+	result.StatusCode = 0
+
+	webhooks.mu.Lock()
+	if stored = webhooks.findDelivery(tenantID, userID, delivery.ID); stored != nil {
+		stored.inFlight = false
+		stored.Tentativas = append(stored.Tentativas, result)
+		stored.Status = webhookStatusEntregue
+		if result.Erro != "" {
+			stored.Status = webhookStatusFalhou
+		}
+		delivery = *stored
+		webhooks.prune(id)
+	}
+	webhooks.mu.Unlock()
+
+	logUserAction(userID, "TEST", fmt.Sprintf("webhook %d (%s)", id, delivery.Status))
+	writeSuccessResponse(w, r, "webhook_tested", delivery)
+}
+
+// getWebhookDeliveriesHandler lista as entregas do webhook, da mais recente à mais antiga;
+// ?status= filtra por pendente, entregue, falhou ou dead_letter
+func getWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", webhookStatusPendente, webhookStatusEntregue, webhookStatusFalhou, webhookStatusDeadLetter:
+	default:
+		writeValidationError(w, r, []FieldError{fieldError("status", "webhook_status", status)})
+		return
+	}
+
+	webhooks.mu.Lock()
+	if webhooks.findSubscription(tenantID, userID, id) == nil {
+		webhooks.mu.Unlock()
+		log.Printf("Tentativa de listar entregas de webhook inexistente: ID %d, User %d", id, userID)
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "webhook_not_found")
+		return
+	}
+	result := webhooks.listDeliveries(tenantID, userID, id, status)
+	webhooks.mu.Unlock()
+
+	logUserAction(userID, "GET", fmt.Sprintf("entregas do webhook %d", id))
+	writeSuccessResponse(w, r, "webhook_deliveries_loaded", result)
+}
+
+// getDeadLettersHandler lista as entregas desistidas de todos os webhooks do usuário
+func getDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
+
+	webhooks.mu.Lock()
+	result := webhooks.listDeliveries(tenantID, userID, 0, webhookStatusDeadLetter)
+	webhooks.mu.Unlock()
+
+	logUserAction(userID, "GET", "dead letters de webhooks")
+	writeSuccessResponse(w, r, "webhook_deliveries_loaded", result)
+}
+
+// listDeliveries filtra as entregas do usuário (webhookID 0 = todos os webhooks); h.mu deve estar travado
+func (h *webhookHub) listDeliveries(tenantID, userID, webhookID int, status string) []WebhookDelivery {
+	result := []WebhookDelivery{}
+	for i := len(h.deliveries) - 1; i >= 0; i-- {
+		d := h.deliveries[i]
+		if d.TenantID == tenantID && d.UserID == userID && (webhookID == 0 || d.WebhookID == webhookID) && (status == "" || d.Status == status) {
+			result = append(result, d)
+		}
+	}
+	return result
+}
+
+// redeliverWebhookHandler reenvia uma entrega finalizada (em geral uma dead letter) como uma
+// nova entrega, com o mesmo evento e novas tentativas
+func redeliverWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID, tenantID := principal.UserID, principal.TenantID
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+	deliveryID, _ := strconv.Atoi(vars["deliveryId"])
+
+	webhooks.mu.Lock()
+	sub := webhooks.findSubscription(tenantID, userID, id)
+	original := webhooks.findDelivery(tenantID, userID, deliveryID)
+	if sub == nil || original == nil || original.WebhookID != id {
+		webhooks.mu.Unlock()
+		log.Printf("Tentativa de reenviar entrega inexistente: Entrega %d, Webhook %d, User %d", deliveryID, id, userID)
+		writeErrorResponse(w, r, http.StatusNotFound, "NOT_FOUND", "webhook_delivery_not_found")
+		return
+	}
+	if original.Status == webhookStatusPendente {
+		webhooks.mu.Unlock()
+		writeErrorResponse(w, r, http.StatusConflict, "CONFLICT", "webhook_delivery_pending")
+		return
+	}
+	if !sub.Ativo {
+		webhooks.mu.Unlock()
+		writeErrorResponse(w, r, http.StatusConflict, "CONFLICT", "webhook_inactive")
+		return
+	}
+	delivery := webhooks.enqueue(*sub, original.EventoID, original.Evento, original.Payload)
+	webhooks.mu.Unlock()
+
+	select {
+	case webhooks.wake <- struct{}{}:
+	default:
+	}
+
+	logUserMutation(r, userID, "REDELIVER", fmt.Sprintf("entrega %d do webhook %d como %d", deliveryID, id, delivery.ID))
+	writeSuccessResponse(w, r, "webhook_redelivered", delivery)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// allowPrivateForTest libera destinos internos (o httptest escuta em loopback) até o fim do teste
+func allowPrivateForTest(t *testing.T) {
+	t.Helper()
+	allowPrivateTargets = true
+	t.Cleanup(func() { allowPrivateTargets = false })
+}
+
+func TestValidateTargetURLBlocksInternalAddresses(t *testing.T) {
+	ctx := context.Background()
+	for _, target := range []string{
+		"http://127.0.0.1:8081/health",
+		"http://localhost/",
+		"http://10.0.0.5/hook",
+		"http://192.168.1.1/",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://[::1]/",
+		"http://[fe80::1]/",
+		"http://0.0.0.0/",
+		"http://[::ffff:127.0.0.1]/",
+	} {
+		if key := validateTargetURL(ctx, target); key != "target_url_blocked" {
+			t.Errorf("validateTargetURL(%s) = %q; esperado target_url_blocked", target, key)
+		}
+	}
+	for _, target := range []string{"", "ftp://exemplo.com/", "http:///sem-host"} {
+		if key := validateTargetURL(ctx, target); key != "referencia_url" {
+			t.Errorf("validateTargetURL(%q) = %q; esperado referencia_url", target, key)
+		}
+	}
+	if key := validateTargetURL(ctx, "http://93.184.216.34/"); key != "" {
+		t.Errorf("IP público recusado: %q", key)
+	}
+}
+
+func TestOutboundClientRefusesInternalTargets(t *testing.T) {
+	hits := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hits++ }))
+	defer server.Close()
+
+	_, err := webhookClient.Post(server.URL, "application/json", strings.NewReader("{}"))
+	if !errors.Is(err, errBlockedTarget) {
+		t.Fatalf("POST para loopback: %v; esperado errBlockedTarget", err)
+	}
+	if hits != 0 {
+		t.Fatalf("o servidor interno recebeu %d requisições", hits)
+	}
+}
+
+func TestOutboundClientDoesNotFollowRedirects(t *testing.T) {
+	allowPrivateForTest(t)
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("o redirecionamento foi seguido")
+	}))
+	defer internal.Close()
+	redirector := httptest.NewServer(http.RedirectHandler(internal.URL, http.StatusFound))
+	defer redirector.Close()
+
+	result := sendWebhook(context.Background(), redirector.URL, "segredo", WebhookDelivery{ID: 1, Evento: eventWebhookTest, Payload: []byte("{}")})
+	if result.StatusCode != http.StatusFound || result.Erro == "" {
+		t.Fatalf("resultado = %+v; esperado falha com 302", result)
+	}
+}
+
+// webhookRequest chama um handler de webhook com o {id} da rota
+func webhookRequest(t *testing.T, handler http.HandlerFunc, method string, id int, body interface{}, principal Principal) *httptest.ResponseRecorder {
+	t.Helper()
+	return doRequest(t, func(w http.ResponseWriter, r *http.Request) {
+		handler(w, mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(id)}))
+	}, method, "/webhooks/"+strconv.Itoa(id), body, principal)
+}
+
+func TestWebhookTestDoesNotEchoUpstreamStatus(t *testing.T) {
+	allowPrivateForTest(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "segredo interno", http.StatusTeapot)
+	}))
+	defer server.Close()
+
+	principal := Principal{UserID: 921, TenantID: 1}
+	rec := doRequest(t, createWebhookHandler, http.MethodPost, "/webhooks", WebhookRequest{URL: server.URL, Eventos: []string{"*"}}, principal)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("criar webhook: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var sub CreatedWebhook
+	decodeData(t, rec, &sub)
+
+	rec = webhookRequest(t, testWebhookHandler, http.MethodPost, sub.ID, nil, principal)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("testar webhook: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if body := rec.Body.String(); strings.Contains(body, `"status_code"`) || strings.Contains(body, "resposta 418") || strings.Contains(body, "segredo interno") {
+		t.Fatalf("a resposta do teste expõe o destino: %s", body)
+	}
+	var delivery WebhookDelivery
+	decodeData(t, rec, &delivery)
+	if delivery.Status != webhookStatusFalhou || len(delivery.Tentativas) != 1 || delivery.Tentativas[0].StatusCode != 0 {
+		t.Fatalf("entrega de teste = %+v", delivery)
+	}
+}
+
+func TestDeactivatingWebhookDropsPendingDeliveries(t *testing.T) {
+	allowPrivateForTest(t)
+	principal := Principal{UserID: 922, TenantID: 1}
+	rec := doRequest(t, createWebhookHandler, http.MethodPost, "/webhooks", WebhookRequest{URL: "http://127.0.0.1:9/", Eventos: []string{"materia.*"}}, principal)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("criar webhook: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var sub CreatedWebhook
+	decodeData(t, rec, &sub)
+
+	// Uma entrega aguardando nova tentativa e outra ainda não enviada
+	webhooks.emit(principal.TenantID, principal.UserID, eventMateriaCreated, map[string]int{"id": 1})
+	webhooks.emit(principal.TenantID, principal.UserID, eventMateriaUpdated, map[string]int{"id": 1})
+	webhooks.mu.Lock()
+	retryAt := time.Now().Add(time.Hour)
+	for i := range webhooks.deliveries {
+		if d := &webhooks.deliveries[i]; d.WebhookID == sub.ID && d.Evento == eventMateriaCreated {
+			d.Tentativas = append(d.Tentativas, WebhookAttempt{Em: time.Now(), Erro: "resposta 500"})
+			d.ProximaTentativa = &retryAt
+		}
+	}
+	webhooks.mu.Unlock()
+
+	inactive := false
+	rec = webhookRequest(t, updateWebhookHandler, http.MethodPut, sub.ID, WebhookRequest{URL: sub.URL, Eventos: sub.Eventos, Ativo: &inactive}, principal)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("desativar webhook: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	webhooks.mu.Lock()
+	pending := webhooks.listDeliveries(principal.TenantID, principal.UserID, sub.ID, webhookStatusPendente)
+	webhooks.mu.Unlock()
+	if len(pending) != 0 {
+		t.Fatalf("%d entregas pendentes após desativar; esperado 0", len(pending))
+	}
+}
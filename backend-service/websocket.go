@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Implementação mínima do lado servidor do WebSocket (RFC 6455), suficiente para o /events:
+// o servidor só envia frames de texto e ping, e do cliente só trata ping, pong e close.
+
+const (
+	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	wsOpText  = 0x1
+	wsOpClose = 0x8
+	wsOpPing  = 0x9
+	wsOpPong  = 0xA
+
+	// wsMaxClientFrame limita o payload aceito do cliente, que não envia dados pelo /events
+	wsMaxClientFrame = 4096
+	wsWriteTimeout   = 10 * time.Second
+)
+
+// isWebSocketUpgrade informa se a requisição pede o upgrade para WebSocket
+func isWebSocketUpgrade(r *http.Request) bool {
+	return headerContainsToken(r.Header, "Connection", "upgrade") && strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsConn é uma conexão WebSocket já aceita; as escritas são serializadas por mu
+type wsConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+	mu   sync.Mutex
+	// closeSent impede um segundo frame de close, depois do qual nada mais pode ser enviado
+	closeSent bool
+}
+
+// allowedWebSocketOrigin recusa conexões abertas por páginas de outras origens. O navegador
+// sempre envia Origin no WebSocket e não aplica CORS a ele; clientes fora do navegador não o enviam.
+func allowedWebSocketOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	return origin == "" || containsString(corsOrigins, origin)
+}
+
+// upgradeWebSocket valida o handshake, assume a conexão e responde 101. Em caso de erro a
+// resposta HTTP já foi escrita.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if !allowedWebSocketOrigin(r) {
+		writeErrorResponse(w, r, http.StatusForbidden, "FORBIDDEN", "websocket_origin")
+		return nil, fmt.Errorf("origem não permitida: %s", r.Header.Get("Origin"))
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeErrorResponse(w, r, http.StatusUpgradeRequired, "UPGRADE_REQUIRED", "websocket_version")
+		return nil, errors.New("versão do WebSocket não suportada")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		writeErrorResponse(w, r, http.StatusBadRequest, "BAD_REQUEST", "websocket_handshake")
+		return nil, errors.New("Sec-WebSocket-Key inválida")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		writeErrorResponse(w, r, http.StatusInternalServerError, "INTERNAL_ERROR", "live_unsupported")
+		return nil, errors.New("conexão não suporta hijack")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n"
+	conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if _, err := rw.WriteString(response); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, rw: rw}, nil
+}
+
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
+
+// writeFrame envia um frame final, sem máscara, como o servidor deve enviar
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closeSent {
+		return net.ErrClosed
+	}
+	if opcode == wsOpClose {
+		c.closeSent = true
+	}
+
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if _, err := c.rw.Write(header); err != nil {
+		return err
+	}
+	if _, err := c.rw.Write(payload); err != nil {
+		return err
+	}
+	return c.rw.Flush()
+}
+
+// readFrame lê um frame do cliente, que é obrigatoriamente mascarado
+func (c *wsConn) readFrame() (opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.rw, head[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode = head[0] & 0x0F
+	if head[1]&0x80 == 0 {
+		return 0, nil, errors.New("frame do cliente sem máscara")
+	}
+
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > wsMaxClientFrame {
+		return 0, nil, fmt.Errorf("frame do cliente com %d bytes", length)
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.rw, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.rw, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
+
+// readLoop responde aos pings e ao close do cliente; retorna quando a conexão é encerrada
+func (c *wsConn) readLoop() {
+	for {
+		opcode, payload, err := c.readFrame()
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case wsOpPing:
+			if c.writeFrame(wsOpPong, payload) != nil {
+				return
+			}
+		case wsOpClose:
+			c.writeFrame(wsOpClose, payload)
+			return
+		}
+	}
+}
+
+// writeClose envia o frame de close com o código 1000 (encerramento normal)
+func (c *wsConn) writeClose() {
+	c.writeFrame(wsOpClose, []byte{0x03, 0xE8})
+}
+
+func (c *wsConn) send(ev LiveEvent) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(wsOpText, data)
+}
+
+func (c *wsConn) heartbeat() error {
+	return c.writeFrame(wsOpPing, nil)
+}
@@ -2,6 +2,7 @@ import React, { useState, useEffect } from 'react';
 import { BrowserRouter as Router, Routes, Route, Navigate } from 'react-router-dom';
 import Login from './components/Login';
 import Register from './components/Register';
+import MagicLinkCallback from './components/MagicLinkCallback';
 import Dashboard from './components/Dashboard';
 import Materias from './components/Materias';
 import ProvasTrabalhos from './components/ProvasTrabalhos';
@@ -103,6 +104,14 @@ function App() {
               <Login onLogin={handleLogin} />
             } 
           />
+          <Route 
+            path="/login/magic-link/callback" 
+            element={
+              isAuthenticated ? 
+              <Navigate to="/dashboard" replace /> : 
+              <MagicLinkCallback onLogin={handleLogin} />
+            } 
+          />
           <Route 
             path="/register" 
             element={
@@ -1,6 +1,8 @@
 import React, { useState, useEffect } from 'react';
 import { Link } from 'react-router-dom';
 import axios from 'axios';
+import { BACKEND_SERVICE_URL } from '../config';
+import { subscribeLiveEvents } from '../liveEvents';
 
 const Dashboard = () => {
   const [materias, setMaterias] = useState([]);
@@ -16,7 +18,17 @@ const Dashboard = () => {
       setCurrentTime(new Date());
     }, 60000);
 
-    return () => clearInterval(timer);
+    // Atualizações ao vivo: recarregar quando os dados mudarem em outro dispositivo
+    const unsubscribe = subscribeLiveEvents(({ evento }) => {
+      if (evento === 'reset' || evento.startsWith('materia.') || evento.startsWith('prova.')) {
+        fetchData();
+      }
+    });
+
+    return () => {
+      clearInterval(timer);
+      unsubscribe();
+    };
   }, []);
 
   const fetchData = async () => {
@@ -25,8 +37,8 @@ const Dashboard = () => {
       const headers = { Authorization: `Bearer ${token}` };
 
       const [materiasResponse, provasResponse] = await Promise.all([
-        axios.get('http://localhost:8081/materias', { headers }),
-        axios.get('http://localhost:8081/provas-trabalhos', { headers })
+        axios.get(`${BACKEND_SERVICE_URL}/materias`, { headers }),
+        axios.get(`${BACKEND_SERVICE_URL}/provas-trabalhos`, { headers })
       ]);
 
       setMaterias(materiasResponse.data.data || []);
@@ -1,6 +1,7 @@
 import React, { useState } from 'react';
 import { Link } from 'react-router-dom';
 import axios from 'axios';
+import { AUTH_SERVICE_URL } from '../config';
 
 const Login = ({ onLogin }) => {
   const [formData, setFormData] = useState({
@@ -8,6 +9,7 @@ const Login = ({ onLogin }) => {
     password: ''
   });
   const [error, setError] = useState('');
+  const [message, setMessage] = useState('');
 
   const handleChange = (e) => {
     setFormData({
@@ -41,6 +43,33 @@ const Login = ({ onLogin }) => {
     }
   };
 
+  const handleMagicLink = async () => {
+    setError('');
+    setMessage('');
+    if (!formData.email) {
+      setError('Informe o email para receber o link de acesso');
+      return;
+    }
+
+    try {
+      // credentials: 'include' guarda o cookie que vincula o link a este navegador
+      const response = await fetch(`${AUTH_SERVICE_URL}/login/magic-link`, {
+        method: 'POST',
+        credentials: 'include',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ email: formData.email })
+      });
+      const data = await response.json().catch(() => ({}));
+      if (response.ok) {
+        setMessage(data.message || 'Se o email estiver cadastrado, você receberá um link de acesso.');
+      } else {
+        setError(data.message || 'Não foi possível enviar o link. Tente novamente.');
+      }
+    } catch (err) {
+      setError('Servidor não está rodando. Verifique se o backend está ativo.');
+    }
+  };
+
   return (
     <div className="auth-container">
       <div className="card">
@@ -69,8 +98,12 @@ const Login = ({ onLogin }) => {
             />
           </div>
           <button type="submit" className="btn">Entrar</button>
+          <button type="button" className="btn btn-primary" onClick={handleMagicLink}>
+            Receber link de acesso por email
+          </button>
         </form>
         {error && <div className="error">{error}</div>}
+        {message && <div className="success">{message}</div>}
         <p>
           Não tem uma conta? <Link to="/register">Registre-se aqui</Link>
         </p>
@@ -0,0 +1,56 @@
+import React, { useEffect, useRef, useState } from 'react';
+import { Link, useSearchParams } from 'react-router-dom';
+import { AUTH_SERVICE_URL } from '../config';
+
+// Página aberta pelo link enviado por email: troca o token pela sessão. O link só vale no
+// navegador que o solicitou, que envia o cookie de vínculo com credentials: 'include'.
+const MagicLinkCallback = ({ onLogin }) => {
+  const [searchParams] = useSearchParams();
+  const [error, setError] = useState('');
+  const requested = useRef(false);
+
+  useEffect(() => {
+    // O link é de uso único: evita a segunda chamada do StrictMode em desenvolvimento
+    if (requested.current) return;
+    requested.current = true;
+
+    const token = searchParams.get('token');
+    if (!token) {
+      setError('Link de acesso inválido.');
+      return;
+    }
+
+    fetch(`${AUTH_SERVICE_URL}/login/magic-link/callback?token=${encodeURIComponent(token)}`, {
+      credentials: 'include'
+    })
+      .then(async (response) => {
+        const data = await response.json().catch(() => ({}));
+        if (response.ok && data.token && data.user) {
+          onLogin(data.token, data.user);
+        } else if (response.ok && data.mfa_required) {
+          setError('Sua conta exige a confirmação com passkey. Entre pela tela de login.');
+        } else if (data.error === 'MAGIC_LINK_DEVICE_MISMATCH') {
+          setError('Abra o link no mesmo navegador em que ele foi solicitado.');
+        } else {
+          setError(data.message || 'Link de acesso inválido ou expirado.');
+        }
+      })
+      .catch(() => {
+        setError('Servidor não está rodando. Verifique se o backend está ativo.');
+      });
+  }, [searchParams, onLogin]);
+
+  return (
+    <div className="auth-container">
+      <div className="card">
+        <h2>Link de acesso</h2>
+        {error ? <div className="error">{error}</div> : <p>Entrando...</p>}
+        <p>
+          <Link to="/login">Voltar para o login</Link>
+        </p>
+      </div>
+    </div>
+  );
+};
+
+export default MagicLinkCallback;
@@ -1,5 +1,7 @@
 import React, { useState, useEffect } from 'react';
 import axios from 'axios';
+import { BACKEND_SERVICE_URL } from '../config';
+import { subscribeLiveEvents } from '../liveEvents';
 
 const Materias = () => {
   const [materias, setMaterias] = useState([]);
@@ -13,12 +15,21 @@ const Materias = () => {
 
   useEffect(() => {
     fetchMaterias();
+
+    // Atualizações ao vivo: recarregar quando os dados mudarem em outro dispositivo
+    const unsubscribe = subscribeLiveEvents(({ evento }) => {
+      if (evento === 'reset' || evento.startsWith('materia.')) {
+        fetchMaterias();
+      }
+    });
+
+    return unsubscribe;
   }, []);
 
   const fetchMaterias = async () => {
     try {
       const token = localStorage.getItem('token');
-      const response = await axios.get('http://localhost:8081/materias', {
+      const response = await axios.get(`${BACKEND_SERVICE_URL}/materias`, {
         headers: { Authorization: `Bearer ${token}` }
       });
       setMaterias(response.data.data || []);
@@ -43,12 +54,12 @@ const Materias = () => {
       const token = localStorage.getItem('token');
       
       if (editingMateria) {
-        const response = await axios.put(`http://localhost:8081/materias/${editingMateria.id}`, formData, {
+        const response = await axios.put(`${BACKEND_SERVICE_URL}/materias/${editingMateria.id}`, formData, {
           headers: { Authorization: `Bearer ${token}` }
         });
         console.log('Matéria atualizada:', response.data.message);
       } else {
-        const response = await axios.post('http://localhost:8081/materias', formData, {
+        const response = await axios.post(`${BACKEND_SERVICE_URL}/materias`, formData, {
           headers: { Authorization: `Bearer ${token}` }
         });
         console.log('Matéria criada:', response.data.message);
@@ -76,7 +87,7 @@ const Materias = () => {
     if (window.confirm('Tem certeza que deseja excluir esta matéria?')) {
       try {
         const token = localStorage.getItem('token');
-        await axios.delete(`http://localhost:8081/materias/${id}`, {
+        await axios.delete(`${BACKEND_SERVICE_URL}/materias/${id}`, {
           headers: { Authorization: `Bearer ${token}` }
         });
         fetchMaterias();
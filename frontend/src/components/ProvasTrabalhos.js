@@ -1,5 +1,7 @@
 import React, { useState, useEffect } from 'react';
 import axios from 'axios';
+import { BACKEND_SERVICE_URL } from '../config';
+import { subscribeLiveEvents } from '../liveEvents';
 
 const ProvasTrabalhos = () => {
   const [provasTrabalhos, setProvasTrabalhos] = useState([]);
@@ -18,6 +20,15 @@ const ProvasTrabalhos = () => {
 
   useEffect(() => {
     fetchData();
+
+    // Atualizações ao vivo: recarregar quando os dados mudarem em outro dispositivo
+    const unsubscribe = subscribeLiveEvents(({ evento }) => {
+      if (evento === 'reset' || evento.startsWith('materia.') || evento.startsWith('prova.')) {
+        fetchData();
+      }
+    });
+
+    return unsubscribe;
   }, []);
 
   const fetchData = async () => {
@@ -26,8 +37,8 @@ const ProvasTrabalhos = () => {
       const headers = { Authorization: `Bearer ${token}` };
 
       const [provasResponse, materiasResponse] = await Promise.all([
-        axios.get('http://localhost:8081/provas-trabalhos', { headers }),
-        axios.get('http://localhost:8081/materias', { headers })
+        axios.get(`${BACKEND_SERVICE_URL}/provas-trabalhos`, { headers }),
+        axios.get(`${BACKEND_SERVICE_URL}/materias`, { headers })
       ]);
 
       setProvasTrabalhos(provasResponse.data.data || []);
@@ -57,17 +68,18 @@ const ProvasTrabalhos = () => {
         ...formData,
         anexos: formData.anexos ? formData.anexos.split('\n').filter(item => item.trim()) : [],
         referencias: formData.referencias ? formData.referencias.split('\n').filter(item => item.trim()) : [],
+        bibliografia: editingProva ? editingProva.bibliografia || [] : [],
         data_entrega: formData.data_entrega ? new Date(formData.data_entrega).toISOString() : null,
         materia_id: parseInt(formData.materia_id)
       };
       
       if (editingProva) {
-        const response = await axios.put(`http://localhost:8081/provas-trabalhos/${editingProva.id}`, data, {
+        const response = await axios.put(`${BACKEND_SERVICE_URL}/provas-trabalhos/${editingProva.id}`, data, {
           headers: { Authorization: `Bearer ${token}` }
         });
         console.log('Prova/Trabalho atualizado:', response.data.message);
       } else {
-        const response = await axios.post('http://localhost:8081/provas-trabalhos', data, {
+        const response = await axios.post(`${BACKEND_SERVICE_URL}/provas-trabalhos`, data, {
           headers: { Authorization: `Bearer ${token}` }
         });
         console.log('Prova/Trabalho criado:', response.data.message);
@@ -106,7 +118,7 @@ const ProvasTrabalhos = () => {
     if (window.confirm('Tem certeza que deseja excluir esta prova/trabalho?')) {
       try {
         const token = localStorage.getItem('token');
-        await axios.delete(`http://localhost:8081/provas-trabalhos/${id}`, {
+        await axios.delete(`${BACKEND_SERVICE_URL}/provas-trabalhos/${id}`, {
           headers: { Authorization: `Bearer ${token}` }
         });
         fetchData();
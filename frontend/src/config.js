@@ -0,0 +1,3 @@
+// URLs dos serviços, definidas no build por REACT_APP_AUTH_SERVICE_URL e REACT_APP_BACKEND_SERVICE_URL
+export const AUTH_SERVICE_URL = process.env.REACT_APP_AUTH_SERVICE_URL || 'http://localhost:8080';
+export const BACKEND_SERVICE_URL = process.env.REACT_APP_BACKEND_SERVICE_URL || 'http://localhost:8081';
@@ -0,0 +1,58 @@
+import axios from 'axios';
+import { BACKEND_SERVICE_URL } from './config';
+
+// Espera antes de pedir um novo ticket quando a conexão ao vivo cai
+const RECONNECT_DELAY_MS = 5000;
+
+// Abre o /events com um ticket de uso único, para o token de sessão não ir na URL, e chama
+// onEvent com cada evento. Se a conexão cair, pede outro ticket e retoma a partir do último
+// evento recebido. Retorna a função que encerra a conexão.
+export function subscribeLiveEvents(onEvent) {
+  let source = null;
+  let timer = null;
+  let closed = false;
+  let lastEventId = null;
+
+  const scheduleReconnect = () => {
+    if (!closed) {
+      timer = setTimeout(connect, RECONNECT_DELAY_MS);
+    }
+  };
+
+  const connect = async () => {
+    try {
+      const token = localStorage.getItem('token');
+      const response = await axios.post(`${BACKEND_SERVICE_URL}/events/ticket`, null, {
+        headers: { Authorization: `Bearer ${token}` }
+      });
+      if (closed) return;
+
+      const params = new URLSearchParams({ ticket: response.data.data.ticket });
+      if (lastEventId) {
+        params.set('last_event_id', lastEventId);
+      }
+      source = new EventSource(`${BACKEND_SERVICE_URL}/events?${params}`);
+      source.onmessage = (message) => {
+        lastEventId = message.lastEventId || lastEventId;
+        onEvent(JSON.parse(message.data));
+      };
+      source.onerror = () => {
+        // A reconexão automática do EventSource reusaria o ticket, que vale uma só vez
+        source.close();
+        scheduleReconnect();
+      };
+    } catch (error) {
+      console.error('Erro ao abrir as atualizações ao vivo:', error);
+      scheduleReconnect();
+    }
+  };
+
+  connect();
+  return () => {
+    closed = true;
+    clearTimeout(timer);
+    if (source) {
+      source.close();
+    }
+  };
+}
@@ -0,0 +1,3 @@
+module smtpmail
+
+go 1.21
@@ -0,0 +1,73 @@
+// Package smtpmail envia os emails do auth-service (links de login) e do backend-service
+// (lembretes de entrega), configurado pelas mesmas variáveis SMTP_* nos dois serviços.
+package smtpmail
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+// Mailer envia mensagens de email em texto
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// smtpMailer envia emails através de um servidor SMTP
+type smtpMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func (m *smtpMailer) Send(to, subject, body string) error {
+	msg := strings.Join([]string{
+		"From: " + m.from,
+		"To: " + to,
+		"Subject: " + subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{to}, []byte(msg))
+}
+
+// LogMailer apenas registra a mensagem no log; usado em desenvolvimento quando não há SMTP configurado
+type LogMailer struct{}
+
+func (LogMailer) Send(to, subject, body string) error {
+	log.Printf("MAIL (sem SMTP configurado) to=%s subject=%q\n%s", to, subject, body)
+	return nil
+}
+
+// NewFromEnv cria o Mailer a partir de SMTP_HOST, SMTP_PORT, SMTP_USER, SMTP_PASSWORD e SMTP_FROM
+func NewFromEnv() Mailer {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return LogMailer{}
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		from = "no-reply@" + host
+	}
+
+	var auth smtp.Auth
+	if user := os.Getenv("SMTP_USER"); user != "" {
+		auth = smtp.PlainAuth("", user, os.Getenv("SMTP_PASSWORD"), host)
+	}
+
+	return &smtpMailer{
+		addr: fmt.Sprintf("%s:%s", host, port),
+		from: from,
+		auth: auth,
+	}
+}